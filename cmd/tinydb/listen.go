@@ -15,10 +15,14 @@ import (
 )
 
 type ListenConfig struct {
-	Addr     string       `yaml:"addr"`
-	DataDir  string       `yaml:"data_directory"`
-	PageSize int          `yaml:"page_size"`
-	LogLevel logrus.Level `yaml:"log_level"`
+	Addr           string       `yaml:"addr"`
+	DataDir        string       `yaml:"data_directory"`
+	PageSize       int          `yaml:"page_size"`
+	AutoCheckpoint int          `yaml:"wal_autocheckpoint"`
+	JournalMode    string       `yaml:"journal_mode"`
+	CacheSize      int          `yaml:"cache_size"`
+	SortMemory     int          `yaml:"sort_memory"`
+	LogLevel       logrus.Level `yaml:"log_level"`
 }
 
 type ListenCommand struct {
@@ -74,8 +78,12 @@ func (i *ListenCommand) Run(args []string) int {
 	defer ln.Close()
 
 	dbEngine, err := backend.Start(logger, backend.Config{
-		DataDir:  config.DataDir,
-		PageSize: 4096,
+		DataDir:        config.DataDir,
+		PageSize:       4096,
+		AutoCheckpoint: config.AutoCheckpoint,
+		JournalMode:    config.JournalMode,
+		CacheSize:      config.CacheSize,
+		SortMemory:     config.SortMemory,
 	})
 	if err != nil {
 		return 1
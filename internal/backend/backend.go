@@ -20,6 +20,12 @@ type Backend struct {
 	failed     bool
 	proc       chan struct{}
 	log        logrus.FieldLogger
+
+	// pageSize is the page size set for the database rebuilt by VACUUM
+	pageSize int
+
+	// sortMemory is the memory limit of a sort before it spills to temporary files
+	sortMemory int
 }
 
 // Row is a row in a result
@@ -42,9 +48,11 @@ type ProgramInstance struct {
 	Output <-chan virtualmachine.Output
 	Exit   <-chan error
 
-	inTx    bool
-	program *virtualmachine.Program
-	pager   pager.Pager
+	inTx       bool
+	pageSize   int
+	sortMemory int
+	program    *virtualmachine.Program
+	pager      pager.Pager
 }
 
 func NewBackend(logger logrus.FieldLogger, p pager.Pager) *Backend {
@@ -60,6 +68,12 @@ func NewBackend(logger logrus.FieldLogger, p pager.Pager) *Backend {
 	}
 }
 
+// SetSortMemory sets the size in bytes of the rows a sort holds in memory before it
+// spills them to temporary files, 0 uses virtualmachine.DefaultSortMemory.
+func (b *Backend) SetSortMemory(size int) {
+	b.sortMemory = size
+}
+
 // Prepare parses and builds a virtual machine program
 func (b *Backend) Prepare(command string) (*virtualmachine.PreparedStatement, error) {
 	stmt, err := tsql.Parse(command)
@@ -67,6 +81,12 @@ func (b *Backend) Prepare(command string) (*virtualmachine.PreparedStatement, er
 		return nil, err
 	}
 
+	// The schema is read from the snapshot of the transaction, if any
+	if err := b.pager.BeginRead(); err != nil {
+		return nil, err
+	}
+	defer b.pager.EndRead()
+
 	// Prepare the program
 	preparedStmt, err := virtualmachine.Prepare(stmt, b.pager)
 	if err != nil {
@@ -85,6 +105,12 @@ func (b *Backend) Exec(ctx context.Context, stmt *virtualmachine.PreparedStateme
 		return nil, fmt.Errorf("backend in failure state and requires reset")
 	}
 
+	// Statements read a snapshot of the database, writers take turns
+	if err := b.beginStatement(stmt); err != nil {
+		b.proc <- struct{}{}
+		return nil, err
+	}
+
 	b.pidCounter++
 	pid := b.pidCounter
 
@@ -95,13 +121,15 @@ func (b *Backend) Exec(ctx context.Context, stmt *virtualmachine.PreparedStateme
 	exitCh := make(chan error, 1)
 
 	instance := &ProgramInstance{
-		Pid:     pid,
-		Output:  program.Output(),
-		Exit:    exitCh,
-		Tag:     stmt.Tag,
-		inTx:    b.inTx,
-		pager:   b.pager,
-		program: program,
+		Pid:        pid,
+		Output:     program.Output(),
+		Exit:       exitCh,
+		Tag:        stmt.Tag,
+		inTx:       b.inTx,
+		pageSize:   b.pageSize,
+		sortMemory: b.sortMemory,
+		pager:      b.pager,
+		program:    program,
 	}
 
 	go func() {
@@ -112,6 +140,9 @@ func (b *Backend) Exec(ctx context.Context, stmt *virtualmachine.PreparedStateme
 
 		log.Debugf("running program")
 		c, err := run(ctx, instance)
+		if err == nil {
+			b.pageSize = instance.pageSize
+		}
 
 		switch c {
 		case exitCodeError:
@@ -146,6 +177,7 @@ func (b *Backend) fatal(err error) error {
 	b.failed = true
 	log.WithError(err).Error("fatal error")
 	b.pager.Reset()
+	b.endTransaction()
 	return err
 }
 
@@ -156,6 +188,7 @@ func (b *Backend) rollback() error {
 	b.inTx = false
 	log.Debug("rollback")
 	b.pager.Reset()
+	b.endTransaction()
 	return nil
 }
 
@@ -170,6 +203,7 @@ func (b *Backend) commit() error {
 		b.rollback()
 		return err
 	}
+	b.endTransaction()
 	return nil
 }
 
@@ -181,15 +215,39 @@ func (b *Backend) begin() error {
 	return nil
 }
 
+// beginStatement starts the read transaction of a statement outside of a transaction,
+// statements which make changes also start a write transaction.
+func (b *Backend) beginStatement(stmt *virtualmachine.PreparedStatement) error {
+	if stmt.Statement.Mutates() {
+		if err := b.pager.BeginWrite(); err != nil {
+			return err
+		}
+	}
+	if b.inTx {
+		return nil
+	}
+
+	return b.pager.BeginRead()
+}
+
+// endTransaction releases the snapshot and lets other connections write
+func (b *Backend) endTransaction() {
+	b.pager.EndWrite()
+	b.pager.EndRead()
+}
+
 // run runs a program and returns an exit code
 func run(ctx context.Context, instance *ProgramInstance) (exitCode, error) {
 	flags, err := instance.program.Run(ctx, virtualmachine.Flags{
 		AutoCommit: !instance.inTx,
 		Rollback:   false,
+		PageSize:   instance.pageSize,
+		SortMemory: instance.sortMemory,
 	}, instance.pager)
 	if err != nil {
 		return exitCodeError, err
 	}
+	instance.pageSize = flags.PageSize
 
 	if flags.Rollback {
 		return exitCodeRollback, nil
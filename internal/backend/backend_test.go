@@ -4,18 +4,24 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/suite"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
 )
 
 type BackendTestSuite struct {
 	suite.Suite
 	tempDir string
+	engine  *Engine
 	backend *Backend
 	sqlite  *sql.DB
 }
@@ -44,11 +50,17 @@ func (s *BackendTestSuite) SetupTest() {
 	db, err := sql.Open("sqlite3", path.Join(tempDir, "tiny-test-sqlite.db")+params)
 	s.NoError(err)
 
+	s.tempDir = tempDir
+	s.engine = dbEngine
 	s.backend = NewBackend(logger, dbEngine.NewPager())
 
 	s.sqlite = db
 }
 
+func (s *BackendTestSuite) TearDownTest() {
+	s.NoError(s.engine.Close())
+}
+
 func TestBackendTestSuite(t *testing.T) {
 	suite.Run(t, new(BackendTestSuite))
 }
@@ -202,6 +214,1056 @@ func (s *BackendTestSuite) TestSimple_WithFilter_ComboOrAndGrouping() {
 	}
 }
 
+func (s *BackendTestSuite) TestRowID_FromBtree() {
+	s.assertQuery("create table foo (id integer primary key, name text)")
+	s.assertQuery("insert into foo (name) values ('a')")
+	s.assertQuery("insert into foo (id, name) values (10, 'b')")
+
+	// Another connection continues from the largest rowid in the table
+	s.backend = NewBackend(logrus.New(), s.engine.NewPager())
+	s.assertQuery("insert into foo (name) values ('c')")
+
+	rows, err := s.simpleQuery("select id, name from foo")
+	s.NoError(err)
+
+	expectedResults := [][]interface{}{
+		{1, "a"},
+		{10, "b"},
+		{11, "c"},
+	}
+	s.Len(rows, len(expectedResults))
+	for i, e := range expectedResults {
+		s.Equal(e, rows[i].Data)
+	}
+
+	// Rowids are 64-bit signed integers
+	s.assertQuery("insert into foo (id, name) values (-5, 'e')")
+	s.assertQuery("insert into foo (id, name) values (5000000000, 'f')")
+	s.assertQuery("insert into foo (name) values ('g')")
+	queries := []string{
+		"select * from foo",
+		"select * from foo where id = -5",
+		"select * from foo where id = 5000000000",
+		"select * from foo where id > 4294967295",
+		"select * from foo where id < 10",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+
+	_, err = s.simpleQuery("insert into foo (id, name) values (10, 'd')")
+	s.EqualError(err, "UNIQUE constraint failed: foo.id")
+
+	// No rowid is larger than the largest rowid
+	s.backend = NewBackend(logrus.New(), s.engine.NewPager())
+	s.assertQuery("insert into foo (id, name) values (9223372036854775807, 'h')")
+	_, err = s.simpleQuery("insert into foo (name) values ('i')")
+	s.EqualError(err, "database or disk is full")
+}
+
+func (s *BackendTestSuite) TestRowID_AutoIncrement() {
+	s.assertQuery("create table foo (id integer primary key autoincrement, name text)")
+	s.assertQuery("create table bar (id integer primary key autoincrement, name text)")
+	s.assertQuery("insert into foo (name) values ('a')")
+	s.assertQuery("insert into foo (id, name) values (300, 'b')")
+	s.assertQuery("insert into bar (name) values ('c')")
+	s.assertQuery("insert into foo (name) values ('d')")
+
+	rows, err := s.simpleQuery("select * from sqlite_sequence")
+	s.NoError(err)
+
+	expectedResults := [][]interface{}{
+		{"foo", 301},
+		{"bar", 1},
+	}
+	s.Len(rows, len(expectedResults))
+	for i, e := range expectedResults {
+		s.Equal(e, rows[i].Data)
+	}
+
+	_, err = s.simpleQuery("create table baz (id int primary key autoincrement)")
+	s.Error(err)
+}
+
+func (s *BackendTestSuite) TestDelete() {
+	s.assertQuery("create table foo (name text)")
+	s.assertQuery("BEGIN")
+	for i := 1; i <= 1000; i++ {
+		s.assertQuery(fmt.Sprintf("insert into foo (name) values ('%d')", i))
+	}
+	s.assertQuery("COMMIT")
+
+	s.assertQuery("delete from foo where name != '5' AND name != '500'")
+
+	rows, err := s.simpleQuery("select * from foo")
+	s.NoError(err)
+
+	expectedResults := [][]interface{}{
+		{"5"},
+		{"500"},
+	}
+	s.Len(rows, len(expectedResults))
+	for i, e := range expectedResults {
+		s.Equal(e, rows[i].Data)
+	}
+
+	s.assertQuery("delete from foo")
+	s.assertQuery("insert into foo (name) values ('bar')")
+
+	rows, err = s.simpleQuery("select * from foo")
+	s.NoError(err)
+	s.Len(rows, 1)
+	s.Equal([]interface{}{"bar"}, rows[0].Data)
+}
+
+func (s *BackendTestSuite) TestUpdate() {
+	s.assertQuery("create table foo (id integer primary key, name text, note text)")
+	s.assertQuery("BEGIN")
+	for i := 1; i <= 500; i++ {
+		s.assertQuery(fmt.Sprintf("insert into foo (name, note) values ('%d', 'short')", i))
+	}
+	s.assertQuery("COMMIT")
+
+	// Grow every record so pages have to split while scanning
+	s.assertQuery(fmt.Sprintf("update foo set note = '%s'", strings.Repeat("long", 20)))
+	s.assertQuery("update foo set note = 'changed', name = note where name = '7' OR name = '400'")
+
+	rows, err := s.simpleQuery("select * from foo")
+	s.NoError(err)
+	s.Len(rows, 500)
+	for i, r := range rows {
+		switch i + 1 {
+		case 7, 400:
+			s.Equal([]interface{}{i + 1, strings.Repeat("long", 20), "changed"}, r.Data)
+		default:
+			s.Equal([]interface{}{i + 1, fmt.Sprintf("%d", i+1), strings.Repeat("long", 20)}, r.Data)
+		}
+	}
+
+	_, err = s.simpleQuery("update foo set missing = 'a'")
+	s.EqualError(err, "no such column: missing")
+}
+
+func (s *BackendTestSuite) TestUpdate_RowID() {
+	s.assertQuery("create table foo (id integer primary key, name text unique, n integer)")
+	s.assertQuery("create index foo_n on foo (n)")
+	s.assertQuery("BEGIN")
+	for i := 1; i <= 300; i++ {
+		s.assertQuery(fmt.Sprintf("insert into foo (name, n) values ('%d', %d)", i, i%7))
+	}
+	s.assertQuery("COMMIT")
+
+	// Moved records aren't updated again
+	s.assertQuery("update foo set id = id + 1000")
+	s.assertQuery("update foo set id = -id, n = n + 1 where id > 1250")
+	s.assertQuery("update foo set id = '7', name = 'seven' where name = '9'")
+	s.assertQuery("update foo set id = id where id < 1010")
+
+	queries := []string{
+		"select * from foo",
+		"select * from foo where id < 0",
+		"select * from foo where id = 7",
+		"select * from foo where n = 3",
+		"select * from foo where name = '280'",
+	}
+	check := func() {
+		for _, q := range queries {
+			rows, err := s.simpleQuery(q)
+			s.NoError(err)
+			s.Equal(s.sqliteQuery(q), rows, q)
+		}
+	}
+	check()
+
+	// The new rowid must be unique and an integer
+	for _, q := range []string{
+		"update foo set id = 1005 where id = 1006",
+		"update foo set id = null where id = 1006",
+		"update foo set id = 'x' where id = 1006",
+	} {
+		_, sqliteErr := s.sqlite.Exec(q)
+		s.Error(sqliteErr, q)
+
+		s.backend = NewBackend(logrus.New(), s.engine.NewPager())
+		_, err := s.simpleQuery(q)
+		s.Error(err, q)
+		s.Contains(sqliteErr.Error(), err.Error(), q)
+	}
+	s.backend = NewBackend(logrus.New(), s.engine.NewPager())
+	check()
+}
+
+func (s *BackendTestSuite) TestRowID_Seek() {
+	s.assertQuery("create table foo (id integer primary key, name text)")
+	s.assertQuery("BEGIN")
+	for i := 1; i <= 500; i++ {
+		s.assertQuery(fmt.Sprintf("insert into foo (id, name) values (%d, '%d')", 2*i, i))
+	}
+	s.assertQuery("COMMIT")
+
+	queries := []string{
+		"select * from foo where id = 100",
+		"select * from foo where id = 101",
+		"select * from foo where rowid = 1000",
+		"select * from foo where id > 990",
+		"select * from foo where id >= 990",
+		"select * from foo where id < 11",
+		"select * from foo where 11 >= id",
+		"select * from foo where id > 100 AND id <= 120",
+		"select * from foo where id >= 100 AND id < 120 AND name != '55'",
+		"select * from foo where id > 2000",
+		"select * from foo where id = 4 OR id = 8",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+
+	s.assertQuery("delete from foo where id > 100 AND id < 900")
+	s.assertQuery("update foo set name = 'x' where id = 900")
+
+	q := "select * from foo where id >= 90"
+	rows, err := s.simpleQuery(q)
+	s.NoError(err)
+	s.Equal(s.sqliteQuery(q), rows)
+}
+
+func (s *BackendTestSuite) TestIndex() {
+	s.assertQuery("create table foo (id integer primary key, name text, age int)")
+	s.assertQuery("BEGIN")
+	for i := 1; i <= 300; i++ {
+		s.assertQuery(fmt.Sprintf("insert into foo (name, age) values ('name %04d', %d)", i, i%50))
+	}
+	s.assertQuery("COMMIT")
+
+	// Built from the existing records and maintained by later inserts
+	s.assertQuery("create index foo_age on foo (age)")
+	s.assertQuery("create unique index foo_name on foo (name)")
+	s.assertQuery("create index if not exists foo_age on foo (age)")
+	s.assertQuery("BEGIN")
+	for i := 301; i <= 600; i++ {
+		s.assertQuery(fmt.Sprintf("insert into foo (name, age) values ('name %04d', %d)", i, i%50))
+	}
+	s.assertQuery("COMMIT")
+
+	_, err := s.simpleQuery("create index foo_age on foo (name)")
+	s.EqualError(err, "index foo_age already exists")
+
+	s.assertQuery("delete from foo where age = 10 OR name = 'name 0100'")
+	s.assertQuery("update foo set age = 99 where age = 20")
+	s.assertQuery("update foo set name = 'renamed' where id = 21")
+
+	queries := []string{
+		"select * from foo where age = 7",
+		"select * from foo where age = 10",
+		"select * from foo where age = 99",
+		"select * from foo where 42 = age AND name != 'name 0042'",
+		"select * from foo where age > 45",
+		"select * from foo where age >= 45 AND age < 48",
+		"select * from foo where age <= 2",
+		"select * from foo where name = 'name 0321'",
+		"select * from foo where name = 'name 0100'",
+		"select * from foo where name = 'renamed'",
+		"select * from foo where name >= 'name 0590'",
+		"select * from foo where name < 'name 0005'",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+
+	_, err = s.simpleQuery("insert into foo (name, age) values ('name 0007', 1)")
+	s.EqualError(err, "UNIQUE constraint failed: foo.name")
+}
+
+func (s *BackendTestSuite) TestNumbers() {
+	s.assertQuery("create table m (id integer primary key, n integer, r real)")
+	values := []string{
+		"(1, 0.5)",
+		"(300, .25)",
+		"(70000, 2.5e3)",
+		"(2147483647, 2500.75)",
+		"(2147483648, 1E-3)",
+		"(3000000000, 12345678.125)",
+		"(9007199254740993, 1e300)",
+		"(9223372036854775807, 3.0)",
+	}
+	for _, v := range values {
+		s.assertQuery("insert into m (n, r) values " + v)
+	}
+	s.assertQuery("create index m_r on m (r)")
+
+	queries := []string{
+		"select * from m",
+		"select * from m where n > 2147483647",
+		"select * from m where n = 9223372036854775807",
+		"select * from m where n < 3000000000.5",
+		"select * from m where r = 2500",
+		"select * from m where r > 3",
+		"select * from m where r >= 0.25 AND r < 2500.75",
+		"select * from m where n > r",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+}
+
+func (s *BackendTestSuite) TestExpressions() {
+	s.assertQuery("create table e (id integer primary key, a integer, b real, s text, d integer default 7, t text default ('x'))")
+	values := []string{
+		"(id, a, b, s) values (2 * 5, 1 + 2 * 3, 10 / 4.0, 'one')",
+		"(a, b, s) values (0 - (4 - 6), 0 - 1.5 * 2, 'two')",
+		"(id, a, s) values (null, 9223372036854775807, 'three')",
+		"(a, b, s, d) values (7 / 2, 1.0 / 3, '4five', 1 - 1)",
+		"(a, s, t) values (null, 'six', 'y')",
+	}
+	for _, v := range values {
+		s.assertQuery("insert into e " + v)
+	}
+	s.assertQuery("update e set a = a * 10 + 1, b = b + a where id > 10")
+
+	queries := []string{
+		"select * from e",
+		"select id, a + 1, a - b, a * 2, a / 2, 0 - a, s * 2 from e",
+		"select a / 0, b / 0, a * 2 + 1 from e",
+		"select a, a > 3, a * 2 = 62, true, false from e where id != 14",
+		"select id from e where a",
+		"select id from e where d",
+		"select id from e where a - 31 OR d - 7",
+		"select id from e where a * 2 > 4 AND b - 1 < 0",
+		"select id from e where id + 0 = 11",
+		"select id from e where 0 - id < 0 - 10",
+		"select a + 1 as next from e where a + 1 > 10",
+	}
+
+	// A program has as many registers as the result columns need
+	columns := make([]string, 120)
+	for i := range columns {
+		columns[i] = fmt.Sprint(i)
+	}
+	queries = append(queries, "select "+strings.Join(columns, ", ")+" from e")
+
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+}
+
+func (s *BackendTestSuite) TestOperators() {
+	s.assertQuery("create table people (id integer primary key, name text, age integer, active integer)")
+	values := []string{
+		"('ann', 34, 1)",
+		"('bob', 17, 0)",
+		"('cy', 21, 0)",
+		"('di', -(-40), NOT 1)",
+		"('ed', 25 % 7 * 3, 1 <> 1)",
+	}
+	for _, v := range values {
+		s.assertQuery("insert into people (name, age, active) values " + v)
+	}
+
+	queries := []string{
+		"select name from people where age >= 21 AND NOT active",
+		"select name from people where not age < 21 and active or name == 'bob'",
+		"select name from people where age <> 21 AND age != 34",
+		"select name || '/' || age, age % 5, -age, - -age, ~age from people",
+		"select age & 6, age | 1, age << 2, age >> 1, 1 << 62 << 1 from people",
+		"select 1 + 2 * 3 - 4 / 2, (1 + 2) * 3, 7 % 3 * 2, 2 * 3 || 4 from people where id = 1",
+		"select 1 < 2 = 1, NOT 1 = 2, 3 & 1 = 1, age + 1 > 20 AND age < 30 from people",
+		"select id from people where age % 2 = 0 OR age - 1 = 16",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+}
+
+func (s *BackendTestSuite) TestNulls() {
+	s.assertQuery("create table n (id integer primary key, a integer, b text)")
+	values := []string{
+		"(1, 'x')",
+		"(null, 'y')",
+		"(3, null)",
+		"(null, null)",
+	}
+	for _, v := range values {
+		s.assertQuery("insert into n (a, b) values " + v)
+	}
+	s.assertQuery("create index n_a on n (a)")
+
+	queries := []string{
+		"select id from n where a = null",
+		"select id from n where a != 1",
+		"select id from n where a < 3 OR b = 'y'",
+		"select id from n where NOT a = 1",
+		"select id from n where NOT (a = 1 AND b = 'x')",
+		"select id from n where NOT (a = 1 OR b = 'y')",
+		"select id from n where a IS NULL",
+		"select id from n where a IS NOT NULL AND b IS NULL",
+		"select id from n where a IS 3 OR b IS NOT 'x'",
+		"select id from n where a > 0",
+		"select id from n where a < 5",
+		"select a = 1, a != 1, a < 2, a IS 1, a IS NOT NULL, b IS b, null = null, null IS null from n",
+		"select a = 1 AND b = 'x', a = 1 OR b = 'y', NOT a = 1, a + 1, b || 'z' from n",
+		"select null AND 0, null AND 1, null OR 0, null OR 1, NOT null from n where id = 1",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+
+	// IS [NOT] DISTINCT FROM is newer than the vendored SQLite, compare with IS [NOT]
+	equivalents := map[string]string{
+		"select id from n where b IS DISTINCT FROM 'x'":      "select id from n where b IS NOT 'x'",
+		"select id from n where a IS NOT DISTINCT FROM null": "select id from n where a IS null",
+	}
+	for q, equivalent := range equivalents {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(equivalent), rows, q)
+	}
+}
+
+func (s *BackendTestSuite) TestPatternsAndLists() {
+	s.assertQuery("create table p (id integer primary key, name text, n integer)")
+	values := []string{"('apple', 1)", "('Apricot', 2)", "('banana', NULL)", "(NULL, 4)", "('a_b%c', 5)", "('Zebra ', 6)", "('x]y', 7)"}
+	for _, v := range values {
+		s.assertQuery("insert into p (name, n) values " + v)
+	}
+
+	queries := []string{
+		"select id from p where name LIKE 'a%'",
+		"select id from p where name NOT LIKE '%an%'",
+		"select id from p where name LIKE '_p%t'",
+		"select id from p where name LIKE 'a!_b!%%' ESCAPE '!'",
+		"select id from p where name GLOB 'a*'",
+		"select id from p where name NOT GLOB '*[a-c]*'",
+		"select id from p where name GLOB '[^ab]*' OR name GLOB '?[]]?'",
+		"select id from p where n BETWEEN 2 AND 5",
+		"select id from p where n NOT BETWEEN 2 AND 5",
+		"select id from p where n IN (1, 4, 9)",
+		"select id from p where n NOT IN (1, NULL)",
+		"select id from p where name IN ('1', 'banana') OR n IN ('2')",
+		"select id from p where name = 'APPLE' COLLATE NOCASE",
+		"select id from p where name COLLATE nocase IN ('apricot', 'BANANA')",
+		"select id from p where name COLLATE rtrim = 'Zebra'",
+		"select id from p where name > 'b' COLLATE nocase",
+		"select id from p where name BETWEEN 'a' AND 'b' COLLATE nocase",
+		"select name LIKE 'A%', name GLOB 'A*', n BETWEEN 1 AND 4, n IN (), n NOT IN (), n IN (2, NULL) from p",
+		"select 12 LIKE '1_', 'a' LIKE NULL, 'a' LIKE 'a' ESCAPE NULL, NULL IN (), 1 BETWEEN NULL AND 0 from p where id = 1",
+		"select name from p order by name COLLATE nocase",
+		"select name from p order by name COLLATE nocase desc, n",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err, q)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+
+	// The same errors as SQLite without extensions
+	for _, q := range []string{
+		"select id from p where name REGEXP 'a'",
+		"select id from p where name MATCH 'a'",
+		"select id from p where name GLOB 'a' ESCAPE 'b'",
+		"select id from p where name LIKE 'a' ESCAPE 'bc'",
+		"select id from p where name = 'a' COLLATE unknown",
+	} {
+		_, sqliteErr := s.sqlite.Exec(q)
+		s.Error(sqliteErr, q)
+
+		s.backend = NewBackend(logrus.New(), s.engine.NewPager())
+		_, err := s.simpleQuery(q)
+		s.Error(err, q)
+		s.Contains(sqliteErr.Error(), err.Error(), q)
+	}
+}
+
+func (s *BackendTestSuite) TestExpressions_RowID() {
+	s.assertQuery("create table r (id integer primary key, n integer)")
+	s.assertQuery("insert into r (id, n) values ('5', 1)")
+	s.assertQuery("insert into r (id, n) values (3.0 * 2, 2)")
+	s.assertQuery("insert into r (id, n) values (null, 3)")
+
+	rows, err := s.simpleQuery("select * from r")
+	s.NoError(err)
+	s.Equal(s.sqliteQuery("select * from r"), rows)
+
+	for _, q := range []string{
+		"insert into r (id, n) values (1.5, 4)",
+		"insert into r (id, n) values ('x', 4)",
+	} {
+		_, err := backendRows(NewBackend(logrus.New(), s.engine.NewPager()), q)
+		s.EqualError(err, "datatype mismatch", q)
+	}
+}
+
+func (s *BackendTestSuite) TestAffinity() {
+	s.assertQuery("create table a (i integer, t text, r real, n numeric, b blob, v varchar(10), d double precision, x)")
+	values := []string{
+		"('5', 5, '2', '3.0', 4, 12, '1e2', '7')",
+		"(' 12 ', 1.5, 3, '1.5', 'text', 'abc', 7, 8.0)",
+		"('abc', 2.0, 'xyz', '9223372036854775808', 2.5, 3.25, '', null)",
+		"(7.0, -0.5, 2.5, 'n', '4', 1e20, '-3', 'x')",
+	}
+	for _, v := range values {
+		s.assertQuery("insert into a (i, t, r, n, b, v, d, x) values " + v)
+	}
+	s.assertQuery("update a set n = '42', t = 3 where i = 7")
+	s.assertQuery("create index a_t on a (t)")
+	s.assertQuery("create index a_i on a (i)")
+
+	queries := []string{
+		"select * from a",
+		"select t from a where t = 5",
+		"select t from a where t = 1.5",
+		"select i from a where i = '5'",
+		"select i from a where i > '6'",
+		"select i from a where i < 'abc'",
+		"select n from a where n > '2'",
+		"select v from a where v = 12",
+		"select b from a where b = '4'",
+		"select b from a where b = 4",
+		"select x from a where x = 7",
+		"select x from a where x = '7'",
+		"select i, t from a where i = t",
+		"select i = '5', t = 5, t < 2, v = 'abc' from a",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+}
+
+func (s *BackendTestSuite) TestOrderBy() {
+	s.assertQuery("create table foo (id integer primary key, name text, age int, score real)")
+	s.assertQuery("BEGIN")
+	for i := 1; i <= 300; i++ {
+		age, score := fmt.Sprint(i%40), fmt.Sprintf("%d.5", i%7)
+		switch {
+		case i%13 == 0:
+			age = "null"
+		case i%17 == 0:
+			age = fmt.Sprintf("'%d years'", i%40)
+		}
+		if i%11 == 0 {
+			score = "null"
+		}
+		s.assertQuery(fmt.Sprintf("insert into foo (name, age, score) values ('name %04d', %s, %s)", (i*37)%301, age, score))
+	}
+	s.assertQuery("COMMIT")
+	s.assertQuery("create unique index foo_name on foo (name)")
+
+	// The rows spill to temporary files while they're sorted
+	s.backend.SetSortMemory(1024)
+
+	queries := []string{
+		"select * from foo order by name",
+		"select * from foo order by name desc",
+		"select * from foo order by id desc",
+		"select * from foo where id > 250 order by id",
+		"select * from foo order by age, id",
+		"select * from foo order by age desc, score, id desc",
+		"select * from foo order by age nulls last, score desc nulls first, id",
+		"select * from foo where age > 20 order by score desc, name",
+		"select * from foo where name > 'name 0200' order by age, name",
+		"select name, age * 2 as double from foo order by double, 1",
+		"select id, score from foo order by 2 desc, -id",
+		"select id from foo where id < 10 order by 'constant', age % 3, id",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+
+	// Ranges of the table and of indexes are scanned in reverse
+	s.assertQuery("create index foo_age on foo (age)")
+	queries = []string{
+		"select * from foo where id < 200 AND id >= 150 order by id desc",
+		"select * from foo where id > 290 order by rowid desc",
+		"select * from foo where id <= 5 order by id desc",
+		"select * from foo where name <= 'name 0100' AND name > 'name 0050' order by name desc",
+		"select * from foo where name = 'name 0037' order by name desc",
+		"select * from foo where name < 'name 0010' order by name desc",
+		"select * from foo order by age desc, id desc",
+		"select * from foo where age < 5 order by age desc, id desc",
+		"select * from foo where age >= 35 order by age desc, id desc",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows, q)
+	}
+
+	_, err := s.simpleQuery("select name, age from foo order by 3")
+	s.EqualError(err, "1st ORDER BY term out of range - should be between 1 and 2")
+	_, err = sqliteRows(s.sqlite, "select name, age from foo order by 3")
+	s.EqualError(err, "1st ORDER BY term out of range - should be between 1 and 2")
+}
+
+func (s *BackendTestSuite) TestIndex_UniqueExistingRecords() {
+	s.assertQuery("create table foo (name text, age int)")
+	s.assertQuery("insert into foo (name, age) values ('a', 1)")
+	s.assertQuery("insert into foo (name, age) values ('b', 2)")
+	s.assertQuery("insert into foo (name, age) values ('c', 1)")
+
+	_, err := s.simpleQuery("create unique index foo_age on foo (age)")
+	s.EqualError(err, "UNIQUE constraint failed: foo.age")
+}
+
+func (s *BackendTestSuite) TestOverflow() {
+	s.assertQuery("create table docs (id integer primary key, body text)")
+	s.assertQuery("create index docs_body on docs (body)")
+	for i, size := range []int{10, 5000, 20000, 4000, 100} {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i)), size)))
+	}
+	s.assertQuery(fmt.Sprintf("update docs set body = '%s' where id = 2", strings.Repeat("z", 9000)))
+	s.assertQuery("delete from docs where id = 3")
+
+	queries := []string{
+		"select * from docs",
+		fmt.Sprintf("select id from docs where body = '%s'", strings.Repeat("z", 9000)),
+		fmt.Sprintf("select id from docs where body > '%s'", strings.Repeat("b", 5000)),
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows)
+	}
+}
+
+func (s *BackendTestSuite) TestDropTable() {
+	create := func() {
+		s.assertQuery("create table docs (id integer primary key autoincrement, body text)")
+		s.assertQuery("create index docs_body on docs (body)")
+		for i, size := range []int{10, 5000, 20000, 4000, 100} {
+			s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i)), size)))
+		}
+	}
+
+	create()
+	totalPages := s.engine.wal.TotalPages()
+
+	s.assertQuery("drop table docs")
+	s.assertQuery("drop table if exists docs")
+	_, err := s.simpleQuery("select * from docs")
+	s.Error(err)
+	_, err = s.simpleQuery("drop table docs")
+	s.Error(err)
+
+	rows, err := s.simpleQuery("select * from sqlite_sequence")
+	s.NoError(err)
+	s.Equal(s.sqliteQuery("select * from sqlite_sequence"), rows)
+
+	// The pages of the dropped table are reused
+	create()
+	s.Equal(totalPages, s.engine.wal.TotalPages())
+
+	rows, err = s.simpleQuery("select * from docs")
+	s.NoError(err)
+	s.Equal(s.sqliteQuery("select * from docs"), rows)
+}
+
+func (s *BackendTestSuite) TestVacuum() {
+	s.assertQuery("create table docs (id integer primary key, body text)")
+	s.assertQuery("create index docs_body on docs (body)")
+	for i := 0; i < 200; i++ {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i%26)), 50*(i%40))))
+	}
+	for id := 1; id <= 200; id++ {
+		if id%5 != 0 {
+			s.assertQuery(fmt.Sprintf("delete from docs where id = %d", id))
+		}
+	}
+
+	queries := []string{
+		"select * from docs",
+		fmt.Sprintf("select id from docs where body > '%s'", strings.Repeat("e", 1000)),
+	}
+	assertQueries := func() {
+		for _, q := range queries {
+			rows, err := s.simpleQuery(q)
+			s.NoError(err)
+			s.Equal(s.sqliteQuery(q), rows)
+		}
+	}
+
+	// Rebuilding the database leaves no free pages behind
+	totalPages := s.engine.wal.TotalPages()
+	s.assertQuery("vacuum")
+	s.Less(s.engine.wal.TotalPages(), totalPages)
+	assertQueries()
+
+	// The page size changes when the database is rebuilt
+	s.assertQuery("pragma page_size = 8192")
+	rows, err := s.simpleQuery("pragma page_size")
+	s.NoError(err)
+	s.Equal([]*Row{{Data: []interface{}{4096}}}, rows)
+
+	s.assertQuery("vacuum")
+	rows, err = s.simpleQuery("pragma page_size")
+	s.NoError(err)
+	s.Equal([]*Row{{Data: []interface{}{8192}}}, rows)
+	assertQueries()
+
+	s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat("z", 10000)))
+	assertQueries()
+
+	// A copy is a database of its own
+	copyDir, err := os.MkdirTemp(".tinydb-test", "backend-copy-*")
+	s.NoError(err)
+	_, err = s.simpleQuery(fmt.Sprintf("vacuum into '%s'", path.Join(copyDir, "tiny.db")))
+	s.NoError(err)
+
+	copyEngine, err := Start(logrus.New(), Config{DataDir: copyDir, PageSize: 4096})
+	s.NoError(err)
+	s.backend = NewBackend(logrus.New(), copyEngine.NewPager())
+	assertQueries()
+}
+
+func (s *BackendTestSuite) TestRecover() {
+	s.assertQuery("create table docs (id integer primary key, body text)")
+	s.assertQuery("create index docs_body on docs (body)")
+	s.assertQuery("BEGIN")
+	for i := 0; i < 100; i++ {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i%26)), 10*i)))
+	}
+	s.assertQuery("COMMIT")
+	s.assertQuery("delete from docs where id = 5")
+
+	// Committed transactions are recovered from the log without a checkpoint
+	dbEngine, err := Start(logrus.New(), Config{DataDir: s.tempDir, PageSize: 4096})
+	s.NoError(err)
+	s.backend = NewBackend(logrus.New(), dbEngine.NewPager())
+
+	for _, q := range []string{
+		"select * from docs",
+		"select id from docs where body > 'k'",
+	} {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows)
+	}
+}
+
+func (s *BackendTestSuite) TestSQLiteReadsWAL() {
+	s.assertQuery("create table docs (id integer primary key, body text)")
+	s.assertQuery("create index docs_body on docs (body)")
+	for i := 0; i < 100; i++ {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i%26)), 100*i)))
+	}
+
+	// SQLite reads the committed transactions from a copy of the database and its log
+	copyDir, err := os.MkdirTemp(".tinydb-test", "backend-copy-*")
+	s.NoError(err)
+	for _, name := range []string{"tiny.db", "tiny.db-wal"} {
+		data, err := os.ReadFile(path.Join(s.tempDir, name))
+		s.NoError(err)
+		s.NoError(os.WriteFile(path.Join(copyDir, name), data, os.ModePerm))
+	}
+
+	queries := []string{
+		"select * from docs",
+		"select id from docs where body > 'k'",
+	}
+	var expected [][]*Row
+	for _, q := range queries {
+		expected = append(expected, s.sqliteQuery(q))
+	}
+
+	db, err := sql.Open("sqlite3", path.Join(copyDir, "tiny.db"))
+	s.NoError(err)
+	defer db.Close()
+	s.sqlite = db
+
+	s.Equal([]*Row{{Data: []interface{}{"ok"}}}, s.sqliteQuery("pragma integrity_check"))
+	for i, q := range queries {
+		s.Equal(expected[i], s.sqliteQuery(q))
+	}
+}
+
+func (s *BackendTestSuite) TestCheckpoint() {
+	s.assertQuery("create table docs (id integer primary key, body text)")
+	for i := 0; i < 50; i++ {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat("x", 100*i)))
+	}
+	frames := s.engine.wal.FrameCount()
+	s.Greater(frames, 0)
+
+	rows, err := s.simpleQuery("pragma wal_checkpoint(truncate)")
+	s.NoError(err)
+	s.Equal([]*Row{{Data: []interface{}{0, frames, frames}}}, rows)
+	s.Zero(s.engine.wal.FrameCount())
+
+	info, err := os.Stat(path.Join(s.tempDir, "tiny.db-wal"))
+	s.NoError(err)
+	s.Zero(info.Size())
+
+	// Reads and writes continue with the database file
+	s.assertQuery("delete from docs where id = 10")
+	s.Equal(1, s.engine.wal.FrameCount())
+	rows, err = s.simpleQuery("pragma wal_checkpoint")
+	s.NoError(err)
+	s.Equal([]*Row{{Data: []interface{}{0, 1, 1}}}, rows)
+
+	rows, err = s.simpleQuery("select * from docs")
+	s.NoError(err)
+	s.Equal(s.sqliteQuery("select * from docs"), rows)
+
+	_, err = s.simpleQuery("pragma wal_checkpoint(sometimes)")
+	s.Error(err)
+}
+
+func (s *BackendTestSuite) TestJournalMode() {
+	s.assertQuery("create table docs (id integer primary key, body text)")
+	s.assertQuery("create index docs_body on docs (body)")
+	for i := 0; i < 50; i++ {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i%26)), 100*i)))
+	}
+
+	// Switching to rollback mode checkpoints and removes the log
+	s.NoError(s.engine.Close())
+	dbEngine, err := Start(logrus.New(), Config{DataDir: s.tempDir, PageSize: 4096, JournalMode: "delete"})
+	s.NoError(err)
+	s.engine = dbEngine
+	s.backend = NewBackend(logrus.New(), dbEngine.NewPager())
+
+	_, err = os.Stat(path.Join(s.tempDir, "tiny.db-wal"))
+	s.True(os.IsNotExist(err))
+
+	for i := 50; i < 100; i++ {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i%26)), 100*i)))
+	}
+	s.assertQuery("delete from docs where id = 5")
+	_, err = os.Stat(path.Join(s.tempDir, "tiny.db-journal"))
+	s.True(os.IsNotExist(err))
+
+	// The file header marks the legacy file format for SQLite
+	data, err := os.ReadFile(path.Join(s.tempDir, "tiny.db"))
+	s.NoError(err)
+	s.Equal([]byte{storage.FileFormatLegacy, storage.FileFormatLegacy}, data[18:20])
+
+	queries := []string{
+		"select * from docs",
+		"select id from docs where body > 'k'",
+	}
+	for _, q := range queries {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows)
+	}
+
+	// SQLite reads a copy of the database file without a log
+	copyDir, err := os.MkdirTemp(".tinydb-test", "backend-copy-*")
+	s.NoError(err)
+	s.NoError(os.WriteFile(path.Join(copyDir, "tiny.db"), data, os.ModePerm))
+
+	var expected [][]*Row
+	for _, q := range queries {
+		expected = append(expected, s.sqliteQuery(q))
+	}
+
+	db, err := sql.Open("sqlite3", path.Join(copyDir, "tiny.db"))
+	s.NoError(err)
+	defer db.Close()
+	s.sqlite = db
+
+	s.Equal([]*Row{{Data: []interface{}{"delete"}}}, s.sqliteQuery("pragma journal_mode"))
+	s.Equal([]*Row{{Data: []interface{}{"ok"}}}, s.sqliteQuery("pragma integrity_check"))
+	for i, q := range queries {
+		s.Equal(expected[i], s.sqliteQuery(q))
+	}
+}
+
+func (s *BackendTestSuite) TestCacheSize() {
+	rows, err := s.simpleQuery("pragma cache_size")
+	s.NoError(err)
+	s.Equal([]*Row{{Data: []interface{}{-2000}}}, rows)
+
+	s.assertQuery("pragma cache_size = 10")
+	rows, err = s.simpleQuery("pragma cache_size")
+	s.NoError(err)
+	s.Equal([]*Row{{Data: []interface{}{10}}}, rows)
+
+	s.assertQuery("create table docs (id integer primary key, body text)")
+	s.assertQuery("create index docs_body on docs (body)")
+	for i := 0; i < 100; i++ {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i%26)), 100*i)))
+	}
+
+	// Scans read far more pages than the pool holds
+	for _, q := range []string{
+		"select * from docs",
+		"select id from docs where body > 'k'",
+	} {
+		rows, err := s.simpleQuery(q)
+		s.NoError(err)
+		s.Equal(s.sqliteQuery(q), rows)
+	}
+	s.Greater(s.backend.pager.CacheStats().Evictions, 0)
+
+	// Connections start with the cache size of the configuration
+	dbEngine, err := Start(logrus.New(), Config{DataDir: s.tempDir, PageSize: 4096, CacheSize: -64})
+	s.NoError(err)
+	defer dbEngine.Close()
+	s.backend = NewBackend(logrus.New(), dbEngine.NewPager())
+	rows, err = s.simpleQuery("pragma cache_size")
+	s.NoError(err)
+	s.Equal([]*Row{{Data: []interface{}{-64}}}, rows)
+}
+
+func (s *BackendTestSuite) TestSharedBufferPool() {
+	s.assertQuery("create table docs (id integer primary key, body text)")
+	for i := 0; i < 50; i++ {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat("a", 200)))
+	}
+
+	// Connections read the pages cached by each other
+	reader := NewBackend(logrus.New(), s.engine.NewPager())
+	before := s.backend.pager.CacheStats()
+	rows, err := s.backendQuery(reader, "select id from docs")
+	s.NoError(err)
+	s.Len(rows, 50)
+	stats := s.backend.pager.CacheStats()
+	s.Greater(stats.Hits, before.Hits)
+	s.Equal(before.Misses, stats.Misses)
+
+	// Commits are visible to every connection right away
+	s.assertQuery("insert into docs (body) values ('b')")
+	rows, err = s.backendQuery(reader, "select id from docs where body = 'b'")
+	s.NoError(err)
+	s.Equal([]*Row{{Data: []interface{}{51}}}, rows)
+
+	// The cache size is set for every connection
+	_, err = s.backendQuery(reader, "pragma cache_size = 20")
+	s.NoError(err)
+	rows, err = s.simpleQuery("pragma cache_size")
+	s.NoError(err)
+	s.Equal([]*Row{{Data: []interface{}{20}}}, rows)
+
+	// Connections read concurrently while another one writes
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := NewBackend(logrus.New(), s.engine.NewPager())
+			for j := 0; j < 10; j++ {
+				rows, err := s.backendQuery(b, "select id from docs")
+				s.NoError(err)
+				s.GreaterOrEqual(len(rows), 51)
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		s.assertQuery("insert into docs (body) values ('c')")
+	}
+	wg.Wait()
+
+	rows, err = s.backendQuery(reader, "select id from docs")
+	s.NoError(err)
+	s.Len(rows, 61)
+}
+
+func (s *BackendTestSuite) TestSnapshotIsolation() {
+	s.assertQuery("create table docs (id integer primary key, body text)")
+	for i := 0; i < 10; i++ {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%d')", i))
+	}
+
+	reader := NewBackend(logrus.New(), s.engine.NewPager())
+	assertCount := func(b *Backend, expected int) {
+		rows, err := s.backendQuery(b, "select id from docs")
+		s.NoError(err)
+		s.Len(rows, expected)
+	}
+
+	// A transaction keeps reading the snapshot it started with
+	_, err := s.backendQuery(reader, "BEGIN")
+	s.NoError(err)
+	assertCount(reader, 10)
+
+	for i := 10; i < 20; i++ {
+		s.assertQuery(fmt.Sprintf("insert into docs (body) values ('%d')", i))
+	}
+	assertCount(s.backend, 20)
+	assertCount(reader, 10)
+
+	// Frames the reader may still need are kept in the log
+	rows, err := s.simpleQuery("pragma wal_checkpoint")
+	s.NoError(err)
+	s.Equal(1, rows[0].Data[0])
+
+	// Writing on top of a stale snapshot would lose the other commits
+	_, err = s.backendQuery(reader, "insert into docs (body) values ('stale')")
+	s.ErrorIs(err, storage.ErrStaleSnapshot)
+	_, err = s.backendQuery(reader, "ROLLBACK")
+	s.NoError(err)
+
+	// Outside of a transaction every statement sees the latest commit
+	assertCount(reader, 20)
+	_, err = s.backendQuery(reader, "insert into docs (body) values ('reader')")
+	s.NoError(err)
+	assertCount(s.backend, 21)
+
+	rows, err = s.simpleQuery("pragma wal_checkpoint")
+	s.NoError(err)
+	s.Equal(0, rows[0].Data[0])
+}
+
+// sqliteQuery runs a query with SQLite producing rows in the same form as the backend.
+func (s *BackendTestSuite) sqliteQuery(query string) []*Row {
+	rows, err := sqliteRows(s.sqlite, query)
+	s.Require().NoError(err)
+	return rows
+}
+
+// sqliteRows runs a query with SQLite producing rows in the same form as the backend.
+func sqliteRows(db *sql.DB, query string) ([]*Row, error) {
+	sqlRows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer sqlRows.Close()
+
+	columns, err := sqlRows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []*Row
+	for sqlRows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := sqlRows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		// Integers which fit in 32 bits are int registers in the virtual machine
+		for i, v := range values {
+			if n, ok := v.(int64); ok && n >= math.MinInt32 && n <= math.MaxInt32 {
+				values[i] = int(n)
+			}
+		}
+		rows = append(rows, &Row{Data: values})
+	}
+
+	return rows, sqlRows.Err()
+}
+
 func (s *BackendTestSuite) assertQuery(query string) {
 	_, err := s.sqlite.Exec(query)
 	s.NoError(err)
@@ -211,12 +1273,22 @@ func (s *BackendTestSuite) assertQuery(query string) {
 }
 
 func (s *BackendTestSuite) simpleQuery(query string) ([]*Row, error) {
-	stmt, err := s.backend.Prepare(query)
+	return s.backendQuery(s.backend, query)
+}
+
+// backendQuery runs a query on a backend of its own connection.
+func (s *BackendTestSuite) backendQuery(b *Backend, query string) ([]*Row, error) {
+	return backendRows(b, query)
+}
+
+// backendRows runs a query on a backend collecting the rows it produces.
+func backendRows(b *Backend, query string) ([]*Row, error) {
+	stmt, err := b.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
 
-	proc, err := s.backend.Exec(context.Background(), stmt)
+	proc, err := b.Exec(context.Background(), stmt)
 	if err != nil {
 		return nil, err
 	}
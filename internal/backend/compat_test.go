@@ -0,0 +1,223 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+// CompatTestSuite checks that tinydb reads databases written by SQLite and SQLite
+// reads the changes made by tinydb, both sharing the same database file.
+type CompatTestSuite struct {
+	suite.Suite
+	tempDir string
+	engine  *Engine
+	backend *Backend
+	sqlite  *sql.DB
+}
+
+func TestCompatTestSuite(t *testing.T) {
+	suite.Run(t, new(CompatTestSuite))
+}
+
+func (s *CompatTestSuite) SetupTest() {
+	s.NoError(os.MkdirAll(".tinydb-test", os.ModePerm))
+
+	tempDir, err := os.MkdirTemp(".tinydb-test", "compat-test-*")
+	s.NoError(err)
+	s.tempDir = tempDir
+	s.openSQLite()
+}
+
+func (s *CompatTestSuite) TearDownTest() {
+	if s.engine != nil {
+		s.NoError(s.engine.Close())
+		s.engine = nil
+	}
+	if s.sqlite != nil {
+		s.NoError(s.sqlite.Close())
+	}
+}
+
+// openSQLite opens the database file with SQLite.
+func (s *CompatTestSuite) openSQLite() {
+	db, err := sql.Open("sqlite3", path.Join(s.tempDir, "tiny.db")+"?_journal_mode=DELETE")
+	s.Require().NoError(err)
+	s.sqlite = db
+}
+
+// switchToTinyDB closes SQLite and opens the database file with tinydb.
+func (s *CompatTestSuite) switchToTinyDB(journalMode string) {
+	s.Require().NoError(s.sqlite.Close())
+	s.sqlite = nil
+
+	dbEngine, err := Start(logrus.New(), Config{DataDir: s.tempDir, PageSize: 1024, JournalMode: journalMode})
+	s.Require().NoError(err)
+	s.engine = dbEngine
+	s.backend = NewBackend(logrus.New(), dbEngine.NewPager())
+}
+
+// switchToSQLite closes tinydb and opens the database file with SQLite.
+func (s *CompatTestSuite) switchToSQLite() {
+	s.Require().NoError(s.engine.Close())
+	s.engine = nil
+	s.openSQLite()
+}
+
+// sqliteExec runs statements with SQLite only.
+func (s *CompatTestSuite) sqliteExec(queries ...string) {
+	for _, q := range queries {
+		_, err := s.sqlite.Exec(q)
+		s.Require().NoError(err, q)
+	}
+}
+
+// tinyExec runs statements with tinydb only.
+func (s *CompatTestSuite) tinyExec(queries ...string) {
+	for _, q := range queries {
+		_, err := s.tinyQuery(q)
+		s.Require().NoError(err, q)
+	}
+}
+
+// sqliteQuery runs a query with SQLite.
+func (s *CompatTestSuite) sqliteQuery(query string) []*Row {
+	rows, err := sqliteRows(s.sqlite, query)
+	s.Require().NoError(err, query)
+	return rows
+}
+
+// tinyQuery runs a query with tinydb.
+func (s *CompatTestSuite) tinyQuery(query string) ([]*Row, error) {
+	return backendRows(s.backend, query)
+}
+
+func (s *CompatTestSuite) TestReadSQLiteFile() {
+	s.sqliteExec(
+		"create table docs (id integer primary key, body text)",
+		"create index docs_body on docs (body)",
+		"create table people (name text unique, age integer, photo blob)",
+		"create table events (id integer primary key autoincrement, name text)",
+		"create table numbers (n integer)",
+		"BEGIN",
+	)
+	for i := 0; i < 300; i++ {
+		s.sqliteExec(
+			fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i%26)), 20*i)),
+			fmt.Sprintf("insert into people (name, age, photo) values ('p%d', %d, x'%04x')", i, i*1000-5000, i),
+			fmt.Sprintf("insert into events (name) values ('e%d')", i),
+		)
+	}
+	for _, n := range []int64{0, 1, -1, 127, -128, 32767, -32769, 1 << 23, 1 << 40, -1 << 47, 1 << 47, 1<<63 - 1, -1 << 63} {
+		s.sqliteExec(fmt.Sprintf("insert into numbers (n) values (%d)", n))
+	}
+	s.sqliteExec(
+		"insert into people (name) values ('nobody')",
+		"COMMIT",
+		"delete from docs where id > 200",
+		"delete from events where id < 100",
+		"insert into docs (id, body) values (-5, 'below')",
+		"insert into docs (id, body) values (5000000000, 'beyond')",
+	)
+
+	queries := []string{
+		"select * from sqlite_master",
+		"select * from docs",
+		"select id from docs where body > 'k'",
+		"select * from docs where id = 150",
+		"select * from docs where id = 5000000000",
+		"select * from docs where id < 10",
+		"select * from people",
+		"select age from people where name = 'p7'",
+		"select * from events",
+		"select * from sqlite_sequence",
+		"select * from numbers",
+	}
+	var expected [][]*Row
+	for _, q := range queries {
+		expected = append(expected, s.sqliteQuery(q))
+	}
+
+	s.switchToTinyDB("delete")
+	for i, q := range queries {
+		rows, err := s.tinyQuery(q)
+		s.NoError(err, q)
+		s.Equal(expected[i], rows, q)
+	}
+
+	// The schema table can't be changed directly
+	_, err := s.tinyQuery("delete from sqlite_master where name = 'docs'")
+	s.Error(err)
+}
+
+func (s *CompatTestSuite) TestSQLiteReadsTinyDBChanges() {
+	s.sqliteExec(
+		"create table docs (id integer primary key, body text)",
+		"create index docs_body on docs (body)",
+		"create table events (id integer primary key autoincrement, name text)",
+		"BEGIN",
+	)
+	for i := 0; i < 200; i++ {
+		s.sqliteExec(
+			fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i%26)), 30*i)),
+			fmt.Sprintf("insert into events (name) values ('e%d')", i),
+		)
+	}
+	s.sqliteExec("COMMIT", "delete from docs where id > 100")
+
+	// tinydb reuses the free pages left by SQLite and keeps the sequence of SQLite
+	s.switchToTinyDB("")
+	s.tinyExec("BEGIN")
+	for i := 0; i < 100; i++ {
+		s.tinyExec(
+			fmt.Sprintf("insert into docs (body) values ('%s')", strings.Repeat(string(rune('a'+i%26)), 40*i)),
+			fmt.Sprintf("insert into events (name) values ('t%d')", i),
+		)
+	}
+	s.tinyExec(
+		"COMMIT",
+		"delete from docs where id = 50",
+		"create table people (name text unique, age integer)",
+		"insert into people (name, age) values ('ada', 36)",
+		"insert into people (name, age) values ('alan', 41)",
+		"insert into docs (id, body) values (-5, 'below')",
+		"insert into docs (id, body) values (5000000000, 'beyond')",
+		"insert into docs (body) values ('next')",
+	)
+
+	// Constraints are kept by indexes like in SQLite
+	other := NewBackend(logrus.New(), s.engine.NewPager())
+	_, err := backendRows(other, "insert into people (name, age) values ('ada', 1)")
+	s.Error(err)
+
+	queries := []string{
+		"select * from sqlite_master",
+		"select * from docs",
+		"select id from docs where body > 'k'",
+		"select * from events",
+		"select * from sqlite_sequence",
+		"select age from people where name = 'alan'",
+	}
+	var expected [][]*Row
+	for _, q := range queries {
+		rows, err := s.tinyQuery(q)
+		s.NoError(err, q)
+		expected = append(expected, rows)
+	}
+
+	s.switchToSQLite()
+	s.Equal([]*Row{{Data: []interface{}{"ok"}}}, s.sqliteQuery("pragma integrity_check"))
+	for i, q := range queries {
+		s.Equal(expected[i], s.sqliteQuery(q), q)
+	}
+	s.Len(s.sqliteQuery("select * from docs"), 202)
+
+	_, err = s.sqlite.Exec("insert into people (name, age) values ('alan', 1)")
+	s.Error(err)
+}
@@ -17,6 +17,21 @@ import (
 type Config struct {
 	DataDir  string	// 数据目录
 	PageSize int	// 页大小
+
+	// AutoCheckpoint is the number of frames in the WAL which triggers a checkpoint,
+	// 0 uses storage.DefaultAutoCheckpoint and a negative number disables it.
+	AutoCheckpoint int
+
+	// JournalMode is WAL, DELETE or TRUNCATE, empty is WAL.
+	JournalMode string
+
+	// CacheSize is the size of the buffer pool shared by the connections like PRAGMA cache_size,
+	// in pages when positive and in KiB when negative. 0 uses pager.DefaultCacheSize.
+	CacheSize int
+
+	// SortMemory is the size in bytes of the rows a sort holds in memory before it spills
+	// them to temporary files. 0 uses virtualmachine.DefaultSortMemory.
+	SortMemory int
 }
 
 // Engine holds metadata and indexes about the database
@@ -27,7 +42,8 @@ type Engine struct {
 	log       logrus.FieldLogger
 	config    Config
 	wal       *storage.WAL	// WAL 日志
-	pagerPool *pager.Pool	// 页缓存
+	journal   *storage.Journal	// 回滚日志
+	pool      *pager.BufferPool	// 共享的缓冲池
 	txID      uint32		// 事务 ID
 }
 
@@ -39,6 +55,11 @@ func Start(log logrus.FieldLogger, config Config) (*Engine, error) {
 		return nil, errors.New("page size must be greater than or equal to 1024")
 	}
 
+	journalMode, err := storage.ParseJournalMode(config.JournalMode)
+	if err != nil {
+		return nil, err
+	}
+
 	// 数据文件
 	dbPath := path.Join(config.DataDir, "tiny.db")
 
@@ -50,6 +71,24 @@ func Start(log logrus.FieldLogger, config Config) (*Engine, error) {
 		return nil, err
 	}
 
+	// A commit in rollback mode may not have finished before the last shutdown,
+	// the log of WAL mode is no longer read in rollback mode.
+	//
+	// 回滚未完成的事务
+	if err := storage.RecoverJournal(dbFile); err != nil {
+		return nil, err
+	}
+	fileFormat := uint8(storage.FileFormatWAL)
+	if journalMode != storage.JournalWAL {
+		if err := storage.RemoveWAL(dbFile); err != nil {
+			return nil, err
+		}
+		fileFormat = storage.FileFormatLegacy
+	}
+	if err := dbFile.SetFileFormat(fileFormat); err != nil {
+		return nil, err
+	}
+
 	// Brand new database needs at least one page.
 	//
 	// 检查页数目，为空则初始化
@@ -59,20 +98,62 @@ func Start(log logrus.FieldLogger, config Config) (*Engine, error) {
 		}
 	}
 
+	e := &Engine{
+		config: config,
+		log:    log,
+	}
+
+	if journalMode != storage.JournalWAL {
+		// 初始化回滚日志
+		e.journal, err = storage.OpenJournal(dbFile, journalMode)
+		if err != nil {
+			return nil, err
+		}
+		e.pool = pager.NewBufferPool(config.CacheSize, dbFile.PageSize(), e.journal.Connect().Snapshot())
+		return e, nil
+	}
+
 	// Initialize WAL
 	//
 	// 初始化 WAL
-	wal, err := storage.OpenWAL(dbFile)
+	e.wal, err = storage.OpenWAL(dbFile)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Engine{
-		config:    config,
-		log:       log,
-		wal:       wal,
-		pagerPool: pager.NewPool(pager.NewPager(wal)),
-	}, nil
+	e.pool = pager.NewBufferPool(config.CacheSize, dbFile.PageSize(), e.wal.Connect().Snapshot())
+
+	if config.AutoCheckpoint < 0 {
+		e.wal.SetAutoCheckpoint(0)
+	} else if config.AutoCheckpoint > 0 {
+		e.wal.SetAutoCheckpoint(config.AutoCheckpoint)
+	}
+
+	// 后台检查点
+	go e.checkpoint()
+
+	return e, nil
+}
+
+// checkpoint runs a passive checkpoint whenever the WAL grows to the automatic
+// checkpoint threshold, until the WAL is closed.
+func (e *Engine) checkpoint() {
+	for range e.wal.CheckpointRequests() {
+		result, err := e.wal.Checkpoint(storage.CheckpointPassive)
+		if err != nil {
+			e.log.WithError(err).Error("automatic checkpoint failed")
+			continue
+		}
+		e.log.Debugf("checkpointed %d of %d frames", result.Checkpointed, result.LogFrames)
+	}
+}
+
+// Close closes the database files, the engine can't be used afterwards.
+func (e *Engine) Close() error {
+	if e.journal != nil {
+		return e.journal.Close()
+	}
+	return e.wal.Close()
 }
 
 // TxID provides a new transaction id
@@ -81,6 +162,18 @@ func (e *Engine) TxID() uint32 {
 	return atomic.AddUint32(&e.txID, 1)
 }
 
+// SortMemory is the memory limit of the sorts of the connections, see Config.SortMemory.
+func (e *Engine) SortMemory() int {
+	return e.config.SortMemory
+}
+
+// NewPager provides a pager for a connection, it reads snapshots of the committed
+// transactions concurrently with other connections. In rollback mode commits wait
+// for the other connections to finish reading instead. Committed pages are cached
+// once in the buffer pool of the engine.
 func (e *Engine) NewPager() pager.Pager {
-	return pager.NewPager(e.wal)
+	if e.journal != nil {
+		return pager.NewPoolPager(e.journal.Connect(), e.pool)
+	}
+	return pager.NewPoolPager(e.wal.Connect(), e.pool)
 }
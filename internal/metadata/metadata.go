@@ -1,8 +1,10 @@
 package metadata
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/joeandaverde/tinydb/internal/pager"
 	"github.com/joeandaverde/tinydb/internal/storage"
@@ -12,11 +14,14 @@ import (
 
 // ColumnDefinition represents a specification for a column in a table
 type ColumnDefinition struct {
-	Name         string
-	Type         storage.SQLType
-	Offset       int
-	PrimaryKey   bool
-	DefaultValue interface{}
+	Name          string
+	Affinity      storage.Affinity // 由声明的类型决定
+	Offset        int
+	PrimaryKey    bool
+	RowIDAlias    bool
+	AutoIncrement bool
+	Unique        bool
+	Default       ast.Expression // 未指定时为 nil，即 NULL
 }
 
 type TableDefinition struct {
@@ -24,47 +29,299 @@ type TableDefinition struct {
 	RawText  string
 	Columns  []*ColumnDefinition
 	RootPage int
+	Indexes  []*IndexDefinition
 }
 
-var tableCache = make(map[string]*TableDefinition)
+// IndexDefinition represents an index on columns of a table. The entries of
+// the index are the indexed columns followed by the rowid.
+type IndexDefinition struct {
+	Name      string
+	TableName string
+	Columns   []*ColumnDefinition
+	Unique    bool
+	RootPage  int
+}
+
+// SchemaTable is the name of the table holding the definitions of the tables and indexes,
+// sqlite_schema is an alias for it.
+const SchemaTable = "sqlite_master"
+
+// autoIndexPrefix starts the names of the indexes made for UNIQUE and PRIMARY KEY constraints
+const autoIndexPrefix = "sqlite_autoindex_"
+
+// SequenceTable is the name of the table that keeps the largest
+// rowid used by each AUTOINCREMENT table.
+const SequenceTable = "sqlite_sequence"
+
+// SequenceTableSQL is the definition of the sequence table.
+const SequenceTableSQL = "CREATE TABLE sqlite_sequence(name text, seq int)"
+
+// ErrTableNotFound indicates a table doesn't exist in the schema
+var ErrTableNotFound = errors.New("table not found")
+
+// ErrIndexNotFound indicates an index doesn't exist in the schema
+var ErrIndexNotFound = errors.New("index not found")
+
+// RowIDColumn returns the column that is an alias for the rowid or nil.
+func (t *TableDefinition) RowIDColumn() *ColumnDefinition {
+	for _, c := range t.Columns {
+		if c.RowIDAlias {
+			return c
+		}
+	}
+	return nil
+}
+
+// Column finds a column of the table by name. The names rowid, oid and _rowid_
+// refer to the rowid unless the table has a column with that name.
+func (t *TableDefinition) Column(name string) *ColumnDefinition {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	for _, alias := range []string{"rowid", "oid", "_rowid_"} {
+		if strings.EqualFold(name, alias) {
+			if c := t.RowIDColumn(); c != nil {
+				return c
+			}
+			return &ColumnDefinition{Name: name, Affinity: storage.AffinityInteger, Offset: -1, RowIDAlias: true}
+		}
+	}
+
+	return nil
+}
+
+// AutoIncrement determines if rowids of the table must never be reused.
+func (t *TableDefinition) AutoIncrement() bool {
+	c := t.RowIDColumn()
+	return c != nil && c.AutoIncrement
+}
+
+// IsRowIDAlias determines if a column is an alias for the rowid, which is the
+// case for a column declared as INTEGER PRIMARY KEY.
+func IsRowIDAlias(c ast.ColumnDefinition) bool {
+	return c.PrimaryKey && strings.EqualFold(c.Type, "integer")
+}
+
+// NeedsAutoIndex determines if a column is declared UNIQUE or PRIMARY KEY without being
+// an alias for the rowid, such columns have an index made along with the table.
+func NeedsAutoIndex(c ast.ColumnDefinition) bool {
+	return c.Unique || (c.PrimaryKey && !IsRowIDAlias(c))
+}
+
+// AutoIndexName is the name of the index made for the n-th column of a table which needs one,
+// counting from 1.
+func AutoIndexName(tableName string, n int) string {
+	return fmt.Sprintf("%s%s_%d", autoIndexPrefix, tableName, n)
+}
+
+// IsSchemaTable determines if the name refers to the schema table, which can't be changed
+// with INSERT, UPDATE or DELETE.
+func IsSchemaTable(name string) bool {
+	return strings.EqualFold(name, SchemaTable) || strings.EqualFold(name, "sqlite_schema")
+}
+
+// schemaTableDefinition describes the schema table by the name it's referred to,
+// it has no definition of its own.
+func schemaTableDefinition(name string) *TableDefinition {
+	columns := []*ColumnDefinition{
+		{Name: "type", Affinity: storage.AffinityText},
+		{Name: "name", Affinity: storage.AffinityText},
+		{Name: "tbl_name", Affinity: storage.AffinityText},
+		{Name: "rootpage", Affinity: storage.AffinityInteger},
+		{Name: "sql", Affinity: storage.AffinityText},
+	}
+	for i, c := range columns {
+		c.Offset = i
+	}
 
+	return &TableDefinition{
+		Name:     name,
+		RootPage: 1,
+		Columns:  columns,
+	}
+}
+
+// GetTableDefinition reads the definition of a table and its indexes from the schema table.
 func GetTableDefinition(p pager.Pager, name string) (*TableDefinition, error) {
-	if tableDefinition, ok := tableCache[name]; ok {
-		return tableDefinition, nil
+	if IsSchemaTable(name) {
+		return schemaTableDefinition(name), nil
 	}
 
-	cursor, err := pager.NewCursor(p, pager.CURSOR_READ, 1, name)
+	var table *TableDefinition
+	var indexRecords []*storage.Record
+
+	err := scanSchema(p, func(record *storage.Record) error {
+		switch record.Fields[0].Data {
+		case "table":
+			if name != record.Fields[1].Data.(string) {
+				return nil
+			}
+			tableDefinition, err := tableDefinitionFromRecord(record)
+			if err != nil {
+				return err
+			}
+			table = tableDefinition
+		case "index":
+			if name == record.Fields[2].Data.(string) {
+				indexRecords = append(indexRecords, record)
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	hasMore, err := cursor.Rewind()
+	if table == nil {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotFound, name)
+	}
+
+	for _, record := range indexRecords {
+		index, err := indexDefinitionFromRecord(table, record)
+		if err != nil {
+			return nil, err
+		}
+		table.Indexes = append(table.Indexes, index)
+	}
+
+	return table, nil
+}
+
+// GetIndexDefinition reads the definition of an index from the schema table.
+func GetIndexDefinition(p pager.Pager, name string) (*IndexDefinition, error) {
+	var indexRecord *storage.Record
+
+	err := scanSchema(p, func(record *storage.Record) error {
+		if record.Fields[0].Data == "index" && name == record.Fields[1].Data.(string) {
+			indexRecord = record
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if indexRecord == nil {
+		return nil, fmt.Errorf("%w: %s", ErrIndexNotFound, name)
+	}
+
+	table, err := GetTableDefinition(p, indexRecord.Fields[2].Data.(string))
 	if err != nil {
 		return nil, err
 	}
 
+	return indexDefinitionFromRecord(table, indexRecord)
+}
+
+// scanSchema calls fn with each record of the schema table.
+func scanSchema(p pager.Pager, fn func(record *storage.Record) error) error {
+	cursor, err := pager.NewCursor(p, pager.CURSOR_READ, 1, "sqlite_master")
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+
+	hasMore, err := cursor.Rewind()
+	if err != nil {
+		return err
+	}
+
 	for hasMore {
 		record, err := cursor.CurrentCell()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		if name == record.Fields[1].Data.(string) {
-			tableDefinition, err := tableDefinitionFromRecord(record)
-			if err != nil {
-				return nil, err
-			}
-			tableCache[name] = tableDefinition
-			return tableDefinition, nil
+		if err := fn(record); err != nil {
+			return err
 		}
 
 		hasMore, err = cursor.Next()
 		if err != nil {
-			return nil, err
+			return err
+		}
+	}
+
+	return nil
+}
+
+func indexDefinitionFromRecord(table *TableDefinition, record *storage.Record) (*IndexDefinition, error) {
+	// Indexes made for constraints have no definition
+	createSQL, ok := record.Fields[4].Data.(string)
+	if !ok {
+		return autoIndexDefinition(table, record)
+	}
+	stmt, err := tsql.Parse(createSQL)
+	if err != nil {
+		return nil, err
+	}
+	createIndex, ok := stmt.(*ast.CreateIndexStatement)
+	if !ok {
+		return nil, fmt.Errorf("unexpected index definition %s", createSQL)
+	}
+
+	var cols []*ColumnDefinition
+	for _, name := range createIndex.Columns {
+		c := table.Column(name)
+		if c == nil {
+			return nil, fmt.Errorf("no such column: %s", name)
+		}
+		cols = append(cols, c)
+	}
+
+	rootPage, err := rootPageFromRecord(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IndexDefinition{
+		Name:      record.Fields[1].Data.(string),
+		TableName: table.Name,
+		Columns:   cols,
+		Unique:    createIndex.Unique,
+		RootPage:  rootPage,
+	}, nil
+}
+
+// autoIndexDefinition finds the column of an index made for a UNIQUE or PRIMARY KEY
+// constraint, the indexes are numbered in the order of the columns.
+func autoIndexDefinition(table *TableDefinition, record *storage.Record) (*IndexDefinition, error) {
+	name := record.Fields[1].Data.(string)
+
+	var n int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(name, autoIndexPrefix+table.Name+"_"), "%d", &n); err != nil {
+		return nil, fmt.Errorf("unexpected index without definition %s", name)
+	}
+
+	var column *ColumnDefinition
+	for _, c := range table.Columns {
+		if !c.Unique && !(c.PrimaryKey && !c.RowIDAlias) {
+			continue
+		}
+		if n--; n == 0 {
+			column = c
+			break
 		}
 	}
+	if column == nil {
+		return nil, fmt.Errorf("no constraint for index %s", name)
+	}
+
+	rootPage, err := rootPageFromRecord(record)
+	if err != nil {
+		return nil, err
+	}
 
-	return nil, fmt.Errorf("table not found: %s", name)
+	return &IndexDefinition{
+		Name:      name,
+		TableName: table.Name,
+		Columns:   []*ColumnDefinition{column},
+		Unique:    true,
+		RootPage:  rootPage,
+	}, nil
 }
 
 func tableDefinitionFromRecord(record *storage.Record) (*TableDefinition, error) {
@@ -75,18 +332,30 @@ func tableDefinitionFromRecord(record *storage.Record) (*TableDefinition, error)
 	}
 	var cols []*ColumnDefinition
 	for i, c := range stmt.(*ast.CreateTableStatement).Columns {
-		sqlType, err := storage.SQLTypeFromString(c.Type)
-		if err != nil {
-			return nil, err
-		}
-
 		cols = append(cols, &ColumnDefinition{
-			Offset:     i,
-			Name:       c.Name,
-			Type:       sqlType,
-			PrimaryKey: c.PrimaryKey,
+			Offset:        i,
+			Name:          c.Name,
+			Affinity:      storage.AffinityFromType(c.Type),
+			PrimaryKey:    c.PrimaryKey,
+			RowIDAlias:    IsRowIDAlias(c),
+			AutoIncrement: c.AutoIncrement,
+			Unique:        c.Unique,
+			Default:       c.Default,
 		})
 	}
+	rootPage, err := rootPageFromRecord(record)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TableDefinition{
+		Name:     record.Fields[1].Data.(string),
+		RootPage: rootPage,
+		Columns:  cols,
+	}, nil
+}
+
+func rootPageFromRecord(record *storage.Record) (int, error) {
 	var rootPage int
 	switch p := record.Fields[3].Data.(type) {
 	case int:
@@ -100,12 +369,8 @@ func tableDefinitionFromRecord(record *storage.Record) (*TableDefinition, error)
 	case uint64:
 		rootPage = int(p)
 	default:
-		return nil, fmt.Errorf("unexpected root page type %v", reflect.TypeOf(record.Fields[3].Data))
+		return 0, fmt.Errorf("unexpected root page type %v", reflect.TypeOf(record.Fields[3].Data))
 	}
 
-	return &TableDefinition{
-		Name:     record.Fields[1].Data.(string),
-		RootPage: rootPage,
-		Columns:  cols,
-	}, nil
+	return rootPage, nil
 }
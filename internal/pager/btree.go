@@ -19,211 +19,514 @@ func NewBTreeTable(rootPage int, p Pager) *BTreeTable {
 	}
 }
 
-func (b *BTreeTable) Insert(r *storage.Record) error {
-	buf := bytes.Buffer{}
-	if err := r.Write(&buf); err != nil {
-		return err
-	}
-	recordBytes := buf.Bytes()
+// errSplitRetry indicates a page was split without the cell being inserted,
+// the cell has to be inserted again from the root.
+var errSplitRetry = errors.New("page split before insert")
 
+func (b *BTreeTable) Insert(r *storage.Record) error {
 	// Load the table root page
 	//
 	// 读取根页
-	root, err := b.pager.Read(b.rootPage)
+	page, err := b.pager.Read(b.rootPage)
 	if err != nil {
 		return err
 	}
 
-	// 检查节点类型
+	recordBytes, err := tableLeafCell(b.pager, len(page.data), r)
+	if err != nil {
+		return err
+	}
 
-	// 如果 root 是页节点
-	if root.header.Type == PageTypeLeaf {
-		// 如果 root 不足以容纳新数据
-		if !root.Fits(len(recordBytes)) {
-			// 分裂页，得到 父、左、右 页
-			parent, left, right, err := splitPage(b.pager, root)
-			if err != nil {
-				return err
-			}
-			// Write the record to the right page
-			// 把新数据写入到右页
-			right.AddCell(recordBytes)
-			// Write all pages to disk
-			// 把所有页刷盘
-			return b.pager.Write(left, right, parent)
+	for {
+		err := b.insert(page, r.RowID, recordBytes)
+		if !errors.Is(err, errSplitRetry) {
+			return err
 		}
+	}
+}
 
-		// Write the record to the leaf page
-		// 如果 root 可以容纳新数据，就直接插入到 root 中。
-		root.AddCell(recordBytes)
-
-		// Save the page
-		// 刷盘
-		return b.pager.Write(root)
-
-	// 如果 root 非页节点
-	} else if root.header.Type == PageTypeInternal {
-
-		// TODO: For now, just write to the right most page.
-		//
-		destPage, err := b.pager.Read(root.header.RightPage)
+// insert places the cell of a record in the leaf where the key belongs, descending from the root page.
+func (b *BTreeTable) insert(page *MemPage, key int64, recordBytes []byte) error {
+	// Descend to the leaf where the key belongs remembering the path taken.
+	var path []pathFrame
+	for page.header.Type == PageTypeInternal {
+		index, _, err := page.Search(key)
 		if err != nil {
 			return err
 		}
+		path = append(path, pathFrame{page: page.Number(), index: index})
 
-		// If the rightmost page is full, create a new page and update the pointer.
-		// 如果最右节点不足以容纳新数据，需要分裂
-		if !destPage.Fits(len(recordBytes)) {
+		child, err := page.ChildPage(index)
+		if err != nil {
+			return err
+		}
+		page, err = b.pager.Read(child)
+		if err != nil {
+			return err
+		}
+	}
 
-			// 获取 destPage 内最大 RowID
-			maxRowID, err := maxRowID(destPage)
-			if err != nil {
-				return err
-			}
+	if page.header.Type != PageTypeLeaf {
+		return errors.New("unsupported page type")
+	}
 
-			//
-			internalNode := storage.InteriorNode{
-				LeftChild: uint32(destPage.Number()),
-				Key:       maxRowID,
-			}
+	// Keep the cells of the leaf ordered by key
+	index, found, err := page.Search(key)
+	if err != nil {
+		return err
+	}
+	if found {
+		return b.replaceCell(path, page, index, recordBytes)
+	}
 
-			/// 现在实现比较原始 ，内部节点的分裂还不支持
-			if !root.Fits(storage.InteriorNodeSize) {
-				return errors.New("not yet supporting adding another internal node")
-			}
+	return b.insertCell(path, page, index, recordBytes)
+}
 
-			// Allocate a new page, update internal node right pointer.
-			destPage, err = b.pager.Allocate(PageTypeLeaf) //Leaf
-			if err != nil {
-				return err
-			}
-			root.header.RightPage = destPage.Number()
+// Delete removes the record with the key from the table,
+// returns false if there is no such record.
+func (b *BTreeTable) Delete(key int64) (bool, error) {
+	page, err := b.pager.Read(b.rootPage)
+	if err != nil {
+		return false, err
+	}
 
-			// Add link to the newly added page.
-			interiorCell, err := internalNode.ToBytes()
-			if err != nil {
-				return err
-			}
-			root.AddCell(interiorCell)
+	var path []pathFrame
+	for page.header.Type == PageTypeInternal {
+		index, _, err := page.Search(key)
+		if err != nil {
+			return false, err
+		}
+		path = append(path, pathFrame{page: page.Number(), index: index})
 
-			// Write the record
-			destPage.AddCell(recordBytes)
-			return b.pager.Write(root, destPage)
+		child, err := page.ChildPage(index)
+		if err != nil {
+			return false, err
 		}
+		page, err = b.pager.Read(child)
+		if err != nil {
+			return false, err
+		}
+	}
 
-		// Write the record
-		destPage.AddCell(recordBytes)
-		return b.pager.Write(destPage)
-	} else {
-		return errors.New("unsupported page type")
+	index, found, err := page.Search(key)
+	if err != nil || !found {
+		return false, err
+	}
+
+	if err := freeOverflow(b.pager, page, index); err != nil {
+		return false, err
 	}
+	if err := page.RemoveCell(index); err != nil {
+		return false, err
+	}
+	if err := b.pager.Write(page); err != nil {
+		return false, err
+	}
+
+	return true, b.rebalance(path, page)
 }
 
-func splitPage(pager Pager, p *MemPage) (*MemPage, *MemPage, *MemPage, error) {
-	// New page for the left node
-	// 创建一个 左 叶节点
-	leftPage, err := pager.Allocate(PageTypeLeaf)
+// MaxRowID returns the largest rowid in the table, found in the right most leaf.
+// An empty table has a max rowid of 0.
+func (b *BTreeTable) MaxRowID() (int64, error) {
+	page, err := b.pager.Read(b.rootPage)
 	if err != nil {
-		return nil, nil, nil, err
+		return 0, err
+	}
+
+	for page.header.Type == PageTypeInternal {
+		page, err = b.pager.Read(page.header.RightPage)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if page.CellCount() == 0 {
+		return 0, nil
 	}
+	return page.CellKey(page.CellCount() - 1)
+}
 
-	// New page for the right node
-	// 创建一个 右 叶节点
-	rightPage, err := pager.Allocate(PageTypeLeaf)
+// pathFrame is an interior page visited while descending the btree
+// and the index of the child that was followed.
+type pathFrame struct {
+	page  int
+	index int
+}
+
+// insertCell places a cell at cellIndex of the page, splitting the page when
+// the cell doesn't fit. Splitting adds a divider to the parent, the last page
+// of the path, which may in turn split all the way up to the root.
+func (b *BTreeTable) insertCell(path []pathFrame, page *MemPage, cellIndex int, cell []byte) error {
+	if page.Fits(len(cell)) {
+		page.InsertCell(cellIndex, cell)
+		return b.pager.Write(page)
+	}
+
+	cells, err := page.Cells()
 	if err != nil {
-		return nil, nil, nil, err
+		return err
 	}
+	oldCells := cells
+	cells = append(append(append([][]byte{}, cells[:cellIndex]...), cell), cells[cellIndex:]...)
 
-	// Max key
-	//
-	maxRowID, err := maxRowID(p)
+	appending, err := appendingCell(b.pager, path, page, cellIndex)
 	if err != nil {
-		return nil, nil, nil, err
+		return err
 	}
 
-	// Copy the page to the left
-	// 把当前页 p 的数据拷贝到 左 叶节点
-	p.CopyTo(leftPage)
+	// The root page can't move because it's referenced by the schema.
+	// Move its content to a new child and make the root an interior page
+	// with a single pointer to the child, then split the child.
+	if len(path) == 0 {
+		child, err := b.pager.Allocate(page.header.Type)
+		if err != nil {
+			return err
+		}
+		child.rebuild(page.header.Type, page.header.RightPage, nil)
+		page.rebuild(PageTypeInternal, child.Number(), nil)
+
+		path = []pathFrame{{page: page.Number(), index: 0}}
+		page = child
+	}
+
+	leftCells, divider, rightCells, leftRightPage, err := splitCells(page.header.Type, len(page.data), cells, appending)
+	retry := false
+	if errors.Is(err, errNoSplit) {
+		// A large cell between large cells, split the page where the cell belongs instead
+		// then the cell is at the edge of a page when inserted again.
+		retry = true
+		leftCells, rightCells = oldCells[:cellIndex], oldCells[cellIndex:]
+		_, rowID, err := storage.ReadRecordHeader(bytes.NewReader(leftCells[len(leftCells)-1]))
+		if err != nil {
+			return err
+		}
+		divider = int64(rowID)
+	} else if err != nil {
+		return err
+	}
 
-	// Update the header to make the page an interior node
-	// 把当前页 p 设置为内部节点，作为左、右页节点的父节点
-	newHeader := NewPageHeader(PageTypeInternal, len(p.data))
-	newHeader.RightPage = rightPage.Number()
-	p.SetHeader(newHeader)
+	// The page keeps the upper half so the pointer in the parent stays valid.
+	left, err := b.pager.Allocate(page.header.Type)
+	if err != nil {
+		return err
+	}
+	left.rebuild(page.header.Type, leftRightPage, leftCells)
+	page.rebuild(page.header.Type, page.header.RightPage, rightCells)
+	if err := b.pager.Write(left, page); err != nil {
+		return err
+	}
 
-	// Add a cell to the new internal page
-	// 创建一个 Cell 添加到 p 上
-	cell := storage.InteriorNode{
-		LeftChild: uint32(leftPage.Number()),	// 左子页节点
-		Key:       maxRowID,					// 最大 RowId
+	// Point the parent at the new page, keyed by its largest key.
+	dividerCell, err := storage.InteriorNode{
+		LeftChild: uint32(left.Number()),
+		Key:       divider,
+	}.ToBytes()
+	if err != nil {
+		return err
 	}
-	cellBytes, err := cell.ToBytes()
+
+	parentFrame := path[len(path)-1]
+	parent, err := b.pager.Read(parentFrame.page)
 	if err != nil {
-		return nil, nil, nil, err
+		return err
 	}
-	p.AddCell(cellBytes)
 
-	// 返回
-	return p, leftPage, rightPage, nil
+	if err := b.insertCell(path[:len(path)-1], parent, parentFrame.index, dividerCell); err != nil {
+		return err
+	}
+	if retry {
+		return errSplitRetry
+	}
+	return nil
 }
 
-// 获取 Page *p 内最大 RowID
-func maxRowID(p *MemPage) (uint32, error) {
-	// 构造迭代器
-	recordIter := newRecordIter(p)
-	maxRowID := uint32(0)
+// replaceCell overwrites the cell at cellIndex of a leaf page. A cell of the same size
+// is replaced in place, otherwise the old cell is removed and the new one inserted,
+// splitting the page if it no longer fits.
+func (b *BTreeTable) replaceCell(path []pathFrame, page *MemPage, cellIndex int, cell []byte) error {
+	if err := freeOverflow(b.pager, page, cellIndex); err != nil {
+		return err
+	}
+
+	if page.ReplaceCell(cellIndex, cell) {
+		return b.pager.Write(page)
+	}
 
-	// 不断读取 Page *p 内的 records
-	for recordIter.Next() {
-		// 错误检查
-		if recordIter.Error() != nil {
-			return 0, recordIter.Error()
+	if err := page.RemoveCell(cellIndex); err != nil {
+		return err
+	}
+
+	return b.insertCell(path, page, cellIndex, cell)
+}
+
+// errNoSplit indicates the cells can't be divided into two pages.
+var errNoSplit = errors.New("cells don't fit in two pages")
+
+// splitCells divides the cells of an overflowing page into a lower and an upper half.
+// The divider is the largest key of the lower half. For interior pages, the middle cell
+// is removed, its key becomes the divider and its child the right page of the lower half.
+// A leaf being appended to keeps all of its cells in the lower half and only the appended
+// cell moves to the upper half, leaving full pages behind when keys are inserted in order.
+func splitCells(pageType PageType, pageSize int, cells [][]byte, appending bool) ([][]byte, int64, [][]byte, int, error) {
+	switch pageType {
+	case PageTypeLeaf:
+		middle := splitPoint(cells)
+		if appending {
+			middle = len(cells) - 1
+		}
+
+		// Large cells may not fit on either side of the middle
+		middle = fittingSplitPoint(pageType, pageSize, cells, middle)
+		if middle < 0 {
+			return nil, 0, nil, 0, errNoSplit
+		}
+		_, rowID, err := storage.ReadRecordHeader(bytes.NewReader(cells[middle-1]))
+		if err != nil {
+			return nil, 0, nil, 0, err
 		}
-		// 取当前 record
-		record := recordIter.Current()
-		// 更新 maxRowID
-		if record.RowID > maxRowID {
-			maxRowID = record.RowID
+
+		return cells[:middle], int64(rowID), cells[middle:], 0, nil
+	case PageTypeInternal:
+		middle := splitPoint(cells)
+		node, err := storage.ReadInteriorNode(cells[middle])
+		if err != nil {
+			return nil, 0, nil, 0, err
 		}
+
+		return cells[:middle], node.Key, cells[middle+1:], int(node.LeftChild), nil
+	default:
+		return nil, 0, nil, 0, errors.New("unsupported page type")
+	}
+}
+
+// splitPoint finds the index that divides the cells into two halves of roughly
+// equal size in bytes. Both halves contain at least one cell.
+func splitPoint(cells [][]byte) int {
+	total := 0
+	for _, c := range cells {
+		total += len(c) + 2
 	}
 
-	// 返回 Page *p 内最大 RowID
-	return maxRowID, nil
+	used := 0
+	for i, c := range cells {
+		used += len(c) + 2
+		if used >= total/2 {
+			if i+1 >= len(cells) {
+				return len(cells) - 1
+			}
+			return i + 1
+		}
+	}
+	return len(cells) / 2
 }
 
+// appendingCell determines if a cell inserted at cellIndex of the page is appended to the
+// end of the btree, the page is a leaf and the path follows the right most child of every
+// interior page.
+func appendingCell(p Pager, path []pathFrame, page *MemPage, cellIndex int) (bool, error) {
+	if !page.IsLeaf() || cellIndex < page.CellCount() {
+		return false, nil
+	}
 
-// 用于遍历 Page 下的所有 Records
-type recordIterator struct {
-	idx      int
-	record *storage.Record
-	p      *MemPage
-	err    error
+	for _, frame := range path {
+		parent, err := p.Read(frame.page)
+		if err != nil {
+			return false, err
+		}
+		if frame.index < parent.CellCount() {
+			return false, nil
+		}
+	}
+
+	return true, nil
 }
 
-func newRecordIter(p *MemPage) *recordIterator {
-	return &recordIterator{
-		p: p,
-		idx: 0,
+// fittingSplitPoint finds the split point nearest to middle where the cells on both
+// sides fit in a page, returns -1 if there is no such split point.
+func fittingSplitPoint(pageType PageType, pageSize int, cells [][]byte, middle int) int {
+	for d := 0; d < len(cells); d++ {
+		for _, i := range []int{middle - d, middle + d} {
+			if i < 1 || i > len(cells)-1 {
+				continue
+			}
+			if cellsFit(pageType, 0, pageSize, cells[:i]) && cellsFit(pageType, 0, pageSize, cells[i:]) {
+				return i
+			}
+		}
 	}
+	return -1
 }
 
-func (i *recordIterator) Next() bool {
-	if i.idx < i.p.CellCount() {
-		// 读取第 i.idx 个 record ，保存 record 和 err 。
-		i.record, i.err = i.p.ReadRecord(i.idx)
-		// 递增游标
-		i.idx++
-		return true
+// tableLeafCell serializes a record to a cell of a table leaf page.
+// [Size, Key, Payload]
+func tableLeafCell(p Pager, usableSize int, r *storage.Record) ([]byte, error) {
+	payload, err := storage.EncodeFields(r.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := bytes.Buffer{}
+	if _, err := storage.WriteVarint(&prefix, uint64(len(payload))); err != nil {
+		return nil, err
+	}
+	if _, err := storage.WriteVarint(&prefix, uint64(r.RowID)); err != nil {
+		return nil, err
 	}
-	return false
+
+	return payloadCell(p, PageTypeLeaf, usableSize, prefix.Bytes(), payload)
 }
 
-// Error 获取当前 err
-func (i *recordIterator) Error() error {
-	return i.err
+// rebalance restores the balance of the tree after cells were removed from the page.
+// An underfull page is merged with a sibling when their cells fit in a single page,
+// otherwise the cells are redistributed evenly between the two. Merging removes a
+// divider from the parent which may in turn need to be rebalanced.
+func (b *BTreeTable) rebalance(path []pathFrame, page *MemPage) error {
+	if len(path) == 0 {
+		return collapseRoot(b.pager, page)
+	}
+
+	if !page.underfull() {
+		return nil
+	}
+
+	parentFrame := path[len(path)-1]
+	parent, err := b.pager.Read(parentFrame.page)
+	if err != nil {
+		return err
+	}
+
+	// The pair of siblings is the page and its left neighbour,
+	// or its right neighbour when the page is the first child.
+	leftIndex := parentFrame.index - 1
+	if parentFrame.index == 0 {
+		leftIndex = 0
+	}
+	// The page is an only child, it can only be merged once the parent is.
+	if leftIndex+1 > parent.CellCount() {
+		return b.rebalance(path[:len(path)-1], parent)
+	}
+
+	leftNumber, err := parent.ChildPage(leftIndex)
+	if err != nil {
+		return err
+	}
+	rightNumber, err := parent.ChildPage(leftIndex + 1)
+	if err != nil {
+		return err
+	}
+	left, err := b.pager.Read(leftNumber)
+	if err != nil {
+		return err
+	}
+	right, err := b.pager.Read(rightNumber)
+	if err != nil {
+		return err
+	}
+
+	// The siblings and the parent are held while freeing pages reads the freelist
+	for _, pg := range []*MemPage{parent, left, right} {
+		pg.Pin()
+		defer pg.Unpin()
+	}
+
+	leftCells, err := left.Cells()
+	if err != nil {
+		return err
+	}
+	rightCells, err := right.Cells()
+	if err != nil {
+		return err
+	}
+
+	pageType := page.header.Type
+	cells := append(leftCells, rightCells...)
+
+	// The divider moves down between the children of interior pages.
+	if pageType == PageTypeInternal {
+		dividerKey, err := parent.CellKey(leftIndex)
+		if err != nil {
+			return err
+		}
+		divider, err := storage.InteriorNode{
+			LeftChild: uint32(left.header.RightPage),
+			Key:       dividerKey,
+		}.ToBytes()
+		if err != nil {
+			return err
+		}
+		cells = append(leftCells, append([][]byte{divider}, rightCells...)...)
+	}
+
+	// Merge into the right page, it's already referenced by the following divider
+	// or the right page pointer of the parent.
+	if cellsFit(pageType, right.Number(), len(right.data), cells) {
+		right.rebuild(pageType, right.header.RightPage, cells)
+		if err := parent.RemoveCell(leftIndex); err != nil {
+			return err
+		}
+		if err := b.pager.Write(right, parent); err != nil {
+			return err
+		}
+		if err := b.pager.Free(left.Number()); err != nil {
+			return err
+		}
+
+		return b.rebalance(path[:len(path)-1], parent)
+	}
+
+	leftCells, dividerKey, rightCells, leftRightPage, err := splitCells(pageType, len(right.data), cells, false)
+	if err != nil {
+		return err
+	}
+	left.rebuild(pageType, leftRightPage, leftCells)
+	right.rebuild(pageType, right.header.RightPage, rightCells)
+	if err := b.pager.Write(left, right); err != nil {
+		return err
+	}
+
+	divider, err := storage.InteriorNode{
+		LeftChild: uint32(left.Number()),
+		Key:       dividerKey,
+	}.ToBytes()
+	if err != nil {
+		return err
+	}
+	if err := parent.RemoveCell(leftIndex); err != nil {
+		return err
+	}
+
+	return b.insertCell(path[:len(path)-1], parent, leftIndex, divider)
 }
 
-// Current 获取当前 record
-func (i *recordIterator) Current() *storage.Record {
-	return i.record
+// collapseRoot moves the content of the only child of an interior root page into the
+// root, reducing the depth of the tree, and frees the child. The child's content may not fit in the root
+// when the root is page 1 which holds the file header, the root is left as is then.
+func collapseRoot(p Pager, root *MemPage) error {
+	if root.IsLeaf() || root.CellCount() > 0 {
+		return nil
+	}
+
+	child, err := p.Read(root.header.RightPage)
+	if err != nil {
+		return err
+	}
+	cells, err := child.Cells()
+	if err != nil {
+		return err
+	}
+
+	if !cellsFit(child.header.Type, root.Number(), len(root.data), cells) {
+		return nil
+	}
+
+	root.rebuild(child.header.Type, child.header.RightPage, cells)
+	if err := p.Write(root); err != nil {
+		return err
+	}
+	if err := p.Free(child.Number()); err != nil {
+		return err
+	}
+
+	return collapseRoot(p, root)
 }
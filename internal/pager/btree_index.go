@@ -0,0 +1,397 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+)
+
+// BTreeIndex is a btree of index entries. An entry is a record of the indexed
+// columns followed by the rowid of the table record, entries are ordered by
+// their fields. Unlike a table btree, the cells of interior pages are entries
+// themselves which sort between the entries of the neighbouring children.
+type BTreeIndex struct {
+	rootPage int
+	pager    Pager
+}
+
+func NewBTreeIndex(rootPage int, p Pager) *BTreeIndex {
+	return &BTreeIndex{
+		rootPage: rootPage,
+		pager:    p,
+	}
+}
+
+// Insert adds an entry to the index. Inserting an existing entry has no effect.
+func (b *BTreeIndex) Insert(entry []*storage.Field) error {
+	page, err := b.pager.Read(b.rootPage)
+	if err != nil {
+		return err
+	}
+
+	// Descend to the leaf where the entry belongs remembering the path taken.
+	var path []pathFrame
+	for {
+		index, found, err := page.SearchEntry(entry, false)
+		if err != nil || found {
+			return err
+		}
+
+		if page.IsLeaf() {
+			cell, err := indexLeafCell(b.pager, len(page.data), entry)
+			if err != nil {
+				return err
+			}
+			return b.insertCell(path, page, index, cell)
+		}
+
+		path = append(path, pathFrame{page: page.Number(), index: index})
+		child, err := page.ChildPage(index)
+		if err != nil {
+			return err
+		}
+		page, err = b.pager.Read(child)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Delete removes an entry from the index,
+// returns false if there is no such entry.
+func (b *BTreeIndex) Delete(entry []*storage.Field) (bool, error) {
+	path, page, index, found, err := b.find(entry)
+	if err != nil || !found {
+		return false, err
+	}
+
+	if err := freeOverflow(b.pager, page, index); err != nil {
+		return false, err
+	}
+	if page.IsLeaf() {
+		return true, b.removeLeafCell(path, page, index)
+	}
+
+	// The entry is replaced by the largest entry of its left child,
+	// which is found in a leaf and removed from there. The overflow
+	// pages of the leaf cell move along with it.
+	leftChild, err := page.ChildPage(index)
+	if err != nil {
+		return false, err
+	}
+	leaf, err := b.pager.Read(leftChild)
+	if err != nil {
+		return false, err
+	}
+	for !leaf.IsLeaf() {
+		leaf, err = b.pager.Read(leaf.header.RightPage)
+		if err != nil {
+			return false, err
+		}
+	}
+	predecessor, err := leaf.CellBytes(leaf.CellCount() - 1)
+	if err != nil {
+		return false, err
+	}
+	predecessorEntry, err := leaf.CellEntry(leaf.CellCount() - 1)
+	if err != nil {
+		return false, err
+	}
+
+	if err := page.RemoveCell(index); err != nil {
+		return false, err
+	}
+	if err := b.insertCell(path, page, index, indexInteriorCell(leftChild, predecessor)); err != nil {
+		return false, err
+	}
+
+	// Inserting may have split pages, find the path to the leaf again.
+	// It's the right most leaf below the copy of the predecessor in the interior page.
+	path, page, index, found, err = b.find(predecessorEntry)
+	if err != nil {
+		return false, err
+	}
+	if !found || page.IsLeaf() {
+		return false, errors.New("index entry moved unexpectedly")
+	}
+	path = append(path, pathFrame{page: page.Number(), index: index})
+	child, err := page.ChildPage(index)
+	if err != nil {
+		return false, err
+	}
+	for {
+		page, err = b.pager.Read(child)
+		if err != nil {
+			return false, err
+		}
+		if page.IsLeaf() {
+			break
+		}
+		path = append(path, pathFrame{page: page.Number(), index: page.CellCount()})
+		child = page.header.RightPage
+	}
+
+	return true, b.removeLeafCell(path, page, page.CellCount()-1)
+}
+
+// find descends to the page containing the entry, returns the path to
+// the page and the index of the entry within the page.
+func (b *BTreeIndex) find(entry []*storage.Field) ([]pathFrame, *MemPage, int, bool, error) {
+	page, err := b.pager.Read(b.rootPage)
+	if err != nil {
+		return nil, nil, 0, false, err
+	}
+
+	var path []pathFrame
+	for {
+		index, found, err := page.SearchEntry(entry, false)
+		if err != nil {
+			return nil, nil, 0, false, err
+		}
+		if found || page.IsLeaf() {
+			return path, page, index, found, nil
+		}
+
+		path = append(path, pathFrame{page: page.Number(), index: index})
+		child, err := page.ChildPage(index)
+		if err != nil {
+			return nil, nil, 0, false, err
+		}
+		page, err = b.pager.Read(child)
+		if err != nil {
+			return nil, nil, 0, false, err
+		}
+	}
+}
+
+// removeLeafCell removes a cell from a leaf page and rebalances the tree.
+func (b *BTreeIndex) removeLeafCell(path []pathFrame, page *MemPage, cellIndex int) error {
+	if err := page.RemoveCell(cellIndex); err != nil {
+		return err
+	}
+	if err := b.pager.Write(page); err != nil {
+		return err
+	}
+
+	return b.rebalance(path, page)
+}
+
+// insertCell places a cell at cellIndex of the page, splitting the page when the
+// cell doesn't fit. The middle entry of a split page moves up to the parent, the
+// last page of the path, which may in turn split all the way up to the root.
+func (b *BTreeIndex) insertCell(path []pathFrame, page *MemPage, cellIndex int, cell []byte) error {
+	if page.Fits(len(cell)) {
+		page.InsertCell(cellIndex, cell)
+		return b.pager.Write(page)
+	}
+
+	cells, err := page.Cells()
+	if err != nil {
+		return err
+	}
+	appending, err := appendingCell(b.pager, path, page, cellIndex)
+	if err != nil {
+		return err
+	}
+	cells = append(cells[:cellIndex], append([][]byte{cell}, cells[cellIndex:]...)...)
+
+	// The root page can't move because it's referenced by the schema.
+	// Move its content to a new child and make the root an interior page
+	// with a single pointer to the child, then split the child.
+	if len(path) == 0 {
+		child, err := b.pager.Allocate(page.header.Type)
+		if err != nil {
+			return err
+		}
+		child.rebuild(page.header.Type, page.header.RightPage, nil)
+		page.rebuild(PageTypeInternalIndex, child.Number(), nil)
+
+		path = []pathFrame{{page: page.Number(), index: 0}}
+		page = child
+	}
+
+	leftCells, middle, rightCells := splitIndexCells(cells, appending)
+	pageType := page.header.Type
+
+	// The page keeps the upper half so the pointer in the parent stays valid.
+	left, err := b.pager.Allocate(pageType)
+	if err != nil {
+		return err
+	}
+	middleEntry := middle
+	if pageType == PageTypeInternalIndex {
+		left.rebuild(pageType, int(binary.BigEndian.Uint32(middle)), leftCells)
+		middleEntry = middle[4:]
+	} else {
+		left.rebuild(pageType, 0, leftCells)
+	}
+	page.rebuild(pageType, page.header.RightPage, rightCells)
+	if err := b.pager.Write(left, page); err != nil {
+		return err
+	}
+
+	parentFrame := path[len(path)-1]
+	parent, err := b.pager.Read(parentFrame.page)
+	if err != nil {
+		return err
+	}
+
+	return b.insertCell(path[:len(path)-1], parent, parentFrame.index, indexInteriorCell(left.Number(), middleEntry))
+}
+
+// rebalance restores the balance of the tree after cells were removed from the page.
+// An underfull page is merged with a sibling and the divider between them when they
+// fit in a single page, otherwise the entries are redistributed evenly between the two
+// with a new divider. Merging removes a divider from the parent which may in turn need
+// to be rebalanced.
+func (b *BTreeIndex) rebalance(path []pathFrame, page *MemPage) error {
+	if len(path) == 0 {
+		return collapseRoot(b.pager, page)
+	}
+
+	if !page.underfull() {
+		return nil
+	}
+
+	parentFrame := path[len(path)-1]
+	parent, err := b.pager.Read(parentFrame.page)
+	if err != nil {
+		return err
+	}
+
+	// The pair of siblings is the page and its left neighbour,
+	// or its right neighbour when the page is the first child.
+	leftIndex := parentFrame.index - 1
+	if parentFrame.index == 0 {
+		leftIndex = 0
+	}
+
+	// The page is an only child, it can only be merged once the parent is.
+	if leftIndex+1 > parent.CellCount() {
+		return b.rebalance(path[:len(path)-1], parent)
+	}
+
+	leftNumber, err := parent.ChildPage(leftIndex)
+	if err != nil {
+		return err
+	}
+	rightNumber, err := parent.ChildPage(leftIndex + 1)
+	if err != nil {
+		return err
+	}
+	left, err := b.pager.Read(leftNumber)
+	if err != nil {
+		return err
+	}
+	right, err := b.pager.Read(rightNumber)
+	if err != nil {
+		return err
+	}
+
+	// The siblings and the parent are held while freeing pages reads the freelist
+	for _, pg := range []*MemPage{parent, left, right} {
+		pg.Pin()
+		defer pg.Unpin()
+	}
+
+	leftCells, err := left.Cells()
+	if err != nil {
+		return err
+	}
+	rightCells, err := right.Cells()
+	if err != nil {
+		return err
+	}
+	dividerCell, err := parent.CellBytes(leftIndex)
+	if err != nil {
+		return err
+	}
+
+	// The divider moves down between the entries of the siblings
+	pageType := page.header.Type
+	divider := dividerCell[4:]
+	if pageType == PageTypeInternalIndex {
+		divider = indexInteriorCell(left.header.RightPage, divider)
+	}
+	cells := append(leftCells, append([][]byte{divider}, rightCells...)...)
+
+	// Merge into the right page, it's already referenced by the following divider
+	// or the right page pointer of the parent.
+	if cellsFit(pageType, right.Number(), len(right.data), cells) {
+		right.rebuild(pageType, right.header.RightPage, cells)
+		if err := parent.RemoveCell(leftIndex); err != nil {
+			return err
+		}
+		if err := b.pager.Write(right, parent); err != nil {
+			return err
+		}
+		if err := b.pager.Free(left.Number()); err != nil {
+			return err
+		}
+
+		return b.rebalance(path[:len(path)-1], parent)
+	}
+
+	leftCells, middle, rightCells := splitIndexCells(cells, false)
+	if pageType == PageTypeInternalIndex {
+		left.rebuild(pageType, int(binary.BigEndian.Uint32(middle)), leftCells)
+		middle = middle[4:]
+	} else {
+		left.rebuild(pageType, 0, leftCells)
+	}
+	right.rebuild(pageType, right.header.RightPage, rightCells)
+	if err := b.pager.Write(left, right); err != nil {
+		return err
+	}
+
+	if err := parent.RemoveCell(leftIndex); err != nil {
+		return err
+	}
+
+	return b.insertCell(path[:len(path)-1], parent, leftIndex, indexInteriorCell(left.Number(), middle))
+}
+
+// splitIndexCells divides the cells of an overflowing index page into a lower half,
+// the middle cell and an upper half. Both halves contain at least one cell. A leaf
+// being appended to keeps as many cells as possible in the lower half.
+func splitIndexCells(cells [][]byte, appending bool) ([][]byte, []byte, [][]byte) {
+	middle := splitPoint(cells)
+	if appending {
+		middle = len(cells) - 2
+	}
+	if middle > len(cells)-2 {
+		middle = len(cells) - 2
+	}
+	if middle < 1 {
+		middle = 1
+	}
+
+	return cells[:middle], cells[middle], cells[middle+1:]
+}
+
+// indexLeafCell serializes an entry to a cell of a leaf index page.
+// [Size, Payload]
+func indexLeafCell(p Pager, usableSize int, entry []*storage.Field) ([]byte, error) {
+	payload, err := storage.EncodeFields(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := bytes.Buffer{}
+	if _, err := storage.WriteVarint(&prefix, uint64(len(payload))); err != nil {
+		return nil, err
+	}
+
+	return payloadCell(p, PageTypeLeafIndex, usableSize, prefix.Bytes(), payload)
+}
+
+// indexInteriorCell makes a cell of an interior index page from the cell of a leaf.
+// [Left Child, Size, Payload]
+func indexInteriorCell(leftChild int, leafCell []byte) []byte {
+	cell := make([]byte, 4+len(leafCell))
+	binary.BigEndian.PutUint32(cell, uint32(leftChild))
+	copy(cell[4:], leafCell)
+	return cell
+}
@@ -0,0 +1,135 @@
+package pager
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func indexEntry(i int) []*storage.Field {
+	return []*storage.Field{
+		{Type: storage.Text, Data: fmt.Sprintf("name %030d", i/2)},
+		{Type: storage.Integer, Data: i},
+	}
+}
+
+func TestBTreeIndex(t *testing.T) {
+	assert := require.New(t)
+
+	file := storage.NewMemoryFile(512)
+	assert.NoError(Initialize(file))
+	p := NewPager(file)
+
+	root, err := p.Allocate(PageTypeLeafIndex)
+	assert.NoError(err)
+	assert.NoError(p.Write(root))
+
+	const total = 2000
+	tree := NewBTreeIndex(root.Number(), p)
+	for _, i := range rand.New(rand.NewSource(1)).Perm(total) {
+		assert.NoError(tree.Insert(indexEntry(i)))
+	}
+
+	cursor, err := NewCursor(p, CURSOR_WRITE, root.Number(), "test")
+	assert.NoError(err)
+	assert.True(cursor.IsIndex())
+
+	// Entries are visited in order in both directions
+	assertEntries := func(expected []int) {
+		var forward []int
+		hasMore, err := cursor.Rewind()
+		assert.NoError(err)
+		for hasMore {
+			rowID, err := cursor.RowID()
+			assert.NoError(err)
+			forward = append(forward, int(rowID))
+			hasMore, err = cursor.Next()
+			assert.NoError(err)
+		}
+		assert.Equal(expected, forward)
+
+		var backward []int
+		hasMore, err = cursor.Last()
+		assert.NoError(err)
+		for hasMore {
+			rowID, err := cursor.RowID()
+			assert.NoError(err)
+			backward = append([]int{int(rowID)}, backward...)
+			hasMore, err = cursor.Prev()
+			assert.NoError(err)
+		}
+		assert.Equal(expected, backward)
+	}
+
+	var expected []int
+	for i := 0; i < total; i++ {
+		expected = append(expected, i)
+	}
+	assertEntries(expected)
+
+	// Seek by the first field only
+	key := []*storage.Field{{Type: storage.Text, Data: fmt.Sprintf("name %030d", 100)}}
+	found, err := cursor.SeekIndexGE(key)
+	assert.NoError(err)
+	assert.True(found)
+	rowID, err := cursor.RowID()
+	assert.NoError(err)
+	assert.Equal(int64(200), rowID)
+
+	found, err = cursor.SeekIndexGT(key)
+	assert.NoError(err)
+	assert.True(found)
+	rowID, err = cursor.RowID()
+	assert.NoError(err)
+	assert.Equal(int64(202), rowID)
+
+	found, err = cursor.SeekIndexLE(key)
+	assert.NoError(err)
+	assert.True(found)
+	rowID, err = cursor.RowID()
+	assert.NoError(err)
+	assert.Equal(int64(201), rowID)
+
+	found, err = cursor.SeekIndexLT(key)
+	assert.NoError(err)
+	assert.True(found)
+	rowID, err = cursor.RowID()
+	assert.NoError(err)
+	assert.Equal(int64(199), rowID)
+
+	// Delete all but multiples of 3
+	for _, i := range rand.New(rand.NewSource(2)).Perm(total) {
+		if i%3 == 0 {
+			continue
+		}
+		deleted, err := tree.Delete(indexEntry(i))
+		assert.NoError(err)
+		assert.True(deleted, i)
+	}
+	deleted, err := tree.Delete(indexEntry(1))
+	assert.NoError(err)
+	assert.False(deleted)
+
+	expected = expected[:0]
+	for i := 0; i < total; i += 3 {
+		expected = append(expected, i)
+	}
+	assertEntries(expected)
+
+	// Deleting with the cursor continues with the following entry
+	hasMore, err := cursor.Rewind()
+	assert.NoError(err)
+	for hasMore {
+		entry, err := cursor.Entry()
+		assert.NoError(err)
+		deleted, err := cursor.DeleteEntry(entry)
+		assert.NoError(err)
+		assert.True(deleted)
+		hasMore, err = cursor.Next()
+		assert.NoError(err)
+	}
+	assertEntries(nil)
+}
@@ -0,0 +1,239 @@
+package pager
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBTreeTable_Insert_MultiLevel(t *testing.T) {
+	assert := require.New(t)
+
+	file := storage.NewMemoryFile(512)
+	assert.NoError(Initialize(file))
+	p := NewPager(file)
+
+	const total = 2000
+	tree := NewBTreeTable(1, p)
+	for i := 1; i <= total; i++ {
+		record := storage.NewRecord(int64(i), []*storage.Field{
+			{Type: storage.Text, Data: fmt.Sprintf("record number %050d", i)},
+		})
+		assert.NoError(tree.Insert(record))
+	}
+
+	// The tree should have grown beyond a single interior level.
+	root, err := p.Read(1)
+	assert.NoError(err)
+	assert.Equal(PageTypeInternal, root.header.Type)
+	child, err := p.Read(root.header.RightPage)
+	assert.NoError(err)
+	assert.Equal(PageTypeInternal, child.header.Type)
+
+	cursor, err := NewCursor(p, CURSOR_READ, 1, "test")
+	assert.NoError(err)
+
+	count := 0
+	hasMore, err := cursor.Rewind()
+	assert.NoError(err)
+	for hasMore {
+		count++
+		record, err := cursor.CurrentCell()
+		assert.NoError(err)
+		assert.Equal(int64(count), record.RowID)
+		assert.Equal(fmt.Sprintf("record number %050d", count), record.Fields[0].Data)
+
+		hasMore, err = cursor.Next()
+		assert.NoError(err)
+	}
+	assert.Equal(total, count)
+}
+
+func TestBTreeTable_Insert_OutOfOrder(t *testing.T) {
+	assert := require.New(t)
+
+	file := storage.NewMemoryFile(512)
+	assert.NoError(Initialize(file))
+	p := NewPager(file)
+
+	const total = 1000
+	tree := NewBTreeTable(1, p)
+	for _, i := range rand.New(rand.NewSource(1)).Perm(total) {
+		record := storage.NewRecord(int64(i+1), []*storage.Field{
+			{Type: storage.Text, Data: fmt.Sprintf("record number %020d", i+1)},
+		})
+		assert.NoError(tree.Insert(record))
+	}
+
+	// Inserting an existing key replaces the record
+	assert.NoError(tree.Insert(storage.NewRecord(500, []*storage.Field{
+		{Type: storage.Text, Data: "replaced"},
+	})))
+
+	cursor, err := NewCursor(p, CURSOR_READ, 1, "test")
+	assert.NoError(err)
+
+	count := 0
+	hasMore, err := cursor.Rewind()
+	assert.NoError(err)
+	for hasMore {
+		count++
+		record, err := cursor.CurrentCell()
+		assert.NoError(err)
+		assert.Equal(int64(count), record.RowID)
+		if count == 500 {
+			assert.Equal("replaced", record.Fields[0].Data)
+		}
+
+		hasMore, err = cursor.Next()
+		assert.NoError(err)
+	}
+	assert.Equal(total, count)
+}
+
+func TestBTreeTable_Delete(t *testing.T) {
+	assert := require.New(t)
+
+	file := storage.NewMemoryFile(512)
+	assert.NoError(Initialize(file))
+	p := NewPager(file)
+
+	const total = 2000
+	tree := NewBTreeTable(1, p)
+	for i := 1; i <= total; i++ {
+		record := storage.NewRecord(int64(i), []*storage.Field{
+			{Type: storage.Text, Data: fmt.Sprintf("record number %030d", i)},
+		})
+		assert.NoError(tree.Insert(record))
+	}
+
+	// Delete every record but multiples of 7 in random order
+	for _, i := range rand.New(rand.NewSource(1)).Perm(total) {
+		key := int64(i + 1)
+		deleted, err := tree.Delete(key)
+		assert.NoError(err)
+		assert.True(deleted)
+		if key%7 == 0 {
+			assert.NoError(tree.Insert(storage.NewRecord(key, []*storage.Field{
+				{Type: storage.Text, Data: fmt.Sprintf("record number %030d", key)},
+			})))
+		}
+	}
+
+	deleted, err := tree.Delete(total + 1)
+	assert.NoError(err)
+	assert.False(deleted)
+
+	cursor, err := NewCursor(p, CURSOR_WRITE, 1, "test")
+	assert.NoError(err)
+
+	var keys []int64
+	hasMore, err := cursor.Rewind()
+	assert.NoError(err)
+	for hasMore {
+		key, err := cursor.RowID()
+		assert.NoError(err)
+		keys = append(keys, key)
+		assert.Equal(int64(0), key%7)
+
+		hasMore, err = cursor.Next()
+		assert.NoError(err)
+	}
+	assert.Len(keys, total/7)
+
+	// Deleting with the cursor continues with the following record
+	hasMore, err = cursor.Rewind()
+	assert.NoError(err)
+	count := 0
+	for hasMore {
+		count++
+		assert.NoError(cursor.Delete())
+		hasMore, err = cursor.Next()
+		assert.NoError(err)
+	}
+	assert.Equal(total/7, count)
+
+	// The tree collapses back into the root
+	root, err := p.Read(1)
+	assert.NoError(err)
+	assert.Equal(PageTypeLeaf, root.header.Type)
+	assert.Equal(0, root.CellCount())
+}
+
+func TestCursor_Seek(t *testing.T) {
+	assert := require.New(t)
+
+	file := storage.NewMemoryFile(512)
+	assert.NoError(Initialize(file))
+	p := NewPager(file)
+
+	// Even keys from 2 to 2000
+	const total = 1000
+	tree := NewBTreeTable(1, p)
+	for i := 1; i <= total; i++ {
+		record := storage.NewRecord(int64(2*i), []*storage.Field{
+			{Type: storage.Text, Data: fmt.Sprintf("record number %030d", 2*i)},
+		})
+		assert.NoError(tree.Insert(record))
+	}
+
+	cursor, err := NewCursor(p, CURSOR_READ, 1, "test")
+	assert.NoError(err)
+
+	assertKey := func(expected int64, found bool, err error) {
+		assert.NoError(err)
+		assert.True(found)
+		key, err := cursor.RowID()
+		assert.NoError(err)
+		assert.Equal(expected, key)
+	}
+
+	found, err := cursor.SeekRowID(500)
+	assertKey(500, found, err)
+	found, err = cursor.SeekRowID(501)
+	assert.NoError(err)
+	assert.False(found)
+
+	for key := int64(1); key < 2*total; key++ {
+		next := key + 2 - key%2
+		prev := key - 2 + key%2
+
+		found, err = cursor.SeekGE(key)
+		assertKey(key+key%2, found, err)
+		found, err = cursor.SeekGT(key)
+		assertKey(next, found, err)
+		if key >= 2 {
+			found, err = cursor.SeekLE(key)
+			assertKey(key-key%2, found, err)
+		}
+		if key >= 3 {
+			found, err = cursor.SeekLT(key)
+			assertKey(prev, found, err)
+		}
+	}
+
+	found, err = cursor.SeekGT(2 * total)
+	assert.NoError(err)
+	assert.False(found)
+	found, err = cursor.SeekLT(2)
+	assert.NoError(err)
+	assert.False(found)
+
+	// Reverse iteration visits every record
+	count := 0
+	hasMore, err := cursor.Last()
+	assert.NoError(err)
+	for hasMore {
+		key, err := cursor.RowID()
+		assert.NoError(err)
+		assert.Equal(int64(2*(total-count)), key)
+		count++
+
+		hasMore, err = cursor.Prev()
+		assert.NoError(err)
+	}
+	assert.Equal(total, count)
+}
@@ -0,0 +1,236 @@
+package pager
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+)
+
+const (
+	// DefaultCacheSize is the cache size of a buffer pool, in pages when positive and in KiB
+	// when negative like PRAGMA cache_size.
+	DefaultCacheSize = -2000
+
+	// minCachePages is the fewest pages a buffer pool holds, b-tree operations
+	// keep a handful of pages around while they read others.
+	minCachePages = 10
+)
+
+// CacheStats counts the reads served by a buffer pool
+type CacheStats struct {
+	Hits      int
+	Misses    int
+	Evictions int
+}
+
+// BufferPool caches committed pages for every connection to a database, so each page is
+// held in memory once. Cached pages are never changed, connections change copies of them
+// and a commit replaces the cached versions of the pages it writes.
+//
+// A page is cached in the version seen by connections reading the latest committed state,
+// connections reading an older snapshot which changed since read the file instead. The
+// frame of a page is latched while the page is loaded, connections reading the same page
+// wait for a single read of the file.
+//
+// 引擎共享的缓冲池，按 LRU 淘汰
+type BufferPool struct {
+	mu         *sync.Mutex
+	cacheSize  int                   // 缓存大小，负数以 KiB 为单位
+	pageSize   int                   // 页大小
+	capacity   int                   // 容量(页数)
+	frames     map[int]*list.Element // 页号 => 链表节点
+	lru        *list.List            // 最近使用的页在前
+	latest     uint64                // 最新提交的快照
+	committing bool                  // 提交期间不缓存新的页
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+// frame holds the committed version of a page
+type frame struct {
+	latch      *sync.RWMutex // 页闩，加载页时持有写闩
+	pageNumber int
+	data       []byte // 加载失败时为空
+	since      uint64 // 该版本可见的最早快照
+}
+
+// NewBufferPool makes a buffer pool for a database whose latest committed state is the
+// snapshot mark, see storage.SnapshotFile. A cache size of 0 is DefaultCacheSize.
+func NewBufferPool(cacheSize int, pageSize int, mark uint64) *BufferPool {
+	if cacheSize == 0 {
+		cacheSize = DefaultCacheSize
+	}
+
+	return &BufferPool{
+		mu:        &sync.Mutex{},
+		cacheSize: cacheSize,
+		pageSize:  pageSize,
+		capacity:  cachePages(cacheSize, pageSize),
+		frames:    make(map[int]*list.Element),
+		lru:       list.New(),
+		latest:    mark,
+	}
+}
+
+// cachePages is the capacity in pages for a cache size in pages or, when negative, in KiB.
+func cachePages(cacheSize int, pageSize int) int {
+	pages := cacheSize
+	if cacheSize < 0 {
+		pages = -cacheSize * 1024 / pageSize
+	}
+	if pages < minCachePages {
+		pages = minCachePages
+	}
+	return pages
+}
+
+// CacheSize is the cache size set by SetCacheSize.
+func (b *BufferPool) CacheSize() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.cacheSize
+}
+
+// SetCacheSize limits the pool to size pages, or to -size KiB when size is negative.
+// The pool holds at least 10 pages.
+func (b *BufferPool) SetCacheSize(size int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.cacheSize = size
+	b.capacity = cachePages(size, b.pageSize)
+	b.evict()
+}
+
+// Stats counts the reads served by the pool and the pages it evicted.
+func (b *BufferPool) Stats() CacheStats {
+	return CacheStats{
+		Hits:      int(atomic.LoadInt64(&b.hits)),
+		Misses:    int(atomic.LoadInt64(&b.misses)),
+		Evictions: int(atomic.LoadInt64(&b.evictions)),
+	}
+}
+
+// read returns the committed version of the page seen by the snapshot mark, load reads
+// it from the file when it isn't cached. The returned data must not be changed.
+func (b *BufferPool) read(pageNumber int, mark uint64, load func() ([]byte, error)) ([]byte, error) {
+	b.mu.Lock()
+	if e, ok := b.frames[pageNumber]; ok {
+		f := e.Value.(*frame)
+		b.lru.MoveToFront(e)
+		b.mu.Unlock()
+
+		// Wait for the page to be loaded
+		f.latch.RLock()
+		data, since := f.data, f.since
+		f.latch.RUnlock()
+
+		if data != nil && since <= mark {
+			atomic.AddInt64(&b.hits, 1)
+			return data, nil
+		}
+		atomic.AddInt64(&b.misses, 1)
+		return load()
+	}
+	atomic.AddInt64(&b.misses, 1)
+
+	// Only the latest committed version of a page is cached
+	if mark != b.latest || b.committing {
+		b.mu.Unlock()
+		return load()
+	}
+
+	f := &frame{latch: &sync.RWMutex{}, pageNumber: pageNumber}
+	f.latch.Lock()
+	b.frames[pageNumber] = b.lru.PushFront(f)
+	b.evict()
+	b.mu.Unlock()
+
+	data, err := load()
+	if err == nil {
+		f.data, f.since = data, mark
+	}
+	f.latch.Unlock()
+
+	if err != nil {
+		b.mu.Lock()
+		b.remove(f)
+		b.mu.Unlock()
+	}
+
+	return data, err
+}
+
+// beginCommit drops the pages written by a commit, no pages are cached until the commit ends.
+func (b *BufferPool) beginCommit(pages []storage.Page) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.committing = true
+	for _, page := range pages {
+		if e, ok := b.frames[page.PageNumber]; ok {
+			b.remove(e.Value.(*frame))
+		}
+	}
+}
+
+// endCommit caches the committed pages, mark is the snapshot of the commit.
+func (b *BufferPool) endCommit(pages []storage.Page, mark uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, page := range pages {
+		data := make([]byte, len(page.Data))
+		copy(data, page.Data)
+
+		f := &frame{latch: &sync.RWMutex{}, pageNumber: page.PageNumber, data: data, since: mark}
+		b.frames[page.PageNumber] = b.lru.PushFront(f)
+	}
+	b.latest = mark
+	b.committing = false
+	b.evict()
+}
+
+// abortCommit ends a commit which failed, the latest committed state didn't change.
+func (b *BufferPool) abortCommit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.committing = false
+}
+
+// reset drops every page once the database is replaced.
+func (b *BufferPool) reset(pageSize int, mark uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.frames = make(map[int]*list.Element)
+	b.lru.Init()
+	b.pageSize = pageSize
+	b.capacity = cachePages(b.cacheSize, pageSize)
+	b.latest = mark
+}
+
+// remove drops the frame unless it was replaced, the mutex must be held.
+func (b *BufferPool) remove(f *frame) {
+	if e, ok := b.frames[f.pageNumber]; ok && e.Value == f {
+		b.lru.Remove(e)
+		delete(b.frames, f.pageNumber)
+	}
+}
+
+// evict drops the least recently used pages until the pool is within its capacity,
+// the mutex must be held.
+func (b *BufferPool) evict() {
+	for b.lru.Len() > b.capacity {
+		f := b.lru.Back().Value.(*frame)
+		b.lru.Remove(b.lru.Back())
+		delete(b.frames, f.pageNumber)
+		atomic.AddInt64(&b.evictions, 1)
+	}
+}
@@ -0,0 +1,105 @@
+package pager
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferPool_Snapshots(t *testing.T) {
+	assert := require.New(t)
+
+	pool := NewBufferPool(10, 1024, 1)
+	loads := 0
+	load := func(b byte) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			loads++
+			return bytes.Repeat([]byte{b}, 1024), nil
+		}
+	}
+
+	// Pages read by the latest snapshot are cached
+	data, err := pool.read(2, 1, load('a'))
+	assert.NoError(err)
+	assert.Equal(byte('a'), data[0])
+	data, err = pool.read(2, 1, load('x'))
+	assert.NoError(err)
+	assert.Equal(byte('a'), data[0])
+	assert.Equal(1, loads)
+
+	// Pages aren't cached while a commit writes them, older snapshots read the file
+	pool.beginCommit([]storage.Page{{PageNumber: 2}})
+	data, err = pool.read(2, 1, load('a'))
+	assert.NoError(err)
+	assert.Equal(byte('a'), data[0])
+	assert.Equal(2, loads)
+
+	pool.endCommit([]storage.Page{{PageNumber: 2, Data: bytes.Repeat([]byte{'b'}, 1024)}}, 2)
+	data, err = pool.read(2, 1, load('a'))
+	assert.NoError(err)
+	assert.Equal(byte('a'), data[0])
+	assert.Equal(3, loads)
+
+	data, err = pool.read(2, 2, load('x'))
+	assert.NoError(err)
+	assert.Equal(byte('b'), data[0])
+	assert.Equal(3, loads)
+
+	// Snapshots older than the latest commit don't cache pages
+	_, err = pool.read(3, 1, load('a'))
+	assert.NoError(err)
+	_, err = pool.read(3, 2, load('c'))
+	assert.NoError(err)
+	data, err = pool.read(3, 2, load('x'))
+	assert.NoError(err)
+	assert.Equal(byte('c'), data[0])
+	assert.Equal(5, loads)
+}
+
+func TestBufferPool_Latch(t *testing.T) {
+	assert := require.New(t)
+
+	pool := NewBufferPool(10, 1024, 0)
+	loading := make(chan struct{})
+	loaded := make(chan struct{})
+	var loads int
+	load := func() ([]byte, error) {
+		loads++
+		close(loading)
+		<-loaded
+		return make([]byte, 1024), nil
+	}
+
+	// Connections reading a page being loaded wait for it
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := pool.read(2, 0, load)
+		assert.NoError(err)
+	}()
+	<-loading
+
+	results := make(chan []byte, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := pool.read(2, 0, load)
+			assert.NoError(err)
+			results <- data
+		}()
+	}
+	close(loaded)
+	wg.Wait()
+	close(results)
+
+	assert.Equal(1, loads)
+	for data := range results {
+		assert.Len(data, 1024)
+	}
+	assert.Equal(3, pool.Stats().Hits)
+}
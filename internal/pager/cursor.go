@@ -2,6 +2,7 @@ package pager
 
 import (
 	"errors"
+	"math"
 
 	"github.com/joeandaverde/tinydb/internal/storage"
 )
@@ -23,26 +24,48 @@ type Cursor struct {
 	currentPage int
 	cellIndex   int
 
-	parentIndex int
-	parentPage  int
+	// stack holds the interior pages above the current page
+	// and the index of the child being traversed in each.
+	// The pages are pinned so they stay cached while the cursor is below them.
+	stack  []pathFrame
+	pinned []*MemPage
+
+	// moved is set when the btree was modified through the cursor, pages may
+	// have been split or merged. Next continues from the record following savedKey.
+	moved    bool
+	savedKey int64
+
+	// index is set for cursors of index btrees,
+	// savedEntry takes the place of savedKey for these.
+	index      bool
+	savedEntry []*storage.Field
 
 	pager Pager
 }
 
 // NewCursor initializes a cursor to traverse the database btree
 func NewCursor(pager Pager, typ CursorType, rootPage int, name string) (*Cursor, error) {
+	root, err := pager.Read(rootPage)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Cursor{
 		Name:        name,
 		pager:       pager,
 		rootPage:    rootPage,
 		currentPage: rootPage,
-		parentPage:  0,
-		parentIndex: 0,
 		cellIndex:   0,
 		typ:         typ,
+		index:       root.IsIndex(),
 	}, nil
 }
 
+// IsIndex determines if the cursor traverses an index btree
+func (c *Cursor) IsIndex() bool {
+	return c.index
+}
+
 // CurrentCell reads the current record
 func (c *Cursor) CurrentCell() (*storage.Record, error) {
 	p, err := c.pager.Read(c.currentPage)
@@ -58,83 +81,635 @@ func (c *Cursor) CurrentCell() (*storage.Record, error) {
 	return p.ReadRecord(c.cellIndex)
 }
 
-// Insert places a record in the btree
+// Insert places a record in the btree, replacing the record with the same key.
+// Next moves to the record after the inserted one.
 func (c *Cursor) Insert(record *storage.Record) error {
 	btreeTable := NewBTreeTable(c.rootPage, c.pager)
-	return btreeTable.Insert(record)
+	if err := btreeTable.Insert(record); err != nil {
+		return err
+	}
+
+	c.moved = true
+	c.savedKey = record.RowID
+
+	return nil
+}
+
+// Delete removes the current record from the btree. The cursor is left
+// pointing between records, Next moves to the record after the deleted one.
+func (c *Cursor) Delete() error {
+	key, err := c.RowID()
+	if err != nil {
+		return err
+	}
+
+	btreeTable := NewBTreeTable(c.rootPage, c.pager)
+	if _, err := btreeTable.Delete(key); err != nil {
+		return err
+	}
+
+	c.moved = true
+	c.savedKey = key
+
+	return nil
+}
+
+// RowID reads the key of the current record,
+// for index cursors it's the rowid of the current entry.
+func (c *Cursor) RowID() (int64, error) {
+	if c.index {
+		entry, err := c.Entry()
+		if err != nil {
+			return 0, err
+		}
+		if len(entry) == 0 {
+			return 0, errors.New("index entry without rowid")
+		}
+		rowID, ok := entry[len(entry)-1].Int()
+		if !ok {
+			return 0, errors.New("index entry without rowid")
+		}
+		return int64(rowID), nil
+	}
+
+	p, err := c.pager.Read(c.currentPage)
+	if err != nil {
+		return 0, err
+	}
+
+	// Attempt to access non-leaf cell
+	if p.header.Type != PageTypeLeaf {
+		return 0, errors.New("expected current position to be on leaf node")
+	}
+
+	return p.CellKey(c.cellIndex)
+}
+
+// NewRowID provides a key for a new record which is one greater
+// than the largest key in the btree.
+func (c *Cursor) NewRowID() (int64, error) {
+	btreeTable := NewBTreeTable(c.rootPage, c.pager)
+	maxRowID, err := btreeTable.MaxRowID()
+	if err != nil {
+		return 0, err
+	}
+
+	if maxRowID == math.MaxInt64 {
+		return 0, errors.New("database or disk is full")
+	}
+
+	return maxRowID + 1, nil
+}
+
+// SeekRowID moves the cursor to the record with the specified key
+// returns true if the record exists false otherwise
+func (c *Cursor) SeekRowID(key int64) (bool, error) {
+	c.resetStack()
+	c.moved = false
+
+	p, err := c.pager.Read(c.rootPage)
+	if err != nil {
+		return false, err
+	}
+
+	for !p.IsLeaf() {
+		index, _, err := p.Search(key)
+		if err != nil {
+			return false, err
+		}
+		c.push(p, index)
+
+		child, err := p.ChildPage(index)
+		if err != nil {
+			return false, err
+		}
+		p, err = c.pager.Read(child)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	index, found, err := p.Search(key)
+	if err != nil {
+		return false, err
+	}
+
+	c.currentPage = p.Number()
+	c.cellIndex = index
+
+	return found, nil
+}
+
+// SeekGE moves the cursor to the first record with a key greater than or equal to key
+// returns true if there is such a record false otherwise
+func (c *Cursor) SeekGE(key int64) (bool, error) {
+	if _, err := c.SeekRowID(key); err != nil {
+		return false, err
+	}
+
+	// The leaf may not have a key as large, continue to the following leaves.
+	c.cellIndex--
+	return c.Next()
+}
+
+// SeekGT moves the cursor to the first record with a key greater than key
+// returns true if there is such a record false otherwise
+func (c *Cursor) SeekGT(key int64) (bool, error) {
+	found, err := c.SeekRowID(key)
+	if err != nil {
+		return false, err
+	}
+
+	if !found {
+		c.cellIndex--
+	}
+	return c.Next()
+}
+
+// SeekLE moves the cursor to the last record with a key less than or equal to key
+// returns true if there is such a record false otherwise
+func (c *Cursor) SeekLE(key int64) (bool, error) {
+	found, err := c.SeekRowID(key)
+	if err != nil || found {
+		return found, err
+	}
+
+	return c.Prev()
+}
+
+// SeekLT moves the cursor to the last record with a key less than key
+// returns true if there is such a record false otherwise
+func (c *Cursor) SeekLT(key int64) (bool, error) {
+	if _, err := c.SeekRowID(key); err != nil {
+		return false, err
+	}
+
+	return c.Prev()
 }
 
 // Next advances the cursor to the next record
 // returns true if there is a record false otherwise
 func (c *Cursor) Next() (bool, error) {
+	if c.index {
+		return c.nextEntry()
+	}
+
+	// Pages may have been split or merged since the cursor was positioned,
+	// find the position of the saved key from the root. When the key is gone
+	// the cursor is on the following record and must not advance past it.
+	if c.moved {
+		found, err := c.SeekRowID(c.savedKey)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			c.cellIndex--
+		}
+	}
 
-	// 读取当前 Page
 	p, err := c.pager.Read(c.currentPage)
 	if err != nil {
 		return false, err
 	}
 
-	nextIndex := c.cellIndex + 1
+	// More cells in the current leaf
+	if c.cellIndex+1 < p.CellCount() {
+		c.cellIndex++
+		return true, nil
+	}
 
-	// Encountering an internal page should traverse its children
-	if p.header.Type == PageTypeInternal {
-		if nextIndex < int(p.header.NumCells) {
-			interiorNode, err := p.ReadInteriorNode(nextIndex)
-			if err != nil {
-				return false, err
-			}
+	// Leaf has been completely traversed, go up to the first
+	// ancestor that has children left to traverse.
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		parent, err := c.pager.Read(top.page)
+		if err != nil {
+			return false, err
+		}
 
-			nextPage := int(interiorNode.LeftChild)
+		// No parent with children left, we're done.
+		if top.index >= parent.CellCount() {
+			c.pop()
+			continue
+		}
 
-			// Store the position in the parent
-			// This may need to become a stack or linked list.
-			c.parentPage = p.Number()
-			c.parentIndex = nextIndex
+		// Start at the beginning of the next child
+		top.index++
+		child, err := parent.ChildPage(top.index)
+		if err != nil {
+			return false, err
+		}
+		if err := c.moveToLeftMost(child); err != nil {
+			return false, err
+		}
+
+		leaf, err := c.pager.Read(c.currentPage)
+		if err != nil {
+			return false, err
+		}
+		if leaf.CellCount() > 0 {
+			return true, nil
+		}
+	}
 
-			// Start at the beginning of the child node
-			c.currentPage = nextPage
-			c.cellIndex = -1
-		} else {
-			// TODO: Confirm intended behavior of right page
+	return false, nil
+}
 
-			// The last node to be traversed.
-			c.parentPage = 0
-			c.parentIndex = 0
+// Prev moves the cursor to the previous record
+// returns true if there is a record false otherwise
+func (c *Cursor) Prev() (bool, error) {
+	if c.index {
+		return c.prevEntry()
+	}
 
-			// Last page is the right page.
-			c.currentPage = p.header.RightPage
-			c.cellIndex = -1
+	// The saved key is either found or the cursor is on the following
+	// record, in both cases the previous record is the one wanted.
+	if c.moved {
+		if _, err := c.SeekRowID(c.savedKey); err != nil {
+			return false, err
 		}
+	}
 
-		return c.Next()
+	// More cells in the current leaf
+	if c.cellIndex > 0 {
+		c.cellIndex--
+		return true, nil
 	}
 
-	// If leaf has been completely traversed.
-	// Go go to next leaf or done.
-	if nextIndex >= int(p.header.NumCells) {
-		// No parent, we're done.
-		if c.parentPage == 0 {
-			return false, nil
+	// Leaf has been completely traversed, go up to the first
+	// ancestor that has children left to traverse.
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		parent, err := c.pager.Read(top.page)
+		if err != nil {
+			return false, err
+		}
+
+		// No parent with children left, we're done.
+		if top.index == 0 {
+			c.pop()
+			continue
+		}
+
+		// Start at the end of the previous child
+		top.index--
+		child, err := parent.ChildPage(top.index)
+		if err != nil {
+			return false, err
+		}
+		if err := c.moveToRightMost(child); err != nil {
+			return false, err
+		}
+
+		if c.cellIndex >= 0 {
+			return true, nil
 		}
+	}
+
+	return false, nil
+}
 
-		// Restore parent interior node position
-		c.currentPage = c.parentPage
-		c.cellIndex = c.parentIndex
+// Last sets the cursor to the last entry in the btree
+// returns true if there is a record false otherwise
+func (c *Cursor) Last() (bool, error) {
+	c.resetStack()
+	c.moved = false
+	if err := c.moveToRightMost(c.rootPage); err != nil {
+		return false, err
+	}
 
-		// Start at next child in parent
-		return c.Next()
+	if c.cellIndex >= 0 {
+		return true, nil
 	}
 
-	c.cellIndex = nextIndex
-	return true, nil
+	// An empty leaf, look for a record in the preceding leaves.
+	return c.Prev()
 }
 
 // Rewind sets the cursor to the first entry in the btree
 // returns true if there is a record false otherwise
 func (c *Cursor) Rewind() (bool, error) {
-	c.currentPage = c.rootPage
-	c.cellIndex = -1
-	c.parentIndex = 0
-	c.parentPage = 0
+	c.resetStack()
+	c.moved = false
+	if err := c.moveToLeftMost(c.rootPage); err != nil {
+		return false, err
+	}
+
+	p, err := c.pager.Read(c.currentPage)
+	if err != nil {
+		return false, err
+	}
+	if p.CellCount() > 0 {
+		return true, nil
+	}
+
+	// An empty leaf, look for a record in the following leaves.
 	return c.Next()
 }
+
+// moveToLeftMost descends from the page to its left most leaf
+// pushing each interior page on the way to the stack.
+func (c *Cursor) moveToLeftMost(pageNumber int) error {
+	for {
+		p, err := c.pager.Read(pageNumber)
+		if err != nil {
+			return err
+		}
+
+		if p.IsLeaf() {
+			c.currentPage = pageNumber
+			c.cellIndex = 0
+			return nil
+		}
+
+		c.push(p, 0)
+		pageNumber, err = p.ChildPage(0)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// moveToRightMost descends from the page to its right most leaf
+// pushing each interior page on the way to the stack.
+func (c *Cursor) moveToRightMost(pageNumber int) error {
+	for {
+		p, err := c.pager.Read(pageNumber)
+		if err != nil {
+			return err
+		}
+
+		if p.IsLeaf() {
+			c.currentPage = pageNumber
+			c.cellIndex = p.CellCount() - 1
+			return nil
+		}
+
+		c.push(p, p.CellCount())
+		pageNumber = p.header.RightPage
+	}
+}
+
+// push adds an interior page to the stack and pins it.
+func (c *Cursor) push(p *MemPage, index int) {
+	p.Pin()
+	c.stack = append(c.stack, pathFrame{page: p.Number(), index: index})
+	c.pinned = append(c.pinned, p)
+}
+
+// pop removes the last page of the stack and unpins it.
+func (c *Cursor) pop() pathFrame {
+	last := len(c.stack) - 1
+	top := c.stack[last]
+	c.pinned[last].Unpin()
+	c.stack = c.stack[:last]
+	c.pinned = c.pinned[:last]
+	return top
+}
+
+// resetStack empties the stack, unpinning its pages.
+func (c *Cursor) resetStack() {
+	for _, p := range c.pinned {
+		p.Unpin()
+	}
+	c.stack = c.stack[:0]
+	c.pinned = c.pinned[:0]
+}
+
+// Close releases the pages pinned by the cursor, it can be positioned again afterwards.
+func (c *Cursor) Close() {
+	c.resetStack()
+}
+
+// Entry reads the current entry of an index cursor
+func (c *Cursor) Entry() ([]*storage.Field, error) {
+	p, err := c.pager.Read(c.currentPage)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.CellEntry(c.cellIndex)
+}
+
+// CompareEntry compares the current entry of an index cursor with a key. Only as
+// many fields as the key has are compared. Returns a negative number when the
+// entry is less than the key, zero when equal and a positive number otherwise.
+func (c *Cursor) CompareEntry(key []*storage.Field) (int, error) {
+	entry, err := c.Entry()
+	if err != nil {
+		return 0, err
+	}
+
+	return storage.CompareFields(entry, key), nil
+}
+
+// InsertEntry adds an entry to an index btree.
+// Next moves to the entry after the inserted one.
+func (c *Cursor) InsertEntry(entry []*storage.Field) error {
+	btreeIndex := NewBTreeIndex(c.rootPage, c.pager)
+	if err := btreeIndex.Insert(entry); err != nil {
+		return err
+	}
+
+	c.moved = true
+	c.savedEntry = entry
+
+	return nil
+}
+
+// DeleteEntry removes an entry from an index btree, returns false if there is no such entry.
+// Next moves to the entry after the deleted one.
+func (c *Cursor) DeleteEntry(entry []*storage.Field) (bool, error) {
+	btreeIndex := NewBTreeIndex(c.rootPage, c.pager)
+	deleted, err := btreeIndex.Delete(entry)
+	if err != nil {
+		return false, err
+	}
+
+	c.moved = true
+	c.savedEntry = entry
+
+	return deleted, nil
+}
+
+// SeekIndexGE moves an index cursor to the first entry greater than or equal to key
+// returns true if there is such an entry false otherwise
+func (c *Cursor) SeekIndexGE(key []*storage.Field) (bool, error) {
+	if err := c.moveToEntry(key, false); err != nil {
+		return false, err
+	}
+
+	c.cellIndex--
+	return c.nextEntry()
+}
+
+// SeekIndexGT moves an index cursor to the first entry greater than key
+// returns true if there is such an entry false otherwise
+func (c *Cursor) SeekIndexGT(key []*storage.Field) (bool, error) {
+	if err := c.moveToEntry(key, true); err != nil {
+		return false, err
+	}
+
+	c.cellIndex--
+	return c.nextEntry()
+}
+
+// SeekIndexLE moves an index cursor to the last entry less than or equal to key
+// returns true if there is such an entry false otherwise
+func (c *Cursor) SeekIndexLE(key []*storage.Field) (bool, error) {
+	if err := c.moveToEntry(key, true); err != nil {
+		return false, err
+	}
+
+	return c.prevEntry()
+}
+
+// SeekIndexLT moves an index cursor to the last entry less than key
+// returns true if there is such an entry false otherwise
+func (c *Cursor) SeekIndexLT(key []*storage.Field) (bool, error) {
+	if err := c.moveToEntry(key, false); err != nil {
+		return false, err
+	}
+
+	return c.prevEntry()
+}
+
+// moveToEntry descends to the leaf where the key belongs and positions the cursor
+// at the first cell greater than or equal to key, or greater than key when strict.
+// The cell may be one past the end of the leaf.
+func (c *Cursor) moveToEntry(key []*storage.Field, strict bool) error {
+	c.resetStack()
+	c.moved = false
+
+	p, err := c.pager.Read(c.rootPage)
+	if err != nil {
+		return err
+	}
+
+	for {
+		index, _, err := p.SearchEntry(key, strict)
+		if err != nil {
+			return err
+		}
+
+		if p.IsLeaf() {
+			c.currentPage = p.Number()
+			c.cellIndex = index
+			return nil
+		}
+
+		c.push(p, index)
+		child, err := p.ChildPage(index)
+		if err != nil {
+			return err
+		}
+		p, err = c.pager.Read(child)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// nextEntry advances an index cursor to the next entry. Entries of interior
+// pages are visited between the entries of the children on either side.
+func (c *Cursor) nextEntry() (bool, error) {
+	if c.moved {
+		return c.SeekIndexGT(c.savedEntry)
+	}
+
+	p, err := c.pager.Read(c.currentPage)
+	if err != nil {
+		return false, err
+	}
+
+	if p.IsLeaf() {
+		// More cells in the current leaf
+		if c.cellIndex+1 < p.CellCount() {
+			c.cellIndex++
+			return true, nil
+		}
+	} else {
+		// The next entry is the first of the following child
+		c.push(p, c.cellIndex+1)
+		child, err := p.ChildPage(c.cellIndex + 1)
+		if err != nil {
+			return false, err
+		}
+		if err := c.moveToLeftMost(child); err != nil {
+			return false, err
+		}
+
+		leaf, err := c.pager.Read(c.currentPage)
+		if err != nil {
+			return false, err
+		}
+		if leaf.CellCount() > 0 {
+			return true, nil
+		}
+	}
+
+	// Leaf has been completely traversed, the next entry
+	// is in the first ancestor with entries left to visit.
+	for len(c.stack) > 0 {
+		top := c.pop()
+
+		parent, err := c.pager.Read(top.page)
+		if err != nil {
+			return false, err
+		}
+		if top.index < parent.CellCount() {
+			c.currentPage = top.page
+			c.cellIndex = top.index
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// prevEntry moves an index cursor to the previous entry.
+func (c *Cursor) prevEntry() (bool, error) {
+	if c.moved {
+		return c.SeekIndexLT(c.savedEntry)
+	}
+
+	p, err := c.pager.Read(c.currentPage)
+	if err != nil {
+		return false, err
+	}
+
+	if p.IsLeaf() {
+		// More cells in the current leaf
+		if c.cellIndex > 0 {
+			c.cellIndex--
+			return true, nil
+		}
+	} else {
+		// The previous entry is the last of the preceding child
+		c.push(p, c.cellIndex)
+		child, err := p.ChildPage(c.cellIndex)
+		if err != nil {
+			return false, err
+		}
+		if err := c.moveToRightMost(child); err != nil {
+			return false, err
+		}
+		if c.cellIndex >= 0 {
+			return true, nil
+		}
+	}
+
+	// Leaf has been completely traversed, the previous entry
+	// is in the first ancestor with entries left to visit.
+	for len(c.stack) > 0 {
+		top := c.pop()
+
+		if top.index > 0 {
+			c.currentPage = top.page
+			c.cellIndex = top.index - 1
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,186 @@
+package pager
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+)
+
+// Pages no longer used by a btree are kept in the freelist and reused by later
+// allocations, the file itself never shrinks. The file header on page 1 holds the
+// first trunk page of the freelist and the total number of free pages.
+//
+// A trunk page starts with the number of the next trunk page, or zero for the last
+// trunk, followed by the count of leaf pages and the leaf page numbers. Leaf pages
+// are free pages without any content of interest.
+
+// freelistTrunkHeaderLen is the length of the next trunk pointer and the leaf count of a trunk page
+const freelistTrunkHeaderLen = 8
+
+// maxTrunkLeaves is the number of leaf pages a trunk page holds. SQLite reads trunks
+// filled up to the end of the page, but only fills them up to 6 entries short of it
+// for compatibility with older versions.
+func maxTrunkLeaves(usableSize int) int {
+	return usableSize/4 - 8
+}
+
+// Free releases a page to the freelist, the page must no longer be referenced.
+// The page either becomes a leaf of the first trunk or, when the trunk is full,
+// the new first trunk.
+func (p *pager) Free(pageNumber int) error {
+	if pageNumber <= 1 || pageNumber > p.pageCount {
+		return fmt.Errorf("page [%d] can't be freed", pageNumber)
+	}
+
+	header, err := p.Read(1)
+	if err != nil {
+		return err
+	}
+	trunkNumber, count := header.freelist()
+
+	if trunkNumber != 0 {
+		trunk, err := p.Read(trunkNumber)
+		if err != nil {
+			return err
+		}
+		next, leaves := trunk.freelistTrunk()
+		if len(leaves) < maxTrunkLeaves(len(trunk.data)) {
+			trunk.setFreelistTrunk(next, append(leaves, pageNumber))
+			header.setFreelist(trunkNumber, count+1)
+			return nil
+		}
+	}
+
+	page, err := p.Read(pageNumber)
+	if err != nil {
+		return err
+	}
+	page.setFreelistTrunk(trunkNumber, nil)
+	header.setFreelist(pageNumber, count+1)
+
+	return nil
+}
+
+// takeFreePage removes a page from the freelist, returns 0 when the freelist is empty.
+// The last leaf of the first trunk is taken, a trunk without leaves is taken itself.
+func (p *pager) takeFreePage() (int, error) {
+	if p.pageCount == 0 {
+		return 0, nil
+	}
+
+	header, err := p.Read(1)
+	if err != nil {
+		return 0, err
+	}
+	trunkNumber, count := header.freelist()
+	if trunkNumber == 0 {
+		return 0, nil
+	}
+
+	trunk, err := p.Read(trunkNumber)
+	if err != nil {
+		return 0, err
+	}
+	next, leaves := trunk.freelistTrunk()
+	if len(leaves) == 0 {
+		header.setFreelist(next, count-1)
+		return trunkNumber, nil
+	}
+
+	trunk.setFreelistTrunk(next, leaves[:len(leaves)-1])
+	header.setFreelist(trunkNumber, count-1)
+
+	return leaves[len(leaves)-1], nil
+}
+
+// FreeBTree releases every page of the btree at rootPage to the freelist,
+// including the overflow pages of its cells.
+func FreeBTree(p Pager, rootPage int) error {
+	page, err := p.Read(rootPage)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < page.CellCount(); i++ {
+		if !page.IsLeaf() {
+			child, err := page.ChildPage(i)
+			if err != nil {
+				return err
+			}
+			if err := FreeBTree(p, child); err != nil {
+				return err
+			}
+		}
+		if err := freeOverflow(p, page, i); err != nil {
+			return err
+		}
+	}
+	if !page.IsLeaf() {
+		if err := FreeBTree(p, page.header.RightPage); err != nil {
+			return err
+		}
+	}
+
+	return p.Free(rootPage)
+}
+
+// freeOverflow releases the overflow pages of the cell at cellIndex of the page to the freelist.
+func freeOverflow(p Pager, page *MemPage, cellIndex int) error {
+	if page.header.Type == PageTypeInternal {
+		return nil
+	}
+
+	pages, err := page.overflowPages(cellIndex)
+	if err != nil {
+		return err
+	}
+	for _, pageNumber := range pages {
+		if err := p.Free(pageNumber); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// freelist reads the first trunk page and the count of free pages from the file header.
+func (p *MemPage) freelist() (int, int) {
+	return int(binary.BigEndian.Uint32(p.data[storage.FreelistTrunkOffset:])),
+		int(binary.BigEndian.Uint32(p.data[storage.FreelistCountOffset:]))
+}
+
+// setFreelist updates the first trunk page and the count of free pages in the file header.
+func (p *MemPage) setFreelist(trunk int, count int) {
+	binary.BigEndian.PutUint32(p.data[storage.FreelistTrunkOffset:], uint32(trunk))
+	binary.BigEndian.PutUint32(p.data[storage.FreelistCountOffset:], uint32(count))
+	p.markDirty()
+}
+
+// freelistTrunk reads the next trunk page and the leaf pages of a trunk page.
+func (p *MemPage) freelistTrunk() (int, []int) {
+	next := int(binary.BigEndian.Uint32(p.data))
+	count := int(binary.BigEndian.Uint32(p.data[4:]))
+
+	leaves := make([]int, count)
+	for i := range leaves {
+		leaves[i] = int(binary.BigEndian.Uint32(p.data[freelistTrunkHeaderLen+4*i:]))
+	}
+
+	return next, leaves
+}
+
+// setFreelistTrunk makes the page a trunk page of the freelist.
+func (p *MemPage) setFreelistTrunk(next int, leaves []int) {
+	for i := range p.data {
+		p.data[i] = 0
+	}
+	binary.BigEndian.PutUint32(p.data, uint32(next))
+	binary.BigEndian.PutUint32(p.data[4:], uint32(len(leaves)))
+	for i, leaf := range leaves {
+		binary.BigEndian.PutUint32(p.data[freelistTrunkHeaderLen+4*i:], uint32(leaf))
+	}
+
+	p.header = PageHeader{Type: PageTypeOverflow}
+	p.markDirty()
+}
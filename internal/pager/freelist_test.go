@@ -0,0 +1,108 @@
+package pager
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPager_Freelist(t *testing.T) {
+	assert := require.New(t)
+
+	file := storage.NewMemoryFile(512)
+	assert.NoError(Initialize(file))
+	p := NewPager(file)
+
+	// Enough pages to fill more than one trunk
+	const total = 300
+	var pages []int
+	for i := 0; i < total; i++ {
+		page, err := p.Allocate(PageTypeLeaf)
+		assert.NoError(err)
+		pages = append(pages, page.Number())
+	}
+	assert.Equal(total+1, p.(*pager).pageCount)
+
+	assert.Error(p.Free(1))
+	for _, pageNumber := range pages {
+		assert.NoError(p.Free(pageNumber))
+	}
+
+	header, err := p.Read(1)
+	assert.NoError(err)
+	trunk, count := header.freelist()
+	assert.Equal(total, count)
+	assert.NotZero(trunk)
+
+	// The freelist survives a flush
+	assert.NoError(p.Flush())
+	p = NewPager(file)
+
+	// Every freed page is reused once before the file grows
+	reused := make(map[int]bool)
+	for i := 0; i < total; i++ {
+		page, err := p.Allocate(PageTypeLeaf)
+		assert.NoError(err)
+		assert.Equal(PageTypeLeaf, page.header.Type)
+		assert.Equal(0, page.CellCount())
+		reused[page.Number()] = true
+	}
+	assert.Len(reused, total)
+	assert.Equal(total+1, p.(*pager).pageCount)
+
+	header, err = p.Read(1)
+	assert.NoError(err)
+	trunk, count = header.freelist()
+	assert.Zero(trunk)
+	assert.Zero(count)
+
+	page, err := p.Allocate(PageTypeLeaf)
+	assert.NoError(err)
+	assert.Equal(total+2, page.Number())
+}
+
+func TestBTreeTable_FreesPages(t *testing.T) {
+	assert := require.New(t)
+
+	file := storage.NewMemoryFile(512)
+	assert.NoError(Initialize(file))
+	p := NewPager(file)
+
+	root, err := p.Allocate(PageTypeLeaf)
+	assert.NoError(err)
+
+	insert := func(tree *BTreeTable, random *rand.Rand) {
+		for _, i := range random.Perm(500) {
+			assert.NoError(tree.Insert(storage.NewRecord(int64(i+1), []*storage.Field{
+				{Type: storage.Text, Data: strings.Repeat("x", random.Intn(1500))},
+			})))
+		}
+	}
+
+	// Deleting every record frees all pages but the root
+	tree := NewBTreeTable(root.Number(), p)
+	insert(tree, rand.New(rand.NewSource(1)))
+	pageCount := p.(*pager).pageCount
+	for key := int64(1); key <= 500; key++ {
+		deleted, err := tree.Delete(key)
+		assert.NoError(err)
+		assert.True(deleted)
+	}
+
+	header, err := p.Read(1)
+	assert.NoError(err)
+	_, count := header.freelist()
+	assert.Equal(pageCount-2, count)
+
+	// The same records fit in the freed pages
+	insert(tree, rand.New(rand.NewSource(1)))
+	assert.Equal(pageCount, p.(*pager).pageCount)
+
+	// Dropping the tree frees every page including the root
+	assert.NoError(FreeBTree(p, root.Number()))
+	_, count = header.freelist()
+	assert.Equal(pageCount-1, count)
+}
@@ -3,7 +3,10 @@ package pager
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"sort"
 
 	"github.com/joeandaverde/tinydb/internal/storage"
 )
@@ -26,6 +29,10 @@ const InteriorHeaderLen = 12
 // LeafHeaderLen is the length of a btree leaf node
 const LeafHeaderLen = 8
 
+// maxFragmentedFreeBytes is the most fragmented free bytes a page has, like SQLite the page
+// is defragmented rather than going past it.
+const maxFragmentedFreeBytes = 60
+
 // PageType type of page. See associated enumeration values.
 type PageType byte
 
@@ -44,6 +51,10 @@ const (
 
 	// PageTypeLeafIndex leaf index page
 	PageTypeLeafIndex PageType = 0x0A
+
+	// PageTypeOverflow overflow page holding the end of a large payload,
+	// it has no btree page header.
+	PageTypeOverflow PageType = 0x00
 )
 
 // PageHeader contains metadata about the page
@@ -106,6 +117,9 @@ type MemPage struct {
 	pageNumber int			// 页标号
 	data       []byte		// 数据
 	dirty      bool			// 是否脏页
+	pins       int			// 固定计数，被固定的页不会被淘汰
+	set        *pageSet		// 持有该页的页集合
+	reader     PageReader	// reads overflow pages
 }
 
 // Number is the page number
@@ -113,6 +127,28 @@ func (p *MemPage) Number() int {
 	return p.pageNumber
 }
 
+// Pin keeps the page in the page set of the pager until Unpin, for callers which hold on
+// to a page while they read others.
+func (p *MemPage) Pin() {
+	p.pins++
+}
+
+// Unpin releases a Pin, the page can be evicted once it's clean and no longer pinned.
+func (p *MemPage) Unpin() {
+	if p.pins > 0 {
+		p.pins--
+	}
+}
+
+// markDirty marks the page as changed. A page evicted while a caller held on to it
+// is cached again, so the change is flushed.
+func (p *MemPage) markDirty() {
+	p.dirty = true
+	if p.set != nil {
+		p.set.restore(p)
+	}
+}
+
 // WriteTo writes the page to the specified writer
 func (p *MemPage) WriteTo(w io.Writer) (int64, error) {
 	n, err := w.Write(p.data)
@@ -121,14 +157,14 @@ func (p *MemPage) WriteTo(w io.Writer) (int64, error) {
 
 // SetHeader sets the page header and marks the page as dirty.
 func (p *MemPage) SetHeader(h PageHeader) {
-	p.dirty = true
+	p.markDirty()
 	p.header = h
 	p.updateHeaderData()
 }
 
 // CopyTo copies the page data to dst and marks dst as dirty.
 func (p *MemPage) CopyTo(dst *MemPage) {
-	dst.dirty = true
+	dst.markDirty()
 	dst.header = p.header
 	copy(dst.data, p.data)
 }
@@ -136,13 +172,39 @@ func (p *MemPage) CopyTo(dst *MemPage) {
 // Fits determines if there's enough space in the page for a cell
 // of the specified size.
 func (p *MemPage) Fits(recordLen int) bool {
-	// Where the cell pointer will be stored
-	cellPointerOffset := cellPointersStart(p.header.Type, p.pageNumber) + int(p.header.NumCells)*2
+	return recordLen+2 <= p.FreeSpace()
+}
 
-	// Where cell data would start
-	cellDataOffset := int(p.header.CellsOffset) - recordLen
+// FreeSpace is the number of bytes available for cells and cell pointers. This includes
+// the gap between the cell pointers and the cell content area, the freeblocks and the
+// fragmented free bytes which are reclaimed by defragmenting the page.
+func (p *MemPage) FreeSpace() int {
+	free := p.gap() + int(p.header.FragmentedFreeBytes)
+	for _, b := range p.freeblocks() {
+		free += b.size
+	}
+	return free
+}
+
+// gap is the unallocated space between the cell pointers and the cell content area.
+func (p *MemPage) gap() int {
+	cellPointersEnd := cellPointersStart(p.header.Type, p.pageNumber) + 2*int(p.header.NumCells)
+	return p.cellsOffset() - cellPointersEnd
+}
 
-	return cellPointerOffset+2 <= cellDataOffset
+// cellsOffset is the start of the cell content area.
+// A zero value in the header is interpreted as 65536.
+func (p *MemPage) cellsOffset() int {
+	if p.header.CellsOffset == 0 && len(p.data) == 65536 {
+		return 65536
+	}
+	return int(p.header.CellsOffset)
+}
+
+// underfull determines if less than a third of the page is in use.
+func (p *MemPage) underfull() bool {
+	usable := len(p.data) - cellPointersStart(p.header.Type, p.pageNumber)
+	return p.FreeSpace()*3 > usable*2
 }
 
 // CellCount the total number of cells in this page
@@ -150,13 +212,25 @@ func (p *MemPage) CellCount() int {
 	return int(p.header.NumCells)
 }
 
-// ReadRecord returns a slice of bytes of the requested cell.
+// ReadRecord reads the record of the requested cell of a table leaf page.
+// The part of a large record stored in overflow pages is read as well.
 func (p *MemPage) ReadRecord(cellIndex int) (*storage.Record, error) {
-	cellDataStart := p.cellDataOffset(cellIndex)
+	info, err := p.parseCell(p.cellDataOffset(cellIndex))
+	if err != nil {
+		return nil, err
+	}
 
-	// TODO: Should this be capped upper and lower bound?
-	reader := bytes.NewReader(p.data[cellDataStart:])
-	return storage.ReadRecord(reader)
+	payload, err := p.payload(info)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := storage.ReadFields(bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.NewRecord(int64(info.key), fields), nil
 }
 
 // ReadInteriorNode returns a slice of bytes of the requested cell.
@@ -173,7 +247,15 @@ func (p *MemPage) ReadInteriorNode(cellIndex int) (*storage.InteriorNode, error)
 //
 func (p *MemPage) AddCell(data []byte) {
 	// 置为脏页
-	p.dirty = true
+	p.markDirty()
+
+	// Reuse a freeblock when the gap can only hold the pointer.
+	if p.gap() < len(data)+2 {
+		if p.gap() >= 2 && p.addCellToFreeblock(data) {
+			return
+		}
+		p.defragment()
+	}
 
 	// Every cell is 2 bytes
 	// 每个 Cell 的 Offset 占 2B ，当前 Page 内有 NumCells 个 cell ，那么前 2*NumCells 个 Bytes 需要跳过。
@@ -207,6 +289,462 @@ func (p *MemPage) AddCell(data []byte) {
 	p.updateHeaderData()
 }
 
+// InsertCell adds a cell entry to the page at the specified position in the
+// cell pointer array, shifting the pointers of the following cells.
+// This function assumes that the page can fit the new cell.
+func (p *MemPage) InsertCell(cellIndex int, data []byte) {
+	p.AddCell(data)
+
+	// AddCell appends the pointer, move it to its position.
+	pointers := p.data[cellPointersStart(p.header.Type, p.pageNumber):][:2*int(p.header.NumCells)]
+	last := binary.BigEndian.Uint16(pointers[len(pointers)-2:])
+	copy(pointers[2*(cellIndex+1):], pointers[2*cellIndex:len(pointers)-2])
+	binary.BigEndian.PutUint16(pointers[2*cellIndex:], last)
+}
+
+// RemoveCell removes a cell entry from the page. The space occupied
+// by the cell is returned to the page as free space.
+func (p *MemPage) RemoveCell(cellIndex int) error {
+	offset := p.cellDataOffset(cellIndex)
+	size, err := p.cellSize(offset)
+	if err != nil {
+		return err
+	}
+
+	p.markDirty()
+
+	// Remove the pointer shifting the pointers of the following cells.
+	pointers := p.data[cellPointersStart(p.header.Type, p.pageNumber):][:2*int(p.header.NumCells)]
+	copy(pointers[2*cellIndex:], pointers[2*(cellIndex+1):])
+	pointers[len(pointers)-2], pointers[len(pointers)-1] = 0, 0
+	p.header.NumCells = p.header.NumCells - 1
+
+	p.free(offset, size)
+	p.updateHeaderData()
+
+	return nil
+}
+
+// ReplaceCell overwrites the cell at cellIndex when the new cell is the same size,
+// returns false if the cell couldn't be replaced in place.
+func (p *MemPage) ReplaceCell(cellIndex int, data []byte) bool {
+	offset := p.cellDataOffset(cellIndex)
+	size, err := p.cellSize(offset)
+	if err != nil || size != len(data) {
+		return false
+	}
+
+	copy(p.data[offset:], data)
+	p.markDirty()
+
+	return true
+}
+
+// freeblock is a region of unallocated space within the cell content area.
+type freeblock struct {
+	offset int
+	size   int
+}
+
+// freeblocks reads the chain of freeblocks. The first 2 bytes of a freeblock are the
+// offset of the next freeblock in the chain and the next 2 bytes are the size of the freeblock.
+func (p *MemPage) freeblocks() []freeblock {
+	var blocks []freeblock
+	for offset := int(p.header.FreeBlock); offset != 0 && offset+4 <= len(p.data); {
+		blocks = append(blocks, freeblock{
+			offset: offset,
+			size:   int(binary.BigEndian.Uint16(p.data[offset+2:])),
+		})
+		offset = int(binary.BigEndian.Uint16(p.data[offset:]))
+	}
+	return blocks
+}
+
+// setFreeblocks writes the chain of freeblocks ordered by offset.
+func (p *MemPage) setFreeblocks(blocks []freeblock) {
+	p.header.FreeBlock = 0
+	for i := len(blocks) - 1; i >= 0; i-- {
+		b := blocks[i]
+		binary.BigEndian.PutUint16(p.data[b.offset:], p.header.FreeBlock)
+		binary.BigEndian.PutUint16(p.data[b.offset+2:], uint16(b.size))
+		p.header.FreeBlock = uint16(b.offset)
+	}
+}
+
+// free returns a region of the cell content area to the page. Adjacent free regions are
+// coalesced, regions too small to be a freeblock are counted as fragmented bytes.
+// The region must no longer be referenced by a cell pointer.
+func (p *MemPage) free(offset int, size int) {
+	for i := offset; i < offset+size; i++ {
+		p.data[i] = 0
+	}
+
+	if size < 4 && offset != p.cellsOffset() {
+		if int(p.header.FragmentedFreeBytes)+size > maxFragmentedFreeBytes {
+			p.defragment()
+			return
+		}
+		p.header.FragmentedFreeBytes += byte(size)
+		return
+	}
+
+	blocks := append(p.freeblocks(), freeblock{offset: offset, size: size})
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].offset < blocks[j].offset })
+
+	var merged []freeblock
+	for _, b := range blocks {
+		if n := len(merged); n > 0 && merged[n-1].offset+merged[n-1].size == b.offset {
+			merged[n-1].size += b.size
+			continue
+		}
+		merged = append(merged, b)
+	}
+
+	// A block at the start of the cell content area grows the gap instead.
+	if len(merged) > 0 && merged[0].offset == p.cellsOffset() {
+		p.header.CellsOffset = uint16(merged[0].offset + merged[0].size)
+		merged = merged[1:]
+	}
+
+	p.setFreeblocks(merged)
+}
+
+// addCellToFreeblock places the cell in the first freeblock large enough to hold it.
+// The cell is taken from the end of the freeblock. Returns false if there is no such freeblock,
+// or when the rest of the freeblock would take the fragmented bytes past their limit.
+func (p *MemPage) addCellToFreeblock(data []byte) bool {
+	blocks := p.freeblocks()
+	for i, b := range blocks {
+		if b.size < len(data) {
+			continue
+		}
+
+		remaining := b.size - len(data)
+		if remaining < 4 {
+			if int(p.header.FragmentedFreeBytes)+remaining > maxFragmentedFreeBytes {
+				return false
+			}
+			p.header.FragmentedFreeBytes += byte(remaining)
+			blocks = append(blocks[:i], blocks[i+1:]...)
+		} else {
+			blocks[i].size = remaining
+		}
+
+		cellOffset := b.offset + remaining
+		copy(p.data[cellOffset:], data)
+		p.setFreeblocks(blocks)
+
+		cellPointerOffset := cellPointersStart(p.header.Type, p.pageNumber) + int(2*p.header.NumCells)
+		binary.BigEndian.PutUint16(p.data[cellPointerOffset:], uint16(cellOffset))
+		p.header.NumCells = p.header.NumCells + 1
+		p.updateHeaderData()
+
+		return true
+	}
+
+	return false
+}
+
+// defragment lays out the cells contiguously at the end of the page
+// so that all free space is in the gap.
+func (p *MemPage) defragment() {
+	cells, err := p.Cells()
+	if err != nil {
+		return
+	}
+	p.rebuild(p.header.Type, p.header.RightPage, cells)
+}
+
+// CellBytes returns a copy of the raw bytes of the requested cell.
+func (p *MemPage) CellBytes(cellIndex int) ([]byte, error) {
+	offset := p.cellDataOffset(cellIndex)
+	size, err := p.cellSize(offset)
+	if err != nil {
+		return nil, err
+	}
+
+	cell := make([]byte, size)
+	copy(cell, p.data[offset:offset+size])
+	return cell, nil
+}
+
+// Cells returns a copy of the raw bytes of all cells in the page in order.
+func (p *MemPage) Cells() ([][]byte, error) {
+	cells := make([][]byte, 0, p.CellCount())
+	for i := 0; i < p.CellCount(); i++ {
+		cell, err := p.CellBytes(i)
+		if err != nil {
+			return nil, err
+		}
+		cells = append(cells, cell)
+	}
+	return cells, nil
+}
+
+// ChildPage returns the page number of the child at the specified index of an
+// interior page. The index one past the last cell refers to the right page.
+func (p *MemPage) ChildPage(index int) (int, error) {
+	if index == p.CellCount() {
+		return p.header.RightPage, nil
+	}
+
+	interiorNode, err := p.ReadInteriorNode(index)
+	if err != nil {
+		return 0, err
+	}
+	return int(interiorNode.LeftChild), nil
+}
+
+// CellKey returns the key of the requested cell, the rowid for leaf cells
+// and the largest key of the left child for interior cells.
+func (p *MemPage) CellKey(cellIndex int) (int64, error) {
+	cellDataStart := p.cellDataOffset(cellIndex)
+
+	switch p.header.Type {
+	case PageTypeInternal:
+		node, err := storage.ReadInteriorNode(p.data[cellDataStart:])
+		if err != nil {
+			return 0, err
+		}
+		return node.Key, nil
+	case PageTypeLeaf:
+		_, rowID, err := storage.ReadRecordHeader(bytes.NewReader(p.data[cellDataStart:]))
+		if err != nil {
+			return 0, err
+		}
+		return int64(rowID), nil
+	default:
+		return 0, fmt.Errorf("unsupported page type %d", p.header.Type)
+	}
+}
+
+// Search performs a binary search over the cells of the page and returns
+// the index of the first cell with a key greater than or equal to key and
+// whether the key was found. For interior pages the index is the child
+// that may contain the key.
+func (p *MemPage) Search(key int64) (int, bool, error) {
+	lo, hi := 0, p.CellCount()
+	for lo < hi {
+		mid := (lo + hi) / 2
+		cellKey, err := p.CellKey(mid)
+		if err != nil {
+			return 0, false, err
+		}
+		if cellKey < key {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	if lo < p.CellCount() {
+		cellKey, err := p.CellKey(lo)
+		if err != nil {
+			return 0, false, err
+		}
+		return lo, cellKey == key, nil
+	}
+	return lo, false, nil
+}
+
+// CellEntry reads the fields of the index entry in the requested cell of an index page.
+func (p *MemPage) CellEntry(cellIndex int) ([]*storage.Field, error) {
+	if !p.IsIndex() {
+		return nil, fmt.Errorf("unsupported page type %d", p.header.Type)
+	}
+
+	info, err := p.parseCell(p.cellDataOffset(cellIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := p.payload(info)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.ReadFields(bytes.NewReader(payload))
+}
+
+// SearchEntry performs a binary search over the cells of an index page and returns the
+// index of the first cell with an entry greater than or equal to key, or greater than key
+// when strict, and whether the entry at the index is equal to key. Only as many fields
+// as the key has are compared. For interior pages the index is the child that may
+// contain the key.
+func (p *MemPage) SearchEntry(key []*storage.Field, strict bool) (int, bool, error) {
+	lo, hi := 0, p.CellCount()
+	for lo < hi {
+		mid := (lo + hi) / 2
+		entry, err := p.CellEntry(mid)
+		if err != nil {
+			return 0, false, err
+		}
+		c := storage.CompareFields(entry, key)
+		if c < 0 || (strict && c == 0) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	if lo < p.CellCount() {
+		entry, err := p.CellEntry(lo)
+		if err != nil {
+			return 0, false, err
+		}
+		return lo, storage.CompareFields(entry, key) == 0, nil
+	}
+	return lo, false, nil
+}
+
+// IsIndex determines if the page belongs to an index btree.
+func (p *MemPage) IsIndex() bool {
+	return p.header.Type == PageTypeLeafIndex || p.header.Type == PageTypeInternalIndex
+}
+
+// IsLeaf determines if the page is a leaf page.
+func (p *MemPage) IsLeaf() bool {
+	return p.header.Type == PageTypeLeaf || p.header.Type == PageTypeLeafIndex
+}
+
+// rebuild clears the page and lays out the cells in order.
+// This function assumes that the page can fit all of the cells.
+func (p *MemPage) rebuild(pageType PageType, rightPage int, cells [][]byte) {
+	data := p.data[headerOffset(p.pageNumber):]
+	for i := range data {
+		data[i] = 0
+	}
+
+	header := NewPageHeader(pageType, len(p.data))
+	header.RightPage = rightPage
+	p.SetHeader(header)
+
+	for _, c := range cells {
+		p.AddCell(c)
+	}
+}
+
+// cellsFit determines if a set of cells can be laid out on a single page.
+func cellsFit(pageType PageType, pageNumber int, pageSize int, cells [][]byte) bool {
+	used := cellPointersStart(pageType, pageNumber)
+	for _, c := range cells {
+		used += len(c) + 2
+	}
+	return used <= pageSize
+}
+
+// cellInfo describes the layout of a cell
+type cellInfo struct {
+	// key is the rowid of a table leaf cell or the key of a table interior cell
+	key uint64
+	// payloadSize is the size of the whole payload
+	payloadSize int
+	// localOffset is the offset of the payload stored in the page
+	localOffset int
+	// localSize is the size of the payload stored in the page
+	localSize int
+	// overflowPage is the first page with the rest of the payload, 0 if none
+	overflowPage int
+	// size is the length of the cell in the page
+	size int
+}
+
+// parseCell reads the layout of the cell starting at offset.
+func (p *MemPage) parseCell(offset int) (cellInfo, error) {
+	reader := bytes.NewReader(p.data[offset:])
+	info := cellInfo{}
+
+	switch p.header.Type {
+	case PageTypeInternal:
+		// [Left Child, Key]
+		if _, err := reader.Seek(4, io.SeekStart); err != nil {
+			return info, err
+		}
+		key, n, err := storage.ReadVarint(reader)
+		if err != nil {
+			return info, err
+		}
+		info.key = key
+		info.size = 4 + n
+		return info, nil
+	case PageTypeLeaf:
+		// [Size, Key, Payload]
+		payloadSize, n1, err := storage.ReadVarint(reader)
+		if err != nil {
+			return info, err
+		}
+		key, n2, err := storage.ReadVarint(reader)
+		if err != nil {
+			return info, err
+		}
+		info.key = key
+		info.payloadSize = int(payloadSize)
+		info.localOffset = offset + n1 + n2
+	case PageTypeInternalIndex:
+		// [Left Child, Size, Payload]
+		if _, err := reader.Seek(4, io.SeekStart); err != nil {
+			return info, err
+		}
+		payloadSize, n, err := storage.ReadVarint(reader)
+		if err != nil {
+			return info, err
+		}
+		info.payloadSize = int(payloadSize)
+		info.localOffset = offset + 4 + n
+	case PageTypeLeafIndex:
+		// [Size, Payload]
+		payloadSize, n, err := storage.ReadVarint(reader)
+		if err != nil {
+			return info, err
+		}
+		info.payloadSize = int(payloadSize)
+		info.localOffset = offset + n
+	default:
+		return info, fmt.Errorf("unsupported page type %d", p.header.Type)
+	}
+
+	// A large payload ends with the first overflow page
+	info.localSize = localPayloadSize(p.header.Type, len(p.data), info.payloadSize)
+	info.size = info.localOffset - offset + info.localSize
+	if info.localSize < info.payloadSize {
+		overflowOffset := info.localOffset + info.localSize
+		if overflowOffset+4 > len(p.data) {
+			return info, errors.New("malformed cell")
+		}
+		info.overflowPage = int(binary.BigEndian.Uint32(p.data[overflowOffset:]))
+		info.size += 4
+	}
+
+	return info, nil
+}
+
+// payload reads the whole payload of a cell, including the part in overflow pages.
+func (p *MemPage) payload(info cellInfo) ([]byte, error) {
+	local := p.data[info.localOffset : info.localOffset+info.localSize]
+	if info.overflowPage == 0 {
+		return local, nil
+	}
+
+	if p.reader == nil {
+		return nil, fmt.Errorf("unable to read overflow page %d", info.overflowPage)
+	}
+	overflow, err := readOverflow(p.reader, info.overflowPage, info.payloadSize-info.localSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]byte{}, local...), overflow...), nil
+}
+
+// cellSize determines the length of the cell starting at offset.
+func (p *MemPage) cellSize(offset int) (int, error) {
+	info, err := p.parseCell(offset)
+	if err != nil {
+		return 0, err
+	}
+	return info.size, nil
+}
+
 // 更新页头
 func (p *MemPage) updateHeaderData() {
 	headerOffset := headerOffset(p.pageNumber)
@@ -30,3 +30,43 @@ func TestMemPage_AddCell_InteriorNode(t *testing.T) {
 		assert.Equal(cellBytes, page.data[page.header.CellsOffset:int(page.header.CellsOffset)+len(cellBytes)])
 	}
 }
+
+func TestMemPage_FragmentedFreeBytes(t *testing.T) {
+	assert := require.New(t)
+	page := blankMemPage(PageTypeInternal)
+
+	// Fill the page with 8 byte cells
+	wide, err := storage.InteriorNode{LeftChild: 2, Key: 1 << 21}.ToBytes()
+	assert.NoError(err)
+	assert.Len(wide, 8)
+	for page.Fits(len(wide)) {
+		page.AddCell(wide)
+	}
+
+	// Freeblocks of every other cell leave 3 fragmented bytes behind a 5 byte cell
+	for i := page.CellCount() - 2; i >= 0; i -= 2 {
+		assert.NoError(page.RemoveCell(i))
+	}
+	narrow, err := storage.InteriorNode{LeftChild: 3, Key: 1}.ToBytes()
+	assert.NoError(err)
+	assert.Len(narrow, 5)
+
+	cellCount := page.CellCount()
+	for i := 0; i < 40; i++ {
+		page.AddCell(narrow)
+		assert.LessOrEqual(int(page.header.FragmentedFreeBytes), maxFragmentedFreeBytes)
+	}
+	assert.Equal(0, int(page.header.FragmentedFreeBytes)%3)
+	assert.Less(int(page.header.FragmentedFreeBytes), maxFragmentedFreeBytes)
+	assert.Equal(cellCount+40, page.CellCount())
+
+	cells, err := page.Cells()
+	assert.NoError(err)
+	for i, cell := range cells {
+		if i < cellCount {
+			assert.Equal(wide, cell)
+		} else {
+			assert.Equal(narrow, cell)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+)
+
+// A payload too large to be stored in a btree page keeps its first bytes in the cell
+// followed by the number of the first overflow page. The rest of the payload is stored
+// in a chain of overflow pages, each starts with the number of the next page in the
+// chain, or zero for the last page, followed by as much of the payload as fits.
+//
+// The number of bytes kept in the cell is determined by the payload size alone so that
+// it can be computed when reading a cell. See localPayloadSize.
+
+// overflowHeaderLen is the length of the pointer to the next page of an overflow page
+const overflowHeaderLen = 4
+
+// maxLocal is the largest payload stored entirely in a cell of the page type.
+func maxLocal(pageType PageType, usableSize int) int {
+	if pageType == PageTypeLeaf {
+		return usableSize - 35
+	}
+	return (usableSize-12)*storage.MaxEmbeddedPayloadFraction/255 - 23
+}
+
+// minLocal is the smallest part of a payload stored in a cell of the page type
+// when the payload spills to overflow pages.
+func minLocal(pageType PageType, usableSize int) int {
+	if pageType == PageTypeLeaf {
+		return (usableSize-12)*storage.LeafPayloadFraction/255 - 23
+	}
+	return (usableSize-12)*storage.MinEmbeddedPayloadFraction/255 - 23
+}
+
+// localPayloadSize is the number of bytes of a payload stored in a cell of the page type.
+// A large payload keeps as many bytes as possible in the cell while filling the last
+// overflow page completely.
+func localPayloadSize(pageType PageType, usableSize int, payloadSize int) int {
+	if payloadSize <= maxLocal(pageType, usableSize) {
+		return payloadSize
+	}
+
+	min := minLocal(pageType, usableSize)
+	surplus := min + (payloadSize-min)%(usableSize-overflowHeaderLen)
+	if surplus <= maxLocal(pageType, usableSize) {
+		return surplus
+	}
+	return min
+}
+
+// payloadCell makes a cell of the page type from the prefix of the cell and the payload.
+// The part of the payload which doesn't fit in the cell is written to overflow pages.
+// [Prefix, Local Payload, First Overflow Page]
+func payloadCell(p Pager, pageType PageType, usableSize int, prefix []byte, payload []byte) ([]byte, error) {
+	local := localPayloadSize(pageType, usableSize, len(payload))
+
+	buf := bytes.Buffer{}
+	buf.Write(prefix)
+	buf.Write(payload[:local])
+
+	if local < len(payload) {
+		overflowPage, err := writeOverflow(p, usableSize, payload[local:])
+		if err != nil {
+			return nil, err
+		}
+		if err := binary.Write(&buf, binary.BigEndian, uint32(overflowPage)); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeOverflow stores data in a chain of new overflow pages and returns the first page.
+func writeOverflow(p Pager, usableSize int, data []byte) (int, error) {
+	contentSize := usableSize - overflowHeaderLen
+
+	var pages []*MemPage
+	for offset := 0; offset < len(data); offset += contentSize {
+		page, err := p.Allocate(PageTypeOverflow)
+		if err != nil {
+			return 0, err
+		}
+		pages = append(pages, page)
+	}
+
+	for i, page := range pages {
+		next := 0
+		if i+1 < len(pages) {
+			next = pages[i+1].Number()
+		}
+
+		content := data[i*contentSize:]
+		if len(content) > contentSize {
+			content = content[:contentSize]
+		}
+		page.setOverflow(next, content)
+	}
+
+	if err := p.Write(pages...); err != nil {
+		return 0, err
+	}
+
+	return pages[0].Number(), nil
+}
+
+// readOverflow reads size bytes from the chain of overflow pages starting at pageNumber.
+func readOverflow(r PageReader, pageNumber int, size int) ([]byte, error) {
+	data := make([]byte, 0, size)
+	for len(data) < size {
+		if pageNumber == 0 {
+			return nil, errors.New("overflow chain ends before the payload")
+		}
+
+		page, err := r.Read(pageNumber)
+		if err != nil {
+			return nil, err
+		}
+
+		content := page.data[overflowHeaderLen:]
+		if remaining := size - len(data); len(content) > remaining {
+			content = content[:remaining]
+		}
+		data = append(data, content...)
+		pageNumber = int(binary.BigEndian.Uint32(page.data))
+	}
+
+	return data, nil
+}
+
+// overflowPages returns the overflow pages of the cell at cellIndex in the order of the chain.
+func (p *MemPage) overflowPages(cellIndex int) ([]int, error) {
+	info, err := p.parseCell(p.cellDataOffset(cellIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	var pages []int
+	for pageNumber := info.overflowPage; pageNumber != 0; {
+		if p.reader == nil {
+			return nil, fmt.Errorf("unable to read overflow page %d", pageNumber)
+		}
+		page, err := p.reader.Read(pageNumber)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, pageNumber)
+		pageNumber = int(binary.BigEndian.Uint32(page.data))
+	}
+
+	return pages, nil
+}
+
+// setOverflow makes the page an overflow page with the content and the next page of the chain.
+func (p *MemPage) setOverflow(next int, content []byte) {
+	for i := range p.data {
+		p.data[i] = 0
+	}
+	binary.BigEndian.PutUint32(p.data, uint32(next))
+	copy(p.data[overflowHeaderLen:], content)
+
+	p.header = PageHeader{Type: PageTypeOverflow}
+	p.markDirty()
+}
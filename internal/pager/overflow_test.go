@@ -0,0 +1,158 @@
+package pager
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalPayloadSize(t *testing.T) {
+	assert := require.New(t)
+
+	// Stored in the cell up to the largest local payload
+	assert.Equal(4061, localPayloadSize(PageTypeLeaf, 4096, 4061))
+	assert.Equal(1002, localPayloadSize(PageTypeLeafIndex, 4096, 1002))
+	assert.Equal(1002, localPayloadSize(PageTypeInternalIndex, 4096, 1002))
+
+	// The remainder fills the last overflow page when it fits the cell
+	assert.Equal(489+(10000-489)%4092, localPayloadSize(PageTypeLeaf, 4096, 10000))
+	assert.Equal(489+(5000-489)%4092, localPayloadSize(PageTypeLeafIndex, 4096, 5000))
+
+	// Otherwise the least is stored in the cell
+	assert.Equal(489, localPayloadSize(PageTypeLeaf, 4096, 4062))
+	assert.Equal(489, localPayloadSize(PageTypeLeafIndex, 4096, 1003))
+}
+
+func TestBTreeTable_Overflow(t *testing.T) {
+	assert := require.New(t)
+
+	file := storage.NewMemoryFile(512)
+	assert.NoError(Initialize(file))
+	p := NewPager(file)
+
+	text := func(key int, size int) string {
+		return strings.Repeat(string(rune('a'+key%26)), size)
+	}
+
+	// Records from a few bytes up to several pages in random order
+	const total = 300
+	random := rand.New(rand.NewSource(1))
+	sizes := make(map[int]int, total)
+	tree := NewBTreeTable(1, p)
+	for _, i := range random.Perm(total) {
+		key := i + 1
+		sizes[key] = random.Intn(3000)
+		assert.NoError(tree.Insert(storage.NewRecord(int64(key), []*storage.Field{
+			{Type: storage.Integer, Data: key},
+			{Type: storage.Text, Data: text(key, sizes[key])},
+		})))
+	}
+
+	// A record larger than a page spills to overflow pages
+	page, err := p.Read(1)
+	assert.NoError(err)
+	for !page.IsLeaf() {
+		page, err = p.Read(page.header.RightPage)
+		assert.NoError(err)
+	}
+	var overflow []int
+	for i := 0; i < page.CellCount(); i++ {
+		pages, err := page.overflowPages(i)
+		assert.NoError(err)
+		overflow = append(overflow, pages...)
+	}
+	assert.NotEmpty(overflow)
+
+	// Replace records with a different size and delete every third
+	for key := 1; key <= total; key++ {
+		if key%3 == 0 {
+			deleted, err := tree.Delete(int64(key))
+			assert.NoError(err)
+			assert.True(deleted)
+			delete(sizes, key)
+			continue
+		}
+		if key%5 == 0 {
+			sizes[key] = random.Intn(3000)
+			assert.NoError(tree.Insert(storage.NewRecord(int64(key), []*storage.Field{
+				{Type: storage.Integer, Data: key},
+				{Type: storage.Text, Data: text(key, sizes[key])},
+			})))
+		}
+	}
+
+	cursor, err := NewCursor(p, CURSOR_READ, 1, "test")
+	assert.NoError(err)
+
+	count := 0
+	hasMore, err := cursor.Rewind()
+	assert.NoError(err)
+	for hasMore {
+		record, err := cursor.CurrentCell()
+		assert.NoError(err)
+		key := int(record.RowID)
+		assert.Equal(key, record.Fields[0].Data)
+		assert.Equal(text(key, sizes[key]), record.Fields[1].Data)
+		count++
+
+		hasMore, err = cursor.Next()
+		assert.NoError(err)
+	}
+	assert.Equal(len(sizes), count)
+}
+
+func TestBTreeIndex_Overflow(t *testing.T) {
+	assert := require.New(t)
+
+	file := storage.NewMemoryFile(512)
+	assert.NoError(Initialize(file))
+	p := NewPager(file)
+
+	root, err := p.Allocate(PageTypeLeafIndex)
+	assert.NoError(err)
+
+	// Entries sharing a long prefix are compared in full
+	entry := func(i int) []*storage.Field {
+		return []*storage.Field{
+			{Type: storage.Text, Data: strings.Repeat("x", 1000) + string(rune('a'+i%26))},
+			{Type: storage.Integer, Data: i},
+		}
+	}
+
+	const total = 200
+	tree := NewBTreeIndex(root.Number(), p)
+	for _, i := range rand.New(rand.NewSource(1)).Perm(total) {
+		assert.NoError(tree.Insert(entry(i)))
+	}
+
+	cursor, err := NewCursor(p, CURSOR_READ, root.Number(), "test")
+	assert.NoError(err)
+
+	var previous []*storage.Field
+	count := 0
+	hasMore, err := cursor.Rewind()
+	assert.NoError(err)
+	for hasMore {
+		e, err := cursor.Entry()
+		assert.NoError(err)
+		if previous != nil {
+			assert.Negative(storage.CompareFields(previous, e))
+		}
+		previous = e
+		count++
+
+		hasMore, err = cursor.Next()
+		assert.NoError(err)
+	}
+	assert.Equal(total, count)
+
+	found, err := cursor.SeekIndexGE(entry(30))
+	assert.NoError(err)
+	assert.True(found)
+	rowID, err := cursor.RowID()
+	assert.NoError(err)
+	assert.Equal(int64(30), rowID)
+}
@@ -0,0 +1,126 @@
+package pager
+
+import "container/list"
+
+// pageSet holds the pages of a connection: the pages changed by its transaction and
+// copies of recently read committed pages. The least recently used clean pages are
+// evicted once it's over capacity, dirty and pinned pages are never evicted.
+//
+// 连接的页集合，按 LRU 淘汰干净且未被固定的页
+type pageSet struct {
+	capacity int                   // 容量(页数)
+	entries  map[int]*list.Element // 页号 => 干净页的链表节点
+	lru      *list.List            // 最近使用的干净页在前
+	dirty    map[int]*MemPage      // 页号 => 脏页，刷盘或重置前一直保留
+}
+
+func newPageSet(capacity int) *pageSet {
+	return &pageSet{
+		capacity: capacity,
+		entries:  make(map[int]*list.Element),
+		lru:      list.New(),
+		dirty:    make(map[int]*MemPage),
+	}
+}
+
+// get finds a page and marks it as recently used.
+func (s *pageSet) get(pageNumber int) (*MemPage, bool) {
+	if page, ok := s.dirty[pageNumber]; ok {
+		return page, true
+	}
+
+	e, ok := s.entries[pageNumber]
+	if !ok {
+		return nil, false
+	}
+
+	s.lru.MoveToFront(e)
+	return e.Value.(*MemPage), true
+}
+
+// put adds the page in place of any other version of it, other pages are evicted
+// when the set is over capacity.
+func (s *pageSet) put(page *MemPage) {
+	page.set = s
+	s.remove(page.Number())
+
+	// Dirty pages are kept apart so eviction only looks at clean pages
+	if page.dirty {
+		s.dirty[page.Number()] = page
+		return
+	}
+
+	s.entries[page.Number()] = s.lru.PushFront(page)
+	s.evict()
+}
+
+// restore adds a page which was just changed, it may have been evicted while clean
+// or still be in the set with the clean pages.
+func (s *pageSet) restore(page *MemPage) {
+	if s.dirty[page.Number()] == page {
+		return
+	}
+	s.put(page)
+}
+
+// remove forgets the page.
+func (s *pageSet) remove(pageNumber int) {
+	delete(s.dirty, pageNumber)
+	if e, ok := s.entries[pageNumber]; ok {
+		s.lru.Remove(e)
+		delete(s.entries, pageNumber)
+	}
+}
+
+// each calls fn for every page.
+func (s *pageSet) each(fn func(page *MemPage)) {
+	for _, page := range s.dirty {
+		fn(page)
+	}
+	for e := s.lru.Front(); e != nil; e = e.Next() {
+		fn(e.Value.(*MemPage))
+	}
+}
+
+// markClean marks the dirty pages as clean once they're written, they can be evicted
+// from then on.
+func (s *pageSet) markClean() {
+	for pageNumber, page := range s.dirty {
+		page.dirty = false
+		s.entries[pageNumber] = s.lru.PushFront(page)
+	}
+	s.dirty = make(map[int]*MemPage)
+	s.evict()
+}
+
+// clear forgets every page.
+func (s *pageSet) clear() {
+	s.entries = make(map[int]*list.Element)
+	s.lru.Init()
+	s.dirty = make(map[int]*MemPage)
+}
+
+// resize changes the capacity, pages are evicted right away when it shrinks.
+func (s *pageSet) resize(capacity int) {
+	s.capacity = capacity
+	s.evict()
+}
+
+// len is the number of pages.
+func (s *pageSet) len() int {
+	return len(s.dirty) + s.lru.Len()
+}
+
+// evict removes the least recently used clean pages which aren't pinned until the clean
+// pages are within the capacity.
+func (s *pageSet) evict() {
+	e := s.lru.Back()
+	for s.lru.Len() > s.capacity && e != nil {
+		prev := e.Prev()
+		if page := e.Value.(*MemPage); page.pins == 0 {
+			s.lru.Remove(e)
+			delete(s.entries, page.Number())
+		}
+		e = prev
+	}
+}
@@ -2,6 +2,8 @@ package pager
 
 import (
 	"fmt"
+	"sort"
+
 	"github.com/joeandaverde/tinydb/internal/storage"
 )
 
@@ -9,12 +11,16 @@ import (
 // PageReader 读取页
 type PageReader interface {
 	Read(page int) (*MemPage, error)
+	PageSize() int
 }
 
 // PageWriter 写入页/刷新页
 type PageWriter interface {
 	Write(pages ...*MemPage) error
 	Allocate(PageType) (*MemPage, error)
+	Free(pageNumber int) error
+	Vacuum(pageSize int) error
+	Checkpoint(mode storage.CheckpointMode) (storage.CheckpointResult, error)
 	Flush() error
 	Reset()
 }
@@ -23,12 +29,27 @@ type PageWriter interface {
 type Pager interface {
 	PageReader
 	PageWriter
+
+	// BeginRead starts reading a snapshot of the database, read transactions nest.
+	BeginRead() error
+	EndRead()
+	// BeginWrite waits for other writers, the snapshot must be the latest.
+	BeginWrite() error
+	EndWrite()
+
+	// CacheSize is the size of the buffer pool, in pages when positive and in KiB when negative.
+	CacheSize() int
+	SetCacheSize(size int)
+	CacheStats() CacheStats
 }
 
 type pager struct {
 	pageCount int					// 页总数
-	pageCache map[int]*MemPage		// 页缓存
+	pool *BufferPool				// 共享的缓冲池
+	pages *pageSet					// 事务修改的页和最近读取的页
 	file storage.File				// 底层文件
+	readers int						// 嵌套的读事务数
+	writing bool					// 是否在写事务中
 }
 
 func Initialize(file storage.File) error {
@@ -42,13 +63,35 @@ func Initialize(file storage.File) error {
 	return file.Write(storage.Page{PageNumber: 1, Data: newPage.data})
 }
 
-//
+// NewPager makes a pager with a buffer pool of its own.
 func NewPager(file storage.File) Pager {
-	return &pager{
+	return NewPoolPager(file, NewBufferPool(DefaultCacheSize, file.PageSize(), snapshot(file)))
+}
+
+// NewPoolPager makes a pager for a connection which shares the buffer pool with the other
+// connections to the database.
+func NewPoolPager(file storage.File, pool *BufferPool) Pager {
+	// Files without transactions are always written to
+	_, transactional := file.(storage.SnapshotFile)
+
+	p := &pager{
 		pageCount: file.TotalPages(),
-		pageCache: make(map[int]*MemPage),
+		pool:      pool,
+		pages:     newPageSet(minCachePages),
 		file:      file,
 	}
+	if !transactional {
+		p.writing = true
+	}
+	return p
+}
+
+// snapshot identifies the committed state read from the file.
+func snapshot(file storage.File) uint64 {
+	if file, ok := file.(storage.SnapshotFile); ok {
+		return file.Snapshot()
+	}
+	return 0
 }
 
 // Read reads a full page from cache or the page source
@@ -60,37 +103,39 @@ func (p *pager) Read(pageNumber int) (*MemPage, error) {
 		return nil, fmt.Errorf("page [%d] out of bounds", pageNumber)
 	}
 
-	// 检查页是否在缓存中，若在直接返回
-	if tablePage, ok := p.pageCache[pageNumber]; ok {
-		return tablePage, nil
-	}
-
-	// Ensure the page hasn't been retrieved into the cache since releasing the read lock
-	// 检查页是否在缓存中，若在直接返回
-	if tablePage, ok := p.pageCache[pageNumber]; ok {
+	// 检查页是否在页集合中，若在直接返回
+	if tablePage, ok := p.pages.get(pageNumber); ok {
 		return tablePage, nil
 	}
 
-	// Read raw page data from the source
-	// 读取页
-	data, err := p.file.Read(pageNumber)
+	// Read raw page data from the buffer pool or the source
+	// 从缓冲池或文件读取页
+	data, err := p.pool.read(pageNumber, snapshot(p.file), func() ([]byte, error) {
+		return p.file.Read(pageNumber)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Parse bytes to a page
+	// Parse bytes to a page, the committed version is shared so changes are made to a copy
 	// 解析页
-	page, err := FromBytes(pageNumber, data)
+	page, err := FromBytes(pageNumber, append([]byte(nil), data...))
 	if err != nil {
 		return nil, err
 	}
+	page.reader = p
 
-	// Cache the result for later reads
+	// Keep the page for later reads
 	// 缓存页
-	p.pageCache[pageNumber] = page
+	p.pages.put(page)
 
 	// 返回页
-	return p.pageCache[pageNumber], nil
+	return page, nil
+}
+
+// PageSize is the size of the pages of the database
+func (p *pager) PageSize() int {
+	return p.file.PageSize()
 }
 
 // Write updates pages in the pager
@@ -98,7 +143,7 @@ func (p *pager) Read(pageNumber int) (*MemPage, error) {
 // 将页(列表)写入到缓存中
 func (p *pager) Write(pages ...*MemPage) error {
 	for _, pg := range pages {
-		p.pageCache[pg.Number()] = pg
+		p.pages.put(pg)
 	}
 	return nil
 }
@@ -108,43 +153,148 @@ func (p *pager) Write(pages ...*MemPage) error {
 //
 func (p *pager) Flush() error {
 	var dirtyPages []storage.Page
-	var dirtyMemPages []*MemPage
 
 	// 遍历所有缓存页，获取所有脏页
-	for _, page := range p.pageCache {
+	p.pages.each(func(page *MemPage) {
 		if !page.dirty {
-			continue
+			return
 		}
 		dirtyPages = append(dirtyPages, storage.Page{PageNumber: page.pageNumber, Data: page.data})
-		dirtyMemPages = append(dirtyMemPages, page)
-	}
+	})
+
+	// A file grows one page at a time so pages are written in order
+	sort.Slice(dirtyPages, func(i, j int) bool {
+		return dirtyPages[i].PageNumber < dirtyPages[j].PageNumber
+	})
 
-	// 将脏页刷盘
+	// 将脏页刷盘，提交后其它连接立即可见
 	if len(dirtyPages) > 0 {
+		p.pool.beginCommit(dirtyPages)
 		if err := p.file.Write(dirtyPages...); err != nil {
+			p.pool.abortCommit()
 			return err
 		}
+		p.pool.endCommit(dirtyPages, snapshot(p.file))
 		p.pageCount = p.file.TotalPages()
 	}
 
-	// 将脏页标记重置
-	for _, p := range dirtyMemPages {
-		p.dirty = false
+	// 将脏页标记重置，之后可以被淘汰
+	p.pages.markClean()
+
+	return nil
+}
+
+// Checkpoint copies the committed pages in the log of the file to the database file.
+// The frame counts are -1 when the file has no log.
+func (p *pager) Checkpoint(mode storage.CheckpointMode) (storage.CheckpointResult, error) {
+	file, ok := p.file.(storage.CheckpointFile)
+	if !ok {
+		return storage.CheckpointResult{LogFrames: -1, Checkpointed: -1}, nil
 	}
 
+	return file.Checkpoint(mode)
+}
+
+// BeginRead starts a read transaction, pages are read from a snapshot of the committed
+// state of the database until the outermost EndRead. Cached pages are discarded when
+// other connections committed since the previous snapshot.
+func (p *pager) BeginRead() error {
+	p.readers++
+	if p.readers > 1 {
+		return nil
+	}
+
+	file, ok := p.file.(storage.SnapshotFile)
+	if !ok {
+		return nil
+	}
+	changed, err := file.BeginRead()
+	if err != nil {
+		p.readers--
+		return err
+	}
+	if changed {
+		p.pages.clear()
+	}
+	p.pageCount = p.file.TotalPages()
+
 	return nil
 }
 
+// EndRead ends a read transaction.
+func (p *pager) EndRead() {
+	if p.readers == 0 {
+		return
+	}
+	p.readers--
+
+	if file, ok := p.file.(storage.SnapshotFile); ok && p.readers == 0 {
+		file.EndRead()
+	}
+}
+
+// BeginWrite starts a write transaction, changes are written to the file by Flush.
+// Changed pages are kept until they're flushed or reset, a page evicted while clean is
+// restored when it's changed.
+func (p *pager) BeginWrite() error {
+	file, ok := p.file.(storage.SnapshotFile)
+	if !ok || p.writing {
+		return nil
+	}
+	if err := file.BeginWrite(); err != nil {
+		return err
+	}
+
+	p.writing = true
+	p.pages.clear()
+
+	return nil
+}
+
+// EndWrite ends a write transaction, changes which weren't flushed must be Reset.
+func (p *pager) EndWrite() {
+	file, ok := p.file.(storage.SnapshotFile)
+	if !ok || !p.writing {
+		return
+	}
+	file.EndWrite()
+
+	p.writing = false
+	p.pages.clear()
+}
+
 // Reset clears all dirty pages
 //
 // 将脏页标记重置
 func (p *pager) Reset() {
 	p.pageCount = p.file.TotalPages()
-	for k, page := range p.pageCache {
+
+	var dirtyPages []int
+	p.pages.each(func(page *MemPage) {
 		if page.dirty {
-			delete(p.pageCache, k)
+			dirtyPages = append(dirtyPages, page.Number())
 		}
+	})
+	for _, pageNumber := range dirtyPages {
+		p.pages.remove(pageNumber)
 	}
+	p.pages.evict()
+}
+
+// CacheSize is the cache size of the buffer pool.
+func (p *pager) CacheSize() int {
+	return p.pool.CacheSize()
+}
+
+// SetCacheSize sets the cache size of the buffer pool, shared pools are resized for
+// every connection.
+func (p *pager) SetCacheSize(size int) {
+	p.pool.SetCacheSize(size)
+}
+
+// CacheStats counts the reads served by the buffer pool and the pages it evicted.
+func (p *pager) CacheStats() CacheStats {
+	return p.pool.Stats()
 }
 
 // Allocate allocates a new dirty page in the pager.
@@ -163,30 +313,41 @@ func (p *pager) Reset() {
 //    sql text
 // );
 //
+// Pages on the freelist are reused before the file grows.
+//
 // 分配一个新页。
 //
 //
 //
 func (p *pager) Allocate(pageType PageType) (*MemPage, error) {
-	// 更新页计数 +1
-	p.pageCount = p.pageCount + 1
+	pageNumber, err := p.takeFreePage()
+	if err != nil {
+		return nil, err
+	}
+
+	if pageNumber == 0 {
+		// 更新页计数 +1
+		p.pageCount = p.pageCount + 1
+		pageNumber = p.pageCount
+	}
 
 	// 创建内存页
 	newPage := &MemPage{
 		header:     NewPageHeader(pageType, p.file.PageSize()),	// 页头
-		pageNumber: p.pageCount,								// 页号
+		pageNumber: pageNumber,									// 页号
 		data:       make([]byte, p.file.PageSize()),			// 数据
 		dirty:      true,										// 脏页标记
+		reader:     p,
 	}
 
 	// 更新页头
 	newPage.updateHeaderData()
 
 	// 缓存页
-	p.pageCache[p.pageCount] = newPage
+	p.pages.put(newPage)
 
 	// 返回页
-	return p.pageCache[p.pageCount], nil
+	return newPage, nil
 }
 
 var _ Pager = (*pager)(nil)
@@ -82,6 +82,137 @@ func (s *PagerTestSuite) TestPager_Reset_PersistedPage() {
 	s.Equal(expectedData, actualPageOne.data)
 }
 
+func (s *PagerTestSuite) TestPager_BufferPool() {
+	file := storage.NewMemoryFile(testPageSize)
+	pool := NewBufferPool(10, testPageSize, 0)
+	writer := NewPoolPager(file, pool)
+
+	// Committed pages are cached once for every pager
+	for i := 0; i < 30; i++ {
+		_, err := writer.Allocate(PageTypeLeaf)
+		s.NoError(err)
+	}
+	s.NoError(writer.Flush())
+	s.Equal(10, pool.lru.Len())
+
+	before := pool.Stats()
+	reader := NewPoolPager(file, pool)
+	page, err := reader.Read(30)
+	s.NoError(err)
+	s.Equal(before.Hits+1, pool.Stats().Hits)
+	s.Equal(0, page.CellCount())
+
+	// Changes are made to a copy until they're committed
+	page, err = writer.Read(30)
+	s.NoError(err)
+	page.AddCell([]byte{0xB, 0xE, 0xE, 0xF})
+	s.NoError(writer.Write(page))
+
+	page, err = NewPoolPager(file, pool).Read(30)
+	s.NoError(err)
+	s.Equal(0, page.CellCount())
+
+	s.NoError(writer.Flush())
+	before = pool.Stats()
+	page, err = NewPoolPager(file, pool).Read(30)
+	s.NoError(err)
+	s.Equal(1, page.CellCount())
+	s.Equal(before.Hits+1, pool.Stats().Hits)
+
+	// A scan evicts the least recently used pages
+	scan := func() {
+		p := NewPoolPager(file, pool)
+		for pageNumber := 1; pageNumber <= 30; pageNumber++ {
+			_, err := p.Read(pageNumber)
+			s.NoError(err)
+		}
+	}
+	scan()
+	before = pool.Stats()
+	scan()
+	stats := pool.Stats()
+	s.Equal(before.Misses+30, stats.Misses)
+	s.Equal(before.Evictions+30, stats.Evictions)
+	s.Equal(10, pool.lru.Len())
+
+	// Shrinking the pool evicts right away, a negative size is in KiB
+	reader.SetCacheSize(-80)
+	s.Equal(-80, writer.CacheSize())
+	s.Equal(20, pool.capacity)
+	reader.SetCacheSize(1)
+	s.Equal(10, pool.lru.Len())
+}
+
+func (s *PagerTestSuite) TestPager_EvictWhileWriting() {
+	p := s.pager.(*pager)
+	for i := 0; i < 30; i++ {
+		_, err := p.Allocate(PageTypeLeaf)
+		s.NoError(err)
+	}
+	s.Equal(30, p.pages.len())
+	s.NoError(p.Flush())
+	s.Equal(minCachePages, p.pages.len())
+
+	// Clean pages are evicted while dirty pages are kept
+	first, err := p.Read(1)
+	s.NoError(err)
+	for pageNumber := 2; pageNumber <= 30; pageNumber++ {
+		page, err := p.Read(pageNumber)
+		s.NoError(err)
+		if pageNumber%10 == 0 {
+			page.AddCell([]byte{0xB, 0xE, 0xE, 0xF})
+		}
+	}
+	s.Len(p.pages.dirty, 3)
+	s.LessOrEqual(p.pages.lru.Len(), minCachePages)
+	_, ok := p.pages.get(1)
+	s.False(ok)
+
+	// An evicted page is cached again when it's changed
+	first.AddCell([]byte{0xD, 0xE, 0xA, 0xD})
+	s.NoError(p.Flush())
+	for _, pageNumber := range []int{1, 10, 20, 30} {
+		page, err := NewPager(p.file).Read(pageNumber)
+		s.NoError(err)
+		s.Equal(1, page.CellCount())
+	}
+}
+
+func (s *PagerTestSuite) TestPager_CursorPins() {
+	p := s.pager.(*pager)
+	root, err := p.Allocate(PageTypeLeaf)
+	s.NoError(err)
+	tree := NewBTreeTable(root.Number(), p)
+	for i := 1; i <= 2000; i++ {
+		s.NoError(tree.Insert(storage.NewRecord(int64(i), []*storage.Field{{Type: storage.Text, Data: "abcdefghijklmnopqrstuvwxyz"}})))
+	}
+	s.NoError(p.Flush())
+
+	cursor, err := NewCursor(p, CURSOR_READ, root.Number(), "pins")
+	s.NoError(err)
+	found, err := cursor.SeekRowID(1000)
+	s.NoError(err)
+	s.True(found)
+	s.NotEmpty(cursor.stack)
+
+	// The pages above the cursor stay while others are read
+	for pageNumber := 1; pageNumber <= p.pageCount; pageNumber++ {
+		_, err := p.Read(pageNumber)
+		s.NoError(err)
+	}
+	for _, frame := range cursor.stack {
+		page, ok := p.pages.get(frame.page)
+		s.True(ok)
+		s.Equal(1, page.pins)
+	}
+
+	pinned := append([]*MemPage(nil), cursor.pinned...)
+	cursor.Close()
+	for _, page := range pinned {
+		s.Equal(0, page.pins)
+	}
+}
+
 func blankMemPage(pageType PageType) *MemPage {
 	p := &MemPage{
 		header:     NewPageHeader(pageType, testPageSize),
@@ -0,0 +1,176 @@
+package pager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+)
+
+// vacuumSuffix is appended to the path of a database for the file being rebuilt by VACUUM
+const vacuumSuffix = "-vacuum"
+
+// Vacuum rebuilds the database in a new file with the page size, 0 keeps the current
+// page size, then replaces the file of the pager with it. The pager must not have
+// uncommitted changes, cached pages are discarded.
+func (p *pager) Vacuum(pageSize int) error {
+	file, ok := p.file.(storage.ReplaceableFile)
+	if !ok {
+		return errors.New("database file can't be replaced")
+	}
+	if pageSize == 0 {
+		pageSize = p.file.PageSize()
+	}
+
+	// A file left behind by an interrupted VACUUM is of no use
+	path := file.Path() + vacuumSuffix
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	dst, err := storage.OpenDbFile(path, pageSize)
+	if err != nil {
+		return err
+	}
+	if err := copyDatabase(p, dst); err != nil {
+		dst.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := file.Replace(dst); err != nil {
+		return err
+	}
+
+	p.pages.clear()
+	p.pool.reset(p.file.PageSize(), snapshot(p.file))
+	p.pageCount = p.file.TotalPages()
+
+	return nil
+}
+
+// VacuumInto writes a copy of the database to a new file at path with the page size,
+// 0 keeps the current page size.
+func VacuumInto(p Pager, path string, pageSize int) error {
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+		return fmt.Errorf("output file already exists: %s", path)
+	}
+
+	if pageSize == 0 {
+		pageSize = p.PageSize()
+	}
+
+	dst, err := storage.OpenDbFile(path, pageSize)
+	if err != nil {
+		return err
+	}
+	if err := copyDatabase(p, dst); err != nil {
+		dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// copyDatabase copies every btree listed in the schema table to the empty file dst.
+// Records and entries are inserted in key order so the btrees are densely packed
+// and the file has no free pages.
+func copyDatabase(src Pager, dst storage.File) error {
+	if err := Initialize(dst); err != nil {
+		return err
+	}
+	p := NewPager(dst)
+
+	schema, err := NewCursor(src, CURSOR_READ, 1, "vacuum")
+	if err != nil {
+		return err
+	}
+	defer schema.Close()
+	schemaTree := NewBTreeTable(1, p)
+
+	hasMore, err := schema.Rewind()
+	if err != nil {
+		return err
+	}
+	for hasMore {
+		record, err := schema.CurrentCell()
+		if err != nil {
+			return err
+		}
+
+		// The fourth column of the schema table is the root page
+		if len(record.Fields) > 3 {
+			if rootPage, ok := record.Fields[3].Int(); ok && rootPage > 0 {
+				newRootPage, err := copyBTree(src, p, rootPage)
+				if err != nil {
+					return err
+				}
+
+				fields := append([]*storage.Field{}, record.Fields...)
+				fields[3] = &storage.Field{Type: storage.Integer, Data: newRootPage}
+				record = storage.NewRecord(record.RowID, fields)
+			}
+		}
+
+		if err := schemaTree.Insert(record); err != nil {
+			return err
+		}
+
+		hasMore, err = schema.Next()
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.Flush()
+}
+
+// copyBTree copies the table or index btree at rootPage of src to a new btree of dst,
+// returns the root page of the copy.
+func copyBTree(src Pager, dst Pager, rootPage int) (int, error) {
+	cursor, err := NewCursor(src, CURSOR_READ, rootPage, "vacuum")
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close()
+
+	pageType := PageTypeLeaf
+	if cursor.IsIndex() {
+		pageType = PageTypeLeafIndex
+	}
+	root, err := dst.Allocate(pageType)
+	if err != nil {
+		return 0, err
+	}
+	table := NewBTreeTable(root.Number(), dst)
+	index := NewBTreeIndex(root.Number(), dst)
+
+	insert := func() error {
+		if cursor.IsIndex() {
+			entry, err := cursor.Entry()
+			if err != nil {
+				return err
+			}
+			return index.Insert(entry)
+		}
+
+		record, err := cursor.CurrentCell()
+		if err != nil {
+			return err
+		}
+		return table.Insert(record)
+	}
+
+	hasMore, err := cursor.Rewind()
+	for err == nil && hasMore {
+		if err := insert(); err != nil {
+			return 0, err
+		}
+		hasMore, err = cursor.Next()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return root.Number(), nil
+}
@@ -0,0 +1,83 @@
+package pager
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCopyDatabase(t *testing.T) {
+	assert := require.New(t)
+
+	src := storage.NewMemoryFile(512)
+	assert.NoError(Initialize(src))
+	p := NewPager(src)
+
+	root, err := p.Allocate(PageTypeLeaf)
+	assert.NoError(err)
+	assert.NoError(NewBTreeTable(1, p).Insert(storage.NewRecord(1, []*storage.Field{
+		{Type: storage.Text, Data: "table"},
+		{Type: storage.Text, Data: "docs"},
+		{Type: storage.Text, Data: "docs"},
+		{Type: storage.Integer, Data: root.Number()},
+		{Type: storage.Text, Data: "create table docs (body text)"},
+	})))
+
+	// Records of every size with most of them deleted afterwards
+	text := func(key int) string {
+		return strings.Repeat(string(rune('a'+key%26)), 20*(key%100))
+	}
+	tree := NewBTreeTable(root.Number(), p)
+	for key := 1; key <= 500; key++ {
+		assert.NoError(tree.Insert(storage.NewRecord(int64(key), []*storage.Field{
+			{Type: storage.Text, Data: text(key)},
+		})))
+	}
+	for key := 1; key <= 500; key++ {
+		if key%5 != 0 {
+			_, err := tree.Delete(int64(key))
+			assert.NoError(err)
+		}
+	}
+	assert.NoError(p.Flush())
+
+	// The copy has no free pages and may use another page size
+	dst := storage.NewMemoryFile(1024)
+	assert.NoError(copyDatabase(p, dst))
+	assert.Less(dst.TotalPages()*2, src.TotalPages())
+
+	copied := NewPager(dst)
+	header, err := copied.Read(1)
+	assert.NoError(err)
+	_, count := header.freelist()
+	assert.Zero(count)
+
+	schema, err := NewCursor(copied, CURSOR_READ, 1, "test")
+	assert.NoError(err)
+	hasMore, err := schema.Rewind()
+	assert.NoError(err)
+	assert.True(hasMore)
+	record, err := schema.CurrentCell()
+	assert.NoError(err)
+	rootPage, ok := record.Fields[3].Int()
+	assert.True(ok)
+
+	cursor, err := NewCursor(copied, CURSOR_READ, rootPage, "test")
+	assert.NoError(err)
+	key := 0
+	hasMore, err = cursor.Rewind()
+	assert.NoError(err)
+	for hasMore {
+		key += 5
+		record, err := cursor.CurrentCell()
+		assert.NoError(err)
+		assert.Equal(int64(key), record.RowID)
+		assert.Equal(text(key), record.Fields[0].Data)
+
+		hasMore, err = cursor.Next()
+		assert.NoError(err)
+	}
+	assert.Equal(500, key)
+}
@@ -60,6 +60,7 @@ func (s *Server) Handle(conn net.Conn, engine *backend.Engine) {
 	s.log.Infof("connect: %+v", conn.RemoteAddr())
 
 	dbConn := NewConnection(s.log, engine.NewPager(), conn)
+	dbConn.backend.SetSortMemory(engine.SortMemory())
 	defer dbConn.Close()
 
 	// TODO: handle errors gracefully rather than closing connection
@@ -0,0 +1,60 @@
+package storage
+
+import "strings"
+
+// Affinity is the type of value preferred by a column. A value stored in the column is
+// converted to the type when it can be without loss, otherwise it's stored as is.
+// The letters are those SQLite uses for affinities.
+type Affinity byte
+
+const (
+	AffinityBlob    Affinity = 'A' // 不转换
+	AffinityText    Affinity = 'B'
+	AffinityNumeric Affinity = 'C'
+	AffinityInteger Affinity = 'D'
+	AffinityReal    Affinity = 'E'
+)
+
+// AffinityFromType determines the affinity of a column from its declared type
+// like SQLite does, by the first rule that applies:
+//
+//	contains INT                 INTEGER
+//	contains CHAR, CLOB or TEXT  TEXT
+//	contains BLOB or no type     BLOB
+//	contains REAL, FLOA or DOUB  REAL
+//	otherwise                    NUMERIC
+func AffinityFromType(declaredType string) Affinity {
+	t := strings.ToUpper(declaredType)
+	switch {
+	case strings.Contains(t, "INT"):
+		return AffinityInteger
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return AffinityText
+	case strings.Contains(t, "BLOB"), strings.TrimSpace(t) == "":
+		return AffinityBlob
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return AffinityReal
+	}
+	return AffinityNumeric
+}
+
+// IsNumeric determines if the affinity converts text to numbers
+func (a Affinity) IsNumeric() bool {
+	return a == AffinityNumeric || a == AffinityInteger || a == AffinityReal
+}
+
+func (a Affinity) String() string {
+	switch a {
+	case AffinityBlob:
+		return "BLOB"
+	case AffinityText:
+		return "TEXT"
+	case AffinityNumeric:
+		return "NUMERIC"
+	case AffinityInteger:
+		return "INTEGER"
+	case AffinityReal:
+		return "REAL"
+	}
+	return "NONE"
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAffinityFromType(t *testing.T) {
+	assert := require.New(t)
+
+	types := map[string]Affinity{
+		"INT":               AffinityInteger,
+		"integer":           AffinityInteger,
+		"UNSIGNED BIG INT":  AffinityInteger,
+		"varchar(255)":      AffinityText,
+		"NATIVE CHARACTER":  AffinityText,
+		"clob":              AffinityText,
+		"text":              AffinityText,
+		"blob":              AffinityBlob,
+		"":                  AffinityBlob,
+		"real":              AffinityReal,
+		"DOUBLE PRECISION":  AffinityReal,
+		"float":             AffinityReal,
+		"numeric":           AffinityNumeric,
+		"DECIMAL(10,5)":     AffinityNumeric,
+		"boolean":           AffinityNumeric,
+		"datetime":          AffinityNumeric,
+		"FLOATING POINT":    AffinityInteger, // INT comes first
+		"CHARINT":           AffinityInteger,
+		"string":            AffinityNumeric,
+		"BLOB WITH A CHAR":  AffinityText,
+		"DOUBLE CLOB":       AffinityText,
+		"REAL BLOB":         AffinityBlob,
+		"byte":              AffinityNumeric,
+		"TINYINT UNSIGNED":  AffinityInteger,
+		"NVARCHAR(100)":     AffinityText,
+		"numeric(6, 2)":     AffinityNumeric,
+		"double":            AffinityReal,
+		"BIGINT":            AffinityInteger,
+		"MEDIUMINT":         AffinityInteger,
+		"VARYING CHARACTER": AffinityText,
+	}
+	for declared, expected := range types {
+		assert.Equal(expected, AffinityFromType(declared), declared)
+	}
+}
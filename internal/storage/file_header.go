@@ -27,8 +27,46 @@ type FileHeader struct {
 	// Size in pages of the database
 	// 页数目
 	SizeInPages uint32
+
+	// 32-35	FirstFreelistTrunk	uint32	Page number of the first freelist trunk page, zero if the freelist is empty.
+	FirstFreelistTrunk uint32
+
+	// 36-39	FreelistPages	uint32	Total number of freelist pages, trunk pages included.
+	FreelistPages uint32
+
+	// 18-19	FileFormat	uint8	FileFormatLegacy for a rollback journal, FileFormatWAL for a write ahead log.
+	FileFormat uint8
 }
 
+// File format versions in the file header, they tell SQLite where to find committed transactions.
+const (
+	FileFormatLegacy = 1
+	FileFormatWAL    = 2
+)
+
+// Offsets of the freelist fields in the file header,
+// the pager maintains them in the data of page 1.
+const (
+	FreelistTrunkOffset = 32
+	FreelistCountOffset = 36
+)
+
+// Payload fractions determine how much of a record is stored in a btree page, the rest
+// of a large record spills to overflow pages. SQLite requires these exact values.
+const (
+	// MaxEmbeddedPayloadFraction is the largest share of an index page used by a single cell, out of 255.
+	MaxEmbeddedPayloadFraction = 64
+	// MinEmbeddedPayloadFraction is the smallest share of an index page used by a cell with overflow, out of 255.
+	MinEmbeddedPayloadFraction = 32
+	// LeafPayloadFraction is the smallest share of a table leaf page used by a cell with overflow, out of 255.
+	LeafPayloadFraction = 32
+)
+
+// ValidPageSize determines if a page size can be used for a database,
+// it must be a power of two from 1024 to 32768.
+func ValidPageSize(pageSize int) bool {
+	return pageSize >= 1024 && pageSize <= 32768 && pageSize&(pageSize-1) == 0
+}
 
 // NewFileHeader creates a new FileHeader
 func NewFileHeader(pageSize uint16) FileHeader {
@@ -37,6 +75,7 @@ func NewFileHeader(pageSize uint16) FileHeader {
 		FileChangeCounter: 0,
 		SchemaVersion:     1,
 		SizeInPages:       1,
+		FileFormat:        FileFormatLegacy,
 	}
 }
 
@@ -56,23 +95,27 @@ func (h FileHeader) WriteTo(w io.Writer) (int64, error) {
 	// the page-size field are equivalent.
 	binary.BigEndian.PutUint16(data[16:], h.PageSize)
 
+	fileFormat := h.FileFormat
+	if fileFormat == 0 {
+		fileFormat = FileFormatLegacy
+	}
 	// 18	1	File format write version. 1 for legacy; 2 for WAL.
-	data[18] = 1
+	data[18] = fileFormat
 	// 19	1	File format read version. 1 for legacy; 2 for WAL.
-	data[19] = 1
+	data[19] = fileFormat
 	// 20	1	Bytes of unused "reserved" space at the end of each page. Usually 0.
 	data[20] = 0
 	// 21	1	Maximum embedded payload fraction. Must be 64.
-	data[21] = 64
+	data[21] = MaxEmbeddedPayloadFraction
 	// 22	1	Minimum embedded payload fraction. Must be 32.
-	data[22] = 32
+	data[22] = MinEmbeddedPayloadFraction
 	// 23	1	Leaf payload fraction. Must be 32.
-	data[23] = 32
+	data[23] = LeafPayloadFraction
 
 	binary.BigEndian.PutUint32(data[24:], h.FileChangeCounter)
 	binary.BigEndian.PutUint32(data[28:], h.SizeInPages)
-	binary.BigEndian.PutUint32(data[32:], 0)
-	binary.BigEndian.PutUint32(data[36:], 0)
+	binary.BigEndian.PutUint32(data[FreelistTrunkOffset:], h.FirstFreelistTrunk)
+	binary.BigEndian.PutUint32(data[FreelistCountOffset:], h.FreelistPages)
 	binary.BigEndian.PutUint32(data[40:], h.SchemaVersion)
 	binary.BigEndian.PutUint32(data[44:], 4) // Schema format
 	binary.BigEndian.PutUint32(data[48:], 0)
@@ -88,6 +131,26 @@ func (h FileHeader) WriteTo(w io.Writer) (int64, error) {
 	return 100, nil
 }
 
+// checkFileHeader makes sure a database made by SQLite uses no features which aren't supported.
+func checkFileHeader(buf []byte) error {
+	if string(buf[:16]) != "SQLite format 3\000" {
+		return fmt.Errorf("file is not a database")
+	}
+	if pageSize := int(binary.BigEndian.Uint16(buf[16:18])); !ValidPageSize(pageSize) {
+		return fmt.Errorf("unsupported page size %d", pageSize)
+	}
+	if buf[20] != 0 {
+		return fmt.Errorf("unsupported reserved space of %d bytes per page", buf[20])
+	}
+	if encoding := binary.BigEndian.Uint32(buf[56:60]); encoding > 1 {
+		return fmt.Errorf("unsupported text encoding %d, only UTF-8 is supported", encoding)
+	}
+	if binary.BigEndian.Uint32(buf[52:56]) != 0 {
+		return fmt.Errorf("auto-vacuum databases are not supported")
+	}
+	return nil
+}
+
 // ParseFileHeader deserializes a FileHeader
 //
 // 解析文件头
@@ -96,9 +159,12 @@ func ParseFileHeader(buf []byte) (FileHeader, error) {
 		return FileHeader{}, fmt.Errorf("unexpected header length")
 	}
 	return FileHeader{
-		PageSize:          binary.BigEndian.Uint16(buf[16:18]),
-		FileChangeCounter: binary.BigEndian.Uint32(buf[24:28]),
-		SizeInPages:       binary.BigEndian.Uint32(buf[28:32]),
-		SchemaVersion:     binary.BigEndian.Uint32(buf[40:44]),
+		PageSize:           binary.BigEndian.Uint16(buf[16:18]),
+		FileChangeCounter:  binary.BigEndian.Uint32(buf[24:28]),
+		SizeInPages:        binary.BigEndian.Uint32(buf[28:32]),
+		FirstFreelistTrunk: binary.BigEndian.Uint32(buf[FreelistTrunkOffset:]),
+		FreelistPages:      binary.BigEndian.Uint32(buf[FreelistCountOffset:]),
+		SchemaVersion:      binary.BigEndian.Uint32(buf[40:44]),
+		FileFormat:         buf[18],
 	}, nil
 }
@@ -10,7 +10,7 @@ const InteriorNodeSize int = 8
 
 type InteriorNode struct {
 	LeftChild uint32
-	Key       uint32
+	Key       int64
 }
 
 // ToBytes serializes an interior node to a byte slice
@@ -59,5 +59,5 @@ func ReadInteriorNode(data []byte) (*InteriorNode, error) {
 		return nil, err
 	}
 
-	return &InteriorNode{LeftChild: leftChild, Key: uint32(key)}, nil
+	return &InteriorNode{LeftChild: leftChild, Key: int64(key)}, nil
 }
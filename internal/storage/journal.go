@@ -0,0 +1,473 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// JournalSectorSize is the size the journal header is padded to
+	JournalSectorSize = 512
+
+	journalHeaderLen = 28
+)
+
+// journalMagic starts the header of a rollback journal
+var journalMagic = []byte{0xd9, 0xd5, 0x05, 0xf9, 0x20, 0xa1, 0x63, 0xd7}
+
+// JournalMode determines how transactions are committed to the database file
+type JournalMode int
+
+const (
+	// JournalWAL appends transactions to a write ahead log, readers run concurrently with a writer.
+	JournalWAL JournalMode = iota
+	// JournalDelete saves the original pages to a rollback journal before writing to the
+	// database file, the journal is deleted to commit.
+	JournalDelete
+	// JournalTruncate is JournalDelete but the journal is truncated to zero bytes to commit.
+	JournalTruncate
+)
+
+// ParseJournalMode parses the name of a journal mode, an empty name is JournalWAL.
+func ParseJournalMode(name string) (JournalMode, error) {
+	switch strings.ToUpper(name) {
+	case "", "WAL":
+		return JournalWAL, nil
+	case "DELETE":
+		return JournalDelete, nil
+	case "TRUNCATE":
+		return JournalTruncate, nil
+	}
+	return 0, fmt.Errorf("unknown journal mode: %s", name)
+}
+
+// Rollback Journal Header Format
+// Offset	Size	Description
+// 0			8	Magic number. 0xd9 0xd5 0x05 0xf9 0x20 0xa1 0x63 0xd7
+// 8			4	Number of page records in the journal
+// 12			4	Checksum nonce: random number added to the checksum of each record
+// 16			4	Size of the database file in pages before the transaction
+// 20			4	Sector size, the header is padded with zeros to a full sector
+// 24			4	Database page size. Example: 1024
+
+// Rollback Journal Page Record Format
+// Offset	Size	Description
+// 0			4	Page number
+// 4			N	Original content of the page, N is the page size
+// N+4			4	Checksum: the nonce plus every 200th byte of the page
+
+// Journal commits transactions to the database file with a rollback journal. Any number
+// of connections read the database file as long as nobody commits, a commit waits for the
+// readers to finish and keeps new ones out until the pages are written.
+type Journal struct {
+	dbFile *DbFile
+	path   string
+	mode   JournalMode
+
+	mu   *sync.Mutex
+	cond *sync.Cond
+
+	// readers is the number of connections reading the database file
+	readers int
+	// pending is set while a commit waits for the readers to finish or writes the database file
+	pending bool
+	// changes is the number of transactions committed since the journal was opened
+	changes uint64
+
+	// writeSema is held by the connection writing to the database
+	writeSema chan struct{}
+}
+
+// OpenJournal commits the transactions to dbFile with a rollback journal. A hot journal
+// must have been rolled back with RecoverJournal beforehand.
+func OpenJournal(dbFile *DbFile, mode JournalMode) (*Journal, error) {
+	if mode != JournalDelete && mode != JournalTruncate {
+		return nil, errors.New("the journal is only used in DELETE and TRUNCATE journal modes")
+	}
+
+	mu := &sync.Mutex{}
+	return &Journal{
+		dbFile:    dbFile,
+		path:      dbFile.Path() + "-journal",
+		mode:      mode,
+		mu:        mu,
+		cond:      sync.NewCond(mu),
+		writeSema: make(chan struct{}, 1),
+	}, nil
+}
+
+// Connect makes a new connection to the database file.
+func (j *Journal) Connect() *JournalConn {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return &JournalConn{journal: j, changes: j.changes}
+}
+
+// Close closes the database file.
+func (j *Journal) Close() error {
+	return j.dbFile.Close()
+}
+
+// RecoverJournal rolls back the transaction of a hot journal left behind by a commit which
+// didn't finish. The original pages are written back up to the first record whose checksum
+// doesn't match and the database file is truncated to its original size. The journal is
+// removed afterwards, a journal with an invalid header has nothing to roll back.
+func RecoverJournal(dbFile *DbFile) error {
+	path := dbFile.Path() + "-journal"
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if size, pages, ok := parseJournal(data); ok {
+		if err := dbFile.restore(pages, size); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(path)
+}
+
+// parseJournal reads the original size of the database and the original pages from a journal.
+func parseJournal(data []byte) (int, []Page, bool) {
+	if len(data) < JournalSectorSize || !bytes.Equal(data[:8], journalMagic) {
+		return 0, nil, false
+	}
+	records := int(binary.BigEndian.Uint32(data[8:12]))
+	nonce := binary.BigEndian.Uint32(data[12:16])
+	size := int(binary.BigEndian.Uint32(data[16:20]))
+	sectorSize := int(binary.BigEndian.Uint32(data[20:24]))
+	pageSize := int(binary.BigEndian.Uint32(data[24:28]))
+	if sectorSize < journalHeaderLen || !ValidPageSize(pageSize) {
+		return 0, nil, false
+	}
+
+	var pages []Page
+	recordLen := 4 + pageSize + 4
+	for offset := sectorSize; len(pages) < records && offset+recordLen <= len(data); offset += recordLen {
+		record := data[offset : offset+recordLen]
+		pageNumber := int(binary.BigEndian.Uint32(record[0:4]))
+		page := record[4 : 4+pageSize]
+		if pageNumber == 0 || journalChecksum(page, nonce) != binary.BigEndian.Uint32(record[4+pageSize:]) {
+			break
+		}
+		pages = append(pages, Page{PageNumber: pageNumber, Data: page})
+	}
+
+	return size, pages, true
+}
+
+// journalChecksum sums the nonce with every 200th byte of the page, starting 200 bytes from the end.
+func journalChecksum(page []byte, nonce uint32) uint32 {
+	checksum := nonce
+	for i := len(page) - 200; i > 0; i -= 200 {
+		checksum += uint32(page[i])
+	}
+	return checksum
+}
+
+// commit writes the pages to the database file once the original pages are safe in the journal.
+func (j *Journal) commit(pages []Page) error {
+	size := j.dbFile.TotalPages()
+
+	// The header on page 1 changes with every commit, other pages past the end of the
+	// database file are removed by truncating the file
+	journaled := map[int]bool{}
+	if size > 0 {
+		journaled[1] = true
+	}
+	for _, page := range pages {
+		if page.PageNumber <= size {
+			journaled[page.PageNumber] = true
+		}
+	}
+	var originals []Page
+	for pageNumber := range journaled {
+		data, err := j.dbFile.Read(pageNumber)
+		if err != nil {
+			return err
+		}
+		originals = append(originals, Page{PageNumber: pageNumber, Data: data})
+	}
+	sort.Slice(originals, func(i, k int) bool {
+		return originals[i].PageNumber < originals[k].PageNumber
+	})
+
+	if err := j.writeJournal(originals, size); err != nil {
+		return err
+	}
+
+	if err := j.dbFile.Write(pages...); err != nil {
+		// Put back the original pages, the journal stays hot if that fails too
+		if rollbackErr := RecoverJournal(j.dbFile); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return j.finalize()
+}
+
+// writeJournal writes the original pages to the journal and syncs it.
+func (j *Journal) writeJournal(pages []Page, size int) error {
+	pageSize := j.dbFile.PageSize()
+	nonce := rand.Uint32()
+
+	buf := make([]byte, JournalSectorSize, JournalSectorSize+len(pages)*(pageSize+8))
+	copy(buf[0:8], journalMagic)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(len(pages)))
+	binary.BigEndian.PutUint32(buf[12:16], nonce)
+	binary.BigEndian.PutUint32(buf[16:20], uint32(size))
+	binary.BigEndian.PutUint32(buf[20:24], JournalSectorSize)
+	binary.BigEndian.PutUint32(buf[24:28], uint32(pageSize))
+
+	for _, page := range pages {
+		record := make([]byte, 4+pageSize+4)
+		binary.BigEndian.PutUint32(record[0:4], uint32(page.PageNumber))
+		copy(record[4:], page.Data)
+		binary.BigEndian.PutUint32(record[4+pageSize:], journalChecksum(page.Data, nonce))
+		buf = append(buf, record...)
+	}
+
+	f, err := os.OpenFile(j.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(buf); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// finalize commits the transaction by deleting or truncating the journal, it's no longer hot.
+func (j *Journal) finalize() error {
+	if j.mode == JournalDelete {
+		return os.Remove(j.path)
+	}
+
+	f, err := os.OpenFile(j.path, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// wait waits until ready or busyTimeout, the mutex must be held.
+func (j *Journal) wait(ready func() bool) error {
+	if ready() {
+		return nil
+	}
+
+	timedOut := false
+	timer := time.AfterFunc(busyTimeout, func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+
+		timedOut = true
+		j.cond.Broadcast()
+	})
+	defer timer.Stop()
+
+	for !ready() {
+		if timedOut {
+			return ErrBusy
+		}
+		j.cond.Wait()
+	}
+	return nil
+}
+
+// JournalConn is a connection to a database file committed with a rollback journal.
+// Unlike a WALConn it reads the committed state of the database file, commits wait
+// for readers to finish instead.
+type JournalConn struct {
+	journal *Journal
+
+	// changes is the number of commits seen by the last read transaction of the connection
+	changes uint64
+	reading bool
+	writing bool
+}
+
+func (c *JournalConn) BeginRead() (bool, error) {
+	if c.reading {
+		return false, nil
+	}
+
+	j := c.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// New readers wait for a pending commit
+	if err := j.wait(func() bool { return !j.pending }); err != nil {
+		return false, err
+	}
+
+	j.readers++
+	changed := j.changes != c.changes
+	c.changes = j.changes
+	c.reading = true
+
+	return changed, nil
+}
+
+func (c *JournalConn) EndRead() {
+	if !c.reading {
+		return
+	}
+
+	j := c.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.readers--
+	c.reading = false
+	j.cond.Broadcast()
+}
+
+func (c *JournalConn) BeginWrite() error {
+	if c.writing {
+		return nil
+	}
+
+	j := c.journal
+	select {
+	case j.writeSema <- struct{}{}:
+	case <-time.After(busyTimeout):
+		return ErrBusy
+	}
+
+	// Changes based on pages read before another connection committed would be lost
+	j.mu.Lock()
+	stale := c.reading && c.changes != j.changes
+	j.mu.Unlock()
+	if stale {
+		<-j.writeSema
+		return ErrStaleSnapshot
+	}
+
+	c.writing = true
+	return nil
+}
+
+func (c *JournalConn) EndWrite() {
+	if !c.writing {
+		return
+	}
+
+	c.writing = false
+	<-c.journal.writeSema
+}
+
+func (c *JournalConn) Snapshot() uint64 {
+	if c.reading {
+		return c.changes
+	}
+
+	j := c.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.changes
+}
+
+func (c *JournalConn) PageSize() int {
+	return c.journal.dbFile.PageSize()
+}
+
+func (c *JournalConn) TotalPages() int {
+	return c.journal.dbFile.TotalPages()
+}
+
+func (c *JournalConn) Path() string {
+	return c.journal.dbFile.Path()
+}
+
+func (c *JournalConn) Read(page int) ([]byte, error) {
+	return c.journal.dbFile.Read(page)
+}
+
+// Write commits the pages of a transaction, the connection must be writing. It waits
+// for the other connections to finish reading.
+func (c *JournalConn) Write(pages ...Page) error {
+	if !c.writing {
+		return errors.New("cannot write outside of a write transaction")
+	}
+
+	return c.exclusive(func() error {
+		return c.journal.commit(pages)
+	})
+}
+
+// Replace replaces the database file with src, the connection must be writing and
+// no other connection may be reading.
+func (c *JournalConn) Replace(src *DbFile) error {
+	if !c.writing {
+		return errors.New("cannot replace the database outside of a write transaction")
+	}
+
+	j := c.journal
+	j.mu.Lock()
+	readers := j.readers
+	j.mu.Unlock()
+	if c.reading {
+		readers--
+	}
+	if readers > 0 {
+		return errors.New("cannot replace the database while other connections are reading")
+	}
+
+	return c.exclusive(func() error {
+		return j.dbFile.Replace(src)
+	})
+}
+
+// exclusive changes the database file once the other connections are done reading.
+func (c *JournalConn) exclusive(change func() error) error {
+	j := c.journal
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	self := 0
+	if c.reading {
+		self = 1
+	}
+	j.pending = true
+	defer func() {
+		j.pending = false
+		j.cond.Broadcast()
+	}()
+	if err := j.wait(func() bool { return j.readers == self }); err != nil {
+		return err
+	}
+
+	if err := change(); err != nil {
+		return err
+	}
+	j.changes++
+	c.changes = j.changes
+
+	return nil
+}
+
+var _ SnapshotFile = (*JournalConn)(nil)
+var _ ReplaceableFile = (*JournalConn)(nil)
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJournalMode(t *testing.T) {
+	assert := require.New(t)
+
+	mode, err := ParseJournalMode("")
+	assert.NoError(err)
+	assert.Equal(JournalWAL, mode)
+
+	mode, err = ParseJournalMode("truncate")
+	assert.NoError(err)
+	assert.Equal(JournalTruncate, mode)
+
+	_, err = ParseJournalMode("memory")
+	assert.Error(err)
+}
+
+func TestJournal_Recover(t *testing.T) {
+	assert := require.New(t)
+
+	dbPath := path.Join(t.TempDir(), "tiny.db")
+	dbFile, err := OpenDbFile(dbPath, 1024)
+	assert.NoError(err)
+	journal, err := OpenJournal(dbFile, JournalDelete)
+	assert.NoError(err)
+
+	conn := journal.Connect()
+	assert.NoError(conn.BeginWrite())
+	assert.NoError(conn.Write(journalPage(1, 'a'), journalPage(2, 'a')))
+	conn.EndWrite()
+
+	// The journal is deleted to commit
+	_, err = os.Stat(dbPath + "-journal")
+	assert.True(os.IsNotExist(err))
+
+	// A commit which didn't finish leaves a hot journal behind
+	originals := []Page{}
+	for pageNumber := 1; pageNumber <= 2; pageNumber++ {
+		data, err := dbFile.Read(pageNumber)
+		assert.NoError(err)
+		originals = append(originals, Page{PageNumber: pageNumber, Data: data})
+	}
+	assert.NoError(journal.writeJournal(originals, 2))
+	assert.NoError(dbFile.Write(journalPage(1, 'b'), journalPage(2, 'b'), journalPage(3, 'b')))
+	assert.NoError(journal.Close())
+
+	dbFile, err = OpenDbFile(dbPath, 1024)
+	assert.NoError(err)
+	assert.Equal(3, dbFile.TotalPages())
+	assert.NoError(RecoverJournal(dbFile))
+
+	assert.Equal(2, dbFile.TotalPages())
+	for pageNumber := 1; pageNumber <= 2; pageNumber++ {
+		data, err := dbFile.Read(pageNumber)
+		assert.NoError(err)
+		assert.Equal(originals[pageNumber-1].Data, data)
+	}
+	info, err := os.Stat(dbPath)
+	assert.NoError(err)
+	assert.Equal(int64(2*1024), info.Size())
+	_, err = os.Stat(dbPath + "-journal")
+	assert.True(os.IsNotExist(err))
+
+	// The journal is truncated to commit in TRUNCATE mode, an empty journal isn't hot
+	journal, err = OpenJournal(dbFile, JournalTruncate)
+	assert.NoError(err)
+	conn = journal.Connect()
+	assert.NoError(conn.BeginWrite())
+	assert.NoError(conn.Write(journalPage(2, 'c')))
+	conn.EndWrite()
+
+	info, err = os.Stat(dbPath + "-journal")
+	assert.NoError(err)
+	assert.Zero(info.Size())
+	assert.NoError(RecoverJournal(dbFile))
+
+	data, err := dbFile.Read(2)
+	assert.NoError(err)
+	assert.Equal(journalPage(2, 'c').Data, data)
+	assert.NoError(journal.Close())
+}
+
+func TestJournalConn_WaitsForReaders(t *testing.T) {
+	assert := require.New(t)
+
+	dbFile, err := OpenDbFile(path.Join(t.TempDir(), "tiny.db"), 1024)
+	assert.NoError(err)
+	journal, err := OpenJournal(dbFile, JournalDelete)
+	assert.NoError(err)
+	defer journal.Close()
+
+	writer := journal.Connect()
+	assert.NoError(writer.BeginWrite())
+	assert.NoError(writer.Write(journalPage(1, 'a')))
+	writer.EndWrite()
+
+	reader := journal.Connect()
+	changed, err := reader.BeginRead()
+	assert.NoError(err)
+	assert.False(changed)
+
+	// The commit waits for the reader to finish
+	assert.NoError(writer.BeginWrite())
+	committed := make(chan error)
+	go func() {
+		committed <- writer.Write(journalPage(2, 'b'))
+	}()
+
+	select {
+	case <-committed:
+		assert.Fail("committed while a connection is reading")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(1, reader.TotalPages())
+
+	reader.EndRead()
+	assert.NoError(<-committed)
+	writer.EndWrite()
+
+	changed, err = reader.BeginRead()
+	assert.NoError(err)
+	assert.True(changed)
+	assert.Equal(2, reader.TotalPages())
+	reader.EndRead()
+}
+
+// journalPage is a page filled with b, page 1 starts with a file header.
+func journalPage(pageNumber int, b byte) Page {
+	data := bytes.Repeat([]byte{b}, 1024)
+	if pageNumber == 1 {
+		var header bytes.Buffer
+		_, _ = NewFileHeader(1024).WriteTo(&header)
+		copy(data, header.Bytes())
+	}
+	return Page{PageNumber: pageNumber, Data: data}
+}
@@ -12,6 +12,13 @@ type File interface {
 	PageWriter
 }
 
+// ReplaceableFile is a database file whose content can be replaced by another database file
+type ReplaceableFile interface {
+	File
+	Path() string
+	Replace(src *DbFile) error
+}
+
 type DbFile struct {
 	path       string		// 文件路径
 	header     FileHeader	// 文件头
@@ -40,6 +47,9 @@ func OpenDbFile(path string, pageSize int) (*DbFile, error) {
 		if _, err := file.ReadAt(headerBytes, 0); err != nil {
 			return nil, err
 		}
+		if err := checkFileHeader(headerBytes); err != nil {
+			return nil, err
+		}
 		header, err = ParseFileHeader(headerBytes)
 		if err != nil {
 			return nil, err
@@ -75,20 +85,12 @@ func (f *DbFile) Read(page int) ([]byte, error) {
 	defer f.mu.RUnlock()
 
 	// 计算页在文件的起始偏移
-	offset := f.pageOffset(page)
-	if _, err := f.file.Seek(offset, io.SeekStart); err != nil {
-		return nil, err
-	}
+	// Page 1 is read along with the file header, the pager maintains the freelist in it.
+	offset := int64(page-1) * int64(f.pageSize)
 
-	readOffset := 0
-	if page == 1 {
-		readOffset = 100
-	}
-
-	// 读取一页数据到内存
+	// 读取一页数据到内存，并发读取不共享文件偏移
 	data := make([]byte, f.pageSize)
-	_, err := f.file.Read(data[readOffset:])
-	if err != nil {
+	if _, err := f.file.ReadAt(data, offset); err != nil {
 		return nil, err
 	}
 
@@ -117,6 +119,14 @@ func (f *DbFile) Write(pages ...Page) error {
 		readOffset := 0
 		if page.PageNumber == 1 {
 			readOffset = 100
+
+			// The rest of the file header is owned by the file
+			pageHeader, err := ParseFileHeader(page.Data[:100])
+			if err != nil {
+				return err
+			}
+			f.header.FirstFreelistTrunk = pageHeader.FirstFreelistTrunk
+			f.header.FreelistPages = pageHeader.FreelistPages
 		}
 
 		// 写入磁盘文件
@@ -138,6 +148,114 @@ func (f *DbFile) Write(pages ...Page) error {
 	return nil
 }
 
+// Replace atomically replaces the database with the content of src by renaming
+// the file of src over the file. src is closed and can no longer be used.
+func (f *DbFile) Replace(src *DbFile) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// The replacement keeps the file format of the database
+	if src.header.FileFormat != f.header.FileFormat {
+		src.header.FileFormat = f.header.FileFormat
+		if err := src.updateFileHeader(); err != nil {
+			return err
+		}
+	}
+	if err := src.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(src.path, f.path); err != nil {
+		return err
+	}
+
+	// 重新打开替换后的文件
+	file, err := os.OpenFile(f.path, os.O_RDWR, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.header = src.header
+	f.pageSize = src.pageSize
+	f.totalPages = src.totalPages
+
+	return nil
+}
+
+// Sync commits the written pages to stable storage.
+func (f *DbFile) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Sync()
+}
+
+// SetFileFormat sets the file format version of the file header, the header of an
+// initialized file is written right away.
+func (f *DbFile) SetFileFormat(format uint8) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.header.FileFormat == format {
+		return nil
+	}
+	f.header.FileFormat = format
+	if f.totalPages == 0 {
+		return nil
+	}
+
+	if err := f.updateFileHeader(); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// restore writes back the original pages of a rolled back transaction, file header included,
+// and truncates the file to its original size in pages.
+func (f *DbFile) restore(pages []Page, size int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, page := range pages {
+		if _, err := f.file.WriteAt(page.Data, int64(page.PageNumber-1)*int64(f.pageSize)); err != nil {
+			return err
+		}
+	}
+	if err := f.file.Truncate(int64(size) * int64(f.pageSize)); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+	if size == 0 {
+		f.totalPages = 0
+		return nil
+	}
+
+	// 重新读取文件头
+	headerBytes := make([]byte, 100)
+	if _, err := f.file.ReadAt(headerBytes, 0); err != nil {
+		return err
+	}
+	header, err := ParseFileHeader(headerBytes)
+	if err != nil {
+		return err
+	}
+	f.header = header
+	f.totalPages = int(header.SizeInPages)
+
+	return nil
+}
+
+// Close closes the file
+func (f *DbFile) Close() error {
+	return f.file.Close()
+}
+
 func (f *DbFile) pageOffset(page int) int64 {
 	if page == 1 {
 		return 100
@@ -163,4 +281,4 @@ func (f *DbFile) updateFileHeader() error {
 	return nil
 }
 
-var _ File = (*DbFile)(nil)
+var _ ReplaceableFile = (*DbFile)(nil)
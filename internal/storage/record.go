@@ -3,9 +3,12 @@ package storage
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"strings"
 )
 
 type SQLType uint32
@@ -14,23 +17,12 @@ const (
 	Null    = 0
 	Byte    = 1
 	Integer = 4
+	Real    = 7
+	Blob    = 12
 	Text    = 28
 	Unknown = 999
 )
 
-func SQLTypeFromString(t string) (SQLType, error) {
-	switch t {
-	case "text":
-		return Text, nil
-	case "int":
-		return Integer, nil
-	case "byte":
-		return Byte, nil
-	default:
-		return Unknown, fmt.Errorf("unexpected SQL string type")
-	}
-}
-
 // Field is a field in a database record
 type Field struct {
 	Type SQLType
@@ -40,12 +32,12 @@ type Field struct {
 
 // Record is a set of fields
 type Record struct {
-	RowID  uint32		// ID
+	RowID  int64		// ID
 	Fields []*Field		// 字段
 }
 
 // NewRecord creates a database record from a set of fields
-func NewRecord(key uint32, fields []*Field) *Record {
+func NewRecord(key int64, fields []*Field) *Record {
 	return &Record{
 		RowID:  key,
 		Fields: fields,
@@ -62,80 +54,227 @@ func (r Record) ToBytes() ([]byte, error) {
 
 // Write writes a record to the specified writer
 func (r Record) Write(bs io.ByteWriter) error {
+	payload, err := EncodeFields(r.Fields)
+	if err != nil {
+		return err
+	}
+
+	// Finally, write everything to the supplied writer
+	// [Size, Key, Record]
+	if _, err := WriteVarint(bs, uint64(len(payload))); err != nil {
+		return err
+	}
+	if _, err := WriteVarint(bs, uint64(r.RowID)); err != nil {
+		return err
+	}
+	for _, b := range payload {
+		if err := bs.WriteByte(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EncodeFields serializes fields to the record format, a header with
+// the serial type of each field followed by the data of the fields.
+//
+// The serial type is chosen by the value of a field: integers take the fewest bytes
+// which hold them, 0 and 1 take none.
+func EncodeFields(fields []*Field) ([]byte, error) {
 	// Record field types
 	colBuf := bytes.Buffer{}
-	for _, f := range r.Fields {
-		// If data is nil indicate
-		// the SQL type is NULL
-		if f.Data == nil {
-			colBuf.WriteByte(0)
-			continue
+	dataBuf := bytes.Buffer{}
+	for _, f := range fields {
+		serialType, data, err := encodeField(f)
+		if err != nil {
+			return nil, err
 		}
-
-		switch f.Type {
-		case Byte:
-			colBuf.WriteByte(1)
-		case Integer:
-			colBuf.WriteByte(4)
-		case Text:
-			fieldSize := uint64(2*len(f.Data.(string)) + 13)
-			_, err := WriteVarint(&colBuf, fieldSize)
-			if err != nil {
-				return fmt.Errorf("unable to write varint")
-			}
-		default:
-			return fmt.Errorf("Unknown sql type")
+		if _, err := WriteVarint(&colBuf, serialType); err != nil {
+			return nil, err
 		}
+		dataBuf.Write(data)
+	}
+
+	// The header size counts the varint holding it
+	headerLen := uint64(colBuf.Len() + 1)
+	for uint64(colBuf.Len()+VarintLen(headerLen)) != headerLen {
+		headerLen = uint64(colBuf.Len() + VarintLen(headerLen))
 	}
 
 	recordBuffer := bytes.Buffer{}
+	if _, err := WriteVarint(&recordBuffer, headerLen); err != nil {
+		return nil, err
+	}
+	recordBuffer.Write(colBuf.Bytes())
+	recordBuffer.Write(dataBuf.Bytes())
 
-	// Record header Size
-	// TODO: this assumes the header size can fit into 7 bit integer, which is usually okay.
-	// Add 1 because to include the first byte that includes size
-	recordBuffer.Write([]byte{byte(colBuf.Len() + 1)})
+	return recordBuffer.Bytes(), nil
+}
 
-	// Copy field types to record buffer
-	recordBuffer.Write(colBuf.Bytes())
+// encodeField finds the serial type of a field and its data.
+func encodeField(f *Field) (uint64, []byte, error) {
+	switch v := f.Data.(type) {
+	case nil:
+		return 0, nil, nil
+	case string:
+		return uint64(2*len(v) + 13), []byte(v), nil
+	case []byte:
+		return uint64(2*len(v) + 12), v, nil
+	case float32:
+		return encodeFloat(float64(v))
+	case float64:
+		return encodeFloat(v)
+	}
 
-	// Write data to record
-	for _, f := range r.Fields {
-		// Nil is specified handled in header
-		if f.Data == nil {
+	i, ok := f.Int64()
+	if !ok {
+		return 0, nil, fmt.Errorf("not supported type: %v", reflect.TypeOf(f.Data))
+	}
+
+	switch {
+	case i == 0:
+		return 8, nil, nil
+	case i == 1:
+		return 9, nil, nil
+	}
+
+	// Pick the smallest width which holds the value
+	for serialType, width := range intWidths {
+		if width == 0 || width == 8 || i < -1<<(8*width-1) || i >= 1<<(8*width-1) {
 			continue
 		}
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, uint64(i))
+		return uint64(serialType), data[8-width:], nil
+	}
+
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(i))
+	return 6, data, nil
+}
 
-		switch f.Data.(type) {
-		case int8:
-			recordBuffer.Write([]byte{byte(f.Data.(int8))})
-		case byte:
-			recordBuffer.Write([]byte{f.Data.(byte)})
-		case int:
-			if err := binary.Write(&recordBuffer, binary.BigEndian, uint32(f.Data.(int))); err != nil {
-				return err
-			}
-		case string:
-			recordBuffer.Write([]byte(f.Data.(string)))
-		default:
-			return fmt.Errorf("not supported type: %v", reflect.TypeOf(f.Data))
+func encodeFloat(v float64) (uint64, []byte, error) {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(v))
+	return 7, data, nil
+}
+
+// intWidths is the number of bytes of the integer serial types 1 to 6
+var intWidths = []int{0, 1, 2, 3, 4, 6, 8}
+
+// CompareFields compares the fields of two records in order. Only as many fields as
+// the shorter of the two are compared, so a prefix of a record compares as equal.
+// NULL sorts before numbers which sort before text.
+// Returns a negative number when a < b, zero when equal and a positive number when a > b.
+func CompareFields(a []*Field, b []*Field) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compareField(a[i], b[i]); c != 0 {
+			return c
 		}
 	}
+	return 0
+}
 
-	// Finally, write everything to the supplied writer
-	// [Size, Key, Record]
-	if _, err := WriteVarint(bs, uint64(len(recordBuffer.Bytes()))); err != nil {
-		return err
+func compareField(a *Field, b *Field) int {
+	rankA, rankB := fieldRank(a), fieldRank(b)
+	if rankA != rankB {
+		return rankA - rankB
 	}
-	if _, err := WriteVarint(bs, uint64(r.RowID)); err != nil {
-		return err
+
+	switch rankA {
+	case 1:
+		return compareNumbers(a, b)
+	case 2:
+		return strings.Compare(a.Data.(string), b.Data.(string))
+	case 3:
+		return bytes.Compare(a.Data.([]byte), b.Data.([]byte))
+	default:
+		return 0
 	}
-	for _, b := range recordBuffer.Bytes() {
-		if err := bs.WriteByte(b); err != nil {
-			return err
+}
+
+// compareNumbers compares integers exactly and as floats when either one is a float.
+func compareNumbers(a *Field, b *Field) int {
+	x, xInt := a.Int64()
+	y, yInt := b.Int64()
+	if xInt && yInt {
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
 		}
+		return 0
 	}
 
-	return nil
+	f, _ := a.Float()
+	g, _ := b.Float()
+	if f < g {
+		return -1
+	} else if f > g {
+		return 1
+	}
+	return 0
+}
+
+// fieldRank orders the storage classes of fields
+func fieldRank(f *Field) int {
+	switch f.Data.(type) {
+	case nil:
+		return 0
+	case string:
+		return 2
+	case []byte:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// Int returns the value of an integer field
+// and false if the field isn't an integer.
+func (f *Field) Int() (int, bool) {
+	v, ok := f.Int64()
+	return int(v), ok
+}
+
+// Int64 returns the value of an integer field
+// and false if the field isn't an integer.
+func (f *Field) Int64() (int64, bool) {
+	switch v := f.Data.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// Float returns the value of a numeric field as a float
+// and false if the field isn't a number.
+func (f *Field) Float() (float64, bool) {
+	switch v := f.Data.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	}
+
+	i, ok := f.Int64()
+	return float64(i), ok
 }
 
 // Header (12 bytes):
@@ -149,7 +288,7 @@ func (r Record) Write(bs io.ByteWriter) error {
 // interior page key: 00
 // last bytes 03 07
 
-func NewMasterTableRecord(rowID uint32, typeName string, name string, tableName string, rootPage int, sqlText string) *Record {
+func NewMasterTableRecord(rowID int64, typeName string, name string, tableName string, rootPage int, sqlText string) *Record {
 	return NewRecord(rowID, []*Field{
 		{
 			Type: Text,
@@ -167,10 +306,9 @@ func NewMasterTableRecord(rowID uint32, typeName string, name string, tableName
 			Data: tableName,
 		},
 		{
-			Type: Byte,
-			// TODO: this seems to be optimized from int to a byte by sqlite for early pages
+			Type: Integer,
 			// rootpage: integer
-			Data: byte(rootPage),
+			Data: rootPage,
 		},
 		{
 			Type: Text,
@@ -200,72 +338,106 @@ func ReadRecord(r io.ByteReader) (*Record, error) {
 		return nil, err
 	}
 
-	var fields []*Field
-	recordHeaderLen, _, err := ReadVarint(r)
+	fields, err := ReadFields(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Record{
+		RowID:  int64(rowID),
+		Fields: fields,
+	}, nil
+}
+
+// ReadFields parses fields in the record format produced by EncodeFields.
+// Integers are read as int, floats as float64 and blobs as []byte.
+func ReadFields(r io.ByteReader) ([]*Field, error) {
+	var serialTypes []uint64
+	recordHeaderLen, n, err := ReadVarint(r)
 	if err != nil {
 		return nil, err
 	}
 
 	// Subtract the # of bytes for the header len.
-	// Need to find out how many bytes were used for the varint
-	recordHeaderLen = recordHeaderLen - 1
+	recordHeaderLen = recordHeaderLen - uint64(n)
 	for recordHeaderLen > 0 {
-		colType, n, err := ReadVarint(r)
+		serialType, n, err := ReadVarint(r)
 		if err != nil {
 			return nil, err
 		}
-
-		var sqlType SQLType
-		numBytes := 1
-		switch colType {
-		case 0:
-			// NULL
-		case 1:
-			sqlType = Byte
-			numBytes = 1
-		case 4:
-			sqlType = Integer
-			numBytes = 4
-		default:
-			// TODO: default for text isnt appropriate, it should be something like
-			// odd numbers greater than 12?
-			sqlType = Text
-			numBytes = int(colType-13) / 2
+		if uint64(n) > recordHeaderLen {
+			return nil, errors.New("malformed record header")
 		}
 
-		fields = append(fields, &Field{
-			Type: sqlType,
-			Len:  numBytes,
-			Data: nil,
-		})
-
+		serialTypes = append(serialTypes, serialType)
 		recordHeaderLen = recordHeaderLen - uint64(n)
 	}
 
-	for _, f := range fields {
-		switch f.Type {
-		case Byte:
-			b, _ := r.ReadByte()
-			f.Data = b
-		case Integer:
-			var bs []byte
-			for i := 0; i < f.Len; i++ {
-				b, _ := r.ReadByte()
-				bs = append(bs, b)
-			}
-			f.Data = int(binary.BigEndian.Uint32(bs))
-		case Text:
-			var bs []byte
-			for i := 0; i < f.Len; i++ {
-				b, _ := r.ReadByte()
-				bs = append(bs, b)
-			}
-			f.Data = string(bs)
+	fields := make([]*Field, 0, len(serialTypes))
+	for _, serialType := range serialTypes {
+		f, err := readField(r, serialType)
+		if err != nil {
+			return nil, err
 		}
+		fields = append(fields, f)
 	}
 
-	return &Record{
-		RowID:  uint32(rowID),
-		Fields: fields,
-	}, nil
+	return fields, nil
+}
+
+// readField reads the data of a field with the serial type.
+func readField(r io.ByteReader, serialType uint64) (*Field, error) {
+	switch {
+	case serialType == 0:
+		return &Field{Type: Null}, nil
+	case serialType <= 6:
+		width := intWidths[serialType]
+		bs, err := readBytes(r, width)
+		if err != nil {
+			return nil, err
+		}
+
+		// Sign extend the big endian value
+		v := int64(int8(bs[0]))
+		for _, b := range bs[1:] {
+			v = v<<8 | int64(b)
+		}
+		return &Field{Type: Integer, Data: int(v), Len: width}, nil
+	case serialType == 7:
+		bs, err := readBytes(r, 8)
+		if err != nil {
+			return nil, err
+		}
+		return &Field{Type: Real, Data: math.Float64frombits(binary.BigEndian.Uint64(bs)), Len: 8}, nil
+	case serialType == 8:
+		return &Field{Type: Integer, Data: 0}, nil
+	case serialType == 9:
+		return &Field{Type: Integer, Data: 1}, nil
+	case serialType < 12:
+		return nil, fmt.Errorf("reserved serial type %d", serialType)
+	case serialType%2 == 0:
+		bs, err := readBytes(r, int(serialType-12)/2)
+		if err != nil {
+			return nil, err
+		}
+		return &Field{Type: Blob, Data: bs, Len: len(bs)}, nil
+	default:
+		bs, err := readBytes(r, int(serialType-13)/2)
+		if err != nil {
+			return nil, err
+		}
+		return &Field{Type: Text, Data: string(bs), Len: len(bs)}, nil
+	}
+}
+
+func readBytes(r io.ByteReader, n int) ([]byte, error) {
+	bs := make([]byte, n)
+	for i := range bs {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		bs[i] = b
+	}
+	return bs, nil
 }
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"bytes"
+	"math"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -21,3 +23,87 @@ func TestNewMasterTableRecord(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(expectedBytes, buf.Bytes())
 }
+
+func TestRecord_SerialTypes(t *testing.T) {
+	assert := require.New(t)
+
+	cases := []struct {
+		data       interface{}
+		serialType byte
+		expected   interface{}
+	}{
+		{nil, 0, nil},
+		{0, 8, 0},
+		{1, 9, 1},
+		{byte(200), 2, 200},
+		{-1, 1, -1},
+		{127, 1, 127},
+		{-128, 1, -128},
+		{128, 2, 128},
+		{-32769, 3, -32769},
+		{1 << 23, 4, 1 << 23},
+		{int64(1) << 40, 5, 1 << 40},
+		{int64(-1) << 47, 5, -1 << 47},
+		{int64(1) << 47, 6, 1 << 47},
+		{int64(math.MinInt64), 6, math.MinInt64},
+		{3.25, 7, 3.25},
+		{[]byte{0xB, 0xE}, 16, []byte{0xB, 0xE}},
+		{"abc", 19, "abc"},
+	}
+
+	for _, c := range cases {
+		payload, err := EncodeFields([]*Field{{Data: c.data}})
+		assert.NoError(err)
+		assert.Equal([]byte{2, c.serialType}, payload[:2], "%v", c.data)
+
+		fields, err := ReadFields(bytes.NewReader(payload))
+		assert.NoError(err)
+		assert.Len(fields, 1)
+		assert.Equal(c.expected, fields[0].Data, "%v", c.data)
+	}
+
+	// Reserved serial types aren't read
+	_, err := ReadFields(bytes.NewReader([]byte{2, 10}))
+	assert.Error(err)
+}
+
+func TestRecord_LongHeader(t *testing.T) {
+	assert := require.New(t)
+
+	// The header size takes two bytes once the header is over 127 bytes
+	var fields []*Field
+	for i := 0; i < 200; i++ {
+		fields = append(fields, &Field{Type: Text, Data: strings.Repeat("x", i)})
+	}
+
+	record := NewRecord(7, fields)
+	data, err := record.ToBytes()
+	assert.NoError(err)
+
+	read, err := ReadRecord(bytes.NewReader(data))
+	assert.NoError(err)
+	assert.Equal(int64(7), read.RowID)
+	assert.Len(read.Fields, 200)
+	for i, f := range read.Fields {
+		assert.Equal(strings.Repeat("x", i), f.Data)
+	}
+}
+
+func TestCompareFields(t *testing.T) {
+	assert := require.New(t)
+
+	ordered := []interface{}{nil, -5, 1.5, 2, int64(1) << 40, "a", "b", []byte{0}, []byte{0, 1}}
+	for i := range ordered {
+		for j := range ordered {
+			c := CompareFields([]*Field{{Data: ordered[i]}}, []*Field{{Data: ordered[j]}})
+			switch {
+			case i < j:
+				assert.Negative(c, "%v < %v", ordered[i], ordered[j])
+			case i > j:
+				assert.Positive(c, "%v > %v", ordered[i], ordered[j])
+			default:
+				assert.Zero(c)
+			}
+		}
+	}
+}
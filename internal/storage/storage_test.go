@@ -29,6 +29,8 @@ func TestFileHeader_ReadHeader(t *testing.T) {
 	assert := require.New(t)
 	buf := bytes.Buffer{}
 	h := NewFileHeader(1024)
+	h.FirstFreelistTrunk = 7
+	h.FreelistPages = 3
 	_, err := h.WriteTo(&buf)
 	assert.NoError(err)
 
@@ -37,3 +39,24 @@ func TestFileHeader_ReadHeader(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(h, result)
 }
+
+func TestFileHeader_Unsupported(t *testing.T) {
+	assert := require.New(t)
+	buf := bytes.Buffer{}
+	_, err := NewFileHeader(1024).WriteTo(&buf)
+	assert.NoError(err)
+	assert.NoError(checkFileHeader(buf.Bytes()))
+
+	// Features of SQLite databases which can't be read
+	for _, change := range []func(bs []byte){
+		func(bs []byte) { copy(bs, "Not a database\000\000") },
+		func(bs []byte) { binary.BigEndian.PutUint16(bs[16:], 512) },
+		func(bs []byte) { bs[20] = 8 },
+		func(bs []byte) { binary.BigEndian.PutUint32(bs[52:], 3) },
+		func(bs []byte) { binary.BigEndian.PutUint32(bs[56:], 2) },
+	} {
+		bs := append([]byte(nil), buf.Bytes()...)
+		change(bs)
+		assert.Error(checkFileHeader(bs))
+	}
+}
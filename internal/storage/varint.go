@@ -5,53 +5,46 @@ import (
 	"io"
 )
 
-// ReadVarint reads a varint in little endian order.
+// ReadVarint reads a varint in little endian order. The ninth byte of a varint
+// holds 8 bits, so 9 bytes hold any 64-bit value.
 func ReadVarint(reader io.ByteReader) (uint64, int, error) {
-	// Copy varint bytes to buffer
-	buf := bytes.Buffer{}
-	for {
+	var x uint64
+	for n := 1; n <= 9; n++ {
 		b, err := reader.ReadByte()
 		if err != nil {
-			return 0, buf.Len(), err
+			return 0, n - 1, err
 		}
-		buf.WriteByte(b)
+		if n == 9 {
+			return x<<8 | uint64(b), n, nil
+		}
+		x = x<<7 | uint64(b&0x7f)
 		if b&0x80 == 0 {
-			break
+			return x, n, nil
 		}
 	}
-
-	// Reverse to reconstruct in big endian order
-	z := buf.Bytes()
-	for i, j := 0, len(z)-1; i < j; i, j = i+1, j-1 {
-		z[i], z[j] = z[j], z[i]
-	}
-
-	// Reconstruct big endian order
-	// TODO: it will depend on system
-	var x uint64
-	var s uint
-	for _, b := range z {
-		x |= uint64(b&0x7f) << s
-		s += 7
-	}
-
-	return x, len(z), nil
+	return x, 9, nil
 }
 
 // WriteVarint writes a varint in little endian order.
 func WriteVarint(w io.ByteWriter, v uint64) (int, error) {
+	// Values over 56 bits take 9 bytes, the last one holds 8 bits
+	var last []byte
+	if v>>56 != 0 {
+		last = []byte{byte(v)}
+		v >>= 8
+	}
+
 	// Collect bytes to be encoded
 	buf := bytes.Buffer{}
 	for {
 		buf.WriteByte(byte(v & 0x7f))
 		v >>= 7
-		if v == 0 {
+		if v == 0 && (last == nil || buf.Len() == 8) {
 			break
 		}
 	}
 
 	// Reverse to write in little endian order
-	// TODO: it will depend on system
 	s := buf.Bytes()
 	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
 		s[i], s[j] = s[j], s[i]
@@ -59,12 +52,29 @@ func WriteVarint(w io.ByteWriter, v uint64) (int, error) {
 
 	// Encode bytes
 	for i, b := range s {
-		if i < len(s)-1 {
-			w.WriteByte(b | 0x80)
+		if i < len(s)-1 || last != nil {
+			b |= 0x80
 		} else {
-			w.WriteByte(b & 0x7f)
+			b &= 0x7f
+		}
+		if err := w.WriteByte(b); err != nil {
+			return i, err
 		}
 	}
+	for _, b := range last {
+		if err := w.WriteByte(b); err != nil {
+			return len(s), err
+		}
+	}
+
+	return len(s) + len(last), nil
+}
 
-	return buf.Len(), nil
+// VarintLen is the number of bytes of the varint of v.
+func VarintLen(v uint64) int {
+	n := 1
+	for v >>= 7; v != 0 && n < 9; v >>= 7 {
+		n++
+	}
+	return n
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"bytes"
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -22,3 +23,26 @@ func TestVarint32(t *testing.T) {
 		r.Equal(uint64(i), uint64(v))
 	}
 }
+
+func TestVarint64(t *testing.T) {
+	r := require.New(t)
+
+	for _, v := range []uint64{1<<56 - 1, 1 << 56, 1<<63 + 5, math.MaxUint64} {
+		bs := bytes.Buffer{}
+		n, err := WriteVarint(&bs, v)
+		r.NoError(err)
+		r.Equal(VarintLen(v), n)
+		r.Equal(n, bs.Len())
+
+		read, n, err := ReadVarint(bytes.NewReader(bs.Bytes()))
+		r.NoError(err)
+		r.Equal(v, read)
+		r.Equal(bs.Len(), n)
+	}
+
+	// The ninth byte holds 8 bits
+	bs := bytes.Buffer{}
+	_, err := WriteVarint(&bs, math.MaxUint64)
+	r.NoError(err)
+	r.Equal(bytes.Repeat([]byte{0xFF}, 9), bs.Bytes())
+}
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"hash/crc64"
+	"fmt"
 	"io"
 	"math/rand"
 	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -16,11 +18,66 @@ const (
 
 	WALFrameHeaderLen = 24
 
-	WALMagicNumber = 0x377f0682
+	// WALMagicNumber marks a log with little-endian checksums, the magic number
+	// with the least significant bit set marks a log with big-endian checksums.
+	WALMagicNumber          = 0x377f0682
+	WALMagicNumberBigEndian = 0x377f0683
 
 	WALFileFormat = 3007000
+
+	// DefaultAutoCheckpoint is the number of frames in the log which triggers a checkpoint
+	DefaultAutoCheckpoint = 1000
+)
+
+// CheckpointMode determines how a checkpoint deals with the log after its frames are
+// copied to the database file. Frames are only copied up to the snapshots of active
+// readers, the log starts over once every frame is copied.
+type CheckpointMode int
+
+const (
+	// CheckpointPassive copies the frames without waiting for readers, the next write
+	// starts the log over.
+	CheckpointPassive CheckpointMode = iota
+	// CheckpointFull waits up to busyTimeout for the readers of older snapshots to
+	// finish so every frame is copied.
+	CheckpointFull
+	// CheckpointRestart also starts the log over with new salts right away.
+	CheckpointRestart
+	// CheckpointTruncate also truncates the log file to zero bytes.
+	CheckpointTruncate
 )
 
+// CheckpointResult reports the progress of a checkpoint
+type CheckpointResult struct {
+	// Busy is set when readers kept frames from being copied to the database file
+	Busy bool
+	// LogFrames is the number of frames in the log
+	LogFrames int
+	// Checkpointed is the number of frames of the log in the database file
+	Checkpointed int
+}
+
+// ParseCheckpointMode parses the name of a checkpoint mode, an empty name is CheckpointPassive.
+func ParseCheckpointMode(name string) (CheckpointMode, error) {
+	switch strings.ToUpper(name) {
+	case "", "PASSIVE":
+		return CheckpointPassive, nil
+	case "FULL":
+		return CheckpointFull, nil
+	case "RESTART":
+		return CheckpointRestart, nil
+	case "TRUNCATE":
+		return CheckpointTruncate, nil
+	}
+	return 0, fmt.Errorf("unknown checkpoint mode: %s", name)
+}
+
+// CheckpointFile is a file whose changes are logged until a checkpoint copies them to the database file
+type CheckpointFile interface {
+	File
+	Checkpoint(mode CheckpointMode) (CheckpointResult, error)
+}
+
 // WAL Header Format
 // Offset	Size	Description
 // 0			4	Magic number. 0x377f0682 or 0x377f0683
@@ -51,10 +108,28 @@ type WAL struct {
 	salt1            uint32
 	salt2            uint32
 	pos              uint32
-	totalPages       int
 
-	pageCache map[int][]byte
-	mu        *sync.RWMutex
+	// checksum1 and checksum2 are the cumulative checksum of the last frame written
+	checksum1 uint32
+	checksum2 uint32
+
+	// order is the byte order of the words summed by the checksums
+	order binary.ByteOrder
+
+	// index holds the committed frames, logStart is the last frame before the log started over
+	index    *walIndex
+	logStart uint64
+	mu       *sync.RWMutex
+
+	// readersDone is signaled when readers end their snapshots
+	readersDone *sync.Cond
+
+	// writeSema is held by the connection writing to the log
+	writeSema chan struct{}
+
+	// autoCheckpoint is the number of frames in the log which requests a checkpoint, 0 disables it
+	autoCheckpoint int
+	checkpointCh   chan struct{}
 }
 
 func OpenWAL(dbFile *DbFile) (*WAL, error) {
@@ -64,26 +139,148 @@ func OpenWAL(dbFile *DbFile) (*WAL, error) {
 		return nil, err
 	}
 
-	return &WAL{
-		file:       f,
-		dbFile:     dbFile,
-		mu:         &sync.RWMutex{},
-		totalPages: dbFile.TotalPages(),
-		pageCache:  make(map[int][]byte),
-	}, nil
+	mu := &sync.RWMutex{}
+	w := &WAL{
+		file:        f,
+		dbFile:      dbFile,
+		mu:          mu,
+		readersDone: sync.NewCond(mu),
+		index:       newWALIndex(),
+		order:       binary.LittleEndian,
+		writeSema:   make(chan struct{}, 1),
+
+		autoCheckpoint: DefaultAutoCheckpoint,
+		checkpointCh:   make(chan struct{}, 1),
+	}
+
+	// Committed transactions may not have been checkpointed before the last shutdown
+	if err := w.recover(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// recover rebuilds the page cache from the frames of committed transactions in the log.
+// Frames are read up to the first frame whose salts don't match the header or whose
+// checksum doesn't match the frames before it. Frames after the last commit frame
+// belong to a transaction which wasn't committed and are overwritten by the next write.
+func (w *WAL) recover() error {
+	info, err := w.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < WALHeaderLen {
+		return nil
+	}
+
+	header := make([]byte, WALHeaderLen)
+	if _, err := w.file.ReadAt(header, 0); err != nil {
+		return err
+	}
+
+	// A log with an invalid header has no frames of interest
+	var order binary.ByteOrder
+	switch binary.BigEndian.Uint32(header[0:4]) {
+	case WALMagicNumber:
+		order = binary.LittleEndian
+	case WALMagicNumberBigEndian:
+		order = binary.BigEndian
+	default:
+		return nil
+	}
+	pageSize := int(binary.BigEndian.Uint32(header[8:12]))
+	if binary.BigEndian.Uint32(header[4:8]) != WALFileFormat || pageSize != w.dbFile.PageSize() {
+		return nil
+	}
+	s0, s1, err := headerChecksum(header[:24], order)
+	if err != nil {
+		return err
+	}
+	if s0 != binary.BigEndian.Uint32(header[24:28]) || s1 != binary.BigEndian.Uint32(header[28:32]) {
+		return nil
+	}
+
+	w.order = order
+	w.checkpointNumber = binary.BigEndian.Uint32(header[12:16])
+	w.salt1 = binary.BigEndian.Uint32(header[16:20])
+	w.salt2 = binary.BigEndian.Uint32(header[20:24])
+
+	frameLen := int64(WALFrameHeaderLen + pageSize)
+	var pending []Page
+	for offset := int64(WALHeaderLen); offset+frameLen <= info.Size(); offset += frameLen {
+		frame := make([]byte, frameLen)
+		if _, err := w.file.ReadAt(frame, offset); err != nil {
+			return err
+		}
+
+		if binary.BigEndian.Uint32(frame[8:12]) != w.salt1 || binary.BigEndian.Uint32(frame[12:16]) != w.salt2 {
+			break
+		}
+		s0, s1, err = frameChecksum(frame, s0, s1, order)
+		if err != nil {
+			return err
+		}
+		if s0 != binary.BigEndian.Uint32(frame[16:20]) || s1 != binary.BigEndian.Uint32(frame[20:24]) {
+			break
+		}
+
+		pageNumber := int(binary.BigEndian.Uint32(frame[0:4]))
+		if pageNumber == 0 {
+			break
+		}
+		pending = append(pending, Page{PageNumber: pageNumber, Data: frame[WALFrameHeaderLen:]})
+
+		// The commit frame holds the size of the database after the transaction
+		if commitSize := binary.BigEndian.Uint32(frame[4:8]); commitSize > 0 {
+			w.index.commit(pending, int(commitSize))
+			pending = nil
+
+			w.pos = uint32(offset + frameLen)
+			w.checksum1, w.checksum2 = s0, s1
+		}
+	}
+
+	return nil
 }
 
 func (w *WAL) TotalPages() int {
-	return w.totalPages
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.totalPages(w.index.mxFrame)
+}
+
+// totalPages is the size of the database in pages as of the end mark.
+func (w *WAL) totalPages(mark uint64) int {
+	if size, ok := w.index.size(mark); ok {
+		return size
+	}
+	return w.dbFile.TotalPages()
+}
+
+func (w *WAL) Path() string {
+	return w.dbFile.Path()
 }
 
 func (w *WAL) PageSize() int {
 	return w.dbFile.PageSize()
 }
 
+// Read reads the latest committed version of the page.
 func (w *WAL) Read(page int) ([]byte, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.read(page, w.index.mxFrame)
+}
+
+// read reads the latest version of the page up to the end mark, a checkpoint moves
+// pages from the log to the database file.
+func (w *WAL) read(page int, mark uint64) ([]byte, error) {
 	// 从页缓存中读取
-	if data, ok := w.pageCache[page]; ok {
+	if data, ok := w.index.lookup(page, mark); ok {
 		dest := make([]byte, len(data))
 		copy(dest, data)
 		return dest, nil
@@ -96,6 +293,15 @@ func (w *WAL) Write(pages ...Page) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	return w.write(pages)
+}
+
+// write logs the pages of a transaction, readers see them once they are all logged.
+func (w *WAL) write(pages []Page) error {
+	if len(pages) == 0 {
+		return nil
+	}
+
 	// First page in the wal
 	// 如果是第一页，需要提前 WAL 写文件头
 	if w.pos == 0 {
@@ -104,47 +310,230 @@ func (w *WAL) Write(pages ...Page) error {
 		}
 	}
 
+	// The size of the database after the commit
+	size := w.totalPages(w.index.mxFrame)
+	for _, p := range pages {
+		if p.PageNumber > size {
+			size = p.PageNumber
+		}
+	}
+
+	// A transaction which fails to be logged leaves no frames behind
+	pos, checksum1, checksum2 := w.pos, w.checksum1, w.checksum2
+
 	// Write all pages out.
 	// The last page written is the commit page.
+	committed := make([]Page, len(pages))
 	for i, p := range pages {
 		// 数据拷贝
 		dest := make([]byte, len(p.Data))
 		copy(dest, p.Data)
+		committed[i] = Page{PageNumber: p.PageNumber, Data: dest}
 
-		// 写页缓存
-		w.pageCache[p.PageNumber] = dest
-
-		// 更新总页数
-		if p.PageNumber > w.totalPages {
-			w.totalPages = p.PageNumber
+		commitSize := 0
+		if i == len(pages)-1 {
+			commitSize = size
 		}
-
-		lastPage := i == len(pages)-1
-		if err := w.writeLog(p.PageNumber, dest, lastPage); err != nil {
+		if err := w.writeLog(p.PageNumber, dest, commitSize); err != nil {
+			w.pos, w.checksum1, w.checksum2 = pos, checksum1, checksum2
 			return err
 		}
 	}
 
+	w.index.commit(committed, size)
+
+	if w.autoCheckpoint > 0 && w.frameCount() >= w.autoCheckpoint {
+		select {
+		case w.checkpointCh <- struct{}{}:
+		default:
+		}
+	}
+
 	return nil
 }
 
-func (w *WAL) Checkpoint() error {
+// SetAutoCheckpoint sets the number of frames in the log which requests a checkpoint,
+// 0 disables automatic checkpoints.
+func (w *WAL) SetAutoCheckpoint(frames int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.autoCheckpoint = frames
+}
+
+// CheckpointRequests receives when the log has grown to the automatic checkpoint
+// threshold, it's closed when the log is closed.
+func (w *WAL) CheckpointRequests() <-chan struct{} {
+	return w.checkpointCh
+}
+
+// FrameCount is the number of frames in the log since it was last started over.
+func (w *WAL) FrameCount() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.frameCount()
+}
+
+func (w *WAL) frameCount() int {
+	if w.pos < WALHeaderLen {
+		return 0
+	}
+	return int(w.pos-WALHeaderLen) / (WALFrameHeaderLen + w.dbFile.PageSize())
+}
+
+// Checkpoint copies the pages of committed transactions in the log to the database file.
+// Frames later than the snapshot of a reader are kept in the log, the log starts over
+// once every frame is copied. Readers see the pages in the database file once the
+// checkpoint completes.
+func (w *WAL) Checkpoint(mode CheckpointMode) (CheckpointResult, error) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	// Write all pages to db file
-	var pagesToWrite []Page
-	for pageNumber, data := range w.pageCache {
-		pagesToWrite = append(pagesToWrite, Page{PageNumber: pageNumber, Data: data})
+	return w.checkpoint(mode, nil)
+}
+
+// checkpoint copies the frames, the mutex must be held. Modes other than CheckpointPassive
+// first wait for the readers of older snapshots, except for the snapshot of conn which
+// can't end while the connection is checkpointing.
+func (w *WAL) checkpoint(mode CheckpointMode, conn *WALConn) (CheckpointResult, error) {
+	if mode != CheckpointPassive {
+		// Readers which don't finish in time keep their frames in the log
+		w.waitReaders(func() bool {
+			limit := w.index.backfillLimit()
+			return limit == w.index.mxFrame || (conn != nil && conn.reading && conn.mark == limit)
+		})
 	}
 
-	if len(pagesToWrite) > 0 {
+	limit := w.index.backfillLimit()
+
+	// Write the pages to db file, the file grows one page at a time
+	if pagesToWrite := w.index.backfill(limit); len(pagesToWrite) > 0 {
 		if err := w.dbFile.Write(pagesToWrite...); err != nil {
-			return err
+			return CheckpointResult{}, err
 		}
 	}
 
+	// The frames may only be discarded once the pages are durable
+	if err := w.dbFile.Sync(); err != nil {
+		return CheckpointResult{}, err
+	}
+	w.index.prune(limit)
+
+	result := CheckpointResult{
+		Busy:      limit < w.index.mxFrame,
+		LogFrames: w.frameCount(),
+	}
+	if limit > w.logStart {
+		result.Checkpointed = int(limit - w.logStart)
+	}
+	if result.Busy {
+		return result, nil
+	}
+
 	// Checkpoints always start at the beginning of the file
+	w.logStart = w.index.mxFrame
+	w.pos = 0
+
+	switch mode {
+	case CheckpointRestart:
+		if err := w.writeHeader(); err != nil {
+			return CheckpointResult{}, err
+		}
+	case CheckpointTruncate:
+		if err := w.file.Truncate(0); err != nil {
+			return CheckpointResult{}, err
+		}
+		if err := w.file.Sync(); err != nil {
+			return CheckpointResult{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// waitReaders waits until ready or busyTimeout, the mutex must be held.
+func (w *WAL) waitReaders(ready func() bool) bool {
+	if ready() {
+		return true
+	}
+
+	timedOut := false
+	timer := time.AfterFunc(busyTimeout, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		timedOut = true
+		w.readersDone.Broadcast()
+	})
+	defer timer.Stop()
+
+	for !ready() {
+		if timedOut {
+			return false
+		}
+		w.readersDone.Wait()
+	}
+	return true
+}
+
+// Close closes the log and the database file.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	close(w.checkpointCh)
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	return w.dbFile.Close()
+}
+
+// RemoveWAL checkpoints the committed transactions of a log left behind in WAL mode and
+// removes it, so the database file can be committed to with a rollback journal.
+func RemoveWAL(dbFile *DbFile) error {
+	path := dbFile.Path() + "-wal"
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	w, err := OpenWAL(dbFile)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Checkpoint(CheckpointTruncate); err != nil {
+		w.file.Close()
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// Replace replaces the database file with src, the frames in the log are discarded.
+// src must contain every committed page.
+func (w *WAL) Replace(src *DbFile) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.replace(src)
+}
+
+func (w *WAL) replace(src *DbFile) error {
+	if err := w.dbFile.Replace(src); err != nil {
+		return err
+	}
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+
+	w.index.reset(w.dbFile.TotalPages())
+
+	// The next write starts a new log
+	w.logStart = w.index.mxFrame
 	w.pos = 0
 
 	return nil
@@ -157,6 +546,7 @@ func (w *WAL) writeHeader() error {
 	w.checkpointNumber++	// 检查点计数
 	w.salt1 = rand.Uint32()	// 随机盐
 	w.salt2 = rand.Uint32()	// 随机盐
+	w.order = binary.LittleEndian
 
 	binary.BigEndian.PutUint32(header[0:4], WALMagicNumber)
 	binary.BigEndian.PutUint32(header[4:8], WALFileFormat)
@@ -167,12 +557,12 @@ func (w *WAL) writeHeader() error {
 
 	// Calculate the sum of the header up to this point
 	// 计算 WAL 文件头的 CRC 校验和，并存储到 header[24:32] 上
-	h := crc64.New(crc64.MakeTable(crc64.ISO))
-	_, err := h.Write(header[:24])
+	s0, s1, err := headerChecksum(header[:24], w.order)
 	if err != nil {
 		return err
 	}
-	binary.BigEndian.PutUint64(header[24:32], h.Sum64())
+	binary.BigEndian.PutUint32(header[24:28], s0)
+	binary.BigEndian.PutUint32(header[28:32], s1)
 
 	// Write the header to the start of the file & flush
 	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
@@ -189,6 +579,9 @@ func (w *WAL) writeHeader() error {
 	// 更新文件写入偏移
 	w.pos = WALHeaderLen
 
+	// The checksum of the first frame continues the checksum of the header
+	w.checksum1, w.checksum2 = s0, s1
+
 	return nil
 }
 
@@ -197,15 +590,15 @@ func (w *WAL) writeHeader() error {
 // 页数据
 // 是否提交
 
-func (w *WAL) writeLog(pageNumber int, data []byte, isCommit bool) error {
+func (w *WAL) writeLog(pageNumber int, data []byte, commitSize int) error {
 	//
-	frame, err := w.makeWalFrame(pageNumber, data, isCommit)
+	frame, err := w.makeWalFrame(pageNumber, data, commitSize)
 	if err != nil {
 		return err
 	}
 
-	// 将 frame 写入到 w.file 中
-	if _, err := io.Copy(w.file, bytes.NewReader(frame)); err != nil {
+	// 将 frame 写入到 w.file 的写入偏移处，覆盖未提交的帧
+	if _, err := w.file.WriteAt(frame, int64(w.pos)); err != nil {
 		return err
 	// 将 w.file 落盘
 	} else if err := w.file.Sync(); err != nil {
@@ -214,30 +607,29 @@ func (w *WAL) writeLog(pageNumber int, data []byte, isCommit bool) error {
 
 	// 更新写入偏移
 	w.pos += uint32(len(frame))
+	w.checksum1 = binary.BigEndian.Uint32(frame[16:20])
+	w.checksum2 = binary.BigEndian.Uint32(frame[20:24])
 	return nil
 }
 
-// WALFrame = WALFrameHeader +
-
-func (w *WAL) makeWalFrame(pageNo int, data []byte, isCommit bool) ([]byte, error) {
-
-	header := make([]byte, WALFrameHeaderLen, WALFrameHeaderLen+w.dbFile.PageSize())
+// WALFrame = WALFrameHeader + page data
+//
+// The commit frame of a transaction holds the size of the database in pages after the
+// commit, it's zero for other frames. The checksum of a frame continues the checksum
+// of the previous frame.
+func (w *WAL) makeWalFrame(pageNo int, data []byte, commitSize int) ([]byte, error) {
+	frame := make([]byte, WALFrameHeaderLen+len(data))
 
 	// 写入页号
-	binary.BigEndian.PutUint32(header[0:4], uint32(pageNo))
-	// 写入 Commit 标识
-	if isCommit {
-		binary.BigEndian.PutUint32(header[4:8], 1)
-	} else {
-		binary.BigEndian.PutUint32(header[4:8], 0)
-	}
+	binary.BigEndian.PutUint32(frame[0:4], uint32(pageNo))
+	// 写入 Commit 标识，即提交后的数据库页数
+	binary.BigEndian.PutUint32(frame[4:8], uint32(commitSize))
 
 	// 写入盐
-	binary.BigEndian.PutUint32(header[8:12], w.salt1)
-	binary.BigEndian.PutUint32(header[12:16], w.salt2)
+	binary.BigEndian.PutUint32(frame[8:12], w.salt1)
+	binary.BigEndian.PutUint32(frame[12:16], w.salt2)
 
-	//
-	//
+	copy(frame[WALFrameHeaderLen:], data)
 
 	// The checksum values in the final 8 bytes of the frame-header exactly
 	// match the checksum computed consecutively on the first 24 bytes of
@@ -245,28 +637,38 @@ func (w *WAL) makeWalFrame(pageNo int, data []byte, isCommit bool) ([]byte, erro
 	// up to and including the current frame.
 	//
 	// 帧头的最后8个字节中的校验和值与在WAL头的前24个字节和前8个字节上连续计算的校验和值以及当前帧之前（包括当前帧）的所有帧的内容完全匹配。
-	//
-	//
-	binary.BigEndian.PutUint64(header[24:32], 0)
-
-	pageBuffer := bytes.NewBuffer(header)
-	if _, err := pageBuffer.Write(header); err != nil {
-		return nil, err
-	}
-	if _, err := pageBuffer.Write(data); err != nil {
+	s0, s1, err := frameChecksum(frame, w.checksum1, w.checksum2, w.order)
+	if err != nil {
 		return nil, err
 	}
+	binary.BigEndian.PutUint32(frame[16:20], s0)
+	binary.BigEndian.PutUint32(frame[20:24], s1)
+
+	return frame, nil
+}
+
+// headerChecksum computes the checksum of the first 24 bytes of the WAL header.
+func headerChecksum(header []byte, order binary.ByteOrder) (uint32, uint32, error) {
+	return checkSum(header, 0, 0, order)
+}
 
-	return pageBuffer.Bytes(), nil
+// frameChecksum continues the checksum s0, s1 with the first 8 bytes of the frame
+// header and the page data of the frame.
+func frameChecksum(frame []byte, s0, s1 uint32, order binary.ByteOrder) (uint32, uint32, error) {
+	s0, s1, err := checkSum(frame[:8], s0, s1, order)
+	if err != nil {
+		return 0, 0, err
+	}
+	return checkSum(frame[WALFrameHeaderLen:], s0, s1, order)
 }
 
-// checkSum only works for content which is an odd multiple of 8 bytes in length.
+// checkSum only works for content which is a multiple of 8 bytes in length.
 func checkSum(b []byte, s0, s1 uint32, order binary.ByteOrder) (uint32, uint32, error) {
 
-	// Work in chunks of 8 bytes, x better be odd
+	// Work in chunks of 8 bytes
 	x := len(b) >> 3
-	if x%2 == 0 {
-		return 0, 0, errors.New("checkSum only works with odd multiples of 8 bytes")
+	if len(b)%8 != 0 {
+		return 0, 0, errors.New("checkSum only works with multiples of 8 bytes")
 	}
 
 	for i := 0; i < x; i++ {
@@ -279,3 +681,5 @@ func checkSum(b []byte, s0, s1 uint32, order binary.ByteOrder) (uint32, uint32,
 
 var _ PageReader = (*WAL)(nil)
 var _ PageWriter = (*WAL)(nil)
+var _ ReplaceableFile = (*WAL)(nil)
+var _ CheckpointFile = (*WAL)(nil)
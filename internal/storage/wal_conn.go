@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// busyTimeout is how long a connection waits for another connection to finish writing,
+// or a checkpoint waits for readers to finish
+var busyTimeout = 30 * time.Second
+
+var (
+	// ErrBusy is returned when another connection keeps writing for longer than busyTimeout
+	ErrBusy = errors.New("database is locked")
+	// ErrStaleSnapshot is returned when a transaction which read a snapshot of the database
+	// begins writing after another connection committed
+	ErrStaleSnapshot = errors.New("database changed since the transaction began")
+)
+
+// SnapshotFile is a file read from a snapshot of the committed transactions,
+// one connection at a time writes to it.
+type SnapshotFile interface {
+	File
+	// BeginRead takes a snapshot of the committed transactions, returns true when
+	// other connections committed since the previous snapshot of the connection.
+	BeginRead() (bool, error)
+	EndRead()
+	// BeginWrite waits for other connections to finish writing.
+	BeginWrite() error
+	EndWrite()
+	// Snapshot identifies the committed state read by the connection, it grows with
+	// every commit.
+	Snapshot() uint64
+}
+
+// WALConn is a connection to the log which reads the database as of the snapshot taken
+// by BeginRead, or the latest committed state outside of a read transaction.
+type WALConn struct {
+	wal *WAL
+
+	// mark is the end mark of the snapshot, or of the latest snapshot seen outside of a read transaction
+	mark    uint64
+	reading bool
+	writing bool
+}
+
+// Connect makes a new connection to the log.
+func (w *WAL) Connect() *WALConn {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return &WALConn{wal: w, mark: w.index.mxFrame}
+}
+
+func (c *WALConn) BeginRead() (bool, error) {
+	if c.reading {
+		return false, nil
+	}
+
+	c.wal.mu.Lock()
+	defer c.wal.mu.Unlock()
+
+	mark := c.wal.index.beginRead()
+	changed := mark != c.mark
+	c.mark = mark
+	c.reading = true
+
+	return changed, nil
+}
+
+func (c *WALConn) EndRead() {
+	if !c.reading {
+		return
+	}
+
+	c.wal.mu.Lock()
+	defer c.wal.mu.Unlock()
+
+	c.wal.index.endRead(c.mark)
+	c.reading = false
+	c.wal.readersDone.Broadcast()
+}
+
+func (c *WALConn) BeginWrite() error {
+	if c.writing {
+		return nil
+	}
+
+	select {
+	case c.wal.writeSema <- struct{}{}:
+	case <-time.After(busyTimeout):
+		return ErrBusy
+	}
+
+	// Changes based on a snapshot which is no longer the latest would be lost
+	c.wal.mu.RLock()
+	stale := c.reading && c.mark != c.wal.index.mxFrame
+	c.wal.mu.RUnlock()
+	if stale {
+		<-c.wal.writeSema
+		return ErrStaleSnapshot
+	}
+
+	c.writing = true
+	return nil
+}
+
+func (c *WALConn) EndWrite() {
+	if !c.writing {
+		return
+	}
+
+	c.writing = false
+	<-c.wal.writeSema
+}
+
+func (c *WALConn) Snapshot() uint64 {
+	c.wal.mu.RLock()
+	defer c.wal.mu.RUnlock()
+
+	return c.snapshot()
+}
+
+func (c *WALConn) PageSize() int {
+	return c.wal.PageSize()
+}
+
+func (c *WALConn) TotalPages() int {
+	c.wal.mu.RLock()
+	defer c.wal.mu.RUnlock()
+
+	return c.wal.totalPages(c.snapshot())
+}
+
+func (c *WALConn) Path() string {
+	return c.wal.Path()
+}
+
+func (c *WALConn) Read(page int) ([]byte, error) {
+	c.wal.mu.RLock()
+	defer c.wal.mu.RUnlock()
+
+	return c.wal.read(page, c.snapshot())
+}
+
+// Write logs the pages of a transaction, the connection must be writing.
+func (c *WALConn) Write(pages ...Page) error {
+	if !c.writing {
+		return errors.New("cannot write outside of a write transaction")
+	}
+
+	c.wal.mu.Lock()
+	defer c.wal.mu.Unlock()
+
+	if err := c.wal.write(pages); err != nil {
+		return err
+	}
+	c.follow()
+
+	return nil
+}
+
+// Replace replaces the database file with src, the connection must be writing and
+// no other connection may be reading.
+func (c *WALConn) Replace(src *DbFile) error {
+	if !c.writing {
+		return errors.New("cannot replace the database outside of a write transaction")
+	}
+
+	c.wal.mu.Lock()
+	defer c.wal.mu.Unlock()
+
+	readers := c.wal.index.readerCount()
+	if c.reading {
+		readers--
+	}
+	if readers > 0 {
+		return errors.New("cannot replace the database while other connections are reading")
+	}
+
+	if err := c.wal.replace(src); err != nil {
+		return err
+	}
+	c.follow()
+
+	return nil
+}
+
+func (c *WALConn) Checkpoint(mode CheckpointMode) (CheckpointResult, error) {
+	c.wal.mu.Lock()
+	defer c.wal.mu.Unlock()
+
+	return c.wal.checkpoint(mode, c)
+}
+
+// snapshot is the end mark of the frames seen by the connection.
+func (c *WALConn) snapshot() uint64 {
+	if c.reading {
+		return c.mark
+	}
+	return c.wal.index.mxFrame
+}
+
+// follow moves the snapshot of the connection to its own commit.
+func (c *WALConn) follow() {
+	if c.reading {
+		c.wal.index.endRead(c.mark)
+		c.mark = c.wal.index.beginRead()
+		c.wal.readersDone.Broadcast()
+		return
+	}
+	c.mark = c.wal.index.mxFrame
+}
+
+var _ SnapshotFile = (*WALConn)(nil)
+var _ ReplaceableFile = (*WALConn)(nil)
+var _ CheckpointFile = (*WALConn)(nil)
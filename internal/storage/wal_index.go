@@ -0,0 +1,153 @@
+package storage
+
+import "sort"
+
+// walIndex maps pages to the frames of the log holding them. Frames are numbered in
+// the order they are committed and the numbers keep growing when the log starts over,
+// so the end mark of a reader's snapshot stays meaningful across checkpoints.
+//
+// A reader sees the latest version of a page up to its end mark, or the page in the
+// database file when there is no such version. A checkpoint copies frames up to the
+// smallest end mark of the readers to the database file, later frames are kept until
+// the readers which can't see them are done.
+type walIndex struct {
+	// pages holds the versions of each page in frame order
+	pages map[int][]walFrame
+
+	// commits holds the commit frames with the size of the database after the commit,
+	// the first one is the last commit already copied to the database file, if any.
+	commits []walCommit
+
+	// mxFrame is the last committed frame
+	mxFrame uint64
+
+	// readers is the number of readers for each end mark
+	readers map[uint64]int
+}
+
+type walFrame struct {
+	frame uint64
+	data  []byte
+}
+
+type walCommit struct {
+	frame uint64
+	size  int
+}
+
+func newWALIndex() *walIndex {
+	return &walIndex{
+		pages:   make(map[int][]walFrame),
+		readers: make(map[uint64]int),
+	}
+}
+
+// commit adds the pages of a transaction, the frames are numbered after the last committed frame.
+func (x *walIndex) commit(pages []Page, size int) {
+	for _, page := range pages {
+		x.mxFrame++
+		x.pages[page.PageNumber] = append(x.pages[page.PageNumber], walFrame{frame: x.mxFrame, data: page.Data})
+	}
+	x.commits = append(x.commits, walCommit{frame: x.mxFrame, size: size})
+}
+
+// lookup finds the latest version of the page up to the end mark.
+func (x *walIndex) lookup(pageNumber int, mark uint64) ([]byte, bool) {
+	versions := x.pages[pageNumber]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].frame <= mark {
+			return versions[i].data, true
+		}
+	}
+	return nil, false
+}
+
+// size finds the size of the database in pages as of the end mark.
+func (x *walIndex) size(mark uint64) (int, bool) {
+	for i := len(x.commits) - 1; i >= 0; i-- {
+		if x.commits[i].frame <= mark {
+			return x.commits[i].size, true
+		}
+	}
+	return 0, false
+}
+
+// beginRead registers a reader of the latest committed frames, returns its end mark.
+func (x *walIndex) beginRead() uint64 {
+	x.readers[x.mxFrame]++
+	return x.mxFrame
+}
+
+// endRead unregisters a reader with the end mark.
+func (x *walIndex) endRead(mark uint64) {
+	if x.readers[mark] <= 1 {
+		delete(x.readers, mark)
+		return
+	}
+	x.readers[mark]--
+}
+
+// readerCount is the number of registered readers.
+func (x *walIndex) readerCount() int {
+	count := 0
+	for _, n := range x.readers {
+		count += n
+	}
+	return count
+}
+
+// backfillLimit is the last frame which can be copied to the database file without
+// changing a page seen by a reader.
+func (x *walIndex) backfillLimit() uint64 {
+	limit := x.mxFrame
+	for mark := range x.readers {
+		if mark < limit {
+			limit = mark
+		}
+	}
+	return limit
+}
+
+// backfill returns the latest version of each page up to the limit in page order.
+func (x *walIndex) backfill(limit uint64) []Page {
+	var pages []Page
+	for pageNumber := range x.pages {
+		if data, ok := x.lookup(pageNumber, limit); ok {
+			pages = append(pages, Page{PageNumber: pageNumber, Data: data})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return pages[i].PageNumber < pages[j].PageNumber
+	})
+	return pages
+}
+
+// prune forgets the frames up to the limit once they are copied to the database file.
+// The last commit up to the limit is kept for the size of the database.
+func (x *walIndex) prune(limit uint64) {
+	for pageNumber, versions := range x.pages {
+		i := 0
+		for i < len(versions) && versions[i].frame <= limit {
+			i++
+		}
+		if i == len(versions) {
+			delete(x.pages, pageNumber)
+			continue
+		}
+		x.pages[pageNumber] = versions[i:]
+	}
+
+	i := 0
+	for i+1 < len(x.commits) && x.commits[i+1].frame <= limit {
+		i++
+	}
+	x.commits = x.commits[i:]
+}
+
+// reset forgets every frame when the database file is replaced, the replacement is
+// committed as a frame of its own so readers notice the change.
+func (x *walIndex) reset(size int) {
+	x.mxFrame++
+	x.pages = make(map[int][]walFrame)
+	x.commits = []walCommit{{frame: x.mxFrame, size: size}}
+}
@@ -1,8 +1,12 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/binary"
+	"os"
+	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -28,3 +32,312 @@ func TestChecksum(t *testing.T) {
 	assert.Equal(expectedSum1, s0)
 	assert.Equal(expectedSum2, s1)
 }
+
+func TestWAL_Recover(t *testing.T) {
+	assert := require.New(t)
+
+	dbPath := path.Join(t.TempDir(), "tiny.db")
+	open := func() *WAL {
+		dbFile, err := OpenDbFile(dbPath, 1024)
+		assert.NoError(err)
+		wal, err := OpenWAL(dbFile)
+		assert.NoError(err)
+		return wal
+	}
+	page := func(pageNumber int, b byte) Page {
+		return Page{PageNumber: pageNumber, Data: bytes.Repeat([]byte{b}, 1024)}
+	}
+
+	wal := open()
+	assert.NoError(wal.Write(page(1, 'a'), page(2, 'a')))
+	assert.NoError(wal.Write(page(2, 'b'), page(3, 'b')))
+
+	// Frames of a transaction which wasn't committed and a torn frame are ignored
+	assert.NoError(wal.writeLog(4, page(4, 'c').Data, 0))
+	_, err := wal.file.WriteAt([]byte("torn"), int64(wal.pos))
+	assert.NoError(err)
+	assert.NoError(wal.Close())
+
+	wal = open()
+	assert.Equal(3, wal.TotalPages())
+	for pageNumber, b := range map[int]byte{1: 'a', 2: 'b', 3: 'b'} {
+		data, err := wal.Read(pageNumber)
+		assert.NoError(err)
+		assert.Equal(page(pageNumber, b).Data, data)
+	}
+
+	// Writes continue after the last commit
+	assert.NoError(wal.Write(page(3, 'd')))
+	assert.NoError(wal.Close())
+
+	wal = open()
+	data, err := wal.Read(3)
+	assert.NoError(err)
+	assert.Equal(page(3, 'd').Data, data)
+
+	// A corrupt frame ends the log
+	frameLen := int64(WALFrameHeaderLen + 1024)
+	_, err = wal.file.WriteAt([]byte{'x'}, WALHeaderLen+2*frameLen+WALFrameHeaderLen)
+	assert.NoError(err)
+	assert.NoError(wal.Close())
+
+	wal = open()
+	assert.Equal(2, wal.TotalPages())
+	data, err = wal.Read(2)
+	assert.NoError(err)
+	assert.Equal(page(2, 'a').Data, data)
+	assert.NoError(wal.Close())
+}
+
+func TestWAL_Checkpoint(t *testing.T) {
+	assert := require.New(t)
+
+	dbPath := path.Join(t.TempDir(), "tiny.db")
+	open := func() *WAL {
+		dbFile, err := OpenDbFile(dbPath, 1024)
+		assert.NoError(err)
+		wal, err := OpenWAL(dbFile)
+		assert.NoError(err)
+		return wal
+	}
+	page := func(pageNumber int, b byte) Page {
+		return Page{PageNumber: pageNumber, Data: bytes.Repeat([]byte{b}, 1024)}
+	}
+	walSize := func() int64 {
+		info, err := os.Stat(dbPath + "-wal")
+		assert.NoError(err)
+		return info.Size()
+	}
+
+	wal := open()
+	wal.SetAutoCheckpoint(3)
+	assert.NoError(wal.Write(page(1, 'a'), page(2, 'a')))
+	assert.Len(wal.CheckpointRequests(), 0)
+	assert.NoError(wal.Write(page(3, 'a')))
+	assert.Len(wal.CheckpointRequests(), 1)
+
+	// The pages are copied to the database file and the log starts over
+	result, err := wal.Checkpoint(CheckpointPassive)
+	assert.NoError(err)
+	assert.Equal(CheckpointResult{LogFrames: 3, Checkpointed: 3}, result)
+	assert.Zero(wal.FrameCount())
+	assert.Equal(3, wal.dbFile.TotalPages())
+	for pageNumber := 2; pageNumber <= 3; pageNumber++ {
+		data, err := wal.dbFile.Read(pageNumber)
+		assert.NoError(err)
+		assert.Equal(page(pageNumber, 'a').Data, data)
+	}
+
+	// Frames before the checkpoint aren't recovered
+	assert.NoError(wal.Write(page(2, 'b')))
+	assert.Equal(1, wal.FrameCount())
+	assert.NoError(wal.Close())
+
+	wal = open()
+	assert.Equal(1, wal.FrameCount())
+	data, err := wal.Read(2)
+	assert.NoError(err)
+	assert.Equal(page(2, 'b').Data, data)
+
+	// A restart writes a new header right away, a truncate empties the log
+	salt1 := wal.salt1
+	_, err = wal.Checkpoint(CheckpointRestart)
+	assert.NoError(err)
+	assert.NotEqual(salt1, wal.salt1)
+	salt1 = wal.salt1
+	assert.NoError(wal.Close())
+
+	wal = open()
+	assert.Zero(wal.FrameCount())
+	assert.Equal(salt1, wal.salt1)
+	data, err = wal.Read(2)
+	assert.NoError(err)
+	assert.Equal(page(2, 'b').Data, data)
+
+	assert.NoError(wal.Write(page(3, 'c')))
+	_, err = wal.Checkpoint(CheckpointTruncate)
+	assert.NoError(err)
+	assert.Zero(walSize())
+	assert.NoError(wal.Close())
+
+	wal = open()
+	data, err = wal.Read(3)
+	assert.NoError(err)
+	assert.Equal(page(3, 'c').Data, data)
+	assert.NoError(wal.Close())
+}
+
+func TestWAL_CheckpointReaders(t *testing.T) {
+	defer func(timeout time.Duration) { busyTimeout = timeout }(busyTimeout)
+
+	page := func(pageNumber int, b byte) Page {
+		return Page{PageNumber: pageNumber, Data: bytes.Repeat([]byte{b}, 1024)}
+	}
+	modes := map[string]CheckpointMode{
+		"passive":  CheckpointPassive,
+		"full":     CheckpointFull,
+		"restart":  CheckpointRestart,
+		"truncate": CheckpointTruncate,
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			assert := require.New(t)
+
+			dbFile, err := OpenDbFile(path.Join(t.TempDir(), "tiny.db"), 1024)
+			assert.NoError(err)
+			wal, err := OpenWAL(dbFile)
+			assert.NoError(err)
+			defer wal.Close()
+
+			writer, reader := wal.Connect(), wal.Connect()
+			write := func(pages ...Page) {
+				assert.NoError(writer.BeginWrite())
+				assert.NoError(writer.Write(pages...))
+				writer.EndWrite()
+			}
+			write(page(1, 'a'))
+			_, err = reader.BeginRead()
+			assert.NoError(err)
+			write(page(1, 'b'), page(2, 'b'))
+			busy := CheckpointResult{Busy: true, LogFrames: 3, Checkpointed: 1}
+
+			// A reader which doesn't finish in time keeps the later frames in the log
+			busyTimeout = 10 * time.Millisecond
+			result, err := wal.Checkpoint(mode)
+			assert.NoError(err)
+			assert.Equal(busy, result)
+
+			// A connection doesn't wait for its own snapshot
+			busyTimeout = time.Minute
+			result, err = reader.Checkpoint(mode)
+			assert.NoError(err)
+			assert.Equal(busy, result)
+
+			if mode == CheckpointPassive {
+				// A passive checkpoint doesn't wait for the reader
+				result, err = wal.Checkpoint(mode)
+				assert.NoError(err)
+				assert.Equal(busy, result)
+				reader.EndRead()
+				result, err = wal.Checkpoint(mode)
+				assert.NoError(err)
+			} else {
+				// The checkpoint waits for the reader to finish
+				done := make(chan CheckpointResult)
+				go func() {
+					result, err := wal.Checkpoint(mode)
+					if err != nil {
+						result = CheckpointResult{}
+					}
+					done <- result
+				}()
+
+				select {
+				case <-done:
+					assert.Fail("checkpoint didn't wait for the reader")
+				case <-time.After(20 * time.Millisecond):
+				}
+
+				reader.EndRead()
+				result = <-done
+			}
+			assert.Equal(CheckpointResult{LogFrames: 3, Checkpointed: 3}, result)
+			assert.Zero(wal.FrameCount())
+
+			// The frames are in the database file
+			data, err := wal.dbFile.Read(2)
+			assert.NoError(err)
+			assert.Equal(page(2, 'b').Data, data)
+		})
+	}
+}
+
+func TestParseCheckpointMode(t *testing.T) {
+	assert := require.New(t)
+
+	for name, expected := range map[string]CheckpointMode{
+		"":         CheckpointPassive,
+		"passive":  CheckpointPassive,
+		"FULL":     CheckpointFull,
+		"Restart":  CheckpointRestart,
+		"TRUNCATE": CheckpointTruncate,
+	} {
+		mode, err := ParseCheckpointMode(name)
+		assert.NoError(err)
+		assert.Equal(expected, mode)
+	}
+
+	_, err := ParseCheckpointMode("sometimes")
+	assert.Error(err)
+}
+
+func TestWALConn_Snapshot(t *testing.T) {
+	assert := require.New(t)
+
+	dbFile, err := OpenDbFile(path.Join(t.TempDir(), "tiny.db"), 1024)
+	assert.NoError(err)
+	wal, err := OpenWAL(dbFile)
+	assert.NoError(err)
+	defer wal.Close()
+
+	page := func(pageNumber int, b byte) Page {
+		return Page{PageNumber: pageNumber, Data: bytes.Repeat([]byte{b}, 1024)}
+	}
+	write := func(c *WALConn, pages ...Page) {
+		assert.NoError(c.BeginWrite())
+		assert.NoError(c.Write(pages...))
+		c.EndWrite()
+	}
+	assertPage := func(c *WALConn, expected Page) {
+		data, err := c.Read(expected.PageNumber)
+		assert.NoError(err)
+		assert.Equal(expected.Data, data)
+	}
+
+	// Checkpoints don't wait long for the reader
+	defer func(timeout time.Duration) { busyTimeout = timeout }(busyTimeout)
+	busyTimeout = 10 * time.Millisecond
+
+	writer, reader := wal.Connect(), wal.Connect()
+	assert.Error(writer.Write(page(1, 'a')))
+	write(writer, page(1, 'a'), page(2, 'a'))
+
+	changed, err := reader.BeginRead()
+	assert.NoError(err)
+	assert.True(changed)
+	assertPage(reader, page(2, 'a'))
+
+	// Later commits aren't part of the snapshot
+	write(writer, page(2, 'b'), page(3, 'b'))
+	assertPage(writer, page(2, 'b'))
+	assertPage(reader, page(2, 'a'))
+	assert.Equal(3, writer.TotalPages())
+	assert.Equal(2, reader.TotalPages())
+	assert.Equal(ErrStaleSnapshot, reader.BeginWrite())
+
+	// Frames are copied up to the snapshot of the reader
+	result, err := wal.Checkpoint(CheckpointTruncate)
+	assert.NoError(err)
+	assert.Equal(CheckpointResult{Busy: true, LogFrames: 4, Checkpointed: 2}, result)
+	assertPage(reader, page(2, 'a'))
+	assertPage(writer, page(2, 'b'))
+
+	reader.EndRead()
+	result, err = wal.Checkpoint(CheckpointTruncate)
+	assert.NoError(err)
+	assert.Equal(CheckpointResult{LogFrames: 4, Checkpointed: 4}, result)
+	assert.Zero(wal.FrameCount())
+
+	// A new snapshot sees every commit
+	changed, err = reader.BeginRead()
+	assert.NoError(err)
+	assert.True(changed)
+	assertPage(reader, page(2, 'b'))
+	assert.Equal(3, reader.TotalPages())
+	reader.EndRead()
+
+	changed, err = reader.BeginRead()
+	assert.NoError(err)
+	assert.False(changed)
+	reader.EndRead()
+}
@@ -0,0 +1,73 @@
+package virtualmachine
+
+import (
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+)
+
+// wellFormedNumber matches a text which reads as a number as a whole
+var wellFormedNumber = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// applyAffinity converts the value of a register to the type preferred by the affinity
+// when it can be done without loss like SQLite does before storing a value in a column.
+// Text which doesn't read as a number is left as text by the numeric affinities,
+// NULLs and blobs are never converted.
+func applyAffinity(r *register, affinity storage.Affinity) {
+	switch affinity {
+	case storage.AffinityText:
+		if r.isNumeric() {
+			r.data = text(r)
+			r.typ = RegString
+		}
+	case storage.AffinityNumeric, storage.AffinityInteger, storage.AffinityReal:
+		if r.typ == RegString {
+			s := strings.TrimSpace(r.data.(string))
+			if !wellFormedNumber.MatchString(s) {
+				return
+			}
+			value, err := parseNumber(s)
+			if err != nil {
+				return
+			}
+			r.setNumber(value)
+		}
+
+		switch {
+		case affinity == storage.AffinityReal && r.isNumeric():
+			r.setFloat64(r.float64())
+		case r.typ == RegFloat64:
+			// A real without a fraction is stored as an integer
+			f := r.data.(float64)
+			if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+				r.setInt64(int64(f))
+			}
+		}
+	}
+}
+
+// withAffinity is a copy of the register with the affinity applied,
+// the register itself is left as is.
+func withAffinity(r *register, affinity storage.Affinity) *register {
+	c := *r
+	applyAffinity(&c, affinity)
+	return &c
+}
+
+// comparisonAffinity is the affinity applied to both values of a comparison given the
+// affinities of the operands, zero for an operand without affinity. Like SQLite, a numeric
+// affinity of either operand wins, otherwise an operand without affinity takes the affinity
+// of the other one. Zero means the values are compared as is.
+func comparisonAffinity(a storage.Affinity, b storage.Affinity) storage.Affinity {
+	switch {
+	case a.IsNumeric() || b.IsNumeric():
+		return storage.AffinityNumeric
+	case a == 0:
+		return b
+	case b == 0:
+		return a
+	}
+	return storage.AffinityBlob
+}
@@ -0,0 +1,272 @@
+package virtualmachine
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// numberPrefix matches the longest prefix of a text which reads as a number
+var numberPrefix = regexp.MustCompile(`^\s*[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?`)
+
+// numeric is the value of a register as a number like SQLite converts operands of
+// arithmetic, text and blobs read as their longest numeric prefix or 0 without one.
+// NULL stays NULL.
+func numeric(r *register) *register {
+	switch r.typ {
+	case RegInt32, RegInt64, RegFloat64, RegNull:
+		return r
+	case RegString:
+		return numericPrefix(r.data.(string))
+	case RegBinary:
+		return numericPrefix(string(r.data.([]byte)))
+	}
+	return &register{typ: RegInt32, data: 0}
+}
+
+func numericPrefix(s string) *register {
+	n := &register{typ: RegInt32, data: 0}
+	if value, err := parseNumber(strings.TrimSpace(numberPrefix.FindString(s))); err == nil {
+		n.setNumber(value)
+	}
+	return n
+}
+
+// text is the value of a register as text, numbers are formatted like SQLite does.
+func text(r *register) string {
+	switch r.typ {
+	case RegString:
+		return r.data.(string)
+	case RegBinary:
+		return string(r.data.([]byte))
+	case RegInt32, RegInt64:
+		v, _ := r.int64()
+		return strconv.FormatInt(v, 10)
+	case RegFloat64:
+		return formatReal(r.data.(float64))
+	}
+	return ""
+}
+
+// formatReal formats a floating point number with 15 significant digits,
+// the mantissa always has a decimal point so the text reads as a real again.
+func formatReal(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+
+	s := strconv.FormatFloat(f, 'g', 15, 64)
+	mantissa, exponent := s, ""
+	if i := strings.IndexByte(s, 'e'); i >= 0 {
+		mantissa, exponent = s[:i], s[i:]
+	}
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	return mantissa + exponent
+}
+
+// truth is the value of a register as a boolean, a number is true unless it's zero.
+// isNull is true for NULL which is neither true nor false.
+func truth(r *register) (value bool, isNull bool) {
+	n := numeric(r)
+	if n.typ == RegNull {
+		return false, true
+	}
+	if i, ok := n.int64(); ok {
+		return i != 0, false
+	}
+	return n.float64() != 0, false
+}
+
+// toInt64 converts a real to an integer, truncating the fraction and
+// saturating at the limits of an int64.
+func toInt64(f float64) int64 {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f <= math.MinInt64:
+		return math.MinInt64
+	case f >= math.MaxInt64:
+		return math.MaxInt64
+	}
+	return int64(f)
+}
+
+// arithmetic computes a op b into dest for OpAdd, OpSubtract, OpMultiply, OpDivide and
+// OpRemainder. Integers give an integer unless the result overflows, which gives a real.
+func arithmetic(op Op, a *register, b *register, dest *register) {
+	a, b = numeric(a), numeric(b)
+	if a.typ == RegNull || b.typ == RegNull {
+		dest.setNull()
+		return
+	}
+
+	x, aInt := a.int64()
+	y, bInt := b.int64()
+
+	if op == OpRemainder {
+		if !aInt {
+			x = toInt64(a.float64())
+		}
+		if !bInt {
+			y = toInt64(b.float64())
+		}
+		switch y {
+		case 0:
+			dest.setNull()
+			return
+		case -1:
+			// The remainder is 0 and MinInt64 % -1 overflows
+			y = 1
+		}
+		if aInt && bInt {
+			dest.setInt64(x % y)
+		} else {
+			dest.setFloat64(float64(x % y))
+		}
+		return
+	}
+
+	if aInt && bInt {
+		if r, ok := integerArithmetic(op, x, y); ok {
+			dest.setInt64(r)
+			return
+		}
+		if op == OpDivide && y == 0 {
+			dest.setNull()
+			return
+		}
+	}
+
+	f, g := a.float64(), b.float64()
+	var r float64
+	switch op {
+	case OpAdd:
+		r = f + g
+	case OpSubtract:
+		r = f - g
+	case OpMultiply:
+		r = f * g
+	case OpDivide:
+		if g == 0 {
+			dest.setNull()
+			return
+		}
+		r = f / g
+	}
+	if math.IsNaN(r) {
+		dest.setNull()
+		return
+	}
+	dest.setFloat64(r)
+}
+
+// integerArithmetic computes x op y, ok is false when the result doesn't fit in an int64
+// or when dividing by zero.
+func integerArithmetic(op Op, x int64, y int64) (r int64, ok bool) {
+	switch op {
+	case OpAdd:
+		r = x + y
+		return r, (y > 0) == (r > x) || y == 0
+	case OpSubtract:
+		r = x - y
+		return r, (y > 0) == (r < x) || y == 0
+	case OpMultiply:
+		if x == 0 || y == 0 {
+			return 0, true
+		}
+		r = x * y
+		return r, r/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64)
+	case OpDivide:
+		if y == 0 || (x == math.MinInt64 && y == -1) {
+			return 0, false
+		}
+		return x / y, true
+	}
+	return 0, false
+}
+
+// integer is the value of a numeric register as an integer, the fraction of a real is truncated.
+func integer(r *register) int64 {
+	if i, ok := r.int64(); ok {
+		return i
+	}
+	return toInt64(r.float64())
+}
+
+// bitwise computes a op b into dest for OpBitAnd, OpBitOr, OpShiftLeft and OpShiftRight.
+// The operands are converted to integers. Shifting by 64 bits or more shifts out every bit.
+func bitwise(op Op, a *register, b *register, dest *register) {
+	a, b = numeric(a), numeric(b)
+	if a.typ == RegNull || b.typ == RegNull {
+		dest.setNull()
+		return
+	}
+
+	x, y := integer(a), integer(b)
+	switch op {
+	case OpBitAnd:
+		dest.setInt64(x & y)
+		return
+	case OpBitOr:
+		dest.setInt64(x | y)
+		return
+	}
+
+	// A negative shift is a shift the other way
+	if y < 0 {
+		y = -y
+		if op == OpShiftLeft {
+			op = OpShiftRight
+		} else {
+			op = OpShiftLeft
+		}
+	}
+	switch {
+	case op == OpShiftLeft && y >= 64:
+		dest.setInt64(0)
+	case op == OpShiftLeft:
+		dest.setInt64(x << uint(y))
+	case y >= 64:
+		// The sign fills the integer
+		dest.setInt64(x >> 63)
+	default:
+		dest.setInt64(x >> uint(y))
+	}
+}
+
+// mustBeInt converts the value of a register to an integer when it reads as
+// one without loss, ok is false otherwise.
+func mustBeInt(r *register) (ok bool) {
+	n := r
+	switch r.typ {
+	case RegInt32, RegInt64:
+		return true
+	case RegString:
+		value, err := parseNumber(strings.TrimSpace(r.data.(string)))
+		if err != nil {
+			return false
+		}
+		n = &register{}
+		n.setNumber(value)
+	case RegFloat64:
+	default:
+		return false
+	}
+
+	if i, isInt := n.int64(); isInt {
+		r.setInt64(i)
+		return true
+	}
+	f := n.float64()
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return false
+	}
+	r.setInt64(int64(f))
+	return true
+}
@@ -1,8 +1,9 @@
 package virtualmachine
 
 import (
-	"errors"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/joeandaverde/tinydb/internal/metadata"
@@ -17,6 +18,7 @@ type program struct {
 	regPool      map[int]struct{}
 	labelRefs    map[int]int
 	readCursors  []int
+	sorters      int
 }
 
 type Instructions []*Instruction
@@ -74,9 +76,24 @@ func (p *program) OpString(reg int, s string) int {
 }
 
 func (p *program) OpInt(reg int, value int) int {
+	if value < math.MinInt32 || value > math.MaxInt32 {
+		return p.Op4(OpInt64, x, reg, x, int64(value))
+	}
 	return p.Op2(OpInteger, value, int(reg))
 }
 
+func (p *program) OpReal(reg int, value float64) int {
+	return p.Op4(OpReal, x, reg, x, value)
+}
+
+// opNumber stores an int or a float64 as returned by parseNumber.
+func (p *program) opNumber(reg int, value interface{}) int {
+	if f, ok := value.(float64); ok {
+		return p.OpReal(reg, f)
+	}
+	return p.OpInt(reg, value.(int))
+}
+
 func (p *program) OpNull(reg int) int {
 	return p.Op2(OpNull, x, reg)
 }
@@ -100,22 +117,28 @@ func (p *program) ReadCursor(page int) int {
 	return len(p.readCursors) - 1
 }
 
+// SorterCursor allocates a cursor for a sorter
+func (p *program) SorterCursor() int {
+	p.sorters++
+	return p.sorters - 1
+}
+
 func (p *program) RegAlloc() int {
-	for i := 0; i < 100; i++ {
+	// The pool grows past the registers in use, the program allocates registers as they're used
+	for i := 0; ; i++ {
 		if _, ok := p.regPool[i]; !ok {
 			p.regPool[i] = struct{}{}
 			return i
 		}
 	}
-
-	panic("who so many registers batman?")
 }
 
+// RegAllocN allocates num contiguous registers and returns the first
 func (p *program) RegAllocN(num int) int {
 	remaining := num
-	startReg := 0
-	for ; startReg < 100; startReg++ {
-		_, ok := p.regPool[startReg]
+	endReg := 0
+	for ; ; endReg++ {
+		_, ok := p.regPool[endReg]
 		// if the reg is taken, reset our count.
 		if ok {
 			remaining = num
@@ -124,11 +147,16 @@ func (p *program) RegAllocN(num int) int {
 		}
 
 		// If we got all contiguous regs, done.
-		if remaining == 0 {
+		if remaining <= 0 {
 			break
 		}
 	}
 
+	startReg := endReg - num + 1
+	for r := startReg; r <= endReg; r++ {
+		p.regPool[r] = struct{}{}
+	}
+
 	return startReg
 }
 
@@ -190,7 +218,7 @@ const x = 0
 // |   39 | Goto        |  0 |  1 |  0 |                                      | 00 |         |
 // +------+-------------+----+----+----+--------------------------------------+----+---------+
 // Generated by https://ozh.github.io/ascii-tables/
-func CreateTableInstructions(stmt *ast.CreateTableStatement) []*Instruction {
+func CreateTableInstructions(stmt *ast.CreateTableStatement, createSequence bool) []*Instruction {
 	p := initProgram()
 
 	// The system table
@@ -200,12 +228,37 @@ func CreateTableInstructions(stmt *ast.CreateTableStatement) []*Instruction {
 	openCursor := 0
 	p.Op4(OpOpenWrite, openCursor, rootPage, 5, ".schema")
 
+	p.emitCreateTable(openCursor, stmt.TableName, stmt.RawText)
+
+	// UNIQUE and PRIMARY KEY constraints are kept by indexes without a definition
+	autoIndexes := 0
+	for _, c := range stmt.Columns {
+		if metadata.NeedsAutoIndex(c) {
+			autoIndexes++
+			p.emitCreateAutoIndex(openCursor, stmt.TableName, metadata.AutoIndexName(stmt.TableName, autoIndexes))
+		}
+	}
+
+	// The first AUTOINCREMENT table brings the sequence table along
+	if createSequence {
+		p.emitCreateTable(openCursor, metadata.SequenceTable, metadata.SequenceTableSQL)
+	}
+
+	p.Op1(OpClose, openCursor)
+	p.OpHalt()
+
+	return p.instructions
+}
+
+// emitCreateTable creates the btree for a table and adds it to the schema table
+// opened at the cursor.
+func (p *program) emitCreateTable(schemaCursor int, tableName string, rawText string) {
 	// Master table entry [Reg 1-5]
-	masterTable1Reg := p.RegAlloc()
-	masterTable2Reg := p.RegAlloc()
-	masterTable3Reg := p.RegAlloc()
-	masterTable4Reg := p.RegAlloc()
-	masterTable5Reg := p.RegAlloc()
+	masterTable1Reg := p.RegAllocN(5)
+	masterTable2Reg := masterTable1Reg + 1
+	masterTable3Reg := masterTable1Reg + 2
+	masterTable4Reg := masterTable1Reg + 3
+	masterTable5Reg := masterTable1Reg + 4
 
 	// Data is in order of the master table columns
 	// Create new table and store root page in [Reg 4]
@@ -213,9 +266,9 @@ func CreateTableInstructions(stmt *ast.CreateTableStatement) []*Instruction {
 
 	// Store strings in registers
 	p.OpString(masterTable1Reg, "table")
-	p.OpString(masterTable2Reg, stmt.TableName)
-	p.OpString(masterTable3Reg, stmt.TableName)
-	p.OpString(masterTable5Reg, stmt.RawText)
+	p.OpString(masterTable2Reg, tableName)
+	p.OpString(masterTable3Reg, tableName)
+	p.OpString(masterTable5Reg, rawText)
 
 	// Make record from [Reg 1-5], store in [Reg 6]
 	recordReg := p.RegAlloc()
@@ -223,14 +276,233 @@ func CreateTableInstructions(stmt *ast.CreateTableStatement) []*Instruction {
 
 	// Acquire a rowid for the new record, store in [Reg 7]
 	rowIDReg := p.RegAlloc()
-	p.Op2(OpRowID, openCursor, rowIDReg)
+	p.Op2(OpNewRowID, schemaCursor, rowIDReg)
 
 	// Insert record to [Cur 0], record from [Reg 6], key from [Reg 7]
-	p.Op3(OpInsert, openCursor, recordReg, rowIDReg)
-	p.Op1(OpClose, openCursor)
+	p.Op3(OpInsert, schemaCursor, recordReg, rowIDReg)
+}
+
+// emitCreateAutoIndex creates the btree for an index of a constraint and adds it to the
+// schema table opened at the cursor, the table is empty so the index is too.
+func (p *program) emitCreateAutoIndex(schemaCursor int, tableName string, indexName string) {
+	masterTable1Reg := p.RegAllocN(5)
+	masterTable2Reg := masterTable1Reg + 1
+	masterTable3Reg := masterTable1Reg + 2
+	masterTable4Reg := masterTable1Reg + 3
+	masterTable5Reg := masterTable1Reg + 4
+
+	p.Op1(OpCreateIndex, masterTable4Reg)
+
+	p.OpString(masterTable1Reg, "index")
+	p.OpString(masterTable2Reg, indexName)
+	p.OpString(masterTable3Reg, tableName)
+	p.OpNull(masterTable5Reg)
+
+	recordReg := p.RegAlloc()
+	p.Op3(OpMakeRecord, masterTable1Reg, 5, recordReg)
+	rowIDReg := p.RegAlloc()
+	p.Op2(OpNewRowID, schemaCursor, rowIDReg)
+	p.Op3(OpInsert, schemaCursor, recordReg, rowIDReg)
+}
+
+// CreateIndexInstructions creates the btree for an index, adds it to the schema table
+// and fills it with an entry for each record of the table.
+//
+// Example from SQLite based on: CREATE TABLE foo (x int, y text)
+//
+// EXPLAIN CREATE UNIQUE INDEX foo_x ON foo (x)
+// +------+---------------+------+----+----+--------------------------------------+----+---------+
+// | addr |     opcode    |  p1  | p2 | p3 |                  p4                  | p5 | comment |
+// +------+---------------+------+----+----+--------------------------------------+----+---------+
+// |    0 | Init          |    0 | 36 |  0 |                                      | 00 |         |
+// |    1 | Noop          |    0 | 35 |  0 |                                      | 00 |         |
+// |    2 | CreateBtree   |    0 |  1 |  2 |                                      | 00 |         |
+// |    3 | OpenWrite     |    0 |  1 |  0 | 5                                    | 00 |         |
+// |    4 | String8       |    0 |  3 |  0 | index                                | 00 |         |
+// |    5 | String8       |    0 |  4 |  0 | foo_x                                | 00 |         |
+// |    6 | String8       |    0 |  5 |  0 | foo                                  | 00 |         |
+// |    7 | SCopy         |    1 |  6 |  0 |                                      | 00 |         |
+// |    8 | String8       |    0 |  7 |  0 | CREATE UNIQUE INDEX foo_x ON foo (x) | 00 |         |
+// |    9 | NewRowid      |    0 |  2 |  0 |                                      | 00 |         |
+// |   10 | MakeRecord    |    3 |  5 |  8 | BBBDB                                | 00 |         |
+// |   11 | Insert        |    0 |  8 |  2 |                                      | 18 |         |
+// |   12 | SorterOpen    |    3 |  0 |  1 | k(2,,)                               | 00 |         |
+// |   13 | OpenRead      |    1 |  2 |  0 | 2                                    | 00 |         |
+// |   14 | Rewind        |    1 | 20 |  0 |                                      | 00 |         |
+// |   15 | Column        |    1 |  0 | 10 |                                      | 00 |         |
+// |   16 | Rowid         |    1 | 11 |  0 |                                      | 00 |         |
+// |   17 | MakeRecord    |   10 |  2 |  9 |                                      | 00 |         |
+// |   18 | SorterInsert  |    3 |  9 |  0 |                                      | 00 |         |
+// |   19 | Next          |    1 | 15 |  0 |                                      | 00 |         |
+// |   20 | OpenWrite     |    2 |  1 |  0 | k(2,,)                               | 11 |         |
+// |   21 | SorterSort    |    3 | 29 |  0 |                                      | 00 |         |
+// |   22 | Goto          |    0 | 25 |  0 |                                      | 00 |         |
+// |   23 | SorterCompare |    3 | 22 |  9 | 1                                    | 00 |         |
+// |   24 | Halt          | 2067 |  2 |  0 | foo.x                                | 02 |         |
+// |   25 | SorterData    |    3 |  9 |  2 |                                      | 00 |         |
+// |   26 | SeekEnd       |    2 |  0 |  0 |                                      | 00 |         |
+// |   27 | IdxInsert     |    2 |  9 |  0 |                                      | 10 |         |
+// |   28 | SorterNext    |    3 | 23 |  0 |                                      | 00 |         |
+// |   29 | Close         |    1 |  0 |  0 |                                      | 00 |         |
+// |   30 | Close         |    2 |  0 |  0 |                                      | 00 |         |
+// |   31 | Close         |    3 |  0 |  0 |                                      | 00 |         |
+// |   32 | SetCookie     |    0 |  1 |  2 |                                      | 00 |         |
+// |   33 | ParseSchema   |    0 |  0 |  0 | name='foo_x' AND type='index'        | 00 |         |
+// |   34 | Expire        |    0 |  1 |  0 |                                      | 00 |         |
+// |   35 | Halt          |    0 |  0 |  0 |                                      | 00 |         |
+// |   36 | Transaction   |    0 |  1 |  1 | 0                                    | 01 |         |
+// |   37 | Goto          |    0 |  1 |  0 |                                      | 00 |         |
+// +------+---------------+------+----+----+--------------------------------------+----+---------+
+func CreateIndexInstructions(table *metadata.TableDefinition, stmt *ast.CreateIndexStatement) ([]*Instruction, error) {
+	index := &metadata.IndexDefinition{
+		Name:      stmt.IndexName,
+		TableName: table.Name,
+		Unique:    stmt.Unique,
+	}
+	for _, name := range stmt.Columns {
+		var column *metadata.ColumnDefinition
+		for _, c := range table.Columns {
+			if c.Name == name {
+				column = c
+			}
+		}
+		if column == nil {
+			return nil, fmt.Errorf("no such column: %s", name)
+		}
+		index.Columns = append(index.Columns, column)
+	}
+
+	p := initProgram()
+
+	// The system table
+	rootPage := 1
+
+	// Open the schema table at [Cur 0], the new index at [Cur 1] and the table at [Cur 2]
+	schemaCursor := 0
+	indexCursor := 1
+	tableCursor := 2
+	p.Op4(OpOpenWrite, schemaCursor, rootPage, 5, ".schema")
+
+	// Master table entry
+	masterTable1Reg := p.RegAllocN(5)
+	masterTable2Reg := masterTable1Reg + 1
+	masterTable3Reg := masterTable1Reg + 2
+	masterTable4Reg := masterTable1Reg + 3
+	masterTable5Reg := masterTable1Reg + 4
+
+	// Create new index and store root page in [Reg 4]
+	p.Op1(OpCreateIndex, masterTable4Reg)
+
+	p.OpString(masterTable1Reg, "index")
+	p.OpString(masterTable2Reg, index.Name)
+	p.OpString(masterTable3Reg, table.Name)
+	p.OpString(masterTable5Reg, stmt.RawText)
+
+	recordReg := p.RegAlloc()
+	p.Op3(OpMakeRecord, masterTable1Reg, 5, recordReg)
+	rowIDReg := p.RegAlloc()
+	p.Op2(OpNewRowID, schemaCursor, rowIDReg)
+	p.Op3(OpInsert, schemaCursor, recordReg, rowIDReg)
+
+	// The root page of the index is only known once it's created
+	p.Op4(OpOpenWrite, indexCursor, masterTable4Reg, len(index.Columns)+1, index.Name)
+	p.instructions[len(p.instructions)-1].P5 = OpFlagP2IsReg
+	p.Op4(OpOpenRead, tableCursor, table.RootPage, len(table.Columns), table.Name)
+
+	// Add an entry for each record of the table
+	doneLabel := p.MakeLabel()
+	loopLabel := p.MakeLabel()
+	keyReg := p.RegAllocN(len(index.Columns) + 1)
+	entryReg := p.RegAlloc()
+
+	p.Op2(OpRewind, tableCursor, doneLabel)
+	p.EmitLabel(loopLabel)
+	for i, column := range index.Columns {
+		p.emitColumn(tableCursor, column, keyReg+i)
+	}
+	p.Op2(OpRowID, tableCursor, keyReg+len(index.Columns))
+	if index.Unique {
+		p.emitUniqueCheck(table, index, indexCursor, keyReg)
+	}
+	p.Op3(OpMakeRecord, keyReg, len(index.Columns)+1, entryReg)
+	p.Op2(OpIdxInsert, indexCursor, entryReg)
+	p.Op2(OpNext, tableCursor, loopLabel)
+	p.EmitLabel(doneLabel)
+
+	p.Op1(OpClose, tableCursor)
+	p.Op1(OpClose, indexCursor)
+	p.Op1(OpClose, schemaCursor)
 	p.OpHalt()
 
-	return p.instructions
+	p.Finalize()
+
+	return p.instructions, nil
+}
+
+// emitUniqueCheck halts the program when the unique index already has an entry
+// with the values of the indexed columns in the registers starting at keyReg.
+func (p *program) emitUniqueCheck(table *metadata.TableDefinition, index *metadata.IndexDefinition, cursor int, keyReg int) {
+	names := make([]string, len(index.Columns))
+	for i, column := range index.Columns {
+		names[i] = table.Name + "." + column.Name
+	}
+
+	uniqueLabel := p.MakeLabel()
+	p.Op4(OpNoConflict, cursor, uniqueLabel, keyReg, len(index.Columns))
+	p.Op4(OpHalt, 1, x, x, fmt.Sprintf("UNIQUE constraint failed: %s", strings.Join(names, ", ")))
+	p.EmitLabel(uniqueLabel)
+}
+
+// openIndexes opens a write cursor for each index of the table starting at firstCursor.
+func (p *program) openIndexes(table *metadata.TableDefinition, firstCursor int) {
+	for i, index := range table.Indexes {
+		p.Op4(OpOpenWrite, firstCursor+i, index.RootPage, len(index.Columns)+1, index.Name)
+	}
+}
+
+// emitIndexKey copies the values of the indexed columns followed by the rowid to
+// contiguous registers and returns the first. The column values are in the registers
+// starting at firstReg in the order of the table columns.
+func (p *program) emitIndexKey(index *metadata.IndexDefinition, firstReg int, rowIDReg int) int {
+	keyReg := p.RegAllocN(len(index.Columns) + 1)
+	for i, column := range index.Columns {
+		if column.RowIDAlias {
+			p.Op2(OpSCopy, rowIDReg, keyReg+i)
+			continue
+		}
+		p.Op2(OpSCopy, firstReg+column.Offset, keyReg+i)
+	}
+	p.Op2(OpSCopy, rowIDReg, keyReg+len(index.Columns))
+	return keyReg
+}
+
+// emitIndexEntries makes the entry of each index for the column values in the registers
+// starting at firstReg, checking unique indexes on the way. The entries are returned
+// in registers so they can be inserted once every check passed.
+func (p *program) emitIndexEntries(table *metadata.TableDefinition, indexes []*metadata.IndexDefinition,
+	firstCursor int, firstReg int, rowIDReg int) []int {
+	var entryRegs []int
+	for i, index := range indexes {
+		keyReg := p.emitIndexKey(index, firstReg, rowIDReg)
+		if index.Unique {
+			p.emitUniqueCheck(table, index, firstCursor+i, keyReg)
+		}
+
+		entryReg := p.RegAlloc()
+		p.Op3(OpMakeRecord, keyReg, len(index.Columns)+1, entryReg)
+		entryRegs = append(entryRegs, entryReg)
+	}
+	return entryRegs
+}
+
+// emitIndexDelete removes the entry of the record at the cursor from the index.
+func (p *program) emitIndexDelete(index *metadata.IndexDefinition, tableCursor int, indexCursor int) {
+	keyReg := p.RegAllocN(len(index.Columns) + 1)
+	for i, column := range index.Columns {
+		p.emitColumn(tableCursor, column, keyReg+i)
+	}
+	p.Op2(OpRowID, tableCursor, keyReg+len(index.Columns))
+	p.Op3(OpIdxDelete, indexCursor, keyReg, len(index.Columns)+1)
 }
 
 // InsertInstructions generates machine code for insert statement
@@ -280,10 +552,14 @@ func CreateTableInstructions(stmt *ast.CreateTableStatement) []*Instruction {
 // |    9 | Transaction |  0 |  1 |  7 | 0         | 01 |         |
 // |   10 | Goto        |  0 |  1 |  0 |           | 00 |         |
 // +------+-------------+----+----+----+-----------+----+---------+
-func InsertInstructions(pager pager.Pager, stmt *ast.InsertStatement) []*Instruction {
+func InsertInstructions(pager pager.Pager, stmt *ast.InsertStatement) ([]*Instruction, error) {
+	if metadata.IsSchemaTable(stmt.Table) {
+		return nil, fmt.Errorf("table %s may not be modified", stmt.Table)
+	}
+
 	table, err := metadata.GetTableDefinition(pager, stmt.Table)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	p := initProgram()
@@ -292,11 +568,7 @@ func InsertInstructions(pager pager.Pager, stmt *ast.InsertStatement) []*Instruc
 	rowIDReg := p.RegAlloc()
 
 	// Allocate registers for each column value
-	// TODO: This should find a contiguous block of regs
-	firstReg := p.RegAlloc()
-	for i := 1; i < len(table.Columns); i++ {
-		p.RegAlloc()
-	}
+	firstReg := p.RegAllocN(len(table.Columns))
 
 	// If there's a returning statement build an easy lookup
 	var returnRegs []int
@@ -311,8 +583,53 @@ func InsertInstructions(pager pager.Pager, stmt *ast.InsertStatement) []*Instruc
 	// Open the root page for writing
 	p.Op4(OpOpenWrite, cursorIndex, table.RootPage, len(table.Columns), table.Name)
 
-	// RowID for table
-	p.Op2(OpRowID, cursorIndex, rowIDReg)
+	// Index cursors follow the sequence table cursor
+	firstIndexCursor := cursorIndex + 2
+	p.openIndexes(table, firstIndexCursor)
+
+	// AUTOINCREMENT tables never reuse the largest rowid
+	var seq *sequence
+	if table.AutoIncrement() {
+		sequenceTable, err := metadata.GetTableDefinition(pager, metadata.SequenceTable)
+		if err != nil {
+			return nil, err
+		}
+		seq = p.loadSequence(cursorIndex+1, sequenceTable, table.Name)
+	}
+
+	// The values can't refer to columns
+	values := exprCompiler{p: p}
+
+	// RowID for table, an explicit value for the INTEGER PRIMARY KEY is used as the rowid
+	newRowIDLabel := p.MakeLabel()
+	rowIDLabel := p.MakeLabel()
+	rowIDColumn := table.RowIDColumn()
+	if rowIDColumn != nil {
+		if expr, ok := stmt.Values[rowIDColumn.Name]; ok {
+			if err := values.emitValueTo(expr, rowIDReg); err != nil {
+				return nil, err
+			}
+
+			// A NULL rowid is generated like a missing one
+			p.Op2(OpIsNull, rowIDReg, newRowIDLabel)
+			p.Op1(OpMustBeInt, rowIDReg)
+
+			// The rowid must be unique
+			uniqueLabel := p.MakeLabel()
+			p.Op3(OpNotExists, cursorIndex, uniqueLabel, rowIDReg)
+			p.Op4(OpHalt, 1, x, x, fmt.Sprintf("UNIQUE constraint failed: %s.%s", table.Name, rowIDColumn.Name))
+			p.EmitLabel(uniqueLabel)
+			p.Op2(OpGoto, x, rowIDLabel)
+		}
+	}
+
+	p.EmitLabel(newRowIDLabel)
+	if seq != nil {
+		p.Op4(OpNewRowID, cursorIndex, rowIDReg, x, seq.valueReg)
+	} else {
+		p.Op2(OpNewRowID, cursorIndex, rowIDReg)
+	}
+	p.EmitLabel(rowIDLabel)
 
 	// Populate registers with values to be inserted
 	for i, column := range table.Columns {
@@ -322,18 +639,31 @@ func InsertInstructions(pager pager.Pager, stmt *ast.InsertStatement) []*Instruc
 			returnRegs = append(returnRegs, reg)
 		}
 
+		// The rowid is stored in the key rather than the record
+		if column.RowIDAlias {
+			p.OpNull(reg)
+			continue
+		}
+
 		// If there's no value that maps to the table column
 		// use the default from table defition.
 		expr, ok := stmt.Values[column.Name]
 		if !ok {
-			p.AddValue(reg, column, column.DefaultValue)
+			expr = column.Default
+		}
+		if expr == nil {
+			p.OpNull(reg)
 			continue
 		}
 
-		// TODO: generate instructions rather than evaluating the expression during codegen (incorrect).
-		v := Evaluate(expr, nil)
-		p.AddValue(reg, column, v.Value)
+		if err := values.emitValueTo(expr, reg); err != nil {
+			return nil, err
+		}
 	}
+	p.emitAffinity(firstReg, table.Columns)
+
+	// Make the index entries, a unique index may reject the record
+	entryRegs := p.emitIndexEntries(table, table.Indexes, firstIndexCursor, firstReg, rowIDReg)
 
 	// Make the record and store in a register
 	recordReg := p.RegAlloc()
@@ -342,6 +672,15 @@ func InsertInstructions(pager pager.Pager, stmt *ast.InsertStatement) []*Instruc
 	// Insert the record to the btree, store rowid in reg
 	p.Op3(OpInsert, cursorIndex, recordReg, rowIDReg)
 
+	// Add the record to each index
+	for i, entryReg := range entryRegs {
+		p.Op2(OpIdxInsert, firstIndexCursor+i, entryReg)
+	}
+
+	if seq != nil {
+		p.saveSequence(seq, table.Name, rowIDReg)
+	}
+
 	// // Returning statement
 	// if len(returnRegs) > 0 {
 	// 	regReturnStart := regNewRecord + 1
@@ -358,32 +697,184 @@ func InsertInstructions(pager pager.Pager, stmt *ast.InsertStatement) []*Instruc
 	// All done
 	p.OpHalt()
 
+	p.Finalize()
+
+	return p.instructions, nil
+}
+
+// DropTableInstructions removes the table and its indexes from the schema table and
+// frees the pages of their btrees. The entry of an AUTOINCREMENT table is removed from
+// the sequence table when there is one.
+func DropTableInstructions(table *metadata.TableDefinition, sequenceTable *metadata.TableDefinition) []*Instruction {
+	p := initProgram()
+
+	// The system table
+	rootPage := 1
+
+	// Remove every schema record of the table, its own and those of its indexes
+	schemaCursor := 0
+	p.Op4(OpOpenWrite, schemaCursor, rootPage, 5, ".schema")
+	p.emitDeleteByName(schemaCursor, 2, table.Name)
+
+	if sequenceTable != nil {
+		sequenceCursor := 1
+		p.Op4(OpOpenWrite, sequenceCursor, sequenceTable.RootPage, len(sequenceTable.Columns), sequenceTable.Name)
+		p.emitDeleteByName(sequenceCursor, 0, table.Name)
+	}
+
+	for _, index := range table.Indexes {
+		p.Op1(OpDestroy, index.RootPage)
+	}
+	p.Op1(OpDestroy, table.RootPage)
+
+	p.OpHalt()
+
+	p.Finalize()
+
 	return p.instructions
 }
 
-func (p *program) AddValue(reg int, column *metadata.ColumnDefinition, value interface{}) int {
-	// Supplied value and column type must match up
-	switch v := value.(type) {
-	case string:
-		if column.Type != storage.Text {
-			panic("type conversion not implemented")
+// emitDeleteByName deletes the records at the cursor where the column holds the name.
+func (p *program) emitDeleteByName(cursor int, column int, name string) {
+	nameReg := p.RegAlloc()
+	valueReg := p.RegAlloc()
+
+	doneLabel := p.MakeLabel()
+	loopLabel := p.MakeLabel()
+	nextLabel := p.MakeLabel()
+
+	p.OpString(nameReg, name)
+	p.Op2(OpRewind, cursor, doneLabel)
+	p.EmitLabel(loopLabel)
+	p.Op3(OpColumn, cursor, column, valueReg)
+	p.Op3(OpNe, valueReg, nextLabel, nameReg)
+	p.Op1(OpDelete, cursor)
+	p.EmitLabel(nextLabel)
+	p.Op2(OpNext, cursor, loopLabel)
+	p.EmitLabel(doneLabel)
+}
+
+// VacuumInstructions rebuilds the database file, or writes a copy of the database
+// to the file named by INTO.
+func VacuumInstructions(stmt *ast.VacuumStatement) []*Instruction {
+	p := initProgram()
+
+	p.Op4(OpVacuum, 0, 0, 0, stmt.Into)
+	p.OpHalt()
+
+	return p.instructions
+}
+
+// PragmaInstructions queries or changes a setting of the database.
+// A query produces a single row with the value of the setting.
+func PragmaInstructions(stmt *ast.PragmaStatement) ([]*Instruction, error) {
+	p := initProgram()
+
+	switch strings.ToLower(stmt.Name) {
+	case "page_size":
+		if stmt.Value == "" {
+			reg := p.RegAlloc()
+			p.Op1(OpPageSize, reg)
+			p.Op2(OpResultRow, reg, 1)
+			break
+		}
+
+		pageSize, err := strconv.Atoi(stmt.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page size: %s", stmt.Value)
 		}
-		return p.OpString(reg, v)
-	case int:
-		if column.Type != storage.Integer {
-			panic("type conversion not implemented")
+		p.Op1(OpSetPageSize, pageSize)
+	case "cache_size":
+		if stmt.Value == "" {
+			reg := p.RegAlloc()
+			p.Op1(OpCacheSize, reg)
+			p.Op2(OpResultRow, reg, 1)
+			break
+		}
+
+		cacheSize, err := strconv.Atoi(stmt.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache size: %s", stmt.Value)
 		}
-		return p.OpInt(reg, v)
-	case byte:
-		if column.Type != storage.Byte {
-			panic("type conversion not implemented")
+		p.Op1(OpSetCacheSize, cacheSize)
+	case "wal_checkpoint":
+		mode, err := storage.ParseCheckpointMode(stmt.Value)
+		if err != nil {
+			return nil, err
 		}
-		return p.OpInt(reg, int(v))
-	case nil:
-		return p.OpNull(reg)
+
+		reg := p.RegAllocN(3)
+		p.Op2(OpCheckpoint, int(mode), reg)
+		p.Op2(OpResultRow, reg, 3)
 	default:
-		panic("unsupported type")
+		return nil, fmt.Errorf("unknown pragma: %s", stmt.Name)
 	}
+
+	p.OpHalt()
+
+	return p.instructions, nil
+}
+
+// sequence holds the registers with an entry of the sequence table
+type sequence struct {
+	cursor   int
+	valueReg int
+	rowIDReg int
+}
+
+// loadSequence opens the sequence table and loads the largest rowid
+// used by the table, 0 if there is no entry for the table yet.
+func (p *program) loadSequence(cursor int, sequenceTable *metadata.TableDefinition, tableName string) *sequence {
+	seq := &sequence{
+		cursor:   cursor,
+		valueReg: p.RegAlloc(),
+		rowIDReg: p.RegAlloc(),
+	}
+	nameReg := p.RegAlloc()
+	tableNameReg := p.RegAlloc()
+
+	doneLabel := p.MakeLabel()
+	loopLabel := p.MakeLabel()
+	nextLabel := p.MakeLabel()
+
+	p.Op4(OpOpenWrite, cursor, sequenceTable.RootPage, len(sequenceTable.Columns), sequenceTable.Name)
+	p.OpInt(seq.valueReg, 0)
+	p.OpNull(seq.rowIDReg)
+	p.OpString(tableNameReg, tableName)
+
+	// Scan for the entry of the table
+	p.Op2(OpRewind, cursor, doneLabel)
+	p.EmitLabel(loopLabel)
+	p.Op3(OpColumn, cursor, 0, nameReg)
+	p.Op3(OpNe, nameReg, nextLabel, tableNameReg)
+	p.Op3(OpColumn, cursor, 1, seq.valueReg)
+	p.Op2(OpRowID, cursor, seq.rowIDReg)
+	p.Op2(OpGoto, x, doneLabel)
+	p.EmitLabel(nextLabel)
+	p.Op2(OpNext, cursor, loopLabel)
+	p.EmitLabel(doneLabel)
+
+	return seq
+}
+
+// saveSequence stores the largest rowid used by the table in the sequence table.
+func (p *program) saveSequence(seq *sequence, tableName string, rowIDReg int) {
+	// An explicit rowid may be larger than any generated so far
+	p.Op2(OpMemMax, seq.valueReg, rowIDReg)
+
+	// Add an entry for the table if there isn't one
+	existsLabel := p.MakeLabel()
+	p.Op2(OpNotNull, seq.rowIDReg, existsLabel)
+	p.Op2(OpNewRowID, seq.cursor, seq.rowIDReg)
+	p.EmitLabel(existsLabel)
+
+	firstReg := p.RegAllocN(2)
+	p.OpString(firstReg, tableName)
+	p.Op2(OpSCopy, seq.valueReg, firstReg+1)
+
+	recordReg := p.RegAlloc()
+	p.Op3(OpMakeRecord, firstReg, 2, recordReg)
+	p.Op3(OpInsert, seq.cursor, recordReg, seq.rowIDReg)
 }
 
 func (p *program) Finalize() {
@@ -416,26 +907,41 @@ func (p *program) Finalize() {
 // |   12 | String8     |  0 |  2 |  0 | joe      | 00 |         |
 // |   13 | Goto        |  0 |  1 |  0 |          | 00 |         |
 // +------+-------------+----+----+----+----------+----+---------+
-func SelectInstructions(tableDefs map[string]*metadata.TableDefinition, stmt *ast.SelectStatement) []*Instruction {
+func SelectInstructions(tableDefs map[string]*metadata.TableDefinition, stmt *ast.SelectStatement) ([]*Instruction, error) {
 	table, ok := tableDefs[stmt.From[0].Name]
 	if !ok {
-		return []*Instruction{}
+		return []*Instruction{}, nil
 	}
 
+	// Build the expressions of the columns being returned, * is every column of the table
 	// TODO: this will also need to handle aliased tables
-	colLookup := make(map[string]*metadata.ColumnDefinition, len(table.Columns))
-	for _, c := range table.Columns {
-		colLookup[c.Name] = c
-	}
-
-	// Build references to the columns being returned
-	selectCols := make([]*metadata.ColumnDefinition, 0, len(stmt.Columns))
+	var resultExprs []ast.Expression
 	for _, c := range stmt.Columns {
-		if c == "*" {
-			selectCols = append(selectCols, table.Columns...)
+		if c.Star() {
+			for _, column := range table.Columns {
+				resultExprs = append(resultExprs, &ast.Ident{Value: column.Name})
+			}
 			continue
 		}
-		selectCols = append(selectCols, colLookup[c])
+		resultExprs = append(resultExprs, c.Expr)
+	}
+
+	orderBy, err := orderingTerms(stmt.Columns, resultExprs, stmt.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter ast.Expression
+	if stmt.Filter != nil {
+		filter = reworkExpression(stmt.Filter)
+	}
+	index := planIndexScan(table, filter, orderBy)
+
+	// The rows are sorted unless the scan visits them in order, or in reverse order
+	sorted, reverse := false, false
+	if len(orderBy) > 0 && !scanOrdered(table, index, orderBy, false) {
+		reverse = scanOrdered(table, index, orderBy, true)
+		sorted = !reverse
 	}
 
 	p := initProgram()
@@ -443,56 +949,787 @@ func SelectInstructions(tableDefs map[string]*metadata.TableDefinition, stmt *as
 	// Set up a read cursor for the root page of the table
 	readCursor := p.ReadCursor(table.RootPage)
 
-	// Allocate registers for result columns
-	firstColReg := p.RegAllocN(len(selectCols))
+	// Allocate registers for the sort keys followed by the result columns
+	rowLen := len(orderBy) + len(resultExprs)
+	firstKeyReg := p.RegAllocN(rowLen)
+	firstColReg := firstKeyReg + len(orderBy)
+
+	// Open table for reading
+	p.Op4(OpOpenRead, readCursor, table.RootPage, len(table.Columns), table.Name)
+
+	sorter := 0
+	if sorted {
+		orders := make([]SortOrder, len(orderBy))
+		for i, term := range orderBy {
+			collation, _ := explicitCollation(term.Expr)
+			orders[i] = SortOrder{Desc: term.Desc, NullsFirst: term.NullsFirst(), Collation: collation}
+		}
+		sorter = p.SorterCursor()
+		p.Op4(OpSorterOpen, sorter, len(orderBy), x, orders)
+	}
+
+	results := exprCompiler{p: p, table: table, cursor: readCursor}
+	body := func() error {
+		// Compute the result columns into registers
+		for i, e := range resultExprs {
+			if err := results.emitValueTo(e, firstColReg+i); err != nil {
+				return err
+			}
+		}
+
+		if !sorted {
+			// Produce a Row
+			p.Op2(OpResultRow, firstColReg, len(resultExprs))
+			return nil
+		}
+
+		// The row is produced once every row is sorted
+		for i, term := range orderBy {
+			if err := results.emitValueTo(term.Expr, firstKeyReg+i); err != nil {
+				return err
+			}
+		}
+		p.Op3(OpSorterInsert, sorter, firstKeyReg, rowLen)
+		return nil
+	}
+
+	if index != nil {
+		err = p.emitIndexScan(table, readCursor, index, filter, reverse, body)
+	} else {
+		err = p.emitScan(table, readCursor, stmt.Filter, reverse, body)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sorted {
+		doneLabel := p.MakeLabel()
+		rowLabel := p.MakeLabel()
+
+		// Produce the rows in order
+		p.Op2(OpSorterSort, sorter, doneLabel)
+		p.EmitLabel(rowLabel)
+		p.Op3(OpSorterData, sorter, firstKeyReg, rowLen)
+		p.Op2(OpResultRow, firstColReg, len(resultExprs))
+		p.Op2(OpSorterNext, sorter, rowLabel)
+		p.EmitLabel(doneLabel)
+	}
+
+	p.OpHalt()
+
+	// Finalize the program to return complete instructions
+	p.Finalize()
+
+	return p.instructions, nil
+}
+
+// orderingTerms resolves the terms of ORDER BY like SQLite, a number is the position of
+// a result column and a name is the alias of a result column before a column of the table.
+func orderingTerms(columns []ast.ResultColumn, resultExprs []ast.Expression, orderBy []ast.OrderingTerm) ([]ast.OrderingTerm, error) {
+	terms := make([]ast.OrderingTerm, len(orderBy))
+	for i, term := range orderBy {
+		terms[i] = term
+		switch e := term.Expr.(type) {
+		case *ast.BasicLiteral:
+			if e.Kind != lexer.TokenNumber {
+				continue
+			}
+			// Any other number is a constant
+			n, err := strconv.Atoi(e.Value)
+			if err != nil {
+				continue
+			}
+			if n < 1 || n > len(resultExprs) {
+				return nil, fmt.Errorf("%s ORDER BY term out of range - should be between 1 and %d",
+					ordinal(i+1), len(resultExprs))
+			}
+			terms[i].Expr = resultExprs[n-1]
+		case *ast.Ident:
+			for _, c := range columns {
+				if !c.Star() && c.Alias == e.Value {
+					terms[i].Expr = c.Expr
+					break
+				}
+			}
+		}
+	}
+	return terms, nil
+}
+
+// ordinal is a number followed by the suffix of its position, 1st, 2nd and so on.
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+// UpdateInstructions assigns new values to the columns of each record of the table matching
+// the filter. The record is rebuilt from the current column values and the assignments
+// then replaces the record with the same rowid. Assigning the INTEGER PRIMARY KEY moves
+// the record to the new rowid, the rowids of the matching records are collected first so
+// the scan doesn't reach a moved record again.
+func UpdateInstructions(tableDefs map[string]*metadata.TableDefinition, stmt *ast.UpdateStatement) ([]*Instruction, error) {
+	table, ok := tableDefs[stmt.Table]
+	if !ok {
+		return []*Instruction{}, nil
+	}
+
+	var rowIDColumn *metadata.ColumnDefinition
+	for name := range stmt.Values {
+		column := table.Column(name)
+		if column == nil {
+			return nil, fmt.Errorf("no such column: %s", name)
+		}
+		if column.RowIDAlias {
+			rowIDColumn = column
+		}
+	}
+
+	p := initProgram()
+
+	// Set up a write cursor for the root page of the table
+	writeCursor := p.ReadCursor(table.RootPage)
+
+	// Allocate registers for the rowid, each column and the record
+	rowIDReg := p.RegAlloc()
+	firstReg := p.RegAllocN(len(table.Columns))
+	recordReg := p.RegAlloc()
+
+	// Open table for writing
+	p.Op4(OpOpenWrite, writeCursor, table.RootPage, len(table.Columns), table.Name)
+
+	// Only the indexes of assigned columns change, unless every entry gets a new rowid
+	var indexes []*metadata.IndexDefinition
+	for _, index := range table.Indexes {
+		for _, column := range index.Columns {
+			if _, ok := stmt.Values[column.Name]; ok || rowIDColumn != nil {
+				indexes = append(indexes, index)
+				break
+			}
+		}
+	}
+	firstIndexCursor := writeCursor + 1
+	for i, index := range indexes {
+		p.Op4(OpOpenWrite, firstIndexCursor+i, index.RootPage, len(index.Columns)+1, index.Name)
+	}
+
+	values := exprCompiler{p: p, table: table, cursor: writeCursor}
+	update := func() error {
+		// Remove the current index entries first so the record doesn't conflict with itself
+		for i, index := range indexes {
+			p.emitIndexDelete(index, writeCursor, firstIndexCursor+i)
+		}
+
+		// Build the new record from the assigned values and the current values
+		p.Op2(OpRowID, writeCursor, rowIDReg)
+		for i, column := range table.Columns {
+			reg := firstReg + i
+
+			// The rowid is stored in the key rather than the record
+			if column.RowIDAlias {
+				p.OpNull(reg)
+				continue
+			}
+
+			expr, ok := stmt.Values[column.Name]
+			if !ok {
+				p.Op3(OpColumn, writeCursor, column.Offset, reg)
+				continue
+			}
+
+			// The value may refer to the current values of the record
+			if err := values.emitValueTo(expr, reg); err != nil {
+				return err
+			}
+		}
+
+		newRowIDReg := rowIDReg
+		if rowIDColumn != nil {
+			newRowIDReg = p.RegAlloc()
+			if err := values.emitValueTo(stmt.Values[rowIDColumn.Name], newRowIDReg); err != nil {
+				return err
+			}
+			p.Op1(OpMustBeInt, newRowIDReg)
+			p.emitMove(table, rowIDColumn, writeCursor, rowIDReg, newRowIDReg)
+		}
+		p.emitAffinity(firstReg, table.Columns)
+
+		entryRegs := p.emitIndexEntries(table, indexes, firstIndexCursor, firstReg, newRowIDReg)
+
+		// Replace the record with the same rowid
+		p.Op3(OpMakeRecord, firstReg, len(table.Columns), recordReg)
+		p.Op3(OpInsert, writeCursor, recordReg, newRowIDReg)
+
+		for i, entryReg := range entryRegs {
+			p.Op2(OpIdxInsert, firstIndexCursor+i, entryReg)
+		}
+		return nil
+	}
+
+	if rowIDColumn == nil {
+		if err := p.emitScan(table, writeCursor, stmt.Filter, false, update); err != nil {
+			return nil, err
+		}
+	} else {
+		// Collect the rowids of the matching records
+		sorter := p.SorterCursor()
+		p.Op4(OpSorterOpen, sorter, 1, x, []SortOrder{{}})
+		err := p.emitScan(table, writeCursor, stmt.Filter, false, func() error {
+			p.Op2(OpRowID, writeCursor, rowIDReg)
+			p.Op3(OpSorterInsert, sorter, rowIDReg, 1)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Then update each of them
+		doneLabel := p.MakeLabel()
+		rowLabel := p.MakeLabel()
+		nextLabel := p.MakeLabel()
+		p.Op2(OpSorterSort, sorter, doneLabel)
+		p.EmitLabel(rowLabel)
+		p.Op3(OpSorterData, sorter, rowIDReg, 1)
+		p.Op3(OpNotExists, writeCursor, nextLabel, rowIDReg)
+		if err := update(); err != nil {
+			return nil, err
+		}
+		p.EmitLabel(nextLabel)
+		p.Op2(OpSorterNext, sorter, rowLabel)
+		p.EmitLabel(doneLabel)
+	}
+
+	p.OpHalt()
+
+	p.Finalize()
+
+	return p.instructions, nil
+}
+
+// emitMove deletes the record at the cursor when its rowid changes, the new rowid must
+// be unique. OpInsert then adds the record under the new rowid.
+func (p *program) emitMove(table *metadata.TableDefinition, rowIDColumn *metadata.ColumnDefinition,
+	cursor int, rowIDReg int, newRowIDReg int) {
+	sameLabel := p.MakeLabel()
+	p.Op3(OpEq, rowIDReg, sameLabel, newRowIDReg)
+
+	uniqueLabel := p.MakeLabel()
+	p.Op3(OpNotExists, cursor, uniqueLabel, newRowIDReg)
+	p.Op4(OpHalt, 1, x, x, fmt.Sprintf("UNIQUE constraint failed: %s.%s", table.Name, rowIDColumn.Name))
+	p.EmitLabel(uniqueLabel)
+
+	// The uniqueness check moved the cursor away from the record
+	p.Op3(OpNotExists, cursor, sameLabel, rowIDReg)
+	p.Op1(OpDelete, cursor)
+	p.EmitLabel(sameLabel)
+}
+
+// DeleteInstructions removes each record of the table matching the filter.
+func DeleteInstructions(tableDefs map[string]*metadata.TableDefinition, stmt *ast.DeleteStatement) ([]*Instruction, error) {
+	table, ok := tableDefs[stmt.Table]
+	if !ok {
+		return []*Instruction{}, nil
+	}
+
+	p := initProgram()
+
+	// Set up a write cursor for the root page of the table
+	writeCursor := p.ReadCursor(table.RootPage)
+
+	// Open table for writing
+	p.Op4(OpOpenWrite, writeCursor, table.RootPage, len(table.Columns), table.Name)
+
+	firstIndexCursor := writeCursor + 1
+	p.openIndexes(table, firstIndexCursor)
+
+	err := p.emitScan(table, writeCursor, stmt.Filter, false, func() error {
+		// Remove the record and its index entries
+		for i, index := range table.Indexes {
+			p.emitIndexDelete(index, writeCursor, firstIndexCursor+i)
+		}
+		p.Op1(OpDelete, writeCursor)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	p.OpHalt()
+
+	p.Finalize()
+
+	return p.instructions, nil
+}
 
+// emitScan emits a loop over the records of the table at the cursor satisfying the filter.
+// The body is emitted with the cursor positioned on a matching record. When the filter
+// constrains the rowid the loop starts at the first rowid in range using a seek and ends
+// past the last one rather than scanning the whole table. A reverse scan starts at the
+// last rowid in range and moves to the previous records.
+func (p *program) emitScan(table *metadata.TableDefinition, cursor int, filter ast.Expression,
+	reverse bool, body func() error) error {
 	// Set up labels for control flow
 	haltLabel := p.MakeLabel()
 	nextLabel := p.MakeLabel()
 	recordLabel := p.MakeLabel()
 	evalLabel := p.MakeLabel()
 
-	// Open table for reading
-	p.Op4(OpOpenRead, readCursor, table.RootPage, len(selectCols), table.Name)
+	var expr ast.Expression
+	if filter != nil {
+		expr = reworkExpression(filter)
+	}
+	r := planRowIDRange(table, expr)
+
+	// Position the cursor on the first record, or the last one in reverse, or go to halt
+	switch {
+	case r.eq != nil:
+		keyReg := p.RegAlloc()
+		p.OpInt(keyReg, r.eq.value)
+		p.Op3(OpSeek, cursor, haltLabel, keyReg)
+	case reverse && r.upper != nil:
+		keyReg := p.RegAlloc()
+		p.OpInt(keyReg, r.upper.value)
+		if r.upper.op == "<" {
+			p.Op3(OpSeekLt, cursor, haltLabel, keyReg)
+		} else {
+			p.Op3(OpSeekLe, cursor, haltLabel, keyReg)
+		}
+	case reverse:
+		p.Op2(OpLast, cursor, haltLabel)
+	case r.lower != nil:
+		keyReg := p.RegAlloc()
+		p.OpInt(keyReg, r.lower.value)
+		if r.lower.op == ">" {
+			p.Op3(OpSeekGt, cursor, haltLabel, keyReg)
+		} else {
+			p.Op3(OpSeekGe, cursor, haltLabel, keyReg)
+		}
+	default:
+		p.Op2(OpRewind, cursor, haltLabel)
+	}
 
-	// Go to first entry in btree or go to halt
-	p.Op2(OpRewind, readCursor, haltLabel)
+	// The scan ends at the upper bound, or the lower bound in reverse
+	end := r.upper
+	if reverse {
+		end = r.lower
+	}
+	rowIDReg, endReg := 0, 0
+	if r.eq == nil && end != nil {
+		rowIDReg = p.RegAlloc()
+		endReg = p.RegAlloc()
+		p.OpInt(endReg, end.value)
+	}
 
-	// Add instructions to check against each row
 	p.EmitLabel(evalLabel)
-	if stmt.Filter != nil {
-		transformedExpr := reworkExpression(stmt.Filter)
-		where := whereClause{p: p, tableDefs: tableDefs}
-		where.emit(transformedExpr, evalContext{
+
+	// Stop once past the last rowid in range
+	if endReg != 0 {
+		p.Op2(OpRowID, cursor, rowIDReg)
+		switch end.op {
+		case "<":
+			p.Op3(OpGe, rowIDReg, haltLabel, endReg)
+		case "<=":
+			p.Op3(OpGt, rowIDReg, haltLabel, endReg)
+		case ">":
+			p.Op3(OpLe, rowIDReg, haltLabel, endReg)
+		case ">=":
+			p.Op3(OpLt, rowIDReg, haltLabel, endReg)
+		}
+	}
+
+	// Add instructions to check against each row
+	if expr != nil {
+		where := whereClause{exprCompiler{p: p, table: table, cursor: cursor}}
+		err := where.emit(expr, evalContext{
 			te:          recordLabel,
 			fe:          nextLabel,
 			conjunction: true,
 		})
+		if err != nil {
+			return err
+		}
+	}
+
+	p.EmitLabel(recordLabel)
+	if err := body(); err != nil {
+		return err
+	}
+
+	// Move cursor to next record and go to address if success, otherwise, fallthrough.
+	// There is at most one record with the rowid.
+	p.EmitLabel(nextLabel)
+	switch {
+	case r.eq != nil:
+	case reverse:
+		p.Op2(OpPrev, cursor, evalLabel)
+	default:
+		p.Op2(OpNext, cursor, evalLabel)
+	}
+
+	p.EmitLabel(haltLabel)
+	return nil
+}
+
+// emitIndexScan emits a loop over the records of the table at the cursor satisfying the
+// filter in the order of an index, or the reverse order. The loop visits the range of
+// index entries planned by planIndexScan and moves the table cursor to the record of each.
+func (p *program) emitIndexScan(table *metadata.TableDefinition, cursor int, r *indexRange,
+	filter ast.Expression, reverse bool, body func() error) error {
+	// Set up labels for control flow
+	haltLabel := p.MakeLabel()
+	nextLabel := p.MakeLabel()
+	recordLabel := p.MakeLabel()
+	evalLabel := p.MakeLabel()
+
+	indexCursor := p.ReadCursor(r.index.RootPage)
+	p.Op4(OpOpenRead, indexCursor, r.index.RootPage, len(r.index.Columns)+1, r.index.Name)
+
+	where := whereClause{exprCompiler{p: p, table: table, cursor: cursor}}
+
+	// Compute the keys of the bounds of the range
+	var eqReg, lowerReg, upperReg int
+	var err error
+	if r.eq != nil {
+		if eqReg, err = where.emitKey(r.eq); err != nil {
+			return err
+		}
+	} else {
+		if r.lower != nil {
+			if lowerReg, err = where.emitKey(r.lower); err != nil {
+				return err
+			}
+		}
+		if r.upper != nil {
+			if upperReg, err = where.emitKey(r.upper); err != nil {
+				return err
+			}
+		}
 	}
 
-	// Load selected columns into registers
-	for i, c := range selectCols {
-		p.Op3(OpColumn, readCursor, c.Offset, firstColReg+i)
+	// NULLs sort first and never satisfy a comparison
+	nullReg := 0
+	if r.eq == nil && r.lower == nil && r.upper != nil {
+		nullReg = p.RegAlloc()
+		p.OpNull(nullReg)
+	}
+
+	// Position the index cursor on the first entry in range, or the last one in reverse,
+	// or go to halt
+	switch {
+	case reverse && r.eq != nil:
+		p.Op4(OpSeekLe, indexCursor, haltLabel, eqReg, 1)
+	case reverse && r.upper != nil && r.upper.op == "<":
+		p.Op4(OpSeekLt, indexCursor, haltLabel, upperReg, 1)
+	case reverse && r.upper != nil:
+		p.Op4(OpSeekLe, indexCursor, haltLabel, upperReg, 1)
+	case reverse:
+		p.Op2(OpLast, indexCursor, haltLabel)
+	case r.eq != nil:
+		p.Op4(OpSeekGe, indexCursor, haltLabel, eqReg, 1)
+	case r.lower != nil && r.lower.op == ">":
+		p.Op4(OpSeekGt, indexCursor, haltLabel, lowerReg, 1)
+	case r.lower != nil:
+		p.Op4(OpSeekGe, indexCursor, haltLabel, lowerReg, 1)
+	case r.upper != nil:
+		p.Op4(OpSeekGt, indexCursor, haltLabel, nullReg, 1)
+	default:
+		// Every entry is visited in the order of the index
+		p.Op2(OpRewind, indexCursor, haltLabel)
+	}
+
+	p.EmitLabel(evalLabel)
+
+	// Stop once past the last entry in range
+	switch {
+	case reverse && r.eq != nil:
+		p.Op4(OpIdxLt, indexCursor, haltLabel, eqReg, 1)
+	case reverse && r.lower != nil && r.lower.op == ">":
+		p.Op4(OpIdxLe, indexCursor, haltLabel, lowerReg, 1)
+	case reverse && r.lower != nil:
+		p.Op4(OpIdxLt, indexCursor, haltLabel, lowerReg, 1)
+	case reverse && r.upper != nil:
+		p.Op4(OpIdxLe, indexCursor, haltLabel, nullReg, 1)
+	case reverse:
+		// Every entry is visited down to the first
+	case r.eq != nil:
+		p.Op4(OpIdxGt, indexCursor, haltLabel, eqReg, 1)
+	case r.upper != nil && r.upper.op == "<":
+		p.Op4(OpIdxGe, indexCursor, haltLabel, upperReg, 1)
+	case r.upper != nil:
+		p.Op4(OpIdxGt, indexCursor, haltLabel, upperReg, 1)
+	}
+
+	// Move the table cursor to the record of the entry
+	rowIDReg := p.RegAlloc()
+	p.Op2(OpIdxPKey, indexCursor, rowIDReg)
+	p.Op3(OpSeek, cursor, nextLabel, rowIDReg)
+
+	// Add instructions to check against each row
+	if filter != nil {
+		err = where.emit(filter, evalContext{
+			te:          recordLabel,
+			fe:          nextLabel,
+			conjunction: true,
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	// Produce a Row
 	p.EmitLabel(recordLabel)
-	p.Op2(OpResultRow, firstColReg, len(selectCols))
+	if err := body(); err != nil {
+		return err
+	}
 
-	// Move cursor to next record and go to address if success, otherwise, fallthrough
 	p.EmitLabel(nextLabel)
-	p.Op2(OpNext, readCursor, evalLabel)
+	if reverse {
+		p.Op2(OpPrev, indexCursor, evalLabel)
+	} else {
+		p.Op2(OpNext, indexCursor, evalLabel)
+	}
 
-	// Set the jump address for halt if there are no records
 	p.EmitLabel(haltLabel)
-	p.OpHalt()
 
-	// Load all literals into registers
+	return nil
+}
 
-	// Finalize the program to return complete instructions
-	p.Finalize()
+// rowIDBound is a comparison of the rowid with a constant
+type rowIDBound struct {
+	op    string
+	value int
+}
 
-	return p.instructions
+// rowIDRange is the range of rowids of the records which may satisfy a filter
+type rowIDRange struct {
+	eq    *rowIDBound
+	lower *rowIDBound
+	upper *rowIDBound
+}
+
+// planRowIDRange finds the comparisons of the rowid with a constant which must hold
+// for the filter to be true, those are the terms of a conjunction. The filter is
+// still evaluated for each record in range, the range only narrows the scan.
+func planRowIDRange(table *metadata.TableDefinition, filter ast.Expression) rowIDRange {
+	r := rowIDRange{}
+	for _, c := range columnComparisons(table, filter) {
+		if !c.column.RowIDAlias || c.literal.Kind != lexer.TokenNumber {
+			continue
+		}
+		value, err := strconv.Atoi(c.literal.Value)
+		if err != nil {
+			continue
+		}
+
+		bound := &rowIDBound{op: c.op, value: value}
+		switch {
+		case c.op == "=" && r.eq == nil:
+			r.eq = bound
+		case (c.op == ">" || c.op == ">=") && r.lower == nil:
+			r.lower = bound
+		case (c.op == "<" || c.op == "<=") && r.upper == nil:
+			r.upper = bound
+		}
+	}
+
+	return r
+}
+
+// indexRange is the range of entries of an index which may satisfy a filter
+type indexRange struct {
+	index *metadata.IndexDefinition
+	eq    *columnComparison
+	lower *columnComparison
+	upper *columnComparison
+}
+
+// planIndexScan picks the range of an index to scan for the filter, or else an index
+// whose order is the order of the terms of ORDER BY so the records aren't sorted.
+// Returns nil when the table is scanned instead, seeking the rowids constrained
+// by the filter is cheaper than an index.
+func planIndexScan(table *metadata.TableDefinition, filter ast.Expression, orderBy []ast.OrderingTerm) *indexRange {
+	if r := planRowIDRange(table, filter); r.eq != nil || r.lower != nil || r.upper != nil {
+		return nil
+	}
+	if r := planIndexRange(table, filter); r != nil {
+		return r
+	}
+
+	if len(orderBy) == 0 || scanOrdered(table, nil, orderBy, false) || scanOrdered(table, nil, orderBy, true) {
+		return nil
+	}
+	for _, index := range table.Indexes {
+		r := &indexRange{index: index}
+		if scanOrdered(table, r, orderBy, false) || scanOrdered(table, r, orderBy, true) {
+			return r
+		}
+	}
+	return nil
+}
+
+// scanOrdered determines if the scan of the range of an index, or of the table when nil,
+// visits the records in the order of the terms of ORDER BY. Entries of an index are ordered
+// by their columns then by rowid in ascending order with NULLs first, like the table by rowid.
+// A reverse scan visits them in descending order with NULLs last.
+func scanOrdered(table *metadata.TableDefinition, r *indexRange, orderBy []ast.OrderingTerm, reverse bool) bool {
+	var columns []*metadata.ColumnDefinition
+	if r != nil {
+		columns = r.index.Columns
+	}
+
+	matched := 0
+	for _, term := range orderBy {
+		ident, ok := term.Expr.(*ast.Ident)
+		if !ok || term.Desc != reverse || term.NullsFirst() == reverse {
+			return false
+		}
+		column := table.Column(ident.Value)
+		if column == nil {
+			return false
+		}
+
+		// The rowid is unique, the terms which follow it don't change the order
+		if column.RowIDAlias {
+			return matched == len(columns)
+		}
+		if matched == len(columns) || column != columns[matched] {
+			return false
+		}
+		matched++
+	}
+	return true
+}
+
+// planIndexRange picks an index whose first column is compared with a constant in
+// the terms of the filter, preferring an equality over a range. Returns nil if
+// there is no such index.
+func planIndexRange(table *metadata.TableDefinition, filter ast.Expression) *indexRange {
+	comparisons := columnComparisons(table, filter)
+
+	var best *indexRange
+	for _, index := range table.Indexes {
+		r := &indexRange{index: index}
+		for i := range comparisons {
+			c := &comparisons[i]
+			// NULL isn't equal to any value
+			if c.column != index.Columns[0] || c.literal.Kind == lexer.TokenNull {
+				continue
+			}
+			switch {
+			case c.op == "=" && r.eq == nil:
+				r.eq = c
+			case (c.op == ">" || c.op == ">=") && r.lower == nil:
+				r.lower = c
+			case (c.op == "<" || c.op == "<=") && r.upper == nil:
+				r.upper = c
+			}
+		}
+
+		switch {
+		case r.eq != nil:
+			return r
+		case best == nil && (r.lower != nil || r.upper != nil):
+			best = r
+		}
+	}
+
+	return best
+}
+
+// columnComparison is a comparison of a column with a literal,
+// the operator is as if the column is the left operand.
+type columnComparison struct {
+	column  *metadata.ColumnDefinition
+	op      string
+	literal *ast.BasicLiteral
+}
+
+// columnComparisons finds the comparisons of a column with a literal in the
+// terms of a conjunction, each of them must hold for the filter to be true.
+func columnComparisons(table *metadata.TableDefinition, filter ast.Expression) []columnComparison {
+	var terms []ast.Expression
+	switch e := filter.(type) {
+	case *ast.LogicalOperation:
+		if e.Operator == "AND" {
+			terms = e.Terms
+		}
+	case *ast.BinaryOperation:
+		terms = []ast.Expression{e}
+	}
+
+	// The operator when the column is the right operand
+	flipped := map[string]string{"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+	var comparisons []columnComparison
+	for _, t := range terms {
+		o, ok := t.(*ast.BinaryOperation)
+		if !ok {
+			continue
+		}
+		op, ok := flipped[o.Operator]
+		if !ok {
+			continue
+		}
+
+		ident, literal := ast.IdentLiteralOperation(o)
+		if ident == nil {
+			continue
+		}
+		column := table.Column(ident.Value)
+		if column == nil {
+			continue
+		}
+		if o.Left == ast.Expression(ident) {
+			op = o.Operator
+		}
+
+		comparisons = append(comparisons, columnComparison{column: column, op: op, literal: literal})
+	}
+
+	return comparisons
+}
+
+// emitKey computes the value of the literal of the comparison as a key of an index on
+// the column. The value is converted to the affinity of the column like the values
+// of the column are converted when compared with it.
+func (c whereClause) emitKey(comparison *columnComparison) (int, error) {
+	reg, err := c.emitValue(comparison.literal)
+	if err != nil {
+		return 0, err
+	}
+	c.p.Op4(OpAffinity, reg, 1, x, string(comparison.column.Affinity))
+	return reg, nil
+}
+
+// emitColumn loads the value of a column of the record at the cursor into a register.
+func (p *program) emitColumn(cursor int, column *metadata.ColumnDefinition, reg int) {
+	if column.RowIDAlias {
+		p.Op2(OpRowID, cursor, reg)
+		return
+	}
+	p.Op3(OpColumn, cursor, column.Offset, reg)
+
+	// SQLite stores a real without a fraction as an integer
+	if column.Affinity == storage.AffinityReal {
+		p.Op4(OpAffinity, reg, 1, x, string(column.Affinity))
+	}
+}
+
+// emitAffinity converts the values in the registers starting with firstReg to
+// the affinities of the columns before they're stored.
+func (p *program) emitAffinity(firstReg int, columns []*metadata.ColumnDefinition) {
+	affinities := make([]byte, len(columns))
+	for i, column := range columns {
+		affinities[i] = byte(column.Affinity)
+	}
+	p.Op4(OpAffinity, firstReg, len(columns), x, string(affinities))
 }
 
 func BeginInstructions(stmt *ast.BeginStatement) []*Instruction {
@@ -529,113 +1766,134 @@ type evalContext struct {
 	fe          int
 }
 
+// whereClause emits the jumps of a filter, comparisons and logical operations jump
+// directly and any other expression is true when its value is a nonzero number.
 type whereClause struct {
-	p         *program
-	tableDefs map[string]*metadata.TableDefinition
+	exprCompiler
 }
 
-func (c whereClause) emit(expr ast.Expression, evalCtx evalContext) int {
+func (c whereClause) emit(expr ast.Expression, evalCtx evalContext) error {
 	switch e := expr.(type) {
 	case *ast.LogicalOperation:
 		return c.emitLogicalExpression(e, evalCtx)
 	case *ast.BinaryOperation:
-		return c.emitBinaryOperation(e, evalCtx)
-	case *ast.BasicLiteral:
-		litReg := c.p.RegAlloc()
-		switch e.Kind {
-		case lexer.TokenString:
-			c.p.OpString(litReg, e.Value)
-		}
-		return litReg
-	case *ast.Ident:
-		// Find the table and cursor
-		_, columnDef, err := c.emitIdent(e.Value)
-		if err != nil {
-			panic(err)
+		if _, ok := comparisonOps[e.Operator]; ok {
+			return c.emitBinaryOperation(e, evalCtx)
 		}
-		// TODO: get correct read cursor
-		colReg := c.p.RegAlloc()
-		c.p.Op3(OpColumn, 0, columnDef.Offset, colReg)
-		return colReg
-	default:
-		panic("unexpected expression type")
 	}
+
+	reg, err := c.emitValue(expr)
+	if err != nil {
+		return err
+	}
+	if evalCtx.conjunction {
+		// NULL isn't true
+		c.p.Op3(OpIfNot, reg, evalCtx.fe, 1)
+	} else if evalCtx.disjunction {
+		c.p.Op3(OpIf, reg, evalCtx.te, 0)
+	} else {
+		panic("unknown logical context")
+	}
+	return nil
 }
 
-func (c whereClause) emitLogicalExpression(e *ast.LogicalOperation, evalCtx evalContext) int {
+// emitLogicalExpression emits the terms of a logical operation. When evaluated in
+// a conjunction, a true result falls through and a false result jumps to fe. In a
+// disjunction, a false result falls through and a true result jumps to te.
+// The last term is evaluated in the context of the logical operation itself.
+func (c whereClause) emitLogicalExpression(e *ast.LogicalOperation, evalCtx evalContext) error {
 	switch e.Operator {
 	case "OR":
-		trueLabel := c.p.MakeLabel()
+		trueLabel := evalCtx.te
+		if !evalCtx.disjunction {
+			trueLabel = c.p.MakeLabel()
+		}
 		lastTermIndex := len(e.Terms) - 1
 		for i, t := range e.Terms {
 			// If any term evaluates to true, short circuit evaluation
 			if i != lastTermIndex {
 				falseExit := c.p.MakeLabel()
-				c.emit(t, evalContext{te: trueLabel, fe: falseExit, disjunction: true})
+				if err := c.emit(t, evalContext{te: trueLabel, fe: falseExit, disjunction: true}); err != nil {
+					return err
+				}
 				c.p.EmitLabel(falseExit)
-			} else {
-				c.emit(t, evalContext{fe: evalCtx.fe, conjunction: true})
+			} else if err := c.emit(t, evalCtx); err != nil {
+				return err
 			}
 		}
-		c.p.EmitLabel(trueLabel)
+		if !evalCtx.disjunction {
+			c.p.EmitLabel(trueLabel)
+		}
 	case "AND":
-		trueLabel := c.p.MakeLabel()
+		falseLabel := evalCtx.fe
+		if !evalCtx.conjunction {
+			falseLabel = c.p.MakeLabel()
+		}
 		lastTermIndex := len(e.Terms) - 1
 		for i, t := range e.Terms {
+			// If any term evaluates to false, short circuit evaluation
 			if i != lastTermIndex {
-				c.emit(t, evalContext{fe: evalCtx.fe, conjunction: true})
-			} else {
-				c.emit(t, evalContext{te: evalCtx.te, conjunction: true})
+				if err := c.emit(t, evalContext{te: evalCtx.te, fe: falseLabel, conjunction: true}); err != nil {
+					return err
+				}
+			} else if err := c.emit(t, evalCtx); err != nil {
+				return err
 			}
 		}
-		c.p.EmitLabel(trueLabel)
+		if !evalCtx.conjunction {
+			c.p.EmitLabel(falseLabel)
+		}
 	default:
-		panic("unexpected logical operator")
+		return fmt.Errorf("unknown operator %s", e.Operator)
 	}
 
-	return -1
+	return nil
 }
 
-func (c whereClause) emitIdent(ident string) (*metadata.TableDefinition, *metadata.ColumnDefinition, error) {
-	// TODO: Make this efficient and use table aliases
-	for _, t := range c.tableDefs {
-		for _, c := range t.Columns {
-			if c.Name == ident {
-				return t, c, nil
-			}
-		}
-	}
-	return nil, nil, errors.New("cannot resolve ident")
+// comparisonOps are the ops jumping when a comparison is true and when it's false.
+// IS and IS NOT compare NULLs, other comparisons with a NULL are neither true nor false.
+var comparisonOps = map[string]struct {
+	jumpTrue, jumpFalse Op
+	nullEq              bool
+}{
+	"=":      {OpEq, OpNe, false},
+	"!=":     {OpNe, OpEq, false},
+	"<":      {OpLt, OpGe, false},
+	"<=":     {OpLe, OpGt, false},
+	">":      {OpGt, OpLe, false},
+	">=":     {OpGe, OpLt, false},
+	"IS":     {OpEq, OpNe, true},
+	"IS NOT": {OpNe, OpEq, true},
 }
 
-func (c whereClause) emitBinaryOperation(o *ast.BinaryOperation, evalCtx evalContext) int {
-	switch o.Operator {
-	case "=":
-		leftReg := c.emit(o.Left, evalContext{})
-		rightReg := c.emit(o.Right, evalContext{})
-		if evalCtx.conjunction {
-			c.p.Op3(OpNe, leftReg, evalCtx.fe, rightReg)
-		} else if evalCtx.disjunction {
-			c.p.Op3(OpEq, leftReg, evalCtx.te, rightReg)
-		} else {
-			panic("unknown logical context")
-		}
+func (c whereClause) emitBinaryOperation(o *ast.BinaryOperation, evalCtx evalContext) error {
+	ops := comparisonOps[o.Operator]
 
-		c.p.Comment(o.String())
-		return -1
-	case "!=":
-		leftReg := c.emit(o.Left, evalContext{})
-		rightReg := c.emit(o.Right, evalContext{})
-		if evalCtx.te != 0 {
-			c.p.Op3(OpNe, leftReg, evalCtx.te, rightReg)
-		} else {
-			c.p.Op3(OpEq, leftReg, evalCtx.fe, rightReg)
+	leftReg, err := c.emitValue(o.Left)
+	if err != nil {
+		return err
+	}
+	rightReg, err := c.emitValue(o.Right)
+	if err != nil {
+		return err
+	}
+	flags := 0
+	if ops.nullEq {
+		flags = OpFlagNullEq
+	}
+	if evalCtx.conjunction {
+		// NULL isn't true
+		if !ops.nullEq {
+			flags = OpFlagJumpIfNull
 		}
-		c.p.Comment(o.String())
-		return -1
+		c.emitComparison(ops.jumpFalse, o, leftReg, evalCtx.fe, rightReg, flags)
+	} else if evalCtx.disjunction {
+		c.emitComparison(ops.jumpTrue, o, leftReg, evalCtx.te, rightReg, flags)
+	} else {
+		panic("unknown logical context")
 	}
 
-	panic("unexpected operator")
+	return nil
 }
 
 func reworkExpression(expr ast.Expression) ast.Expression {
@@ -667,7 +1925,7 @@ func (g logicalGrouper) Visit(expr ast.Expression) ast.Expression {
 
 			rightExpr := g.Visit(e.Right)
 			if rightTerm, ok := rightExpr.(*ast.LogicalOperation); ok && rightTerm.Operator == e.Operator {
-				result.Terms = rightTerm.Terms
+				result.Terms = append(result.Terms, rightTerm.Terms...)
 			} else {
 				result.Terms = append(result.Terms, rightExpr)
 			}
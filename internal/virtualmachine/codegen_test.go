@@ -1,6 +1,8 @@
 package virtualmachine
 
 import (
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -17,18 +19,49 @@ var jumpOps = map[Op]bool{
 	OpLt: true, OpLe: true,
 	OpGt: true, OpGe: true,
 	OpRewind: true, OpNext: true,
+	OpGoto: true, OpNotExists: true,
+	OpIsNull: true, OpNotNull: true,
+	OpLast: true, OpPrev: true,
+	OpSeek: true, OpSeekGt: true, OpSeekGe: true,
+	OpSeekLt: true, OpSeekLe: true,
+	OpIdxGt: true, OpIdxGe: true,
+	OpIdxLt: true, OpIdxLe: true,
+	OpIf: true, OpIfNot: true,
+	OpSorterSort: true, OpSorterNext: true,
+	OpNoConflict: true,
+}
+
+var bazColumns = []*metadata.ColumnDefinition{
+	{Name: "id", Offset: 0, Affinity: storage.AffinityInteger},
+	{Name: "email", Offset: 1, Affinity: storage.AffinityText},
 }
 
 var testTableDefs = map[string]*metadata.TableDefinition{
 	"foo": {
 		Name: "foo",
 		Columns: []*metadata.ColumnDefinition{
-			{Name: "id", Offset: 0, Type: storage.Integer},
-			{Name: "email", Offset: 1, Type: storage.Text},
-			{Name: "state", Offset: 2, Type: storage.Text},
+			{Name: "id", Offset: 0, Affinity: storage.AffinityInteger},
+			{Name: "email", Offset: 1, Affinity: storage.AffinityText},
+			{Name: "state", Offset: 2, Affinity: storage.AffinityText},
 		},
 		RootPage: 1337,
 	},
+	"bar": {
+		Name: "bar",
+		Columns: []*metadata.ColumnDefinition{
+			{Name: "id", Offset: 0, Affinity: storage.AffinityInteger, PrimaryKey: true, RowIDAlias: true},
+			{Name: "email", Offset: 1, Affinity: storage.AffinityText},
+		},
+		RootPage: 1338,
+	},
+	"baz": {
+		Name:    "baz",
+		Columns: bazColumns,
+		Indexes: []*metadata.IndexDefinition{
+			{Name: "baz_email", TableName: "baz", Columns: bazColumns[1:], RootPage: 1340},
+		},
+		RootPage: 1339,
+	},
 }
 
 func TestSelectInstructions(t *testing.T) {
@@ -37,7 +70,8 @@ func TestSelectInstructions(t *testing.T) {
 	stmt, err := parser.ParseStatement("SELECT * FROM foo")
 	r.NoError(err)
 
-	instructions := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
 	r.NotEmpty(instructions)
 	result := Instructions(instructions).String()
 	r.NotEmpty(result)
@@ -71,12 +105,84 @@ func TestSelectInstructions_SingleConditionWhereClause(t *testing.T) {
 	stmt, err := parser.ParseStatement("SELECT * FROM foo WHERE email = 'a'")
 	r.NoError(err)
 
-	instructions := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
 	r.NotEmpty(instructions)
 
 	assertJumpsValid(instructions, t)
 }
 
+func TestSelectInstructions_Expressions(t *testing.T) {
+	r := require.New(t)
+
+	stmt, err := parser.ParseStatement("SELECT email, id + 1 FROM foo WHERE id * 2 > 4")
+	r.NoError(err)
+
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	r.Len(groupedByOp[OpAdd], 1)
+	r.Len(groupedByOp[OpMultiply], 1)
+	r.Len(groupedByOp[OpResultRow], 1)
+	r.Equal(2, groupedByOp[OpResultRow][0].ixn.P2)
+
+	// The filter is computed before the result columns
+	r.Less(groupedByOp[OpMultiply][0].addr, groupedByOp[OpAdd][0].addr)
+
+	assertJumpsValid(instructions, t)
+
+	stmt, err = parser.ParseStatement("SELECT nope + 1 FROM foo")
+	r.NoError(err)
+
+	_, err = SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.EqualError(err, "no such column: nope")
+}
+
+func TestSelectInstructions_ManyColumns(t *testing.T) {
+	r := require.New(t)
+
+	columns := make([]string, 120)
+	for i := range columns {
+		columns[i] = strconv.Itoa(i)
+	}
+	stmt, err := parser.ParseStatement("SELECT " + strings.Join(columns, ", ") + " FROM foo")
+	r.NoError(err)
+
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	// The result row takes as many contiguous registers as there are columns
+	r.Len(groupedByOp[OpResultRow], 1)
+	r.Equal(120, groupedByOp[OpResultRow][0].ixn.P2)
+	first := groupedByOp[OpResultRow][0].ixn.P1
+	r.Len(groupedByOp[OpInteger], 120)
+	for i, op := range groupedByOp[OpInteger] {
+		r.Equal(first+i, op.ixn.P2)
+	}
+
+	assertJumpsValid(instructions, t)
+}
+
+func TestSelectInstructions_Nulls(t *testing.T) {
+	r := require.New(t)
+
+	stmt, err := parser.ParseStatement("SELECT * FROM foo WHERE email IS NULL AND state = 'a'")
+	r.NoError(err)
+
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	// IS compares NULLs, a comparison with NULL in a conjunction is false
+	r.Len(groupedByOp[OpNe], 2)
+	r.Equal(OpFlagNullEq, groupedByOp[OpNe][0].ixn.P5)
+	r.Equal(OpFlagJumpIfNull, groupedByOp[OpNe][1].ixn.P5)
+
+	assertJumpsValid(instructions, t)
+}
+
 // +----+-----------+--+--+--+--------+--+-------+
 // |addr|opcode     |p1|p2|p3|p4      |p5|comment|
 // +----+-----------+--+--+--+--------+--+-------+
@@ -110,7 +216,8 @@ func TestSelectInstructions2(t *testing.T) {
 	`)
 	r.NoError(err)
 
-	instructions := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
 	r.NotEmpty(instructions)
 
 	code := Instructions(instructions).String()
@@ -131,7 +238,8 @@ func TestSelectInstructions3(t *testing.T) {
 	`)
 	r.NoError(err)
 
-	instructions := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
 	r.NotEmpty(instructions)
 
 	code := Instructions(instructions).String()
@@ -140,6 +248,334 @@ func TestSelectInstructions3(t *testing.T) {
 	assertJumpsValid(instructions, t)
 }
 
+func TestSelectInstructions_RowIDSeek(t *testing.T) {
+	r := require.New(t)
+
+	stmt, err := parser.ParseStatement("SELECT * FROM bar WHERE id = 10")
+	r.NoError(err)
+
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	// A point lookup doesn't loop over the table
+	r.Len(groupedByOp[OpSeek], 1)
+	r.Empty(groupedByOp[OpRewind])
+	r.Empty(groupedByOp[OpNext])
+
+	assertJumpsValid(instructions, t)
+}
+
+func TestSelectInstructions_RowIDRange(t *testing.T) {
+	r := require.New(t)
+
+	stmt, err := parser.ParseStatement("SELECT * FROM bar WHERE 10 < rowid AND email = 'a' AND id <= 20")
+	r.NoError(err)
+
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	// The scan starts after 10 and ends past 20
+	r.Len(groupedByOp[OpSeekGt], 1)
+	r.Empty(groupedByOp[OpRewind])
+	r.Len(groupedByOp[OpGt], 2)
+	r.Equal(groupedByOp[OpNext][0].ixn.P2, groupedByOp[OpRowID][0].addr)
+
+	assertJumpsValid(instructions, t)
+}
+
+func TestSelectInstructions_RowIDDisjunction(t *testing.T) {
+	r := require.New(t)
+
+	stmt, err := parser.ParseStatement("SELECT * FROM bar WHERE id = 10 OR id = 20")
+	r.NoError(err)
+
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	// Either term may be true, the whole table is scanned
+	r.Len(groupedByOp[OpRewind], 1)
+	r.Empty(groupedByOp[OpSeek])
+
+	assertJumpsValid(instructions, t)
+}
+
+func TestSelectInstructions_IndexEquality(t *testing.T) {
+	r := require.New(t)
+
+	stmt, err := parser.ParseStatement("SELECT * FROM baz WHERE email = 'a' AND id > 2")
+	r.NoError(err)
+
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	// The index is scanned from the first entry equal to the key
+	r.Len(groupedByOp[OpOpenRead], 2)
+	r.Equal(1340, groupedByOp[OpOpenRead][1].ixn.P2)
+	r.Len(groupedByOp[OpSeekGe], 1)
+	r.Equal(1, groupedByOp[OpSeekGe][0].ixn.P1)
+	r.Len(groupedByOp[OpIdxGt], 1)
+	r.Empty(groupedByOp[OpRewind])
+
+	// Each entry moves the table cursor to the record
+	r.Len(groupedByOp[OpIdxPKey], 1)
+	r.Equal(0, groupedByOp[OpSeek][0].ixn.P1)
+	r.Equal(groupedByOp[OpNext][0].ixn.P2, groupedByOp[OpIdxGt][0].addr)
+
+	assertJumpsValid(instructions, t)
+}
+
+func TestSelectInstructions_IndexRange(t *testing.T) {
+	r := require.New(t)
+
+	stmt, err := parser.ParseStatement("SELECT * FROM baz WHERE 'a' < email AND email <= 'c'")
+	r.NoError(err)
+
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	// The scan starts after 'a' and ends past 'c'
+	r.Len(groupedByOp[OpSeekGt], 1)
+	r.Len(groupedByOp[OpIdxGt], 1)
+	r.Empty(groupedByOp[OpRewind])
+
+	// A literal of another type is converted to the affinity of the column like the entries
+	stmt, err = parser.ParseStatement("SELECT * FROM baz WHERE email = 1")
+	r.NoError(err)
+
+	instructions, err = SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp = groupInstructions(instructions)
+	r.Empty(groupedByOp[OpRewind])
+	r.Len(groupedByOp[OpIdxPKey], 1)
+	r.Len(groupedByOp[OpAffinity], 1)
+	r.Equal("B", groupedByOp[OpAffinity][0].ixn.P4)
+	r.Less(groupedByOp[OpAffinity][0].addr, groupedByOp[OpSeekGe][0].addr)
+
+	// The comparison converts the values to the affinity of the column
+	r.Equal(storage.AffinityText, groupedByOp[OpNe][0].ixn.P4)
+
+	// NULL is never equal to an entry
+	stmt, err = parser.ParseStatement("SELECT * FROM baz WHERE email = NULL")
+	r.NoError(err)
+
+	instructions, err = SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp = groupInstructions(instructions)
+	r.Len(groupedByOp[OpRewind], 1)
+	r.Empty(groupedByOp[OpIdxPKey])
+
+	assertJumpsValid(instructions, t)
+}
+
+func TestSelectInstructions_OrderBy(t *testing.T) {
+	r := require.New(t)
+
+	stmt, err := parser.ParseStatement("SELECT email, id + 1 AS next FROM foo WHERE id > 2 ORDER BY state DESC, next, 1 NULLS LAST")
+	r.NoError(err)
+
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	// The keys are stored with the result columns
+	sorterOpen := groupedByOp[OpSorterOpen]
+	r.Len(sorterOpen, 1)
+	r.Equal(3, sorterOpen[0].ixn.P2)
+	r.Equal([]SortOrder{{Desc: true}, {NullsFirst: true}, {}}, sorterOpen[0].ixn.P4)
+	r.Len(groupedByOp[OpSorterInsert], 1)
+	r.Equal(5, groupedByOp[OpSorterInsert][0].ixn.P3)
+
+	// The alias refers to the expression of the result column
+	r.Len(groupedByOp[OpAdd], 2)
+
+	// Rows are produced in order after the scan
+	r.Len(groupedByOp[OpResultRow], 1)
+	r.Less(groupedByOp[OpNext][0].addr, groupedByOp[OpSorterSort][0].addr)
+	r.Less(groupedByOp[OpSorterSort][0].addr, groupedByOp[OpResultRow][0].addr)
+	r.Equal(groupedByOp[OpSorterData][0].addr, groupedByOp[OpSorterNext][0].ixn.P2)
+
+	assertJumpsValid(instructions, t)
+
+	stmt, err = parser.ParseStatement("SELECT email FROM foo ORDER BY email, 2")
+	r.NoError(err)
+
+	_, err = SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.EqualError(err, "2nd ORDER BY term out of range - should be between 1 and 1")
+}
+
+func TestSelectInstructions_OrderByScan(t *testing.T) {
+	r := require.New(t)
+
+	tests := []struct {
+		sql    string
+		sorted bool
+		index  bool
+	}{
+		// The table is scanned in rowid order
+		{"SELECT * FROM bar ORDER BY id", false, false},
+		{"SELECT * FROM bar WHERE id > 2 ORDER BY rowid, email", false, false},
+		{"SELECT * FROM bar ORDER BY id DESC", false, false},
+		{"SELECT * FROM bar ORDER BY id DESC NULLS FIRST", true, false},
+		{"SELECT * FROM bar ORDER BY email", true, false},
+		// The index is scanned in the order of its columns then the rowid
+		{"SELECT * FROM baz ORDER BY email", false, true},
+		{"SELECT * FROM baz WHERE email > 'a' ORDER BY email, rowid", false, true},
+		{"SELECT * FROM baz ORDER BY email NULLS LAST", true, false},
+		{"SELECT * FROM baz ORDER BY email, id", true, false},
+		{"SELECT * FROM baz WHERE email > 'a' ORDER BY rowid", true, true},
+		{"SELECT * FROM baz WHERE rowid > 2 ORDER BY email", true, false},
+		// In reverse order with NULLs last
+		{"SELECT * FROM baz ORDER BY email DESC, rowid DESC", false, true},
+		{"SELECT * FROM baz ORDER BY email DESC, rowid", true, false},
+	}
+	for _, test := range tests {
+		stmt, err := parser.ParseStatement(test.sql)
+		r.NoError(err)
+
+		instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+		r.NoError(err)
+		groupedByOp := groupInstructions(instructions)
+
+		r.Equal(test.sorted, len(groupedByOp[OpSorterOpen]) > 0, test.sql)
+		r.Equal(test.index, len(groupedByOp[OpIdxPKey]) > 0, test.sql)
+		assertJumpsValid(instructions, t)
+	}
+
+	// Every entry of the index is visited including NULLs
+	stmt, err := parser.ParseStatement("SELECT * FROM baz ORDER BY email")
+	r.NoError(err)
+
+	instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+	r.Len(groupedByOp[OpRewind], 1)
+	r.Equal(1, groupedByOp[OpRewind][0].ixn.P1)
+}
+
+func TestSelectInstructions_OrderByReverse(t *testing.T) {
+	r := require.New(t)
+
+	tests := []struct {
+		sql   string
+		start Op
+		end   Op
+	}{
+		{"SELECT * FROM bar ORDER BY id DESC", OpLast, OpNoOp},
+		{"SELECT * FROM bar WHERE id < 20 AND id > 10 ORDER BY id DESC", OpSeekLt, OpLe},
+		{"SELECT * FROM bar WHERE id >= 10 ORDER BY rowid DESC", OpLast, OpLt},
+		{"SELECT * FROM baz ORDER BY email DESC", OpLast, OpNoOp},
+		{"SELECT * FROM baz WHERE email = 'a' ORDER BY email DESC", OpSeekLe, OpIdxLt},
+		{"SELECT * FROM baz WHERE email <= 'b' AND email > 'a' ORDER BY email DESC", OpSeekLe, OpIdxLe},
+		{"SELECT * FROM baz WHERE email < 'b' ORDER BY email DESC", OpSeekLt, OpIdxLe},
+	}
+	for _, test := range tests {
+		stmt, err := parser.ParseStatement(test.sql)
+		r.NoError(err)
+
+		instructions, err := SelectInstructions(testTableDefs, stmt.(*ast.SelectStatement))
+		r.NoError(err)
+		groupedByOp := groupInstructions(instructions)
+
+		// The scan starts at the end of the range and moves back, nothing is sorted
+		r.Empty(groupedByOp[OpSorterOpen], test.sql)
+		r.Empty(groupedByOp[OpNext], test.sql)
+		r.Len(groupedByOp[OpPrev], 1, test.sql)
+		r.Len(groupedByOp[test.start], 1, test.sql)
+		if test.end != OpNoOp {
+			// Each record is checked against the end of the range before the filter
+			r.NotEmpty(groupedByOp[test.end], test.sql)
+			r.Greater(groupedByOp[OpPrev][0].ixn.P2, groupedByOp[test.start][0].addr, test.sql)
+			r.LessOrEqual(groupedByOp[OpPrev][0].ixn.P2, groupedByOp[test.end][0].addr, test.sql)
+		}
+		assertJumpsValid(instructions, t)
+	}
+}
+
+func TestDeleteInstructions_Index(t *testing.T) {
+	r := require.New(t)
+
+	stmt, err := parser.ParseStatement("DELETE FROM baz WHERE id = 1")
+	r.NoError(err)
+
+	instructions, err := DeleteInstructions(testTableDefs, stmt.(*ast.DeleteStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	// The entry is removed from the index along with the record
+	r.Len(groupedByOp[OpOpenWrite], 2)
+	r.Len(groupedByOp[OpIdxDelete], 1)
+	r.Equal(1, groupedByOp[OpIdxDelete][0].ixn.P1)
+	r.Equal(2, groupedByOp[OpIdxDelete][0].ixn.P3)
+	r.Less(groupedByOp[OpIdxDelete][0].addr, groupedByOp[OpDelete][0].addr)
+
+	assertJumpsValid(instructions, t)
+}
+
+func TestCreateIndexInstructions(t *testing.T) {
+	r := require.New(t)
+
+	stmt, err := parser.ParseStatement("CREATE UNIQUE INDEX foo_email ON foo (email, state)")
+	r.NoError(err)
+
+	instructions, err := CreateIndexInstructions(testTableDefs["foo"], stmt.(*ast.CreateIndexStatement))
+	r.NoError(err)
+	groupedByOp := groupInstructions(instructions)
+
+	// The index is opened from the register with the new root page
+	createIndex := groupedByOp[OpCreateIndex]
+	r.Len(createIndex, 1)
+	openIndex := groupedByOp[OpOpenWrite][1].ixn
+	r.Equal(createIndex[0].ixn.P1, openIndex.P2)
+	r.Equal(OpFlagP2IsReg, openIndex.P5)
+
+	// Each record is checked and added to the index
+	r.Len(groupedByOp[OpNoConflict], 1)
+	r.Equal(2, groupedByOp[OpNoConflict][0].ixn.P4)
+	r.Equal("UNIQUE constraint failed: foo.email, foo.state", groupedByOp[OpHalt][0].ixn.P4)
+	r.Len(groupedByOp[OpIdxInsert], 1)
+
+	assertJumpsValid(instructions, t)
+
+	stmt, err = parser.ParseStatement("CREATE INDEX foo_missing ON foo (missing)")
+	r.NoError(err)
+
+	_, err = CreateIndexInstructions(testTableDefs["foo"], stmt.(*ast.CreateIndexStatement))
+	r.EqualError(err, "no such column: missing")
+}
+
+func TestDropTableInstructions(t *testing.T) {
+	r := require.New(t)
+
+	sequenceTable := &metadata.TableDefinition{
+		Name: metadata.SequenceTable,
+		Columns: []*metadata.ColumnDefinition{
+			{Name: "name", Offset: 0, Affinity: storage.AffinityText},
+			{Name: "seq", Offset: 1, Affinity: storage.AffinityInteger},
+		},
+		RootPage: 2,
+	}
+
+	instructions := DropTableInstructions(testTableDefs["baz"], sequenceTable)
+	groupedByOp := groupInstructions(instructions)
+
+	// Records are removed from the schema and sequence tables
+	r.Len(groupedByOp[OpOpenWrite], 2)
+	r.Len(groupedByOp[OpDelete], 2)
+
+	// The index and table btrees are freed
+	destroy := groupedByOp[OpDestroy]
+	r.Len(destroy, 2)
+	r.Equal(1340, destroy[0].ixn.P1)
+	r.Equal(1339, destroy[1].ixn.P1)
+
+	assertJumpsValid(instructions, t)
+}
+
 type groupItem struct {
 	addr int
 	ixn  *Instruction
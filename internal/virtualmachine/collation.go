@@ -0,0 +1,85 @@
+package virtualmachine
+
+import (
+	"strings"
+
+	"github.com/joeandaverde/tinydb/tsql/ast"
+)
+
+// Collation is a collating sequence, the order of text values when they're compared.
+// Numbers and blobs are compared the same way with any collation.
+type Collation int
+
+const (
+	CollationBinary Collation = iota // 逐字节比较
+	CollationNoCase                  // 忽略 ASCII 字母的大小写
+	CollationRTrim                   // 忽略末尾的空格
+)
+
+// collations are the built-in collating sequences of SQLite by name
+var collations = map[string]Collation{
+	"BINARY": CollationBinary,
+	"NOCASE": CollationNoCase,
+	"RTRIM":  CollationRTrim,
+}
+
+// compare compares two texts, it returns -1, 0 or 1.
+func (c Collation) compare(a string, b string) int {
+	switch c {
+	case CollationNoCase:
+		return strings.Compare(lowerASCII(a), lowerASCII(b))
+	case CollationRTrim:
+		return strings.Compare(strings.TrimRight(a, " "), strings.TrimRight(b, " "))
+	}
+	return strings.Compare(a, b)
+}
+
+func (c Collation) String() string {
+	switch c {
+	case CollationNoCase:
+		return "NOCASE"
+	case CollationRTrim:
+		return "RTRIM"
+	}
+	return "BINARY"
+}
+
+// lowerASCII converts the ASCII letters of a text to lower case, other letters are left as is.
+func lowerASCII(s string) string {
+	return strings.Map(lowerASCIIRune, s)
+}
+
+func lowerASCIIRune(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r + 'a' - 'A'
+	}
+	return r
+}
+
+// explicitCollation is the collating sequence set by a COLLATE clause of an expression,
+// or of its left-most operand which has one. ok is false without a COLLATE clause.
+func explicitCollation(expr ast.Expression) (collation Collation, ok bool) {
+	switch e := expr.(type) {
+	case *ast.CollateOperation:
+		collation, ok = collations[strings.ToUpper(e.Collation)]
+		return collation, ok
+	case *ast.UnaryOperation:
+		return explicitCollation(e.Operand)
+	case *ast.BinaryOperation:
+		if collation, ok = explicitCollation(e.Left); ok {
+			return collation, ok
+		}
+		return explicitCollation(e.Right)
+	}
+	return CollationBinary, false
+}
+
+// comparisonCollation is the collating sequence of a comparison, the collation of the
+// left operand if it has one, else of the right operand and BINARY without either.
+func comparisonCollation(left ast.Expression, right ast.Expression) Collation {
+	if collation, ok := explicitCollation(left); ok {
+		return collation
+	}
+	collation, _ := explicitCollation(right)
+	return collation
+}
@@ -0,0 +1,372 @@
+package virtualmachine
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/joeandaverde/tinydb/internal/metadata"
+	"github.com/joeandaverde/tinydb/internal/storage"
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+)
+
+// exprCompiler emits instructions computing the value of expressions into registers.
+// Columns are read from the record at the cursor of the table, an expression without
+// a table such as a value of an insert can't refer to columns.
+//
+// 表达式编译器
+type exprCompiler struct {
+	p      *program
+	table  *metadata.TableDefinition // 列所在的表，可为 nil
+	cursor int                       // 指向当前记录的游标
+}
+
+// operatorOps are the ops computing the value of a binary operator
+var operatorOps = map[string]Op{
+	"+":   OpAdd,
+	"-":   OpSubtract,
+	"*":   OpMultiply,
+	"/":   OpDivide,
+	"%":   OpRemainder,
+	"||":  OpConcat,
+	"&":   OpBitAnd,
+	"|":   OpBitOr,
+	"<<":  OpShiftLeft,
+	">>":  OpShiftRight,
+	"AND": OpAnd,
+	"OR":  OpOr,
+}
+
+// emitValue computes the value of the expression in a new register and returns the register.
+func (c exprCompiler) emitValue(expr ast.Expression) (int, error) {
+	reg := c.p.RegAlloc()
+	return reg, c.emitValueTo(expr, reg)
+}
+
+// emitValueTo computes the value of the expression in the register.
+func (c exprCompiler) emitValueTo(expr ast.Expression, reg int) error {
+	switch e := expr.(type) {
+	case *ast.BasicLiteral:
+		return c.emitLiteral(e, reg)
+	case *ast.Ident:
+		column, err := c.column(e.Value)
+		if err != nil {
+			return err
+		}
+		c.p.emitColumn(c.cursor, column, reg)
+		return nil
+	case *ast.UnaryOperation:
+		return c.emitUnaryOperation(e, reg)
+	case *ast.LikeOperation:
+		return c.emitLikeOperation(e, reg)
+	case *ast.BetweenOperation:
+		// x BETWEEN y AND z is x >= y AND x <= z like SQLite
+		return c.emitValueTo(betweenExpression(e), reg)
+	case *ast.InOperation:
+		return c.emitInOperation(e, reg)
+	case *ast.CollateOperation:
+		// The value is the operand, the collation only applies when it's compared
+		if _, ok := collations[strings.ToUpper(e.Collation)]; !ok {
+			return fmt.Errorf("no such collation sequence: %s", e.Collation)
+		}
+		return c.emitValueTo(e.Operand, reg)
+	case *ast.BinaryOperation:
+		operator := strings.ToUpper(e.Operator)
+		if _, ok := comparisonOps[operator]; ok {
+			return c.emitComparisonValue(e, reg)
+		}
+		op, ok := operatorOps[operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", e.Operator)
+		}
+
+		leftReg, err := c.emitValue(e.Left)
+		if err != nil {
+			return err
+		}
+		rightReg, err := c.emitValue(e.Right)
+		if err != nil {
+			return err
+		}
+		c.p.Op3(op, leftReg, rightReg, reg)
+		c.p.Comment(e.String())
+		return nil
+	case *ast.LogicalOperation:
+		op, ok := operatorOps[strings.ToUpper(e.Operator)]
+		if !ok || len(e.Terms) == 0 {
+			return fmt.Errorf("unknown operator %s", e.Operator)
+		}
+
+		// Combine the terms from left to right
+		if err := c.emitValueTo(e.Terms[0], reg); err != nil {
+			return err
+		}
+		for _, t := range e.Terms[1:] {
+			termReg, err := c.emitValue(t)
+			if err != nil {
+				return err
+			}
+			c.p.Op3(op, reg, termReg, reg)
+		}
+		return nil
+	default:
+		return errors.New("unexpected expression type")
+	}
+}
+
+func (c exprCompiler) emitLiteral(e *ast.BasicLiteral, reg int) error {
+	switch e.Kind {
+	case lexer.TokenString:
+		c.p.OpString(reg, e.Value)
+	case lexer.TokenNumber:
+		value, err := parseNumber(e.Value)
+		if err != nil {
+			return err
+		}
+		c.p.opNumber(reg, value)
+	case lexer.TokenBoolean:
+		// TRUE and FALSE are the integers 1 and 0
+		value, err := strconv.ParseBool(strings.ToLower(e.Value))
+		if err != nil {
+			return err
+		}
+		c.p.OpInt(reg, int(boolInt(value)))
+	case lexer.TokenNull:
+		c.p.OpNull(reg)
+	default:
+		return errors.New("unexpected literal type")
+	}
+	return nil
+}
+
+func (c exprCompiler) emitUnaryOperation(e *ast.UnaryOperation, reg int) error {
+	switch strings.ToUpper(e.Operator) {
+	case "-":
+		// A negative number is a constant
+		if literal, ok := e.Operand.(*ast.BasicLiteral); ok && literal.Kind == lexer.TokenNumber {
+			value, err := parseNumber("-" + literal.Value)
+			if err != nil {
+				return err
+			}
+			c.p.opNumber(reg, value)
+			return nil
+		}
+
+		operandReg, err := c.emitValue(e.Operand)
+		if err != nil {
+			return err
+		}
+		zeroReg := c.p.RegAlloc()
+		c.p.OpInt(zeroReg, 0)
+		c.p.Op3(OpSubtract, zeroReg, operandReg, reg)
+	case "+":
+		// The value of the operand as is, even if it isn't a number
+		return c.emitValueTo(e.Operand, reg)
+	case "NOT":
+		operandReg, err := c.emitValue(e.Operand)
+		if err != nil {
+			return err
+		}
+		c.p.Op2(OpNot, operandReg, reg)
+	case "~":
+		operandReg, err := c.emitValue(e.Operand)
+		if err != nil {
+			return err
+		}
+		c.p.Op2(OpBitNot, operandReg, reg)
+	default:
+		return fmt.Errorf("unknown operator %s", e.Operator)
+	}
+
+	c.p.Comment(e.String())
+	return nil
+}
+
+// emitLikeOperation stores 1 in the register if the operand matches the pattern, 0 if
+// it doesn't and NULL if either value is NULL. NOT LIKE and NOT GLOB are the negation.
+// Like SQLite without extensions, REGEXP and MATCH have no implementation.
+func (c exprCompiler) emitLikeOperation(e *ast.LikeOperation, reg int) error {
+	var op Op
+	switch e.Operator {
+	case "LIKE":
+		op = OpLike
+	case "GLOB":
+		if e.Escape != nil {
+			return fmt.Errorf("wrong number of arguments to function %s()", e.Operator)
+		}
+		op = OpGlob
+	case "REGEXP":
+		return fmt.Errorf("no such function: %s", e.Operator)
+	case "MATCH":
+		return errors.New("unable to use function MATCH in the requested context")
+	default:
+		return fmt.Errorf("unknown operator %s", e.Operator)
+	}
+
+	operandReg, err := c.emitValue(e.Operand)
+	if err != nil {
+		return err
+	}
+	patternReg, err := c.emitValue(e.Pattern)
+	if err != nil {
+		return err
+	}
+	var escapeReg interface{}
+	if e.Escape != nil {
+		if escapeReg, err = c.emitValue(e.Escape); err != nil {
+			return err
+		}
+	}
+
+	c.p.Op4(op, operandReg, patternReg, reg, escapeReg)
+	c.p.Comment(e.String())
+	if e.Not {
+		c.p.Op2(OpNot, reg, reg)
+	}
+	return nil
+}
+
+// betweenExpression is x >= y AND x <= z for x BETWEEN y AND z, NOT BETWEEN is its negation.
+func betweenExpression(e *ast.BetweenOperation) ast.Expression {
+	var expr ast.Expression = &ast.BinaryOperation{
+		Left:     &ast.BinaryOperation{Left: e.Operand, Operator: ">=", Right: e.Low},
+		Operator: "AND",
+		Right:    &ast.BinaryOperation{Left: e.Operand, Operator: "<=", Right: e.High},
+	}
+	if e.Not {
+		expr = &ast.UnaryOperation{Operand: expr, Operator: "NOT"}
+	}
+	return expr
+}
+
+// emitInOperation stores 1 in the register if the operand is equal to a value of the list,
+// NULL if it isn't but the operand or a value is NULL and 0 otherwise. NOT IN is the
+// negation. Like SQLite, the values are compared with the affinity and the collation
+// of the operand, and nothing is in an empty list, not even NULL.
+func (c exprCompiler) emitInOperation(e *ast.InOperation, reg int) error {
+	found, notFound := 1, 0
+	if e.Not {
+		found, notFound = 0, 1
+	}
+	if len(e.List) == 0 {
+		c.p.OpInt(reg, notFound)
+		return nil
+	}
+
+	operandReg, err := c.emitValue(e.Operand)
+	if err != nil {
+		return err
+	}
+	var p4 interface{}
+	if affinity := c.affinity(e.Operand); affinity != 0 && affinity != storage.AffinityBlob {
+		p4 = affinity
+	}
+	collation, _ := explicitCollation(e.Operand)
+
+	foundLabel := c.p.MakeLabel()
+	doneLabel := c.p.MakeLabel()
+	c.p.OpNull(reg)
+	c.p.Op2(OpIsNull, operandReg, doneLabel)
+	valueRegs := make([]int, len(e.List))
+	for i, value := range e.List {
+		if valueRegs[i], err = c.emitValue(value); err != nil {
+			return err
+		}
+		c.p.Op4(OpEq, operandReg, foundLabel, valueRegs[i], p4)
+		c.p.instructions[len(c.p.instructions)-1].P5 = int(collation)
+		c.p.Comment(e.String())
+	}
+
+	// None of the values is equal, the result is NULL if any of them is NULL
+	for _, valueReg := range valueRegs {
+		c.p.Op2(OpIsNull, valueReg, doneLabel)
+	}
+	c.p.OpInt(reg, notFound)
+	c.p.Op2(OpGoto, 0, doneLabel)
+	c.p.EmitLabel(foundLabel)
+	c.p.OpInt(reg, found)
+	c.p.EmitLabel(doneLabel)
+	return nil
+}
+
+// emitComparisonValue stores 1 in the register if the comparison is true, 0 if it's false
+// and NULL if either value is NULL, unless the comparison is IS or IS NOT.
+func (c exprCompiler) emitComparisonValue(e *ast.BinaryOperation, reg int) error {
+	ops := comparisonOps[e.Operator]
+
+	leftReg, err := c.emitValue(e.Left)
+	if err != nil {
+		return err
+	}
+	rightReg, err := c.emitValue(e.Right)
+	if err != nil {
+		return err
+	}
+
+	doneLabel := c.p.MakeLabel()
+	if !ops.nullEq {
+		c.p.OpNull(reg)
+		c.p.Op2(OpIsNull, leftReg, doneLabel)
+		c.p.Op2(OpIsNull, rightReg, doneLabel)
+	}
+	c.p.OpInt(reg, 1)
+	flags := 0
+	if ops.nullEq {
+		flags = OpFlagNullEq
+	}
+	c.emitComparison(ops.jumpTrue, e, leftReg, doneLabel, rightReg, flags)
+	c.p.OpInt(reg, 0)
+	c.p.EmitLabel(doneLabel)
+	return nil
+}
+
+// emitComparison emits the comparison op jumping to the address when the values of the
+// operands in the registers compare as the op requires. The values are converted to the
+// affinity of the comparison first and text is compared with its collation. The flags
+// are the NULL flags of P5.
+func (c exprCompiler) emitComparison(op Op, e *ast.BinaryOperation, leftReg int, jump int, rightReg int, flags int) {
+	var p4 interface{}
+	if affinity := comparisonAffinity(c.affinity(e.Left), c.affinity(e.Right)); affinity != 0 && affinity != storage.AffinityBlob {
+		p4 = affinity
+	}
+	c.p.Op4(op, leftReg, jump, rightReg, p4)
+	c.p.instructions[len(c.p.instructions)-1].P5 = flags | int(comparisonCollation(e.Left, e.Right))
+	c.p.Comment(e.String())
+}
+
+// affinity is the affinity of the value of an expression, a column has the affinity
+// of its declared type and any other expression has none.
+func (c exprCompiler) affinity(expr ast.Expression) storage.Affinity {
+	if ident, ok := expr.(*ast.Ident); ok {
+		if column, err := c.column(ident.Value); err == nil {
+			return column.Affinity
+		}
+	}
+	return 0
+}
+
+// column resolves the name of a column of the table.
+func (c exprCompiler) column(name string) (*metadata.ColumnDefinition, error) {
+	if c.table != nil {
+		if column := c.table.Column(name); column != nil {
+			return column, nil
+		}
+	}
+	return nil, fmt.Errorf("no such column: %s", name)
+}
+
+// parseNumber converts a numeric literal to an int, or to a float64 when it
+// has a fraction or an exponent or doesn't fit in 64 bits.
+func parseNumber(s string) (interface{}, error) {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return int(i), nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("malformed number %s", s)
+	}
+	return f, nil
+}
@@ -0,0 +1,124 @@
+package virtualmachine
+
+// likeMatch reports whether the text matches the LIKE pattern like SQLite does:
+// % matches any sequence of characters, _ matches any one character and ASCII
+// letters match regardless of their case. The escape character, if escaped is
+// true, makes the character after it match itself.
+func likeMatch(pattern []rune, s []rune, escape rune, escaped bool) bool {
+	for len(pattern) > 0 {
+		c := pattern[0]
+		pattern = pattern[1:]
+
+		switch {
+		case escaped && c == escape:
+			// An escape at the end of the pattern matches nothing
+			if len(pattern) == 0 {
+				return false
+			}
+			c = pattern[0]
+			pattern = pattern[1:]
+		case c == '%':
+			for i := 0; i <= len(s); i++ {
+				if likeMatch(pattern, s[i:], escape, escaped) {
+					return true
+				}
+			}
+			return false
+		case c == '_':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			continue
+		}
+
+		if len(s) == 0 || lowerASCIIRune(s[0]) != lowerASCIIRune(c) {
+			return false
+		}
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
+
+// globMatch reports whether the text matches the GLOB pattern like SQLite does:
+// * matches any sequence of characters, ? matches any one character and [...]
+// matches one of the characters or ranges in the brackets, or any other
+// character if it starts with ^. Letters must have the same case.
+func globMatch(pattern []rune, s []rune) bool {
+	for len(pattern) > 0 {
+		c := pattern[0]
+		pattern = pattern[1:]
+
+		switch c {
+		case '*':
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			continue
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			matched, rest := globClass(pattern, s[0])
+			if !matched {
+				return false
+			}
+			pattern, s = rest, s[1:]
+			continue
+		}
+
+		if len(s) == 0 || s[0] != c {
+			return false
+		}
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
+
+// globClass matches a character with the class of a GLOB pattern which follows [
+// and returns the rest of the pattern after the closing ]. A ] right after the [ or
+// the ^ is part of the class, as is a - which isn't between two characters.
+// A class without the closing ] matches nothing.
+func globClass(pattern []rune, c rune) (bool, []rune) {
+	invert, matched := false, false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		invert = true
+		pattern = pattern[1:]
+	}
+	if len(pattern) > 0 && pattern[0] == ']' {
+		matched = c == ']'
+		pattern = pattern[1:]
+	}
+
+	// The character before a -, 0 when a - can't start a range
+	var prior rune
+	for i := 0; i < len(pattern); i++ {
+		switch p := pattern[i]; {
+		case p == ']':
+			return matched != invert, pattern[i+1:]
+		case p == '-' && prior != 0 && i+1 < len(pattern) && pattern[i+1] != ']':
+			if c >= prior && c <= pattern[i+1] {
+				matched = true
+			}
+			prior = 0
+			i++
+		default:
+			if c == p {
+				matched = true
+			}
+			prior = p
+		}
+	}
+
+	return false, nil
+}
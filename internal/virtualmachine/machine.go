@@ -1,19 +1,20 @@
 package virtualmachine
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 )
 
-// TODO: this is to get things to compile, need to actually get auto incr key
-var keys = make(map[string]uint32)
-
 // Register Types
 type reg uint
 
 const (
 	RegUnspecified reg = iota
 	RegNull
-	RegInt32
+	RegInt32   // int which fits in 32 bits
+	RegInt64   // int64 which doesn't fit in 32 bits
+	RegFloat64 // float64
 	RegString
 	RegBinary
 	RegRecord
@@ -29,28 +30,59 @@ const (
 	// and stores cursor in c
 	// 	P1 - cursor (c)
 	// 	P2 - page number (n)
-	// 	P3 - col count (0 if opening index)
+	// 	P3 - col count
+	// 	P5 - OpFlagP2IsReg if P2 is the register with the page number
 	OpOpenRead
 	// Opens B-Tree Rooted at page n
 	// and stores cursor in c
 	// 	P1 - cursor (c)
-	// 	P2 - page number register (n)
-	// 	P3 - col count (0 if opening index)
+	// 	P2 - page number (n)
+	// 	P3 - col count
+	// 	P5 - OpFlagP2IsReg if P2 is the register with the page number
 	OpOpenWrite
 	OpClose
 	// Point to first entry in btree
 	// 	P1 - Cursor
 	// 	P2 - Jump address (if btree is empty)
 	OpRewind
+	// Point to last entry in btree
+	// 	P1 - Cursor
+	// 	P2 - Jump address (if btree is empty)
+	OpLast
 	// Read next cell at read cursor and go to address if more, otherwise, fallthrough.
 	// 	P1 - Cursor
 	// 	P2 - Jump Address
 	OpNext
+	// Read previous cell at read cursor and go to address if more, otherwise, fallthrough.
+	// 	P1 - Cursor
+	// 	P2 - Jump Address
 	OpPrev
+	// Move the cursor to the record with the rowid in P3.
+	// The seek ops move an index cursor by the key in P4 registers starting at P3 instead.
+	// 	P1 - Cursor
+	// 	P2 - Jump address (if there is no such record)
+	// 	P3 - register with the rowid
+	// 	P4 - (index only) count of key registers
 	OpSeek
+	// Move the cursor to the first record with a rowid greater than the value in P3.
+	// 	P1 - Cursor
+	// 	P2 - Jump address (if there is no such record)
+	// 	P3 - register with the rowid
 	OpSeekGt
+	// Move the cursor to the first record with a rowid greater than or equal to the value in P3.
+	// 	P1 - Cursor
+	// 	P2 - Jump address (if there is no such record)
+	// 	P3 - register with the rowid
 	OpSeekGe
+	// Move the cursor to the last record with a rowid less than the value in P3.
+	// 	P1 - Cursor
+	// 	P2 - Jump address (if there is no such record)
+	// 	P3 - register with the rowid
 	OpSeekLt
+	// Move the cursor to the last record with a rowid less than or equal to the value in P3.
+	// 	P1 - Cursor
+	// 	P2 - Jump address (if there is no such record)
+	// 	P3 - register with the rowid
 	OpSeekLe
 
 	// Set the database auto-commit flag to P1 (1 or 0).
@@ -67,6 +99,14 @@ const (
 	// 	P1 - the int
 	// 	P2 - the register
 	OpInteger
+	// Stores a 64-bit int in register
+	// 	P2 - the register
+	// 	P4 - the int64
+	OpInt64
+	// Stores a floating point number in register
+	// 	P2 - the register
+	// 	P4 - the float64
+	OpReal
 	OpString
 	OpNull
 	// 	P1 - register start
@@ -76,49 +116,215 @@ const (
 	// 	P2 - count of cols
 	// 	P3 - store record in this register
 	OpMakeRecord
+	// 	P1 - cursor positioned on a record
+	// 	P2 - write rowid of the record to this register
+	OpRowID
+	// Get a new rowid, one greater than the largest rowid in the btree.
 	// 	P1 - cursor for table to get rowid
 	// 	P2 - write rowid to this register
-	OpRowID
+	// 	P4 - (optional) register with the largest rowid previously used, updated with the new rowid
+	OpNewRowID
+	// Jump to address P2 if there is no record with the rowid in the btree.
+	// Otherwise, the cursor is moved to the record.
+	// 	P1 - cursor
+	// 	P2 - jump address
+	// 	P3 - register with the rowid
+	OpNotExists
 	// 	P1 - cursor
 	// 	P2 - register containing the record
 	// 	P3 - register with record key
 	OpInsert
+	// Delete the record at the cursor. The cursor is left between records,
+	// the following OpNext moves to the record after the deleted one.
+	// 	P1 - cursor
+	OpDelete
 	// Take the logical AND of the values in registers P1 and P2 and write the result into register P3.
 	// If either P1 or P2 is 0 (false) then the result is 0 even if the other input is NULL. A NULL and true or two NULLs give a NULL output.
 	OpAnd
+	// Take the logical OR of the values in registers P1 and P2 and write the result into register P3.
+	// If either P1 or P2 is nonzero (true) then the result is 1 even if the other input is NULL. A NULL and false or two NULLs give a NULL output.
+	OpOr
+	// Store the boolean complement of the value in register P1 in register P2. If P1 is NULL, the result is NULL.
+	OpNot
 	// Add the value in register P1 to the value in register P2 and store the result in register P3. If either input is NULL, the result is NULL.
 	OpAdd
+	// Subtract the value in register P2 from the value in register P1 and store the result in register P3. If either input is NULL, the result is NULL.
+	OpSubtract
+	// Multiply the value in register P1 by the value in register P2 and store the result in register P3. If either input is NULL, the result is NULL.
+	OpMultiply
+	// Divide the value in register P1 by the value in register P2 and store the result in register P3.
+	// If either input is NULL or P2 is zero, the result is NULL. Two integers give an integer.
+	OpDivide
+	// Store the remainder of dividing the integer part of register P1 by the integer part of register P2 in register P3.
+	// If either input is NULL or P2 is zero, the result is NULL.
+	OpRemainder
+	// Append the text of register P2 to the text of register P1 and store the result in register P3. If either input is NULL, the result is NULL.
+	OpConcat
+	// Take the bitwise AND of the integers in registers P1 and P2 and store the result in register P3. If either input is NULL, the result is NULL.
+	OpBitAnd
+	// Take the bitwise OR of the integers in registers P1 and P2 and store the result in register P3. If either input is NULL, the result is NULL.
+	OpBitOr
+	// Shift the integer in register P1 left by the number of bits in register P2 and store the result in register P3.
+	// A negative shift shifts right. If either input is NULL, the result is NULL.
+	OpShiftLeft
+	// Shift the integer in register P1 right by the number of bits in register P2 and store the result in register P3.
+	// A negative shift shifts left. If either input is NULL, the result is NULL.
+	OpShiftRight
+	// Store the ones-complement of the integer in register P1 in register P2. If P1 is NULL, the result is NULL.
+	OpBitNot
+	// Store 1 in register P3 if the text of register P1 matches the LIKE pattern in register P2, 0 otherwise.
+	// P4 is the register of the ESCAPE character, nil without one. If any input is NULL, the result is NULL.
+	OpLike
+	// Store 1 in register P3 if the text of register P1 matches the GLOB pattern in register P2, 0 otherwise.
+	// If either input is NULL, the result is NULL.
+	OpGlob
+	// Jump to address P2 if the value in register P1 is true, a nonzero number.
+	// A NULL value jumps when P3 is nonzero.
+	OpIf
+	// Jump to address P2 if the value in register P1 is false, zero.
+	// A NULL value jumps when P3 is nonzero.
+	OpIfNot
+	// Convert the value in register P1 to an integer if it can be done without loss,
+	// otherwise halt with a datatype mismatch.
+	OpMustBeInt
+	// Apply affinities to the P2 registers starting with P1, P4 is a string with the
+	// letter of the affinity of each register, see storage.Affinity.
+	OpAffinity
+	// Set the value of register P1 to the maximum of its current value and the value in register P2.
+	OpMemMax
+	// Jump to address P2 if the value in register P1 is NULL.
+	OpIsNull
+	// Jump to address P2 if the value in register P1 is not NULL.
+	OpNotNull
+	// Jump to address P2.
+	OpGoto
 	// Compare the values in register P1 and P3.
 	// If reg(P3)==reg(P1) then jump to address P2.
+	//
+	// When P4 is a storage.Affinity the values are compared as if it's applied to them.
+	// Text is compared with the Collation in the OpFlagCollation bits of P5.
+	// A comparison with a NULL is neither true nor false, then the comparison ops jump
+	// only when P5 has OpFlagJumpIfNull. With OpFlagNullEq, NULLs are equal to each
+	// other and not equal to any other value like IS and IS NOT.
 	OpEq
 	// Compare the values in register P1 and P3.
 	// If reg(P3)!=reg(P1) then jump to address P2.
 	OpNe
+	// If reg(P1)<reg(P3) then jump to address P2.
 	OpLt
+	// If reg(P1)<=reg(P3) then jump to address P2.
 	OpLe
+	// If reg(P1)>reg(P3) then jump to address P2.
 	OpGt
+	// If reg(P1)>=reg(P3) then jump to address P2.
 	OpGe
+	// Compare the entry at the index cursor with the key in registers,
+	// only as many fields as the key has are compared.
+	// If entry > key then jump to address P2.
+	// 	P1 - cursor
+	// 	P2 - jump address
+	// 	P3 - first key register
+	// 	P4 - count of key registers
 	OpIdxGt
+	// If entry >= key then jump to address P2.
 	OpIdxGe
+	// If entry < key then jump to address P2.
 	OpIdxLt
+	// If entry <= key then jump to address P2.
 	OpIdxLe
+	// Write the rowid of the entry at the index cursor to a register.
+	// 	P1 - cursor
+	// 	P2 - register for the rowid
 	OpIdxPKey
+	// 	P1 - index cursor
+	// 	P2 - register containing the entry
 	OpIdxInsert
+	// Remove the entry made of the values in registers from the index.
+	// 	P1 - index cursor
+	// 	P2 - first register of the entry
+	// 	P3 - count of registers
+	OpIdxDelete
+	// Jump to address P2 if no entry of the index starts with the key in registers
+	// or if any value of the key is NULL.
+	// 	P1 - index cursor
+	// 	P2 - jump address
+	// 	P3 - first key register
+	// 	P4 - count of key registers
+	OpNoConflict
+	// Open a sorter which orders rows by their first P2 values.
+	// 	P1 - sorter cursor
+	// 	P2 - count of key values
+	// 	P4 - []SortOrder, the order of each key value
+	OpSorterOpen
+	// Add a row made of the values in registers to the sorter.
+	// 	P1 - sorter cursor
+	// 	P2 - first register of the row
+	// 	P3 - count of registers
+	OpSorterInsert
+	// Sort the rows of the sorter and move to the first row.
+	// 	P1 - sorter cursor
+	// 	P2 - jump address (if the sorter is empty)
+	OpSorterSort
+	// Move to the next row of the sorter and go to address if more, otherwise, fallthrough.
+	// 	P1 - sorter cursor
+	// 	P2 - jump address
+	OpSorterNext
+	// Copy the values of the current row of the sorter to registers.
+	// 	P1 - sorter cursor
+	// 	P2 - first register
+	// 	P3 - count of registers
+	OpSorterData
 	// Create a new B-Tree
 	// 	P1 - register for root page
 	OpCreateTable
+	// Create a new index B-Tree
+	// 	P1 - register for root page
 	OpCreateIndex
+	// Free every page of the B-Tree rooted at page P1
+	// 	P1 - root page
+	OpDestroy
+	// Rebuild the database with densely packed pages, or write a copy of it to a new file.
+	// The database is rebuilt with the page size set by OpSetPageSize, if any.
+	// 	P4 - path of the copy, empty to rebuild the database in place
+	OpVacuum
+	// Store the page size of the database in register P1.
+	OpPageSize
+	// Set the page size of the database rebuilt by OpVacuum to P1.
+	// Ignored unless the page size is a power of two from 1024 to 32768.
+	OpSetPageSize
+	// Store the cache size of the pager in register P1.
+	OpCacheSize
+	// Set the cache size of the pager to P1 pages, or -P1 KiB when P1 is negative.
+	OpSetCacheSize
+	// Copy the committed pages in the log to the database file.
+	// 	P1 - checkpoint mode
+	// 	P2 - first of three registers for the busy flag, the frames in the log and the frames checkpointed
+	OpCheckpoint
 	OpCopy
 	OpSCopy
+	// Stop the program.
+	// 	P1 - error code, 0 for success
+	// 	P4 - error message
 	OpHalt
 )
 
+// OpFlagP2IsReg is set in P5 of OpOpenRead and OpOpenWrite when P2 is a register
+const OpFlagP2IsReg = 1
+
+// Flags in P5 of the comparison ops
+const (
+	OpFlagCollation  = 0x0f // mask of the Collation of text values
+	OpFlagJumpIfNull = 0x10 // jump when either value is NULL
+	OpFlagNullEq     = 0x80 // NULLs are equal to each other, for IS and IS NOT
+)
+
 type Instruction struct {
 	Op Op
 	P1 int
 	P2 int
 	P3 int
 	P4 interface{}
+	P5 int
 
 	Comment string
 }
@@ -128,46 +334,170 @@ type register struct {
 	data interface{}
 }
 
-// nextKey is a temporary mechanism to generate an identifier for new records
-func nextKey(tableName string) uint32 {
-	if _, ok := keys[tableName]; !ok {
-		keys[tableName] = 0
+// setInt64 stores an integer as RegInt32 when it fits in 32 bits, as RegInt64 otherwise.
+func (r *register) setInt64(v int64) {
+	if v >= math.MinInt32 && v <= math.MaxInt32 {
+		r.typ = RegInt32
+		r.data = int(v)
+		return
 	}
-	keys[tableName] = keys[tableName] + 1
-	return keys[tableName]
+	r.typ = RegInt64
+	r.data = v
 }
 
-func less(a *register, b *register) bool {
-	if a.typ != b.typ {
-		return false
+func (r *register) setFloat64(v float64) {
+	r.typ = RegFloat64
+	r.data = v
+}
+
+func (r *register) setNull() {
+	r.typ = RegNull
+	r.data = nil
+}
+
+// setNumber stores an int or a float64 as returned by parseNumber.
+func (r *register) setNumber(v interface{}) {
+	switch n := v.(type) {
+	case int:
+		r.setInt64(int64(n))
+	case float64:
+		r.setFloat64(n)
+	}
+}
+
+// isNumeric determines if the register holds an integer or a floating point number.
+func (r *register) isNumeric() bool {
+	return r.typ == RegInt32 || r.typ == RegInt64 || r.typ == RegFloat64
+}
+
+// int64 is the integer in the register, ok is false unless the register holds an integer.
+func (r *register) int64() (v int64, ok bool) {
+	switch r.typ {
+	case RegInt32:
+		return int64(r.data.(int)), true
+	case RegInt64:
+		return r.data.(int64), true
+	}
+	return 0, false
+}
+
+// float64 is the number in the register as a floating point number.
+func (r *register) float64() float64 {
+	if r.typ == RegFloat64 {
+		return r.data.(float64)
+	}
+	v, _ := r.int64()
+	return float64(v)
+}
+
+// compareNumeric compares the numbers in two registers, integers are compared exactly
+// and compare with reals by value like SQLite. It returns -1, 0 or 1.
+func compareNumeric(a *register, b *register) int {
+	x, aInt := a.int64()
+	y, bInt := b.int64()
+	switch {
+	case aInt && bInt:
+		return compareInt64(x, y)
+	case aInt:
+		return -compareIntFloat(b.float64(), x)
+	case bInt:
+		return compareIntFloat(a.float64(), y)
+	}
+
+	f, g := a.float64(), b.float64()
+	switch {
+	case f < g:
+		return -1
+	case f > g:
+		return 1
+	}
+	return 0
+}
+
+// compareIntFloat compares the real f with the integer i without losing the precision
+// of large integers.
+func compareIntFloat(f float64, i int64) int {
+	switch {
+	case math.IsNaN(f):
+		return -1
+	case f < -9223372036854775808.0:
+		return -1
+	case f >= 9223372036854775808.0:
+		return 1
+	}
+
+	// Compare the integral part exactly, then the fraction
+	if c := compareInt64(int64(f), i); c != 0 {
+		return c
+	}
+	switch fraction := f - math.Trunc(f); {
+	case fraction > 0:
+		return 1
+	case fraction < 0:
+		return -1
+	}
+	return 0
+}
+
+func compareInt64(x int64, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// storageClass orders the types of values when comparing values of different types,
+// NULL is less than a number which is less than text which is less than a blob.
+func storageClass(r *register) int {
+	switch r.typ {
+	case RegNull, RegUnspecified:
+		return 0
+	case RegInt32, RegInt64, RegFloat64:
+		return 1
+	case RegString:
+		return 2
+	}
+	return 3
+}
+
+// compare compares the values in two registers like SQLite sorts them, NULLs are
+// equal to each other and less than any other value. It returns -1, 0 or 1.
+func compare(a *register, b *register) int {
+	return compareCollated(a, b, CollationBinary)
+}
+
+// compareCollated is compare with text compared by the collating sequence.
+func compareCollated(a *register, b *register, collation Collation) int {
+	if ca, cb := storageClass(a), storageClass(b); ca != cb {
+		return compareInt64(int64(ca), int64(cb))
 	}
 
 	switch a.typ {
+	case RegInt32, RegInt64, RegFloat64:
+		return compareNumeric(a, b)
 	case RegString:
-		return a.data.(string) < b.data.(string)
-	case RegInt32:
-		return a.data.(int) < b.data.(int)
-	case RegNull:
-		return false
+		return collation.compare(a.data.(string), b.data.(string))
 	case RegBinary:
-		// Two binary blobs are equal if and only if they have the same length
-		// and contain the exact same bytes. If two binary blobs have different
-		// lengths, order is determined by the common bytes between the two blobs.
-		// If the common bytes are equal, then the blob with the fewer bytes
-		// is considered to be less than the blob with more bytes.
-		// TODO: implement above
-		return len(a.data.([]byte)) < len(b.data.([]byte))
+		// Blobs are compared byte by byte, a blob which is a prefix of the other is less
+		return bytes.Compare(a.data.([]byte), b.data.([]byte))
 	}
 
-	return false
+	return 0
+}
+
+func less(a *register, b *register) bool {
+	return compare(a, b) < 0
 }
 
 func eq(a *register, b *register) bool {
-	return !less(a, b) && !less(b, a)
+	return compare(a, b) == 0
 }
 
 func (i Instruction) String() string {
-	return fmt.Sprintf("%-30v | %-4d | %-4d | %-4d | %-4v | %s", i.Op, i.P1, i.P2, i.P3, i.P4, i.Comment)
+	return fmt.Sprintf("%-30v | %-4d | %-4d | %-4d | %-4v | %-2d | %s", i.Op, i.P1, i.P2, i.P3, i.P4, i.P5, i.Comment)
 }
 
 func (o Op) String() string {
@@ -177,33 +507,39 @@ func (o Op) String() string {
 	case OpInit:
 		return "OpInit"
 	case OpOpenRead:
-		return "OpOpenRead(cur, pg, cols, tbl)"
+		return "OpOpenRead(cur, pg, cols, tbl, flags)"
 	case OpOpenWrite:
-		return "OpOpenWrite(cur, pg, cols, tbl)"
+		return "OpOpenWrite(cur, pg, cols, tbl, flags)"
 	case OpClose:
 		return "OpClose"
 	case OpRewind:
 		return "OpRewind(cur, jmp)"
+	case OpLast:
+		return "OpLast(cur, jmp)"
 	case OpNext:
 		return "OpNext(cur, jmp)"
 	case OpPrev:
-		return "OpPrev"
+		return "OpPrev(cur, jmp)"
 	case OpSeek:
-		return "OpSeek"
+		return "OpSeek(cur, jmp, reg, n)"
 	case OpSeekGt:
-		return "OpSeekGt"
+		return "OpSeekGt(cur, jmp, reg, n)"
 	case OpSeekGe:
-		return "OpSeekGe"
+		return "OpSeekGe(cur, jmp, reg, n)"
 	case OpSeekLt:
-		return "OpSeekLt"
+		return "OpSeekLt(cur, jmp, reg, n)"
 	case OpSeekLe:
-		return "OpSeekLe"
+		return "OpSeekLe(cur, jmp, reg, n)"
 	case OpColumn:
 		return "OpColumn(cur, col, reg)"
 	case OpKey:
 		return "OpKey"
 	case OpInteger:
 		return "OpInteger(int, reg)"
+	case OpInt64:
+		return "OpInt64(_, reg, _, int64)"
+	case OpReal:
+		return "OpReal(_, reg, _, float64)"
 	case OpString:
 		return "OpString"
 	case OpNull:
@@ -214,8 +550,62 @@ func (o Op) String() string {
 		return "OpMakeRecord(startreg, cols, reg)"
 	case OpRowID:
 		return "OpRowID(cur, reg)"
+	case OpNewRowID:
+		return "OpNewRowID(cur, reg, _, seqreg)"
+	case OpNotExists:
+		return "OpNotExists(cur, jmp, reg)"
+	case OpAffinity:
+		return "OpAffinity(startreg, n, _, affinities)"
+	case OpMemMax:
+		return "OpMemMax(reg, reg)"
+	case OpAnd:
+		return "OpAnd(reg, reg, reg)"
+	case OpOr:
+		return "OpOr(reg, reg, reg)"
+	case OpNot:
+		return "OpNot(reg, reg)"
+	case OpAdd:
+		return "OpAdd(reg, reg, reg)"
+	case OpSubtract:
+		return "OpSubtract(reg, reg, reg)"
+	case OpMultiply:
+		return "OpMultiply(reg, reg, reg)"
+	case OpDivide:
+		return "OpDivide(reg, reg, reg)"
+	case OpRemainder:
+		return "OpRemainder(reg, reg, reg)"
+	case OpConcat:
+		return "OpConcat(reg, reg, reg)"
+	case OpBitAnd:
+		return "OpBitAnd(reg, reg, reg)"
+	case OpBitOr:
+		return "OpBitOr(reg, reg, reg)"
+	case OpShiftLeft:
+		return "OpShiftLeft(reg, reg, reg)"
+	case OpShiftRight:
+		return "OpShiftRight(reg, reg, reg)"
+	case OpBitNot:
+		return "OpBitNot(reg, reg)"
+	case OpLike:
+		return "OpLike(reg, reg, reg, escreg)"
+	case OpGlob:
+		return "OpGlob(reg, reg, reg)"
+	case OpIf:
+		return "OpIf(reg, jmp, null)"
+	case OpIfNot:
+		return "OpIfNot(reg, jmp, null)"
+	case OpMustBeInt:
+		return "OpMustBeInt(reg)"
+	case OpIsNull:
+		return "OpIsNull(reg, jmp)"
+	case OpNotNull:
+		return "OpNotNull(reg, jmp)"
+	case OpGoto:
+		return "OpGoto(_, jmp)"
 	case OpInsert:
 		return "OpInsert(cur, reg, regkey)"
+	case OpDelete:
+		return "OpDelete(cur)"
 	case OpEq:
 		return "OpEq"
 	case OpNe:
@@ -229,27 +619,55 @@ func (o Op) String() string {
 	case OpGe:
 		return "OpGe"
 	case OpIdxGt:
-		return "OpIdxGt"
+		return "OpIdxGt(cur, jmp, reg, n)"
 	case OpIdxGe:
-		return "OpIdxGe"
+		return "OpIdxGe(cur, jmp, reg, n)"
 	case OpIdxLt:
-		return "OpIdxLt"
+		return "OpIdxLt(cur, jmp, reg, n)"
 	case OpIdxLe:
-		return "OpIdxLe"
+		return "OpIdxLe(cur, jmp, reg, n)"
 	case OpIdxPKey:
-		return "OpIdxPKey"
+		return "OpIdxPKey(cur, reg)"
 	case OpIdxInsert:
-		return "OpIdxInsert"
+		return "OpIdxInsert(cur, reg)"
+	case OpIdxDelete:
+		return "OpIdxDelete(cur, reg, n)"
+	case OpNoConflict:
+		return "OpNoConflict(cur, jmp, reg, n)"
+	case OpSorterOpen:
+		return "OpSorterOpen(sorter, keys, _, order)"
+	case OpSorterInsert:
+		return "OpSorterInsert(sorter, reg, n)"
+	case OpSorterSort:
+		return "OpSorterSort(sorter, jmp)"
+	case OpSorterNext:
+		return "OpSorterNext(sorter, jmp)"
+	case OpSorterData:
+		return "OpSorterData(sorter, reg, n)"
 	case OpCreateTable:
 		return "OpCreateTable(reg)"
 	case OpCreateIndex:
-		return "OpCreateIndex"
+		return "OpCreateIndex(reg)"
+	case OpDestroy:
+		return "OpDestroy(pg)"
+	case OpVacuum:
+		return "OpVacuum(path)"
+	case OpPageSize:
+		return "OpPageSize(reg)"
+	case OpSetPageSize:
+		return "OpSetPageSize(size)"
+	case OpCacheSize:
+		return "OpCacheSize(reg)"
+	case OpSetCacheSize:
+		return "OpSetCacheSize(size)"
+	case OpCheckpoint:
+		return "OpCheckpoint(mode, reg)"
 	case OpCopy:
 		return "OpCopy"
 	case OpSCopy:
 		return "OpSCopy"
 	case OpHalt:
-		return "OpHalt"
+		return "OpHalt(code, _, _, msg)"
 	}
 
 	return string(o)
@@ -0,0 +1,366 @@
+package virtualmachine
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+)
+
+func TestLess_Numeric(t *testing.T) {
+	r := require.New(t)
+
+	i32 := func(v int) *register { return &register{typ: RegInt32, data: v} }
+	i64 := func(v int64) *register { return &register{typ: RegInt64, data: v} }
+	f64 := func(v float64) *register { return &register{typ: RegFloat64, data: v} }
+
+	ordered := []*register{
+		f64(-1e300),
+		i64(math.MinInt64),
+		i32(-3),
+		f64(-2.5),
+		i32(0),
+		f64(0.5),
+		i32(2),
+		i64(math.MaxInt32 + 1),
+		f64(1 << 53),
+		i64(1<<53 + 1),
+		i64(math.MaxInt64),
+		f64(1e300),
+	}
+	for a := range ordered {
+		for b := range ordered {
+			r.Equal(a < b, less(ordered[a], ordered[b]), "%v < %v", ordered[a].data, ordered[b].data)
+			r.Equal(a == b, eq(ordered[a], ordered[b]), "%v = %v", ordered[a].data, ordered[b].data)
+		}
+	}
+
+	r.True(eq(i32(2500), f64(2500)))
+	r.True(eq(i64(math.MaxInt32+1), f64(math.MaxInt32+1)))
+}
+
+func TestCompare(t *testing.T) {
+	r := require.New(t)
+
+	// NULLs, then numbers, then text, then blobs
+	ordered := []*register{
+		{typ: RegNull},
+		{typ: RegInt32, data: -1},
+		{typ: RegFloat64, data: 1.5},
+		{typ: RegInt64, data: int64(math.MaxInt64)},
+		{typ: RegString, data: ""},
+		{typ: RegString, data: "1"},
+		{typ: RegString, data: "a"},
+		{typ: RegBinary, data: []byte{}},
+		{typ: RegBinary, data: []byte{0}},
+		{typ: RegBinary, data: []byte{0, 0}},
+		{typ: RegBinary, data: []byte{1}},
+	}
+	for a := range ordered {
+		for b := range ordered {
+			expected := 0
+			if a < b {
+				expected = -1
+			} else if a > b {
+				expected = 1
+			}
+			r.Equal(expected, compare(ordered[a], ordered[b]), "%v <=> %v", ordered[a].data, ordered[b].data)
+		}
+	}
+}
+
+func TestCompare_Null(t *testing.T) {
+	r := require.New(t)
+
+	p := &Program{}
+	p.reg(0).setNull()
+	p.reg(1).setInt64(1)
+
+	tests := []struct {
+		ixn      Instruction
+		expected bool
+	}{
+		{Instruction{Op: OpEq, P1: 0, P3: 0}, false},
+		{Instruction{Op: OpNe, P1: 0, P3: 1}, false},
+		{Instruction{Op: OpLt, P1: 0, P3: 1}, false},
+		{Instruction{Op: OpGe, P1: 1, P3: 0, P5: OpFlagJumpIfNull}, true},
+		{Instruction{Op: OpEq, P1: 0, P3: 0, P5: OpFlagNullEq}, true},
+		{Instruction{Op: OpEq, P1: 0, P3: 1, P5: OpFlagNullEq}, false},
+		{Instruction{Op: OpNe, P1: 1, P3: 0, P5: OpFlagNullEq}, true},
+		{Instruction{Op: OpLe, P1: 1, P3: 1}, true},
+	}
+	for _, test := range tests {
+		r.Equal(test.expected, p.compare(&test.ixn), "%v", test.ixn)
+	}
+}
+
+func TestCompare_Collation(t *testing.T) {
+	r := require.New(t)
+
+	p := &Program{}
+	p.reg(0).data, p.reg(0).typ = "abc ", RegString
+	p.reg(1).data, p.reg(1).typ = "ABC", RegString
+
+	tests := []struct {
+		ixn      Instruction
+		expected bool
+	}{
+		{Instruction{Op: OpEq, P1: 0, P3: 1}, false},
+		{Instruction{Op: OpGt, P1: 0, P3: 1}, true},
+		{Instruction{Op: OpEq, P1: 0, P3: 1, P5: int(CollationNoCase)}, false},
+		{Instruction{Op: OpGt, P1: 0, P3: 1, P5: int(CollationNoCase)}, true},
+		{Instruction{Op: OpGt, P1: 0, P3: 1, P5: int(CollationRTrim)}, true},
+		{Instruction{Op: OpNe, P1: 1, P3: 1, P5: int(CollationRTrim) | OpFlagJumpIfNull}, false},
+	}
+	for _, test := range tests {
+		r.Equal(test.expected, p.compare(&test.ixn), "%v", test.ixn)
+	}
+
+	r.Equal(0, CollationNoCase.compare("Straße", "STRAßE"))
+	r.Equal(1, CollationNoCase.compare("é", "É"))
+	r.Equal(0, CollationRTrim.compare("a  ", "a"))
+	r.Equal(-1, CollationRTrim.compare(" a", "a"))
+}
+
+func TestLikeMatch(t *testing.T) {
+	r := require.New(t)
+
+	tests := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"a%", "Apple", true},
+		{"%", "", true},
+		{"_", "", false},
+		{"_b_", "äbc", true},
+		{"%an%a", "banana", true},
+		{"%an", "banana", false},
+		{"É", "é", false},
+		{"a!%", "a%", true},
+		{"a!%", "ab", false},
+		{"a!_%", "a_bc", true},
+		{"a!", "a", false},
+		{"!!", "!", true},
+	}
+	for _, test := range tests {
+		r.Equal(test.expected, likeMatch([]rune(test.pattern), []rune(test.s), '!', true), "%q LIKE %q", test.s, test.pattern)
+	}
+	r.True(likeMatch([]rune("a!"), []rune("a!"), 0, false))
+}
+
+func TestGlobMatch(t *testing.T) {
+	r := require.New(t)
+
+	tests := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"a*", "apple", true},
+		{"a*", "Apple", false},
+		{"*", "", true},
+		{"?b?", "äbc", true},
+		{"a[a-c]?", "abc", true},
+		{"a[^a-c]?", "abc", false},
+		{"[]]", "]", true},
+		{"[^]]", "A", true},
+		{"[a-]", "-", true},
+		{"[b-a]", "a", false},
+		{"[", "[", false},
+		{"ab[c", "abc", false},
+		{"*[0-9]", "x9", true},
+	}
+	for _, test := range tests {
+		r.Equal(test.expected, globMatch([]rune(test.pattern), []rune(test.s)), "%q GLOB %q", test.s, test.pattern)
+	}
+}
+
+func TestSetInt64Reg(t *testing.T) {
+	r := require.New(t)
+
+	p := &Program{}
+	p.setInt64Reg(0, math.MaxInt32)
+	r.Equal(&register{typ: RegInt32, data: math.MaxInt32}, p.reg(0))
+
+	p.setInt64Reg(0, math.MinInt32-1)
+	r.Equal(&register{typ: RegInt64, data: int64(math.MinInt32 - 1)}, p.reg(0))
+}
+
+func TestParseNumber(t *testing.T) {
+	r := require.New(t)
+
+	numbers := map[string]interface{}{
+		"42":                  42,
+		"9223372036854775807": math.MaxInt64,
+		"9223372036854775808": 9223372036854775808.0,
+		"1.5":                 1.5,
+		".25":                 0.25,
+		"2.5e3":               2500.0,
+		"1E-3":                0.001,
+		"1e999":               math.Inf(1),
+	}
+	for s, expected := range numbers {
+		value, err := parseNumber(s)
+		r.NoError(err)
+		r.Equal(expected, value, s)
+	}
+
+	_, err := parseNumber("1e")
+	r.Error(err)
+}
+
+func TestArithmetic(t *testing.T) {
+	r := require.New(t)
+
+	i32 := func(v int) *register { return &register{typ: RegInt32, data: v} }
+	i64 := func(v int64) *register { return &register{typ: RegInt64, data: v} }
+	f64 := func(v float64) *register { return &register{typ: RegFloat64, data: v} }
+	str := func(v string) *register { return &register{typ: RegString, data: v} }
+	null := &register{typ: RegNull}
+
+	tests := []struct {
+		op       Op
+		a, b     *register
+		expected *register
+	}{
+		{OpAdd, i32(2), i32(3), i32(5)},
+		{OpAdd, i32(math.MaxInt32), i32(1), i64(math.MaxInt32 + 1)},
+		{OpAdd, i64(math.MaxInt64), i32(1), f64(math.MaxInt64 + 1.0)},
+		{OpAdd, i32(1), f64(0.5), f64(1.5)},
+		{OpAdd, str("12abc"), str(" 1.5"), f64(13.5)},
+		{OpAdd, str("abc"), i32(1), i32(1)},
+		{OpAdd, null, i32(1), null},
+		{OpSubtract, i32(2), i32(3), i32(-1)},
+		{OpSubtract, i64(math.MinInt64), i32(1), f64(math.MinInt64 - 1.0)},
+		{OpMultiply, i32(6), i32(7), i32(42)},
+		{OpMultiply, i64(math.MaxInt64), i32(2), f64(math.MaxInt64 * 2.0)},
+		{OpDivide, i32(7), i32(2), i32(3)},
+		{OpDivide, i32(-7), i32(2), i32(-3)},
+		{OpDivide, f64(7), i32(2), f64(3.5)},
+		{OpDivide, i32(7), i32(0), null},
+		{OpDivide, f64(7), f64(0), null},
+		{OpDivide, i64(math.MinInt64), i32(-1), f64(-(math.MinInt64 * 1.0))},
+		{OpRemainder, i32(7), i32(3), i32(1)},
+		{OpRemainder, i32(-7), i32(3), i32(-1)},
+		{OpRemainder, f64(7.5), i32(2), f64(1)},
+		{OpRemainder, i32(7), i32(0), null},
+		{OpRemainder, i64(math.MinInt64), i32(-1), i32(0)},
+	}
+	for _, test := range tests {
+		dest := &register{}
+		arithmetic(test.op, test.a, test.b, dest)
+		r.Equal(test.expected, dest, "%v %v %v", test.a.data, test.op, test.b.data)
+	}
+}
+
+func TestTextAndTruth(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal("42", text(&register{typ: RegInt32, data: 42}))
+	r.Equal("1.5", text(&register{typ: RegFloat64, data: 1.5}))
+	r.Equal("2.0", text(&register{typ: RegFloat64, data: 2.0}))
+	r.Equal("1.0e+20", text(&register{typ: RegFloat64, data: 1e20}))
+
+	value, isNull := truth(&register{typ: RegString, data: "0.5"})
+	r.True(value)
+	r.False(isNull)
+	value, _ = truth(&register{typ: RegString, data: "abc"})
+	r.False(value)
+	_, isNull = truth(&register{typ: RegNull})
+	r.True(isNull)
+}
+
+func TestMustBeInt(t *testing.T) {
+	r := require.New(t)
+
+	reg := &register{typ: RegFloat64, data: 3.0}
+	r.True(mustBeInt(reg))
+	r.Equal(&register{typ: RegInt32, data: 3}, reg)
+
+	reg = &register{typ: RegString, data: " 5000000000 "}
+	r.True(mustBeInt(reg))
+	r.Equal(&register{typ: RegInt64, data: int64(5000000000)}, reg)
+
+	r.False(mustBeInt(&register{typ: RegFloat64, data: 3.5}))
+	r.False(mustBeInt(&register{typ: RegString, data: "5x"}))
+	r.False(mustBeInt(&register{typ: RegNull}))
+}
+
+func TestBitwise(t *testing.T) {
+	r := require.New(t)
+
+	i32 := func(v int) *register { return &register{typ: RegInt32, data: v} }
+	i64 := func(v int64) *register { return &register{typ: RegInt64, data: v} }
+	f64 := func(v float64) *register { return &register{typ: RegFloat64, data: v} }
+	null := &register{typ: RegNull}
+
+	tests := []struct {
+		op       Op
+		a, b     *register
+		expected *register
+	}{
+		{OpBitAnd, i32(6), i32(3), i32(2)},
+		{OpBitOr, i32(6), i32(3), i32(7)},
+		{OpBitOr, f64(4.9), &register{typ: RegString, data: "1"}, i32(5)},
+		{OpBitAnd, null, i32(1), null},
+		{OpShiftLeft, i32(1), i32(40), i64(1 << 40)},
+		{OpShiftLeft, i32(1), i32(64), i32(0)},
+		{OpShiftLeft, i32(8), i32(-2), i32(2)},
+		{OpShiftRight, i32(-8), i32(1), i32(-4)},
+		{OpShiftRight, i32(-8), i32(64), i32(-1)},
+		{OpShiftRight, i32(1), i32(-3), i32(8)},
+	}
+	for _, test := range tests {
+		dest := &register{}
+		bitwise(test.op, test.a, test.b, dest)
+		r.Equal(test.expected, dest, "%v %v %v", test.a.data, test.op, test.b.data)
+	}
+}
+
+func TestApplyAffinity(t *testing.T) {
+	r := require.New(t)
+
+	i32 := func(v int) *register { return &register{typ: RegInt32, data: v} }
+	f64 := func(v float64) *register { return &register{typ: RegFloat64, data: v} }
+	str := func(v string) *register { return &register{typ: RegString, data: v} }
+	blob := &register{typ: RegBinary, data: []byte("5")}
+	null := &register{typ: RegNull}
+
+	tests := []struct {
+		affinity storage.Affinity
+		value    *register
+		expected *register
+	}{
+		{storage.AffinityInteger, str("5"), i32(5)},
+		{storage.AffinityInteger, str(" -12 "), i32(-12)},
+		{storage.AffinityInteger, str("2.5"), f64(2.5)},
+		{storage.AffinityInteger, str("3.0e2"), i32(300)},
+		{storage.AffinityInteger, str("5x"), str("5x")},
+		{storage.AffinityInteger, f64(4), i32(4)},
+		{storage.AffinityInteger, blob, blob},
+		{storage.AffinityInteger, null, null},
+		{storage.AffinityNumeric, str("9223372036854775808"), f64(9223372036854775808)},
+		{storage.AffinityReal, str("5"), f64(5)},
+		{storage.AffinityReal, i32(7), f64(7)},
+		{storage.AffinityText, i32(7), str("7")},
+		{storage.AffinityText, f64(2), str("2.0")},
+		{storage.AffinityText, null, null},
+		{storage.AffinityBlob, str("5"), str("5")},
+		{storage.AffinityBlob, i32(5), i32(5)},
+	}
+	for _, test := range tests {
+		r.Equal(test.expected, withAffinity(test.value, test.affinity), "%v %v", test.affinity, test.value.data)
+	}
+}
+
+func TestComparisonAffinity(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal(storage.AffinityNumeric, comparisonAffinity(storage.AffinityInteger, storage.AffinityText))
+	r.Equal(storage.AffinityNumeric, comparisonAffinity(0, storage.AffinityReal))
+	r.Equal(storage.AffinityText, comparisonAffinity(storage.AffinityText, 0))
+	r.Equal(storage.AffinityBlob, comparisonAffinity(storage.AffinityText, storage.AffinityText))
+	r.Equal(storage.AffinityBlob, comparisonAffinity(storage.AffinityBlob, 0))
+	r.Equal(storage.Affinity(0), comparisonAffinity(0, 0))
+}
@@ -1,7 +1,9 @@
 package virtualmachine
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/joeandaverde/tinydb/internal/metadata"
 	"github.com/joeandaverde/tinydb/internal/pager"
@@ -23,12 +25,91 @@ func Prepare(stmt ast.Statement, pager pager.Pager) (*PreparedStatement, error)
 
 	switch s := stmt.(type) {
 	case *ast.CreateTableStatement:
+		autoIncrement := false
+		for _, c := range s.Columns {
+			if c.AutoIncrement && !metadata.IsRowIDAlias(c) {
+				return nil, errors.New("AUTOINCREMENT is only allowed on an INTEGER PRIMARY KEY")
+			}
+			autoIncrement = autoIncrement || c.AutoIncrement
+		}
+
+		// The sequence table is created along with the first AUTOINCREMENT table
+		createSequence := false
+		if autoIncrement {
+			_, err := metadata.GetTableDefinition(pager, metadata.SequenceTable)
+			if err != nil && !errors.Is(err, metadata.ErrTableNotFound) {
+				return nil, err
+			}
+			createSequence = err != nil
+		}
+
 		preparedStatement.Tag = "CREATE"
-		preparedStatement.Instructions = CreateTableInstructions(s)
+		preparedStatement.Instructions = CreateTableInstructions(s, createSequence)
+	case *ast.CreateIndexStatement:
+		preparedStatement.Tag = "CREATE"
+
+		_, err := metadata.GetIndexDefinition(pager, s.IndexName)
+		if err == nil {
+			if s.IfNotExists {
+				p := initProgram()
+				p.OpHalt()
+				preparedStatement.Instructions = p.instructions
+				break
+			}
+			return nil, fmt.Errorf("index %s already exists", s.IndexName)
+		}
+		if !errors.Is(err, metadata.ErrIndexNotFound) {
+			return nil, err
+		}
+
+		if _, err := metadata.GetTableDefinition(pager, s.IndexName); err == nil {
+			return nil, fmt.Errorf("there is already a table named %s", s.IndexName)
+		}
+
+		table, err := metadata.GetTableDefinition(pager, s.TableName)
+		if err != nil {
+			return nil, err
+		}
+
+		instructions, err := CreateIndexInstructions(table, s)
+		if err != nil {
+			return nil, err
+		}
+		preparedStatement.Instructions = instructions
+	case *ast.DropTableStatement:
+		preparedStatement.Tag = "DROP"
+
+		if s.TableName == metadata.SequenceTable || metadata.IsSchemaTable(s.TableName) {
+			return nil, fmt.Errorf("table %s may not be dropped", s.TableName)
+		}
+
+		table, err := metadata.GetTableDefinition(pager, s.TableName)
+		if errors.Is(err, metadata.ErrTableNotFound) && s.IfExists {
+			p := initProgram()
+			p.OpHalt()
+			preparedStatement.Instructions = p.instructions
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		sequenceTable, err := metadata.GetTableDefinition(pager, metadata.SequenceTable)
+		if errors.Is(err, metadata.ErrTableNotFound) {
+			sequenceTable = nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		preparedStatement.Instructions = DropTableInstructions(table, sequenceTable)
 	case *ast.InsertStatement:
+		instructions, err := InsertInstructions(pager, s)
+		if err != nil {
+			return nil, err
+		}
 		preparedStatement.Tag = "INSERT"
 		preparedStatement.Columns = s.Returning
-		preparedStatement.Instructions = InsertInstructions(pager, s)
+		preparedStatement.Instructions = instructions
 	case *ast.SelectStatement:
 		preparedStatement.Tag = "SELECT"
 		table, err := metadata.GetTableDefinition(pager, s.From[0].Name)
@@ -38,8 +119,72 @@ func Prepare(stmt ast.Statement, pager pager.Pager) (*PreparedStatement, error)
 		tableLookup := make(map[string]*metadata.TableDefinition)
 		tableLookup[table.Name] = table
 
-		preparedStatement.Columns = s.Columns
-		preparedStatement.Instructions = SelectInstructions(tableLookup, s)
+		// The columns are named by their alias or their expression, * is every column of the table
+		for _, c := range s.Columns {
+			if !c.Star() {
+				preparedStatement.Columns = append(preparedStatement.Columns, c.Name())
+				continue
+			}
+			for _, column := range table.Columns {
+				preparedStatement.Columns = append(preparedStatement.Columns, column.Name)
+			}
+		}
+
+		instructions, err := SelectInstructions(tableLookup, s)
+		if err != nil {
+			return nil, err
+		}
+		preparedStatement.Instructions = instructions
+	case *ast.UpdateStatement:
+		if metadata.IsSchemaTable(s.Table) {
+			return nil, fmt.Errorf("table %s may not be modified", s.Table)
+		}
+		table, err := metadata.GetTableDefinition(pager, s.Table)
+		if err != nil {
+			return nil, err
+		}
+		tableLookup := make(map[string]*metadata.TableDefinition)
+		tableLookup[table.Name] = table
+
+		instructions, err := UpdateInstructions(tableLookup, s)
+		if err != nil {
+			return nil, err
+		}
+		preparedStatement.Tag = "UPDATE"
+		preparedStatement.Instructions = instructions
+	case *ast.DeleteStatement:
+		preparedStatement.Tag = "DELETE"
+		if metadata.IsSchemaTable(s.Table) {
+			return nil, fmt.Errorf("table %s may not be modified", s.Table)
+		}
+		table, err := metadata.GetTableDefinition(pager, s.Table)
+		if err != nil {
+			return nil, err
+		}
+		tableLookup := make(map[string]*metadata.TableDefinition)
+		tableLookup[table.Name] = table
+
+		instructions, err := DeleteInstructions(tableLookup, s)
+		if err != nil {
+			return nil, err
+		}
+		preparedStatement.Instructions = instructions
+	case *ast.VacuumStatement:
+		preparedStatement.Tag = "VACUUM"
+		preparedStatement.Instructions = VacuumInstructions(s)
+	case *ast.PragmaStatement:
+		instructions, err := PragmaInstructions(s)
+		if err != nil {
+			return nil, err
+		}
+		preparedStatement.Tag = "PRAGMA"
+		switch {
+		case strings.EqualFold(s.Name, "wal_checkpoint"):
+			preparedStatement.Columns = []string{"busy", "log", "checkpointed"}
+		case s.Value == "":
+			preparedStatement.Columns = []string{strings.ToLower(s.Name)}
+		}
+		preparedStatement.Instructions = instructions
 	case *ast.BeginStatement:
 		preparedStatement.Tag = "BEGIN"
 		preparedStatement.Instructions = BeginInstructions(s)
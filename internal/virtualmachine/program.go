@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
 
 	"github.com/joeandaverde/tinydb/internal/pager"
 	"github.com/joeandaverde/tinydb/internal/storage"
@@ -12,6 +13,11 @@ import (
 type Flags struct {
 	AutoCommit bool
 	Rollback   bool
+	// PageSize is the page size of the database rebuilt by VACUUM, 0 keeps the current page size
+	PageSize int
+	// SortMemory is the size in bytes of the rows a sorter holds in memory before it
+	// spills them to temporary files, 0 uses DefaultSortMemory
+	SortMemory int
 }
 
 type Output struct {
@@ -23,6 +29,7 @@ type Program struct {
 	instructions []*Instruction
 	regs         []*register
 	cursors      []*pager.Cursor
+	sorters      []*sorter
 	pc           int
 	halted       bool
 	out          chan Output
@@ -51,6 +58,8 @@ func NewProgram(pid int, stmt *PreparedStatement) *Program {
 
 func (p *Program) Run(ctx context.Context, flags Flags, pgr pager.Pager) (Flags, error) {
 	defer close(p.out)
+	defer p.closeSorters()
+	defer p.closeCursors()
 	for p.pc < len(p.instructions) {
 		nextPc := p.step(ctx, &flags, pgr)
 		if nextPc == -1 {
@@ -86,9 +95,122 @@ func (p *Program) step(ctx context.Context, flags *Flags, pgr pager.Pager) int {
 	switch i.Op {
 	case OpNoOp:
 	case OpHalt:
+		if i.P1 != 0 {
+			return p.error(i.P4.(string))
+		}
 		p.halted = true
+	case OpGoto:
+		return i.P2
+	case OpIsNull:
+		if p.reg(i.P1).typ == RegNull {
+			return i.P2
+		}
+	case OpNotNull:
+		if p.reg(i.P1).typ != RegNull {
+			return i.P2
+		}
+	case OpMemMax:
+		a := p.reg(i.P1)
+		b := p.reg(i.P2)
+		if !a.isNumeric() || less(a, b) {
+			a.typ = b.typ
+			a.data = b.data
+		}
+	case OpIf, OpIfNot:
+		value, isNull := truth(p.reg(i.P1))
+		if isNull {
+			if i.P3 != 0 {
+				return i.P2
+			}
+		} else if value == (i.Op == OpIf) {
+			return i.P2
+		}
+	case OpAnd, OpOr:
+		a, aNull := truth(p.reg(i.P1))
+		b, bNull := truth(p.reg(i.P2))
+		dest := p.reg(i.P3)
+		// A false operand decides AND and a true operand decides OR, even with a NULL
+		decisive := i.Op == OpOr
+		switch {
+		case (!aNull && a == decisive) || (!bNull && b == decisive):
+			dest.setInt64(boolInt(decisive))
+		case aNull || bNull:
+			dest.setNull()
+		default:
+			dest.setInt64(boolInt(!decisive))
+		}
+	case OpNot:
+		value, isNull := truth(p.reg(i.P1))
+		if isNull {
+			p.reg(i.P2).setNull()
+		} else {
+			p.reg(i.P2).setInt64(boolInt(!value))
+		}
+	case OpAdd, OpSubtract, OpMultiply, OpDivide, OpRemainder:
+		arithmetic(i.Op, p.reg(i.P1), p.reg(i.P2), p.reg(i.P3))
+	case OpConcat:
+		a, b := p.reg(i.P1), p.reg(i.P2)
+		dest := p.reg(i.P3)
+		if a.typ == RegNull || b.typ == RegNull {
+			dest.setNull()
+		} else {
+			dest.data = text(a) + text(b)
+			dest.typ = RegString
+		}
+	case OpBitAnd, OpBitOr, OpShiftLeft, OpShiftRight:
+		bitwise(i.Op, p.reg(i.P1), p.reg(i.P2), p.reg(i.P3))
+	case OpBitNot:
+		a := numeric(p.reg(i.P1))
+		if a.typ == RegNull {
+			p.reg(i.P2).setNull()
+		} else {
+			p.reg(i.P2).setInt64(^integer(a))
+		}
+	case OpLike:
+		var escape rune
+		escapeReg, escaped := i.P4.(int)
+		if escaped {
+			e := p.reg(escapeReg)
+			if e.typ == RegNull {
+				p.reg(i.P3).setNull()
+				break
+			}
+			runes := []rune(text(e))
+			if len(runes) != 1 {
+				return p.error("ESCAPE expression must be a single character")
+			}
+			escape = runes[0]
+		}
+
+		a, b := p.reg(i.P1), p.reg(i.P2)
+		if a.typ == RegNull || b.typ == RegNull {
+			p.reg(i.P3).setNull()
+		} else {
+			p.reg(i.P3).setInt64(boolInt(likeMatch([]rune(text(b)), []rune(text(a)), escape, escaped)))
+		}
+	case OpGlob:
+		a, b := p.reg(i.P1), p.reg(i.P2)
+		if a.typ == RegNull || b.typ == RegNull {
+			p.reg(i.P3).setNull()
+		} else {
+			p.reg(i.P3).setInt64(boolInt(globMatch([]rune(text(b)), []rune(text(a)))))
+		}
+	case OpAffinity:
+		for j, affinity := range []byte(i.P4.(string)) {
+			applyAffinity(p.reg(i.P1+j), storage.Affinity(affinity))
+		}
+	case OpMustBeInt:
+		if !mustBeInt(p.reg(i.P1)) {
+			return p.error("datatype mismatch")
+		}
 	case OpInteger:
 		p.setIntReg(i.P2, i.P1)
+	case OpInt64:
+		p.setInt64Reg(i.P2, i.P4.(int64))
+	case OpReal:
+		reg := p.reg(i.P2)
+		reg.data = i.P4.(float64)
+		reg.typ = RegFloat64
 	case OpString:
 		r := i.P2
 		s := i.P4.(string)
@@ -105,65 +227,30 @@ func (p *Program) step(ctx context.Context, flags *Flags, pgr pager.Pager) int {
 		r2 := p.reg(i.P2)
 		r2.data = r1.data
 		r2.typ = r1.typ
-	case OpEq:
-		a := p.reg(i.P1)
-		jmp := i.P2
-		b := p.reg(i.P3)
-		if eq(a, b) {
-			return jmp
-		}
-	case OpLt:
-		a := p.reg(i.P1)
-		jmp := i.P2
-		b := p.reg(i.P3)
-		if less(a, b) {
-			return jmp
-		}
-	case OpLe:
-		a := p.reg(i.P1)
-		jmp := i.P2
-		b := p.reg(i.P3)
-		if less(a, b) || eq(a, b) {
-			return jmp
-		}
-	case OpGt:
-		a := p.reg(i.P1)
-		jmp := i.P2
-		b := p.reg(i.P3)
-		if !less(a, b) && !eq(a, b) {
-			return jmp
-		}
-	case OpGe:
-		a := p.reg(i.P1)
-		jmp := i.P2
-		b := p.reg(i.P3)
-		if !less(a, b) {
-			return jmp
-		}
-	case OpNe:
-		a := p.reg(i.P1)
-		jmp := i.P2
-		b := p.reg(i.P3)
-		if !eq(a, b) {
-			return jmp
+	case OpEq, OpNe, OpLt, OpLe, OpGt, OpGe:
+		if p.compare(i) {
+			return i.P2
 		}
 	case OpOpenRead:
 		cursor := i.P1
-		pageNo := i.P2
+		pageNo := p.pageNumber(i)
 		f, err := pager.NewCursor(pgr, pager.CURSOR_READ, pageNo, i.P4.(string))
 		if err != nil {
 			return p.error("open read error")
 		}
-		p.cursors[cursor] = f
+		p.setCursor(cursor, f)
 	case OpOpenWrite:
 		cursorIndex := i.P1
-		pageNo := i.P2
+		pageNo := p.pageNumber(i)
 		f, err := pager.NewCursor(pgr, pager.CURSOR_WRITE, pageNo, i.P4.(string))
 		if err != nil {
 			return p.error("open write error")
 		}
-		p.cursors[cursorIndex] = f
+		p.setCursor(cursorIndex, f)
 	case OpClose:
+		if cursor := p.cursors[i.P1]; cursor != nil {
+			cursor.Close()
+		}
 		p.cursors[i.P1] = nil
 	case OpRewind:
 		cursor := p.cursors[i.P1]
@@ -186,6 +273,55 @@ func (p *Program) step(ctx context.Context, flags *Flags, pgr pager.Pager) int {
 		if hasMore {
 			return jmpAddr
 		}
+	case OpLast:
+		cursor := p.cursors[i.P1]
+		hasRecords, err := cursor.Last()
+		if err != nil {
+			return p.error("error moving cursor to last")
+		}
+		if !hasRecords {
+			return i.P2
+		}
+	case OpPrev:
+		cursor := p.cursors[i.P1]
+		hasMore, err := cursor.Prev()
+		if err != nil {
+			return p.error("error moving to previous cell")
+		}
+		if hasMore {
+			return i.P2
+		}
+	case OpSeek, OpSeekGt, OpSeekGe, OpSeekLt, OpSeekLe:
+		cursor := p.cursors[i.P1]
+		if cursor.IsIndex() {
+			return p.seekIndex(cursor, i)
+		}
+
+		key, ok := p.reg(i.P3).int64()
+		if !ok {
+			return p.error("seek key must be a rowid")
+		}
+
+		var found bool
+		var err error
+		switch i.Op {
+		case OpSeek:
+			found, err = cursor.SeekRowID(key)
+		case OpSeekGt:
+			found, err = cursor.SeekGT(key)
+		case OpSeekGe:
+			found, err = cursor.SeekGE(key)
+		case OpSeekLt:
+			found, err = cursor.SeekLT(key)
+		case OpSeekLe:
+			found, err = cursor.SeekLE(key)
+		}
+		if err != nil {
+			return p.error(err.Error())
+		}
+		if !found {
+			return i.P2
+		}
 	case OpAutoCommit:
 		flags.AutoCommit = i.P1 == 1
 		flags.Rollback = i.P2 == 1
@@ -199,21 +335,8 @@ func (p *Program) step(ctx context.Context, flags *Flags, pgr pager.Pager) int {
 			return p.error(err.Error())
 		}
 
-		field := record.Fields[col]
-		reg.data = field.Data
-		if field.Data == nil {
-			reg.typ = RegNull
-		} else {
-			switch field.Type {
-			case storage.Text:
-				reg.typ = RegString
-			case storage.Integer:
-				reg.typ = RegInt32
-			case storage.Byte:
-				reg.typ = RegBinary
-			default:
-				return p.error(fmt.Sprintf("unexpected field type %v", field.Type))
-			}
+		if err := reg.setField(record.Fields[col]); err != nil {
+			return p.error(err.Error())
 		}
 	case OpResultRow:
 		startReg := i.P1
@@ -225,6 +348,10 @@ func (p *Program) step(ctx context.Context, flags *Flags, pgr pager.Pager) int {
 			switch reg.typ {
 			case RegInt32:
 				result = append(result, reg.data.(int))
+			case RegInt64:
+				result = append(result, reg.data.(int64))
+			case RegFloat64:
+				result = append(result, reg.data.(float64))
 			case RegBinary:
 				// TODO: should copy the buffer?
 				result = append(result, reg.data.([]byte))
@@ -250,71 +377,411 @@ func (p *Program) step(ctx context.Context, flags *Flags, pgr pager.Pager) int {
 			return p.error(fmt.Sprintf("unable to persist new table page: %s", err.Error()))
 		}
 		p.setIntReg(i.P1, rootPage.Number())
+	case OpCreateIndex:
+		// Allocate a page for the new index
+		rootPage, err := pgr.Allocate(pager.PageTypeLeafIndex)
+		if err != nil {
+			return p.error(fmt.Sprintf("unable to allocate page for index: %s", err.Error()))
+		}
+		if err := pgr.Write(rootPage); err != nil {
+			return p.error(fmt.Sprintf("unable to persist new index page: %s", err.Error()))
+		}
+		p.setIntReg(i.P1, rootPage.Number())
+	case OpDestroy:
+		if err := pager.FreeBTree(pgr, i.P1); err != nil {
+			return p.error(fmt.Sprintf("unable to free pages of btree: %s", err.Error()))
+		}
+	case OpVacuum:
+		if !flags.AutoCommit {
+			return p.error("cannot VACUUM from within a transaction")
+		}
+
+		var err error
+		if path, _ := i.P4.(string); path != "" {
+			err = pager.VacuumInto(pgr, path, flags.PageSize)
+		} else {
+			err = pgr.Vacuum(flags.PageSize)
+		}
+		if err != nil {
+			return p.error(fmt.Sprintf("unable to vacuum: %s", err.Error()))
+		}
+	case OpPageSize:
+		p.setIntReg(i.P1, pgr.PageSize())
+	case OpSetPageSize:
+		if storage.ValidPageSize(i.P1) {
+			flags.PageSize = i.P1
+		}
+	case OpCacheSize:
+		p.setIntReg(i.P1, pgr.CacheSize())
+	case OpSetCacheSize:
+		pgr.SetCacheSize(i.P1)
+	case OpCheckpoint:
+		result, err := pgr.Checkpoint(storage.CheckpointMode(i.P1))
+		if err != nil {
+			return p.error(fmt.Sprintf("unable to checkpoint: %s", err.Error()))
+		}
+		busy := 0
+		if result.Busy {
+			busy = 1
+		}
+		p.setIntReg(i.P2, busy)
+		p.setIntReg(i.P2+1, result.LogFrames)
+		p.setIntReg(i.P2+2, result.Checkpointed)
 	case OpMakeRecord:
-		startReg := i.P1
-		colCount := i.P2
-		endReg := startReg + colCount - 1
+		fields, err := p.fields(i.P1, i.P2)
+		if err != nil {
+			return p.error(err.Error())
+		}
+
 		destReg := p.reg(i.P3)
-		var fields []*storage.Field
+		destReg.typ = RegRecord
+		destReg.data = fields
+	case OpRowID:
+		cursor := p.cursors[i.P1]
+		rowID, err := cursor.RowID()
+		if err != nil {
+			return p.error(err.Error())
+		}
+		p.setInt64Reg(i.P2, rowID)
+	case OpNewRowID:
+		cursor := p.cursors[i.P1]
+		rowID, err := cursor.NewRowID()
+		if err != nil {
+			return p.error(err.Error())
+		}
 
-		for i := startReg; i <= endReg; i++ {
-			reg := p.reg(i)
-			switch reg.typ {
-			case RegInt32:
-				// TODO: this needs to be more sophisticated and handle signed ints appropriately
-				value := reg.data.(int)
-
-				// Can this number fit in a single byte?
-				if 0xFF&value == value {
-					fields = append(fields, &storage.Field{
-						Type: storage.Byte,
-						Data: byte(value),
-					})
-					continue
+		// Never reuse a rowid up to the largest previously used
+		if seqReg, ok := i.P4.(int); ok {
+			seq := p.reg(seqReg)
+			if last, ok := seq.int64(); ok && last >= rowID {
+				if last == math.MaxInt64 {
+					return p.error("database or disk is full")
 				}
-
-				// Can't fit in a single byte - store as int
-				fields = append(fields, &storage.Field{
-					Type: storage.Integer,
-					Data: value,
-				})
-			case RegString:
-				fields = append(fields, &storage.Field{
-					Type: storage.Text,
-					Data: reg.data.(string),
-				})
-			case RegNull:
-				fields = append(fields, &storage.Field{
-					Type: storage.Null,
-					Data: nil,
-				})
-			default:
-				return p.error("unsupported register type for record")
+				rowID = last + 1
 			}
+			p.setInt64Reg(seqReg, rowID)
 		}
 
-		destReg.typ = RegRecord
-		destReg.data = fields
-	case OpRowID:
+		p.setInt64Reg(i.P2, rowID)
+	case OpNotExists:
 		cursor := p.cursors[i.P1]
-		p.setIntReg(i.P2, int(nextKey(cursor.Name)))
+		key, err := p.rowID(i.P3)
+		if err != nil {
+			return p.error(err.Error())
+		}
+		found, err := cursor.SeekRowID(key)
+		if err != nil {
+			return p.error(err.Error())
+		}
+		if !found {
+			return i.P2
+		}
 	case OpInsert:
 		cursor := p.cursors[i.P1]
 		fields := p.reg(i.P2).data.([]*storage.Field)
-		key := p.reg(i.P3).data.(int)
-		record := storage.NewRecord(uint32(key), fields)
+		key, err := p.rowID(i.P3)
+		if err != nil {
+			return p.error(err.Error())
+		}
+		record := storage.NewRecord(key, fields)
 		if err := cursor.Insert(record); err != nil {
-			return p.error("error performing insert")
+			return p.error(fmt.Sprintf("error performing insert: %s", err.Error()))
+		}
+	case OpDelete:
+		cursor := p.cursors[i.P1]
+		if err := cursor.Delete(); err != nil {
+			return p.error(fmt.Sprintf("error performing delete: %s", err.Error()))
+		}
+	case OpIdxInsert:
+		cursor := p.cursors[i.P1]
+		entry := p.reg(i.P2).data.([]*storage.Field)
+		if err := cursor.InsertEntry(entry); err != nil {
+			return p.error(fmt.Sprintf("error performing index insert: %s", err.Error()))
+		}
+	case OpIdxDelete:
+		cursor := p.cursors[i.P1]
+		entry, err := p.fields(i.P2, i.P3)
+		if err != nil {
+			return p.error(err.Error())
+		}
+		if _, err := cursor.DeleteEntry(entry); err != nil {
+			return p.error(fmt.Sprintf("error performing index delete: %s", err.Error()))
+		}
+	case OpNoConflict:
+		cursor := p.cursors[i.P1]
+		key, err := p.fields(i.P3, i.P4.(int))
+		if err != nil {
+			return p.error(err.Error())
+		}
+
+		// NULLs are distinct from each other
+		for _, f := range key {
+			if f.Data == nil {
+				return i.P2
+			}
+		}
+
+		found, err := cursor.SeekIndexGE(key)
+		if err != nil {
+			return p.error(err.Error())
+		}
+		if !found {
+			return i.P2
+		}
+		if c, err := cursor.CompareEntry(key); err != nil {
+			return p.error(err.Error())
+		} else if c != 0 {
+			return i.P2
+		}
+	case OpIdxGt, OpIdxGe, OpIdxLt, OpIdxLe:
+		cursor := p.cursors[i.P1]
+		key, err := p.fields(i.P3, i.P4.(int))
+		if err != nil {
+			return p.error(err.Error())
+		}
+
+		c, err := cursor.CompareEntry(key)
+		if err != nil {
+			return p.error(err.Error())
+		}
+
+		var jump bool
+		switch i.Op {
+		case OpIdxGt:
+			jump = c > 0
+		case OpIdxGe:
+			jump = c >= 0
+		case OpIdxLt:
+			jump = c < 0
+		case OpIdxLe:
+			jump = c <= 0
+		}
+		if jump {
+			return i.P2
+		}
+	case OpIdxPKey:
+		cursor := p.cursors[i.P1]
+		rowID, err := cursor.RowID()
+		if err != nil {
+			return p.error(err.Error())
+		}
+		p.setInt64Reg(i.P2, rowID)
+	case OpSorterOpen:
+		for len(p.sorters) <= i.P1 {
+			p.sorters = append(p.sorters, nil)
+		}
+		p.sorters[i.P1] = newSorter(i.P4.([]SortOrder), flags.SortMemory, "")
+	case OpSorterInsert:
+		r := make(row, i.P3)
+		for j := range r {
+			r[j] = *p.reg(i.P2 + j)
+		}
+		if err := p.sorters[i.P1].insert(r); err != nil {
+			return p.error(fmt.Sprintf("unable to sort: %s", err.Error()))
+		}
+	case OpSorterSort:
+		hasRows, err := p.sorters[i.P1].sort()
+		if err != nil {
+			return p.error(fmt.Sprintf("unable to sort: %s", err.Error()))
+		}
+		if !hasRows {
+			return i.P2
+		}
+	case OpSorterNext:
+		hasMore, err := p.sorters[i.P1].next()
+		if err != nil {
+			return p.error(fmt.Sprintf("unable to sort: %s", err.Error()))
+		}
+		if hasMore {
+			return i.P2
+		}
+	case OpSorterData:
+		r := p.sorters[i.P1].row()
+		for j := 0; j < i.P3; j++ {
+			*p.reg(i.P2 + j) = r[j]
+		}
+	}
+
+	return 0
+}
+
+// seekIndex moves an index cursor by the key in the registers of a seek instruction.
+func (p *Program) seekIndex(cursor *pager.Cursor, i *Instruction) int {
+	key, err := p.fields(i.P3, i.P4.(int))
+	if err != nil {
+		return p.error(err.Error())
+	}
+
+	var found bool
+	switch i.Op {
+	case OpSeekGt:
+		found, err = cursor.SeekIndexGT(key)
+	case OpSeek, OpSeekGe:
+		found, err = cursor.SeekIndexGE(key)
+	case OpSeekLt:
+		found, err = cursor.SeekIndexLT(key)
+	case OpSeekLe:
+		found, err = cursor.SeekIndexLE(key)
+	}
+	if err != nil {
+		return p.error(err.Error())
+	}
+	if !found {
+		return i.P2
+	}
+
+	return 0
+}
+
+// fields converts the values in count registers starting at startReg to record fields.
+func (p *Program) fields(startReg int, count int) ([]*storage.Field, error) {
+	var fields []*storage.Field
+
+	for r := startReg; r < startReg+count; r++ {
+		f, err := p.reg(r).field()
+		if err != nil {
+			return nil, err
 		}
+		fields = append(fields, f)
 	}
 
+	return fields, nil
+}
+
+// field converts the value of the register to a record field.
+func (r *register) field() (*storage.Field, error) {
+	switch r.typ {
+	case RegInt32, RegInt64:
+		// The record picks the smallest serial type which holds the value
+		return &storage.Field{Type: storage.Integer, Data: r.data}, nil
+	case RegFloat64:
+		return &storage.Field{Type: storage.Real, Data: r.data.(float64)}, nil
+	case RegBinary:
+		return &storage.Field{Type: storage.Blob, Data: r.data.([]byte)}, nil
+	case RegString:
+		return &storage.Field{Type: storage.Text, Data: r.data.(string)}, nil
+	case RegNull:
+		return &storage.Field{Type: storage.Null, Data: nil}, nil
+	}
+	return nil, errors.New("unsupported register type for record")
+}
+
+// setField stores the value of a record field in the register.
+func (r *register) setField(f *storage.Field) error {
+	if f.Data == nil {
+		r.setNull()
+		return nil
+	}
+
+	switch f.Type {
+	case storage.Text:
+		r.typ = RegString
+		r.data = f.Data
+	case storage.Integer:
+		v, _ := f.Int64()
+		r.setInt64(v)
+	case storage.Real:
+		r.setFloat64(f.Data.(float64))
+	case storage.Byte:
+		r.setInt64(int64(f.Data.(byte)))
+	case storage.Blob:
+		r.typ = RegBinary
+		r.data = f.Data
+	default:
+		return fmt.Errorf("unexpected field type %v", f.Type)
+	}
+	return nil
+}
+
+// pageNumber is the root page opened by an instruction, read from
+// the register in P2 when flagged in P5.
+func (p *Program) pageNumber(i *Instruction) int {
+	if i.P5&OpFlagP2IsReg != 0 {
+		pageNumber, _ := p.reg(i.P2).int64()
+		return int(pageNumber)
+	}
+	return i.P2
+}
+
+// rowID is the key of a record in register r, rowids are 64-bit signed integers.
+func (p *Program) rowID(r int) (int64, error) {
+	key, ok := p.reg(r).int64()
+	if !ok {
+		return 0, errors.New("datatype mismatch")
+	}
+	return key, nil
+}
+
+// compare is the result of a comparison op, a comparison with a NULL
+// is false unless P5 has flags for NULLs.
+func (p *Program) compare(i *Instruction) bool {
+	a := p.reg(i.P1)
+	b := p.reg(i.P3)
+	if affinity, ok := i.P4.(storage.Affinity); ok {
+		a, b = withAffinity(a, affinity), withAffinity(b, affinity)
+	}
+	if (a.typ == RegNull || b.typ == RegNull) && i.P5&OpFlagNullEq == 0 {
+		return i.P5&OpFlagJumpIfNull != 0
+	}
+
+	c := compareCollated(a, b, Collation(i.P5&OpFlagCollation))
+	switch i.Op {
+	case OpEq:
+		return c == 0
+	case OpNe:
+		return c != 0
+	case OpLt:
+		return c < 0
+	case OpLe:
+		return c <= 0
+	case OpGt:
+		return c > 0
+	}
+	return c >= 0
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
 	return 0
 }
 
+// closeCursors releases the pages pinned by the cursors
+func (p *Program) closeCursors() {
+	for _, c := range p.cursors {
+		if c != nil {
+			c.Close()
+		}
+	}
+}
+
+// closeSorters removes the temporary files of the sorters
+func (p *Program) closeSorters() {
+	for _, s := range p.sorters {
+		if s != nil {
+			_ = s.close()
+		}
+	}
+}
+
+func (p *Program) setCursor(i int, cursor *pager.Cursor) {
+	for len(p.cursors) <= i {
+		p.cursors = append(p.cursors, nil)
+	}
+	if p.cursors[i] != nil {
+		p.cursors[i].Close()
+	}
+	p.cursors[i] = cursor
+}
+
 func (p *Program) setIntReg(r int, v int) {
-	reg := p.reg(r)
-	reg.typ = RegInt32
-	reg.data = v
+	p.setInt64Reg(r, int64(v))
+}
+
+// setInt64Reg stores an integer as RegInt32 when it fits in 32 bits, as RegInt64 otherwise.
+func (p *Program) setInt64Reg(r int, v int64) {
+	p.reg(r).setInt64(v)
 }
 
 func (p *Program) error(message string) int {
@@ -324,7 +791,7 @@ func (p *Program) error(message string) int {
 
 func (p *Program) reg(i int) *register {
 	if len(p.regs) <= i {
-		diff := len(p.regs) - i + 1
+		diff := i - len(p.regs) + 1
 		// Allocate some number of registers
 		for i := 0; i < diff; i++ {
 			p.regs = append(p.regs, &register{
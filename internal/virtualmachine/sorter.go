@@ -0,0 +1,313 @@
+package virtualmachine
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/joeandaverde/tinydb/internal/storage"
+)
+
+// DefaultSortMemory is the size in bytes of the rows a sorter holds in memory
+// before it spills them to a temporary file.
+const DefaultSortMemory = 4 << 20
+
+// SortOrder is the order of a key column of a sorter
+type SortOrder struct {
+	Desc       bool      // 降序
+	NullsFirst bool      // NULL 排在其它值之前
+	Collation  Collation // 文本的排序规则
+}
+
+func (o SortOrder) String() string {
+	var sb strings.Builder
+	if o.Desc {
+		sb.WriteString("DESC")
+	} else {
+		sb.WriteString("ASC")
+	}
+	if o.NullsFirst {
+		sb.WriteString(" NULLS FIRST")
+	} else {
+		sb.WriteString(" NULLS LAST")
+	}
+	if o.Collation != CollationBinary {
+		sb.WriteString(" COLLATE ")
+		sb.WriteString(o.Collation.String())
+	}
+	return sb.String()
+}
+
+// sortRun is a sorted run of rows written to the temporary file of a sorter
+type sortRun struct {
+	offset int64
+	size   int64
+}
+
+// sorter sorts rows by their first key columns. Rows are held in memory until they
+// exceed the memory limit, then they're sorted and written to a temporary file as
+// a run. Once every row is inserted the runs are merged as the rows are read.
+// Rows with equal keys keep the order they're inserted in.
+//
+// 外部归并排序
+type sorter struct {
+	keys  []SortOrder
+	limit int    // 内存中行的字节数上限
+	dir   string // 临时文件的目录，为空时使用系统默认目录
+
+	rows []row // 内存中的行
+	size int   // 内存中行的字节数
+
+	file *os.File  // 临时文件
+	runs []sortRun // 临时文件中已排序的段
+
+	pos     int         // 内存中当前行的位置
+	merging *mergeQueue // 归并中的段
+}
+
+// row is a copy of the values of the registers inserted in a sorter
+type row []register
+
+func newSorter(keys []SortOrder, limit int, dir string) *sorter {
+	if limit <= 0 {
+		limit = DefaultSortMemory
+	}
+	return &sorter{keys: keys, limit: limit, dir: dir}
+}
+
+// insert adds a row to the sorter, the rows in memory are spilled to the
+// temporary file once they exceed the memory limit.
+func (s *sorter) insert(r row) error {
+	s.rows = append(s.rows, r)
+	s.size += r.size()
+	if s.size <= s.limit {
+		return nil
+	}
+	return s.spill()
+}
+
+// sort finishes inserting rows and moves to the first row,
+// returns false when the sorter is empty.
+func (s *sorter) sort() (bool, error) {
+	if len(s.runs) == 0 {
+		s.sortRows()
+		return len(s.rows) > 0, nil
+	}
+
+	// The rows in memory are the last run
+	if len(s.rows) > 0 {
+		if err := s.spill(); err != nil {
+			return false, err
+		}
+	}
+
+	s.merging = &mergeQueue{less: s.less}
+	for i, run := range s.runs {
+		reader := &runReader{
+			index: i,
+			r:     bufio.NewReader(io.NewSectionReader(s.file, run.offset, run.size)),
+		}
+		if ok, err := reader.next(); err != nil {
+			return false, err
+		} else if ok {
+			s.merging.readers = append(s.merging.readers, reader)
+		}
+	}
+	heap.Init(s.merging)
+
+	return s.merging.Len() > 0, nil
+}
+
+// next moves to the next row in order, returns false after the last row.
+func (s *sorter) next() (bool, error) {
+	if s.merging == nil {
+		s.pos++
+		return s.pos < len(s.rows), nil
+	}
+
+	reader := s.merging.readers[0]
+	ok, err := reader.next()
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		heap.Fix(s.merging, 0)
+	} else {
+		heap.Pop(s.merging)
+	}
+	return s.merging.Len() > 0, nil
+}
+
+// row is the current row
+func (s *sorter) row() row {
+	if s.merging == nil {
+		return s.rows[s.pos]
+	}
+	return s.merging.readers[0].current
+}
+
+// close removes the temporary file
+func (s *sorter) close() error {
+	s.rows = nil
+	s.merging = nil
+	if s.file == nil {
+		return nil
+	}
+
+	name := s.file.Name()
+	err := s.file.Close()
+	if removeErr := os.Remove(name); err == nil {
+		err = removeErr
+	}
+	s.file = nil
+	return err
+}
+
+// spill writes the rows in memory to the end of the temporary file as a sorted run
+func (s *sorter) spill() error {
+	if s.file == nil {
+		f, err := os.CreateTemp(s.dir, "tinydb-sort-*")
+		if err != nil {
+			return err
+		}
+		s.file = f
+	}
+
+	offset, err := s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	s.sortRows()
+	w := bufio.NewWriter(s.file)
+	var size int64
+	for _, r := range s.rows {
+		fields := make([]*storage.Field, len(r))
+		for i := range r {
+			if fields[i], err = r[i].field(); err != nil {
+				return err
+			}
+		}
+		record, err := storage.EncodeFields(fields)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+		size += int64(len(record))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	s.runs = append(s.runs, sortRun{offset: offset, size: size})
+	s.rows = s.rows[:0]
+	s.size = 0
+	return nil
+}
+
+func (s *sorter) sortRows() {
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		return s.less(s.rows[i], s.rows[j])
+	})
+}
+
+// less compares the key columns of two rows
+func (s *sorter) less(a row, b row) bool {
+	for i, key := range s.keys {
+		x, y := &a[i], &b[i]
+
+		// NULLs are placed regardless of the direction
+		xNull, yNull := x.typ == RegNull, y.typ == RegNull
+		switch {
+		case xNull && yNull:
+			continue
+		case xNull || yNull:
+			return xNull == key.NullsFirst
+		}
+
+		c := compareCollated(x, y, key.Collation)
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return false
+}
+
+// size estimates the memory held by a row
+func (r row) size() int {
+	n := 0
+	for _, reg := range r {
+		// The header of a register
+		n += 24
+		switch v := reg.data.(type) {
+		case string:
+			n += len(v)
+		case []byte:
+			n += len(v)
+		}
+	}
+	return n
+}
+
+// runReader reads the rows of a run
+type runReader struct {
+	index   int // 段的序号，相等的行按段的顺序
+	r       *bufio.Reader
+	current row
+}
+
+// next reads the next row of the run, returns false after the last row.
+func (r *runReader) next() (bool, error) {
+	if _, err := r.r.Peek(1); err == io.EOF {
+		return false, nil
+	}
+
+	fields, err := storage.ReadFields(r.r)
+	if err != nil {
+		return false, err
+	}
+	r.current = make(row, len(fields))
+	for i, f := range fields {
+		if err := r.current[i].setField(f); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// mergeQueue is a heap of the readers of runs ordered by their current rows
+type mergeQueue struct {
+	readers []*runReader
+	less    func(a row, b row) bool
+}
+
+func (q *mergeQueue) Len() int { return len(q.readers) }
+
+func (q *mergeQueue) Less(i, j int) bool {
+	a, b := q.readers[i], q.readers[j]
+	if q.less(a.current, b.current) {
+		return true
+	}
+	if q.less(b.current, a.current) {
+		return false
+	}
+	return a.index < b.index
+}
+
+func (q *mergeQueue) Swap(i, j int) { q.readers[i], q.readers[j] = q.readers[j], q.readers[i] }
+
+func (q *mergeQueue) Push(x interface{}) { q.readers = append(q.readers, x.(*runReader)) }
+
+func (q *mergeQueue) Pop() interface{} {
+	last := q.readers[len(q.readers)-1]
+	q.readers = q.readers[:len(q.readers)-1]
+	return last
+}
@@ -0,0 +1,89 @@
+package virtualmachine
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sortedRows(t *testing.T, s *sorter) []row {
+	var rows []row
+	ok, err := s.sort()
+	require.NoError(t, err)
+	for ok {
+		rows = append(rows, s.row())
+		ok, err = s.next()
+		require.NoError(t, err)
+	}
+	return rows
+}
+
+func TestSorter(t *testing.T) {
+	r := require.New(t)
+
+	null := register{typ: RegNull}
+	i32 := func(v int) register { return register{typ: RegInt32, data: v} }
+	str := func(v string) register { return register{typ: RegString, data: v} }
+
+	s := newSorter([]SortOrder{{Desc: true}, {NullsFirst: true}}, 0, t.TempDir())
+	rows := []row{
+		{i32(1), str("a"), i32(0)},
+		{null, str("b"), i32(1)},
+		{i32(2), null, i32(2)},
+		{i32(1), null, i32(3)},
+		{i32(2), str("a"), i32(4)},
+		{i32(1), str("a"), i32(5)},
+	}
+	for _, row := range rows {
+		r.NoError(s.insert(row))
+	}
+
+	// Descending with NULLs last, then ascending with NULLs first, equal keys keep their order
+	var order []interface{}
+	for _, row := range sortedRows(t, s) {
+		order = append(order, row[2].data)
+	}
+	r.Equal([]interface{}{2, 4, 3, 0, 5, 1}, order)
+	r.NoError(s.close())
+
+	empty := newSorter([]SortOrder{{}}, 0, t.TempDir())
+	r.Empty(sortedRows(t, empty))
+	r.NoError(empty.close())
+}
+
+func TestSorter_Spill(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	s := newSorter([]SortOrder{{NullsFirst: true}}, 1024, dir)
+
+	// Keys repeat so the order of equal keys across runs is kept
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		key := register{typ: RegInt32, data: rnd.Intn(100)}
+		if i%10 == 0 {
+			key = register{typ: RegFloat64, data: float64(rnd.Intn(100)) + 0.5}
+		}
+		r.NoError(s.insert(row{key, {typ: RegInt32, data: i}, {typ: RegString, data: "some text"}}))
+	}
+
+	rows := sortedRows(t, s)
+	r.Greater(len(s.runs), 10)
+	r.Len(rows, 1000)
+	for i := 1; i < len(rows); i++ {
+		c := compare(&rows[i-1][0], &rows[i][0])
+		r.LessOrEqual(c, 0)
+		if c == 0 {
+			r.Less(rows[i-1][1].data, rows[i][1].data)
+		}
+	}
+	r.Equal(register{typ: RegString, data: "some text"}, rows[0][2])
+
+	// The temporary file is removed
+	r.NoError(s.close())
+	entries, err := os.ReadDir(dir)
+	r.NoError(err)
+	r.Empty(entries)
+}
@@ -0,0 +1,17 @@
+package ast
+
+// CreateIndexStatement represents an instruction to create an index on columns of a table
+type CreateIndexStatement struct {
+	IndexName   string
+	TableName   string
+	Unique      bool
+	IfNotExists bool
+	Columns     []string
+	RawText     string
+}
+
+func (*CreateIndexStatement) iStatement() {}
+
+func (*CreateIndexStatement) Mutates() bool { return true }
+
+func (*CreateIndexStatement) ReturnsRows() bool { return false }
@@ -2,9 +2,12 @@ package ast
 
 // ColumnDefinition represents a specification for a column in a table
 type ColumnDefinition struct {
-	Name       string
-	Type       string
-	PrimaryKey bool
+	Name          string
+	Type          string
+	PrimaryKey    bool
+	AutoIncrement bool
+	Unique        bool
+	Default       Expression
 }
 
 // CreateTableStatement represents an instruction to create a table
@@ -0,0 +1,19 @@
+package ast
+
+import "fmt"
+
+// DeleteStatement represents an instruction to remove rows from a table
+type DeleteStatement struct {
+	Table  string
+	Filter Expression
+}
+
+func (s *DeleteStatement) String() string {
+	return fmt.Sprintf("DELETE FROM %s\nWHERE %s", s.Table, s.Filter)
+}
+
+func (*DeleteStatement) iStatement() {}
+
+func (*DeleteStatement) Mutates() bool { return true }
+
+func (*DeleteStatement) ReturnsRows() bool { return false }
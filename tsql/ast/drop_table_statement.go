@@ -0,0 +1,13 @@
+package ast
+
+// DropTableStatement represents an instruction to remove a table along with its indexes
+type DropTableStatement struct {
+	TableName string
+	IfExists  bool
+}
+
+func (*DropTableStatement) iStatement() {}
+
+func (*DropTableStatement) Mutates() bool { return true }
+
+func (*DropTableStatement) ReturnsRows() bool { return false }
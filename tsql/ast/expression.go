@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/joeandaverde/tinydb/tsql/lexer"
 )
@@ -23,6 +24,43 @@ type BinaryOperation struct {
 	Operator string
 }
 
+// UnaryOperation is an expression with a single operand, e.g. -x or NOT x
+type UnaryOperation struct {
+	Operand  Expression
+	Operator string
+}
+
+// LikeOperation matches a value with a pattern: LIKE, GLOB, REGEXP or MATCH,
+// e.g. name NOT LIKE 'a\%' ESCAPE '\'. Escape is nil without an ESCAPE clause.
+type LikeOperation struct {
+	Operand  Expression
+	Pattern  Expression
+	Escape   Expression
+	Operator string
+	Not      bool
+}
+
+// BetweenOperation is true when the operand is within the bounds, e.g. x BETWEEN 1 AND 5
+type BetweenOperation struct {
+	Operand Expression
+	Low     Expression
+	High    Expression
+	Not     bool
+}
+
+// InOperation is true when the operand equals a value of the list, e.g. x IN (1, 2)
+type InOperation struct {
+	Operand Expression
+	List    []Expression
+	Not     bool
+}
+
+// CollateOperation sets the collating sequence the operand is compared with, e.g. name COLLATE NOCASE
+type CollateOperation struct {
+	Operand   Expression
+	Collation string
+}
+
 // Ident is a reference to something in the environment
 type Ident struct {
 	Value string
@@ -36,6 +74,11 @@ type BasicLiteral struct {
 
 func (*BinaryOperation) iExpression()  {}
 func (*LogicalOperation) iExpression() {}
+func (*UnaryOperation) iExpression()   {}
+func (*LikeOperation) iExpression()    {}
+func (*BetweenOperation) iExpression() {}
+func (*InOperation) iExpression()      {}
+func (*CollateOperation) iExpression() {}
 func (*Ident) iExpression()            {}
 func (*BasicLiteral) iExpression()     {}
 
@@ -71,6 +114,59 @@ func (o *BinaryOperation) String() string {
 	return fmt.Sprintf("(%s %s %s)", o.Left, o.Operator, o.Right)
 }
 
+func (o *UnaryOperation) String() string {
+	return fmt.Sprintf("(%s %s)", o.Operator, o.Operand)
+}
+
+func (o *LikeOperation) String() string {
+	operator := o.Operator
+	if o.Not {
+		operator = "NOT " + operator
+	}
+	if o.Escape != nil {
+		return fmt.Sprintf("(%s %s %s ESCAPE %s)", o.Operand, operator, o.Pattern, o.Escape)
+	}
+	return fmt.Sprintf("(%s %s %s)", o.Operand, operator, o.Pattern)
+}
+
+func (o *BetweenOperation) String() string {
+	operator := "BETWEEN"
+	if o.Not {
+		operator = "NOT BETWEEN"
+	}
+	return fmt.Sprintf("(%s %s %s AND %s)", o.Operand, operator, o.Low, o.High)
+}
+
+func (o *InOperation) String() string {
+	operator := "IN"
+	if o.Not {
+		operator = "NOT IN"
+	}
+	list := make([]string, len(o.List))
+	for i, e := range o.List {
+		list[i] = fmt.Sprint(e)
+	}
+	return fmt.Sprintf("(%s %s (%s))", o.Operand, operator, strings.Join(list, ", "))
+}
+
+func (o *CollateOperation) String() string {
+	return fmt.Sprintf("(%s COLLATE %s)", o.Operand, o.Collation)
+}
+
+func (i *Ident) String() string {
+	return i.Value
+}
+
+func (l *BasicLiteral) String() string {
+	switch l.Kind {
+	case lexer.TokenString:
+		return "'" + l.Value + "'"
+	case lexer.TokenNull:
+		return "NULL"
+	}
+	return l.Value
+}
+
 func (o *LogicalOperation) String() string {
 	return fmt.Sprintf("(%s %v)", o.Operator, o.Terms)
 }
@@ -0,0 +1,19 @@
+package ast
+
+import "strings"
+
+// PragmaStatement represents an instruction to query or change a setting of the database.
+// Value is empty when the setting is queried.
+type PragmaStatement struct {
+	Name  string
+	Value string
+}
+
+func (*PragmaStatement) iStatement() {}
+
+func (s *PragmaStatement) Mutates() bool { return s.Value != "" }
+
+// ReturnsRows is true when the setting is queried or the pragma reports the result of an operation.
+func (s *PragmaStatement) ReturnsRows() bool {
+	return s.Value == "" || strings.EqualFold(s.Name, "wal_checkpoint")
+}
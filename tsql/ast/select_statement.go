@@ -8,11 +8,48 @@ type TableAlias struct {
 	Alias string
 }
 
+// ResultColumn is an expression in the result of a select, or every column of the table for *
+type ResultColumn struct {
+	Expr  Expression // 为 nil 时表示 *
+	Alias string
+	Text  string // 表达式的原文
+}
+
+// Star determines if the result column is *
+func (c ResultColumn) Star() bool {
+	return c.Expr == nil
+}
+
+// Name is the name of the result column, the alias if there is one or else the text of the expression
+func (c ResultColumn) Name() string {
+	if c.Alias != "" {
+		return c.Alias
+	}
+	return c.Text
+}
+
+// OrderingTerm is an expression of ORDER BY and the direction the rows are sorted in
+type OrderingTerm struct {
+	Expr  Expression
+	Desc  bool
+	Nulls string // FIRST 或 LAST，为空时取决于排序方向
+}
+
+// NullsFirst determines if NULLs sort before other values, by default NULLs
+// are the smallest values like in SQLite.
+func (t OrderingTerm) NullsFirst() bool {
+	if t.Nulls == "" {
+		return !t.Desc
+	}
+	return t.Nulls == "FIRST"
+}
+
 // SelectStatement represents an instruction to select/filter rows from one or more tables
 type SelectStatement struct {
 	From    []TableAlias
-	Columns []string
+	Columns []ResultColumn
 	Filter  Expression
+	OrderBy []OrderingTerm
 }
 
 func (s *SelectStatement) String() string {
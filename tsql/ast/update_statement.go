@@ -0,0 +1,20 @@
+package ast
+
+import "fmt"
+
+// UpdateStatement represents an instruction to assign new values to columns of the rows of a table
+type UpdateStatement struct {
+	Table  string
+	Values ValueSet
+	Filter Expression
+}
+
+func (s *UpdateStatement) String() string {
+	return fmt.Sprintf("UPDATE %s\nSET %v\nWHERE %s", s.Table, s.Values, s.Filter)
+}
+
+func (*UpdateStatement) iStatement() {}
+
+func (*UpdateStatement) Mutates() bool { return true }
+
+func (*UpdateStatement) ReturnsRows() bool { return false }
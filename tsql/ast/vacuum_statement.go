@@ -0,0 +1,13 @@
+package ast
+
+// VacuumStatement represents an instruction to rebuild the database file,
+// or to write a copy of the database to a new file when Into is set
+type VacuumStatement struct {
+	Into string
+}
+
+func (*VacuumStatement) iStatement() {}
+
+func (*VacuumStatement) Mutates() bool { return true }
+
+func (*VacuumStatement) ReturnsRows() bool { return false }
@@ -58,6 +58,28 @@ func lexNumber(l *Lexer) stateFn {
 		l.next()
 	}
 
+	// Fraction of a real number
+	if l.peek() == '.' {
+		l.next()
+		for unicode.IsDigit(l.peek()) {
+			l.next()
+		}
+	}
+
+	// Exponent of a real number, e.g. 1.5e-3
+	if r := l.peek(); r == 'e' || r == 'E' {
+		l.next()
+		if r := l.peek(); r == '+' || r == '-' {
+			l.next()
+		}
+		if !unicode.IsDigit(l.peek()) {
+			return l.errorf("malformed number %s", l.input[l.start:l.pos])
+		}
+		for unicode.IsDigit(l.peek()) {
+			l.next()
+		}
+	}
+
 	l.emit(TokenNumber)
 
 	return lexTinySQL
@@ -83,16 +105,56 @@ func lexAlphaNumeric(l *Lexer) stateFn {
 			l.emit(TokenAs)
 		} else if strings.ToUpper(value) == "TABLE" {
 			l.emit(TokenTable)
+		} else if strings.ToUpper(value) == "INDEX" {
+			l.emit(TokenIndex)
+		} else if strings.ToUpper(value) == "UNIQUE" {
+			l.emit(TokenUnique)
+		} else if strings.ToUpper(value) == "ON" {
+			l.emit(TokenOn)
 		} else if strings.ToUpper(value) == "WHERE" {
 			l.emit(TokenWhere)
+		} else if strings.ToUpper(value) == "ORDER" {
+			l.emit(TokenOrder)
 		} else if strings.ToUpper(value) == "AND" {
 			l.emit(TokenAnd)
 		} else if strings.ToUpper(value) == "OR" {
 			l.emit(TokenOr)
+		} else if strings.ToUpper(value) == "IS" {
+			l.emit(TokenIs)
+		} else if strings.ToUpper(value) == "DISTINCT" {
+			l.emit(TokenDistinct)
+		} else if strings.ToUpper(value) == "LIKE" {
+			l.emit(TokenLike)
+		} else if strings.ToUpper(value) == "GLOB" {
+			l.emit(TokenGlob)
+		} else if strings.ToUpper(value) == "REGEXP" {
+			l.emit(TokenRegexp)
+		} else if strings.ToUpper(value) == "MATCH" {
+			l.emit(TokenMatch)
+		} else if strings.ToUpper(value) == "ESCAPE" {
+			l.emit(TokenEscape)
+		} else if strings.ToUpper(value) == "IN" {
+			l.emit(TokenIn)
+		} else if strings.ToUpper(value) == "BETWEEN" {
+			l.emit(TokenBetween)
+		} else if strings.ToUpper(value) == "COLLATE" {
+			l.emit(TokenCollate)
 		} else if strings.ToUpper(value) == "CREATE" {
 			l.emit(TokenCreate)
 		} else if strings.ToUpper(value) == "INSERT" {
 			l.emit(TokenInsert)
+		} else if strings.ToUpper(value) == "DELETE" {
+			l.emit(TokenDelete)
+		} else if strings.ToUpper(value) == "UPDATE" {
+			l.emit(TokenUpdate)
+		} else if strings.ToUpper(value) == "DROP" {
+			l.emit(TokenDrop)
+		} else if strings.ToUpper(value) == "VACUUM" {
+			l.emit(TokenVacuum)
+		} else if strings.ToUpper(value) == "PRAGMA" {
+			l.emit(TokenPragma)
+		} else if strings.ToUpper(value) == "SET" {
+			l.emit(TokenSet)
 		} else if strings.ToUpper(value) == "VALUES" {
 			l.emit(TokenValues)
 		} else if strings.ToUpper(value) == "INTO" {
@@ -130,29 +192,44 @@ func lexSymbol(l *Lexer) stateFn {
 	case '>':
 		l.next()
 
-		if l.next() == '=' {
+		switch l.next() {
+		case '=':
 			l.emit(TokenGte)
-		} else {
+		case '>':
+			l.emit(TokenShiftRight)
+		default:
 			l.backup()
 			l.emit(TokenGt)
 		}
 	case '<':
 		l.next()
 
-		if l.next() == '=' {
+		switch l.next() {
+		case '=':
 			l.emit(TokenLte)
-		} else {
+		case '>':
+			l.emit(TokenNotEq)
+		case '<':
+			l.emit(TokenShiftLeft)
+		default:
 			l.backup()
 			l.emit(TokenLt)
 		}
 	case '=':
 		l.next()
+
+		// == is the same as =
+		if l.next() != '=' {
+			l.backup()
+		}
 		l.emit(TokenEquals)
 	case '!':
 		if l.peek2() == '=' {
 			l.next()
 			l.next()
 			l.emit(TokenNotEq)
+		} else {
+			return nil
 		}
 	case '*':
 		l.next()
@@ -166,6 +243,24 @@ func lexSymbol(l *Lexer) stateFn {
 	case '/':
 		l.next()
 		l.emit(TokenDivide)
+	case '%':
+		l.next()
+		l.emit(TokenRemainder)
+	case '|':
+		l.next()
+
+		if l.next() == '|' {
+			l.emit(TokenConcat)
+		} else {
+			l.backup()
+			l.emit(TokenBitOr)
+		}
+	case '&':
+		l.next()
+		l.emit(TokenBitAnd)
+	case '~':
+		l.next()
+		l.emit(TokenBitNot)
 	case '(':
 		l.next()
 		l.emit(TokenOpenParen)
@@ -220,7 +315,7 @@ func lexTinySQL(l *Lexer) stateFn {
 		return resume
 	} else if resume := lexString(l); resume != nil {
 		return resume
-	} else if unicode.IsDigit(r) {
+	} else if unicode.IsDigit(r) || (r == '.' && unicode.IsDigit(l.peek2())) {
 		return lexNumber(l)
 	} else if isAlphaNumeric(r) {
 		return lexAlphaNumeric(l)
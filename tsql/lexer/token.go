@@ -25,6 +25,7 @@ const (
 	TokenSelect
 	TokenFrom
 	TokenWhere
+	TokenOrder
 	TokenAs
 	TokenIf
 	TokenNot
@@ -32,8 +33,17 @@ const (
 
 	TokenCreate
 	TokenInsert
+	TokenDelete
+	TokenUpdate
+	TokenDrop
+	TokenVacuum
+	TokenPragma
+	TokenSet
 	TokenInto
 	TokenTable
+	TokenIndex
+	TokenUnique
+	TokenOn
 	TokenValues
 	TokenReturning
 
@@ -46,10 +56,27 @@ const (
 
 	TokenAnd
 	TokenOr
+	TokenIs
+	TokenDistinct
+	TokenLike
+	TokenGlob
+	TokenRegexp
+	TokenMatch
+	TokenEscape
+	TokenIn
+	TokenBetween
+	TokenCollate
 
 	TokenPlus
 	TokenMinus
 	TokenDivide
+	TokenRemainder
+	TokenConcat
+	TokenBitAnd
+	TokenBitOr
+	TokenBitNot
+	TokenShiftLeft
+	TokenShiftRight
 
 	TokenString
 	TokenNumber
@@ -82,6 +109,8 @@ func (t Kind) String() string {
 		return "FROM"
 	case t == TokenWhere:
 		return "WHERE"
+	case t == TokenOrder:
+		return "ORDER"
 	case t == TokenAnd:
 		return "AND"
 	case t == TokenOr:
@@ -0,0 +1,43 @@
+package parser
+
+import (
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+	"github.com/joeandaverde/tinydb/tsql/scan"
+)
+
+func parseCreateIndex(scanner scan.TinyScanner) (*ast.CreateIndexStatement, error) {
+	createIndexStatement := ast.CreateIndexStatement{}
+
+	ok, _ := allX(
+		keyword(lexer.TokenCreate),
+		optional(keyword(lexer.TokenUnique), func(tokens []lexer.Token) {
+			createIndexStatement.Unique = true
+		}),
+		keyword(lexer.TokenIndex),
+		optional(
+			allX(keyword(lexer.TokenIf), keyword(lexer.TokenNot), keyword(lexer.TokenExists)),
+			func(tokens []lexer.Token) {
+				createIndexStatement.IfNotExists = true
+			}),
+		ident(func(indexName string) {
+			createIndexStatement.IndexName = indexName
+		}),
+		keyword(lexer.TokenOn),
+		ident(func(tableName string) {
+			createIndexStatement.TableName = tableName
+		}),
+		parensCommaSep(
+			ident(func(column string) {
+				createIndexStatement.Columns = append(createIndexStatement.Columns, column)
+			}),
+		),
+	)(scanner)
+
+	if ok {
+		createIndexStatement.RawText = scanner.Text()
+		return &createIndexStatement, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,35 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/joeandaverde/tinydb/tsql/ast"
+)
+
+func Test_parseCreateIndex(t *testing.T) {
+	assert := require.New(t)
+
+	stmt, err := ParseStatement(`CREATE UNIQUE INDEX IF NOT EXISTS apples_color ON apples (color, size)`)
+
+	assert.NoError(err)
+	assert.Equal(&ast.CreateIndexStatement{
+		IndexName:   "apples_color",
+		TableName:   "apples",
+		Unique:      true,
+		IfNotExists: true,
+		Columns:     []string{"color", "size"},
+		RawText:     `CREATE UNIQUE INDEX IF NOT EXISTS apples_color ON apples (color, size)`,
+	}, stmt)
+
+	stmt, err = ParseStatement(`create index apples_color on apples(color)`)
+
+	assert.NoError(err)
+	assert.Equal(&ast.CreateIndexStatement{
+		IndexName: "apples_color",
+		TableName: "apples",
+		Columns:   []string{"color"},
+		RawText:   `create index apples_color on apples(color)`,
+	}, stmt)
+}
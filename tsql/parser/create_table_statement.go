@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"strings"
+
 	"github.com/joeandaverde/tinydb/tsql/ast"
 	"github.com/joeandaverde/tinydb/tsql/lexer"
 	"github.com/joeandaverde/tinydb/tsql/scan"
@@ -9,12 +11,17 @@ import (
 func parseCreateTable(scanner scan.TinyScanner) (*ast.CreateTableStatement, error) {
 	createTableStatement := ast.CreateTableStatement{}
 	flags := make(map[string]string)
+	var defaultValue ast.Expression
 
 	columnDefinition := all([]parserFn{
 		optWS,
 		requiredToken(lexer.TokenIdentifier, nil),
-		reqWS,
-		requiredToken(lexer.TokenIdentifier, nil),
+		optionalX(allX(
+			reqWS,
+			typeName(func(name string) {
+				flags["type"] = name
+			}),
+		)),
 		optional(all([]parserFn{
 			reqWS,
 			text("PRIMARY"),
@@ -23,20 +30,49 @@ func parseCreateTable(scanner scan.TinyScanner) (*ast.CreateTableStatement, erro
 		}, nil), func(tokens []lexer.Token) {
 			flags["primary_key"] = "true"
 		}),
+		optional(all([]parserFn{
+			reqWS,
+			text("AUTOINCREMENT"),
+		}, nil), func(tokens []lexer.Token) {
+			flags["autoincrement"] = "true"
+		}),
+		optional(all([]parserFn{
+			reqWS,
+			keyword(lexer.TokenUnique),
+		}, nil), func(tokens []lexer.Token) {
+			flags["unique"] = "true"
+		}),
+		optionalX(allX(
+			reqWS,
+			text("DEFAULT"),
+			reqWS,
+			func(scanner scan.TinyScanner) (bool, interface{}) {
+				// A signed literal or an expression in parentheses
+				ok, expr := prefix(parseTermExpression(), sum())(scanner)
+				defaultValue = expr
+				return ok, expr
+			},
+		)),
 		optWS,
 	}, func(tokens [][]lexer.Token) {
 		columnName := tokens[1][0].Text
-		columnType := tokens[3][0].Text
 
 		_, isPrimaryKey := flags["primary_key"]
+		_, isAutoIncrement := flags["autoincrement"]
+		_, isUnique := flags["unique"]
 
+		// The type of a column is optional like in SQLite
 		createTableStatement.Columns = append(createTableStatement.Columns, ast.ColumnDefinition{
-			Name:       columnName,
-			Type:       columnType,
-			PrimaryKey: isPrimaryKey,
+			Name:          columnName,
+			Type:          flags["type"],
+			PrimaryKey:    isPrimaryKey,
+			AutoIncrement: isAutoIncrement,
+			Unique:        isUnique,
+			Default:       defaultValue,
 		})
 
 		flags = make(map[string]string)
+		defaultValue = nil
 	})
 
 	ok, _ := allX(
@@ -60,3 +96,46 @@ func parseCreateTable(scanner scan.TinyScanner) (*ast.CreateTableStatement, erro
 
 	return nil, nil
 }
+
+// constraintKeywords start the constraints of a column, they end the type name
+var constraintKeywords = map[string]bool{
+	"PRIMARY":       true,
+	"AUTOINCREMENT": true,
+	"DEFAULT":       true,
+	"CONSTRAINT":    true,
+	"CHECK":         true,
+	"COLLATE":       true,
+	"REFERENCES":    true,
+}
+
+// typeName parses the declared type of a column, one or more names optionally followed
+// by sizes in parentheses such as VARCHAR(255), DECIMAL(10, 5) or DOUBLE PRECISION.
+// The sizes are ignored like in SQLite, only the names determine the affinity of the column.
+func typeName(nodify func(string)) parserFn {
+	name := func(scanner scan.TinyScanner) (bool, interface{}) {
+		next := scanner.Next()
+		return next.Kind == lexer.TokenIdentifier && !constraintKeywords[strings.ToUpper(next.Text)], nil
+	}
+
+	signedNumber := allX(
+		optionalX(regex(`^(\+|-)$`)),
+		token(lexer.TokenNumber),
+	)
+
+	return required(allX(
+		name,
+		zeroOrMore(allX(reqWS, name)),
+		optionalX(allX(
+			optWS,
+			token(lexer.TokenOpenParen),
+			commaSeparated(signedNumber),
+			token(lexer.TokenCloseParen),
+		)),
+	), func(tokens []lexer.Token) {
+		var sb strings.Builder
+		for _, t := range tokens {
+			sb.WriteString(t.Text)
+		}
+		nodify(sb.String())
+	})
+}
@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+)
+
+func Test_parseCreateTable(t *testing.T) {
+	assert := require.New(t)
+
+	stmt, err := ParseStatement(`CREATE TABLE people (id integer PRIMARY KEY AUTOINCREMENT, name text UNIQUE, tag text primary key)`)
+
+	assert.NoError(err)
+	assert.Equal(&ast.CreateTableStatement{
+		TableName: "people",
+		Columns: []ast.ColumnDefinition{
+			{Name: "id", Type: "integer", PrimaryKey: true, AutoIncrement: true},
+			{Name: "name", Type: "text", Unique: true},
+			{Name: "tag", Type: "text", PrimaryKey: true},
+		},
+		RawText: `CREATE TABLE people (id integer PRIMARY KEY AUTOINCREMENT, name text UNIQUE, tag text primary key)`,
+	}, stmt)
+
+	// The type of a column is optional like in the tables SQLite makes
+	stmt, err = ParseStatement(`CREATE TABLE sqlite_sequence(name,seq)`)
+
+	assert.NoError(err)
+	assert.Equal(&ast.CreateTableStatement{
+		TableName: "sqlite_sequence",
+		Columns: []ast.ColumnDefinition{
+			{Name: "name"},
+			{Name: "seq"},
+		},
+		RawText: `CREATE TABLE sqlite_sequence(name,seq)`,
+	}, stmt)
+
+	// A type is one or more names with optional sizes
+	stmt, err = ParseStatement(`CREATE TABLE t (a VARCHAR(255), b double  precision default 1, c decimal (10, -2) primary key, d UNSIGNED BIG INT)`)
+
+	assert.NoError(err)
+	assert.Equal([]ast.ColumnDefinition{
+		{Name: "a", Type: "VARCHAR(255)"},
+		{Name: "b", Type: "double  precision", Default: &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "1"}},
+		{Name: "c", Type: "decimal (10, -2)", PrimaryKey: true},
+		{Name: "d", Type: "UNSIGNED BIG INT"},
+	}, stmt.(*ast.CreateTableStatement).Columns)
+
+	// A default is a signed literal or an expression in parentheses
+	stmt, err = ParseStatement(`CREATE TABLE t (n integer DEFAULT 7, s text DEFAULT ('a'), r real DEFAULT (1 + 2.5), m integer DEFAULT -1)`)
+
+	assert.NoError(err)
+	assert.Equal([]ast.ColumnDefinition{
+		{Name: "n", Type: "integer", Default: &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "7"}},
+		{Name: "s", Type: "text", Default: &ast.BasicLiteral{Kind: lexer.TokenString, Value: "a"}},
+		{Name: "r", Type: "real", Default: &ast.BinaryOperation{
+			Left:     &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "1"},
+			Operator: "+",
+			Right:    &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "2.5"},
+		}},
+		{Name: "m", Type: "integer", Default: &ast.UnaryOperation{
+			Operator: "-",
+			Operand:  &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "1"},
+		}},
+	}, stmt.(*ast.CreateTableStatement).Columns)
+}
@@ -0,0 +1,33 @@
+package parser
+
+import (
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+	"github.com/joeandaverde/tinydb/tsql/scan"
+)
+
+func parseDelete(scanner scan.TinyScanner) (*ast.DeleteStatement, error) {
+	deleteStatement := ast.DeleteStatement{}
+
+	whereClause := allX(
+		keyword(lexer.TokenWhere),
+		committed("WHERE", makeExpressionParser(func(filter ast.Expression) {
+			deleteStatement.Filter = filter
+		})),
+	)
+
+	ok, _ := allX(
+		committed("DELETE", keyword(lexer.TokenDelete)),
+		committed("FROM", keyword(lexer.TokenFrom)),
+		committed("RELATION", ident(func(tableName string) {
+			deleteStatement.Table = tableName
+		})),
+		optionalX(whereClause),
+	)(scanner)
+
+	if ok {
+		return &deleteStatement, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+	"github.com/joeandaverde/tinydb/tsql/scan"
+)
+
+func Test_parseDelete(t *testing.T) {
+	assert := require.New(t)
+
+	scanner := scan.NewScanner(`
+		DELETE FROM apples WHERE color = 'red'
+	`)
+
+	stmt, err := parseDelete(scanner)
+
+	assert.NotNil(stmt)
+	assert.NoError(err)
+	assert.Equal(&ast.DeleteStatement{
+		Table: "apples",
+		Filter: &ast.BinaryOperation{
+			Left:     &ast.Ident{Value: "color"},
+			Right:    &ast.BasicLiteral{Value: "red", Kind: lexer.TokenString},
+			Operator: "=",
+		},
+	}, stmt)
+}
+
+func Test_parseDelete_NoFilter(t *testing.T) {
+	assert := require.New(t)
+
+	stmt, err := ParseStatement(`delete from apples`)
+
+	assert.NoError(err)
+	assert.Equal(&ast.DeleteStatement{Table: "apples"}, stmt)
+}
@@ -0,0 +1,30 @@
+package parser
+
+import (
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+	"github.com/joeandaverde/tinydb/tsql/scan"
+)
+
+func parseDropTable(scanner scan.TinyScanner) (*ast.DropTableStatement, error) {
+	dropTableStatement := ast.DropTableStatement{}
+
+	ok, _ := allX(
+		committed("DROP", keyword(lexer.TokenDrop)),
+		committed("TABLE", keyword(lexer.TokenTable)),
+		optional(
+			allX(keyword(lexer.TokenIf), keyword(lexer.TokenExists)),
+			func(tokens []lexer.Token) {
+				dropTableStatement.IfExists = true
+			}),
+		committed("RELATION", ident(func(tableName string) {
+			dropTableStatement.TableName = tableName
+		})),
+	)(scanner)
+
+	if ok {
+		return &dropTableStatement, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,27 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/joeandaverde/tinydb/tsql/ast"
+)
+
+func Test_parseDropTable(t *testing.T) {
+	assert := require.New(t)
+
+	stmt, err := ParseStatement(`DROP TABLE apples`)
+
+	assert.NoError(err)
+	assert.Equal(&ast.DropTableStatement{TableName: "apples"}, stmt)
+}
+
+func Test_parseDropTable_IfExists(t *testing.T) {
+	assert := require.New(t)
+
+	stmt, err := ParseStatement(`drop table if exists apples`)
+
+	assert.NoError(err)
+	assert.Equal(&ast.DropTableStatement{TableName: "apples", IfExists: true}, stmt)
+}
@@ -130,59 +130,306 @@ func firstNonSpace(tokens []lexer.Token) lexer.Token {
 	}
 }
 
+// canonicalOperator is the text of an operator token, an operator with more than
+// one spelling such as != and <> always has the same text.
+func canonicalOperator(token lexer.Token) string {
+	switch token.Kind {
+	case lexer.TokenEquals:
+		return "="
+	case lexer.TokenNotEq:
+		return "!="
+	}
+
+	return strings.ToUpper(token.Text)
+}
+
+func or() opParserFn {
+	return operatorParser(operator(`(?i)^OR$`), canonicalOperator)
+}
+
+func and() opParserFn {
+	return operatorParser(operator(`(?i)^AND$`), canonicalOperator)
+}
+
+func not() opParserFn {
+	return operatorParser(operator(`(?i)^NOT$`), canonicalOperator)
+}
+
+func equality() opParserFn {
+	return oneOfOperators(
+		operatorParser(operator(`^(=|==|!=|<>)$`), canonicalOperator),
+		is(),
+	)
+}
+
+// is parses IS, IS NOT, IS DISTINCT FROM and IS NOT DISTINCT FROM.
+// IS DISTINCT FROM is the same as IS NOT.
+func is() opParserFn {
+	return func(scanner scan.TinyScanner) (bool, string) {
+		not, distinct := false, false
+
+		ok, _ := allX(
+			optWS,
+			token(lexer.TokenIs),
+			optional(allX(reqWS, token(lexer.TokenNot)), func([]lexer.Token) {
+				not = true
+			}),
+			optional(allX(reqWS, token(lexer.TokenDistinct), reqWS, token(lexer.TokenFrom)), func([]lexer.Token) {
+				distinct = true
+			}),
+			optWS,
+		)(scanner)
+
+		if !ok {
+			return false, ""
+		}
+		if not != distinct {
+			return true, "IS NOT"
+		}
+		return true, "IS"
+	}
+}
+
+// oneOfOperators parses the first of the operators which matches
+func oneOfOperators(opParsers ...opParserFn) opParserFn {
+	return func(scanner scan.TinyScanner) (bool, string) {
+		for _, opParser := range opParsers {
+			if ok, op := opParser(scanner); ok {
+				return true, op
+			}
+		}
+
+		return false, ""
+	}
+}
+
 func comparison() opParserFn {
-	return operatorParser(operator(`=`), func(token lexer.Token) string {
-		return token.Text
-	})
+	return operatorParser(operator(`^(<|>|<=|>=)$`), canonicalOperator)
 }
 
-func logical() opParserFn {
-	return operatorParser(oneOf([]parserFn{
-		operator(`AND`),
-		operator(`OR`),
-	}, nil), func(token lexer.Token) string {
-		return token.Text
-	})
+func bitwise() opParserFn {
+	return operatorParser(operator(`^(&|\||<<|>>)$`), canonicalOperator)
+}
+
+func sum() opParserFn {
+	return operatorParser(operator(`^(\+|-)$`), canonicalOperator)
 }
 
 func mult() opParserFn {
-	return operatorParser(oneOf([]parserFn{
-		operator(`\*`),
-		operator(`/`),
-	}, nil), func(token lexer.Token) string {
-		return token.Text
-	})
+	return operatorParser(operator(`^(\*|/|%)$`), canonicalOperator)
 }
 
-func sum() opParserFn {
-	return operatorParser(oneOf([]parserFn{
-		operator(`\+`),
-		operator(`-`),
-	}, nil), func(token lexer.Token) string {
-		return token.Text
-	})
+func concat() opParserFn {
+	return operatorParser(operator(`^\|\|$`), canonicalOperator)
 }
 
-func parseExpression() expressionParserFn {
-	return chainl(
-		chainl(
-			chainl(
-				chainl(
-					parseTermExpression(),
-					makeBinaryExpression(),
-					mult(),
-				),
-				makeBinaryExpression(),
-				sum(),
-			),
-			makeBinaryExpression(),
-			comparison(),
-		),
-		makeBinaryExpression(),
-		logical(),
+func unary() opParserFn {
+	return operatorParser(operator(`^(-|\+|~)$`), canonicalOperator)
+}
+
+// prefix parses an expression preceded by any number of prefix operators, e.g. - - x.
+// The operators apply from right to left.
+func prefix(ep expressionParserFn, opParser opParserFn) expressionParserFn {
+	var parse expressionParserFn
+	parse = func(scanner scan.TinyScanner) (bool, ast.Expression) {
+		_, reset := scanner.Mark()
+
+		if os, op := opParser(scanner); os {
+			if ps, operand := parse(scanner); ps {
+				return true, &ast.UnaryOperation{
+					Operand:  operand,
+					Operator: op,
+				}
+			}
+
+			reset()
+			return false, nil
+		}
+
+		return ep(scanner)
+	}
+
+	return parse
+}
+
+// postfixParserFn parses the rest of an operation following its first operand
+type postfixParserFn func(scanner scan.TinyScanner, operand ast.Expression) (bool, ast.Expression)
+
+// collate parses an expression followed by any number of COLLATE clauses, e.g. name COLLATE NOCASE.
+func collate(ep expressionParserFn) expressionParserFn {
+	return func(scanner scan.TinyScanner) (bool, ast.Expression) {
+		success, expression := ep(scanner)
+		if !success {
+			return false, expression
+		}
+
+		for {
+			var name string
+			if ok, _ := allX(optWS, token(lexer.TokenCollate), reqWS, ident(func(n string) {
+				name = n
+			}))(scanner); !ok {
+				return true, expression
+			}
+
+			expression = &ast.CollateOperation{
+				Operand:   expression,
+				Collation: name,
+			}
+		}
+	}
+}
+
+// equalityChain is chainl for the operators with the precedence of equality. Besides the
+// binary operators of equality, the operand may be followed by LIKE, BETWEEN or IN.
+func equalityChain(ep expressionParserFn) expressionParserFn {
+	binary := makeBinaryExpression()
+	postfixes := []postfixParserFn{like(ep), between(ep), in()}
+
+	return func(scanner scan.TinyScanner) (bool, ast.Expression) {
+		success, expression := ep(scanner)
+		if !success {
+			return false, expression
+		}
+
+	next:
+		for {
+			if os, op := equality()(scanner); os {
+				ps, right := ep(scanner)
+				if !ps {
+					return false, nil
+				}
+				expression = binary(op, expression, right)
+				continue
+			}
+
+			for _, postfix := range postfixes {
+				if ps, e := postfix(scanner, expression); ps {
+					expression = e
+					continue next
+				}
+			}
+
+			return true, expression
+		}
+	}
+}
+
+// like parses [NOT] LIKE, GLOB, REGEXP or MATCH and the pattern, with an optional ESCAPE.
+func like(ep expressionParserFn) postfixParserFn {
+	return func(scanner scan.TinyScanner, operand ast.Expression) (bool, ast.Expression) {
+		e := &ast.LikeOperation{Operand: operand}
+
+		operators := []parserFn{
+			token(lexer.TokenLike),
+			token(lexer.TokenGlob),
+			token(lexer.TokenRegexp),
+			token(lexer.TokenMatch),
+		}
+		ok, _ := allX(
+			negation(&e.Not),
+			oneOf(operators, func(tokens []lexer.Token) {
+				e.Operator = strings.ToUpper(tokens[0].Text)
+			}),
+			optWS,
+			expressionTo(ep, &e.Pattern),
+			optionalX(allX(optWS, token(lexer.TokenEscape), optWS, expressionTo(ep, &e.Escape))),
+		)(scanner)
+
+		return ok, e
+	}
+}
+
+// between parses [NOT] BETWEEN and the bounds
+func between(ep expressionParserFn) postfixParserFn {
+	return func(scanner scan.TinyScanner, operand ast.Expression) (bool, ast.Expression) {
+		e := &ast.BetweenOperation{Operand: operand}
+
+		ok, _ := allX(
+			negation(&e.Not),
+			token(lexer.TokenBetween),
+			optWS,
+			expressionTo(ep, &e.Low),
+			optWS,
+			token(lexer.TokenAnd),
+			optWS,
+			expressionTo(ep, &e.High),
+		)(scanner)
+
+		return ok, e
+	}
+}
+
+// in parses [NOT] IN and the list of values, which may be empty
+func in() postfixParserFn {
+	return func(scanner scan.TinyScanner, operand ast.Expression) (bool, ast.Expression) {
+		e := &ast.InOperation{Operand: operand}
+
+		ok, _ := allX(
+			negation(&e.Not),
+			token(lexer.TokenIn),
+			parens(optionalX(commaSeparated(makeExpressionParser(func(expr ast.Expression) {
+				e.List = append(e.List, expr)
+			})))),
+		)(scanner)
+
+		return ok, e
+	}
+}
+
+// negation parses the NOT of an operator such as NOT LIKE, if any
+func negation(not *bool) parserFn {
+	return allX(
+		optWS,
+		optional(allX(token(lexer.TokenNot), reqWS), func([]lexer.Token) {
+			*not = true
+		}),
 	)
 }
 
+// expressionTo parses an expression into dest
+func expressionTo(ep expressionParserFn, dest *ast.Expression) parserFn {
+	return func(scanner scan.TinyScanner) (bool, interface{}) {
+		success, expr := ep(scanner)
+		if success {
+			*dest = expr
+		}
+
+		return success, expr
+	}
+}
+
+// parseExpression parses an expression using the operator precedence of SQLite.
+// From the operators which bind the tightest to those which bind the loosest:
+//
+//	x COLLATE name
+//	-x  +x  ~x
+//	x || y
+//	x * y  x / y  x % y
+//	x + y  x - y
+//	x & y  x | y  x << y  x >> y
+//	x < y  x <= y  x > y  x >= y
+//	x = y  x == y  x != y  x <> y  x IS [NOT] y  x IS [NOT] DISTINCT FROM y
+//	  x [NOT] LIKE y [ESCAPE z]  x [NOT] GLOB y  x [NOT] REGEXP y  x [NOT] MATCH y
+//	  x [NOT] BETWEEN y AND z  x [NOT] IN (y, ...)
+//	NOT x
+//	x AND y
+//	x OR y
+//
+// The operand of IN is a list of values, a subquery isn't supported.
+func parseExpression() expressionParserFn {
+	binary := makeBinaryExpression()
+
+	expression := prefix(collate(parseTermExpression()), unary())
+	for _, op := range []opParserFn{concat(), mult(), sum(), bitwise(), comparison()} {
+		expression = chainl(expression, binary, op)
+	}
+	expression = equalityChain(expression)
+	expression = prefix(expression, not())
+	expression = chainl(expression, binary, and())
+
+	return chainl(expression, binary, or())
+}
+
 func parseTerm(nodify nodifyExpression) parserFn {
 	return oneOf([]parserFn{
 		requiredToken(lexer.TokenIdentifier, func(tokens []lexer.Token) {
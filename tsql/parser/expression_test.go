@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+	"github.com/joeandaverde/tinydb/tsql/scan"
+)
+
+func Test_parseExpression_Precedence(t *testing.T) {
+	assert := require.New(t)
+
+	expressions := map[string]string{
+		"1 + 2 * 3":                 "(1 + (2 * 3))",
+		"1 - 2 - 3":                 "((1 - 2) - 3)",
+		"a / b % c":                 "((a / b) % c)",
+		"a || b * c":                "((a || b) * c)",
+		"-a || b":                   "((- a) || b)",
+		"- - a":                     "(- (- a))",
+		"a - -1":                    "(a - (- 1))",
+		"~a & b | c << 2 >> 1":      "(((((~ a) & b) | c) << 2) >> 1)",
+		"a + 1 < b & 3":             "((a + 1) < (b & 3))",
+		"a < b = c > d":             "((a < b) = (c > d))",
+		"a == b":                    "(a = b)",
+		"a <> b":                    "(a != b)",
+		"a != b":                    "(a != b)",
+		"NOT a = b":                 "(NOT (a = b))",
+		"not not a":                 "(NOT (NOT a))",
+		"a OR b AND c":              "(a OR (b AND c))",
+		"a and not b or c":          "((a AND (NOT b)) OR c)",
+		"(a OR b) AND c":            "((a OR b) AND c)",
+		"age >= 21 AND NOT active":  "((age >= 21) AND (NOT active))",
+		"'AND' = brand":             "('AND' = brand)",
+		"(1 + 2) * -(3 - 4)":        "((1 + 2) * (- (3 - 4)))",
+		"name || ' ' || 'x' = NULL": "(((name || ' ') || 'x') = NULL)",
+		"a IS NULL":                 "(a IS NULL)",
+		"a is not null":             "(a IS NOT NULL)",
+		"a IS DISTINCT FROM b + 1":  "(a IS NOT (b + 1))",
+		"a IS NOT DISTINCT FROM b":  "(a IS b)",
+		"NOT a IS NULL AND b":       "((NOT (a IS NULL)) AND b)",
+		"a = b IS NULL":             "((a = b) IS NULL)",
+	}
+	for input, expected := range expressions {
+		ok, expr := parseExpression()(scan.NewScanner(input))
+		assert.True(ok, input)
+		assert.Equal(expected, fmt.Sprint(expr), input)
+	}
+}
+
+func Test_parseExpression_Equality(t *testing.T) {
+	assert := require.New(t)
+
+	expressions := map[string]string{
+		"name LIKE 'a%' || b":         "(name LIKE ('a%' || b))",
+		"a not like b escape '!'":     "(a NOT LIKE b ESCAPE '!')",
+		"a GLOB b = 1":                "((a GLOB b) = 1)",
+		"a regexp b OR a match c":     "((a REGEXP b) OR (a MATCH c))",
+		"NOT a LIKE b":                "(NOT (a LIKE b))",
+		"a BETWEEN 1 AND b + 1":       "(a BETWEEN 1 AND (b + 1))",
+		"a NOT BETWEEN b AND c AND d": "((a NOT BETWEEN b AND c) AND d)",
+		"a between b and c = 1":       "((a BETWEEN b AND c) = 1)",
+		"a IN (1, b + 2)":             "(a IN (1, (b + 2)))",
+		"a not in ()":                 "(a NOT IN ())",
+		"a IN (1) IS NULL":            "((a IN (1)) IS NULL)",
+		"a < b IN (c)":                "((a < b) IN (c))",
+		"a COLLATE nocase = b":        "((a COLLATE nocase) = b)",
+		"-a collate rtrim":            "(- (a COLLATE rtrim))",
+		"a || b COLLATE x COLLATE y":  "(a || ((b COLLATE x) COLLATE y))",
+	}
+	for input, expected := range expressions {
+		ok, expr := parseExpression()(scan.NewScanner(input))
+		assert.True(ok, input)
+		assert.Equal(expected, fmt.Sprint(expr), input)
+	}
+
+	// An incomplete operation isn't parsed
+	for _, input := range []string{"a LIKE", "a NOT BETWEEN 1", "a IN 1", "a COLLATE"} {
+		ok, expr := parseExpression()(scan.NewScanner(input))
+		assert.True(ok, input)
+		assert.Equal("a", fmt.Sprint(expr), input)
+	}
+}
+
+func Test_parseExpression_Unary(t *testing.T) {
+	assert := require.New(t)
+
+	stmt, err := ParseStatement("SELECT * FROM people WHERE age >= 21 AND NOT active")
+	assert.NoError(err)
+	assert.Equal(&ast.BinaryOperation{
+		Left: &ast.BinaryOperation{
+			Left:     &ast.Ident{Value: "age"},
+			Operator: ">=",
+			Right:    &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "21"},
+		},
+		Operator: "AND",
+		Right: &ast.UnaryOperation{
+			Operator: "NOT",
+			Operand:  &ast.Ident{Value: "active"},
+		},
+	}, stmt.(*ast.SelectStatement).Filter)
+}
@@ -19,6 +19,13 @@ var topLevelStatements = []struct {
 			return s, s != nil, err
 		},
 	},
+	{
+		Name: "CREATE INDEX",
+		Parse: func(scanner scan.TinyScanner) (ast.Statement, bool, error) {
+			s, err := parseCreateIndex(scanner)
+			return s, s != nil, err
+		},
+	},
 	{
 		Name: "INSERT",
 		Parse: func(scanner scan.TinyScanner) (ast.Statement, bool, error) {
@@ -26,6 +33,41 @@ var topLevelStatements = []struct {
 			return s, s != nil, err
 		},
 	},
+	{
+		Name: "UPDATE",
+		Parse: func(scanner scan.TinyScanner) (ast.Statement, bool, error) {
+			s, err := parseUpdate(scanner)
+			return s, s != nil, err
+		},
+	},
+	{
+		Name: "DELETE",
+		Parse: func(scanner scan.TinyScanner) (ast.Statement, bool, error) {
+			s, err := parseDelete(scanner)
+			return s, s != nil, err
+		},
+	},
+	{
+		Name: "DROP",
+		Parse: func(scanner scan.TinyScanner) (ast.Statement, bool, error) {
+			s, err := parseDropTable(scanner)
+			return s, s != nil, err
+		},
+	},
+	{
+		Name: "VACUUM",
+		Parse: func(scanner scan.TinyScanner) (ast.Statement, bool, error) {
+			s, err := parseVacuum(scanner)
+			return s, s != nil, err
+		},
+	},
+	{
+		Name: "PRAGMA",
+		Parse: func(scanner scan.TinyScanner) (ast.Statement, bool, error) {
+			s, err := parsePragma(scanner)
+			return s, s != nil, err
+		},
+	},
 	{
 		Name: "SELECT",
 		Parse: func(scanner scan.TinyScanner) (ast.Statement, bool, error) {
@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+	"github.com/joeandaverde/tinydb/tsql/scan"
+)
+
+func parsePragma(scanner scan.TinyScanner) (*ast.PragmaStatement, error) {
+	pragmaStatement := ast.PragmaStatement{}
+
+	setValue := func(tokens []lexer.Token) {
+		pragmaStatement.Value = tokens[0].Text
+		if tokens[0].Kind == lexer.TokenString {
+			pragmaStatement.Value = tokens[0].Text[1 : len(tokens[0].Text)-1]
+		}
+	}
+	setNegative := func(tokens []lexer.Token) {
+		pragmaStatement.Value = "-" + tokens[0].Text
+	}
+	value := oneOf([]parserFn{
+		requiredToken(lexer.TokenNumber, setValue),
+		allX(token(lexer.TokenMinus), requiredToken(lexer.TokenNumber, setNegative)),
+		requiredToken(lexer.TokenIdentifier, setValue),
+		requiredToken(lexer.TokenString, setValue),
+	}, nil)
+
+	ok, _ := allX(
+		committed("PRAGMA", keyword(lexer.TokenPragma)),
+		committed("NAME", ident(func(name string) {
+			pragmaStatement.Name = name
+		})),
+		optionalX(oneOf([]parserFn{
+			allX(optWS, token(lexer.TokenEquals), optWS, value),
+			parens(value),
+		}, nil)),
+	)(scanner)
+
+	if ok {
+		return &pragmaStatement, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,34 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/joeandaverde/tinydb/tsql/ast"
+)
+
+func Test_parsePragma(t *testing.T) {
+	assert := require.New(t)
+
+	stmt, err := ParseStatement(`PRAGMA page_size`)
+	assert.NoError(err)
+	assert.Equal(&ast.PragmaStatement{Name: "page_size"}, stmt)
+
+	stmt, err = ParseStatement(`pragma page_size = 8192`)
+	assert.NoError(err)
+	assert.Equal(&ast.PragmaStatement{Name: "page_size", Value: "8192"}, stmt)
+
+	stmt, err = ParseStatement(`pragma page_size(1024)`)
+	assert.NoError(err)
+	assert.Equal(&ast.PragmaStatement{Name: "page_size", Value: "1024"}, stmt)
+
+	stmt, err = ParseStatement(`pragma cache_size = -2000`)
+	assert.NoError(err)
+	assert.Equal(&ast.PragmaStatement{Name: "cache_size", Value: "-2000"}, stmt)
+
+	stmt, err = ParseStatement(`pragma wal_checkpoint(TRUNCATE)`)
+	assert.NoError(err)
+	assert.Equal(&ast.PragmaStatement{Name: "wal_checkpoint", Value: "TRUNCATE"}, stmt)
+	assert.True(stmt.ReturnsRows())
+}
@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"strings"
+
 	"github.com/joeandaverde/tinydb/tsql/ast"
 	"github.com/joeandaverde/tinydb/tsql/lexer"
 	"github.com/joeandaverde/tinydb/tsql/scan"
@@ -16,14 +18,21 @@ func parseSelect(scanner scan.TinyScanner) (*ast.SelectStatement, error) {
 		})),
 	)
 
+	orderByClause := allX(
+		keyword(lexer.TokenOrder),
+		committed("ORDER BY", allX(
+			text("BY"),
+			commaSeparated(orderingTerm(func(term ast.OrderingTerm) {
+				selectStatement.OrderBy = append(selectStatement.OrderBy, term)
+			})),
+		)),
+	)
+
 	ok, _ := allX(
 		committed("SELECT", keyword(lexer.TokenSelect)),
 		committed("COLUMNS", commaSeparated(
-			oneOf([]parserFn{
-				token(lexer.TokenIdentifier),
-				token(lexer.TokenAsterisk),
-			}, func(tokens []lexer.Token) {
-				selectStatement.Columns = append(selectStatement.Columns, tokens[0].Text)
+			resultColumn(func(column ast.ResultColumn) {
+				selectStatement.Columns = append(selectStatement.Columns, column)
 			}),
 		)),
 		committed("FROM", keyword(lexer.TokenFrom)),
@@ -49,6 +58,7 @@ func parseSelect(scanner scan.TinyScanner) (*ast.SelectStatement, error) {
 			}),
 		)),
 		optionalX(whereClause),
+		optionalX(orderByClause),
 	)(scanner)
 
 	if ok {
@@ -57,3 +67,68 @@ func parseSelect(scanner scan.TinyScanner) (*ast.SelectStatement, error) {
 
 	return nil, nil
 }
+
+// resultColumn parses * or an expression followed by an optional alias
+func resultColumn(nodify func(column ast.ResultColumn)) parserFn {
+	return func(scanner scan.TinyScanner) (bool, interface{}) {
+		if ok, _ := token(lexer.TokenAsterisk)(scanner); ok {
+			nodify(ast.ResultColumn{Text: "*"})
+			return true, nil
+		}
+
+		start, reset := scanner.Mark()
+		ok, expr := parseExpression()(scanner)
+		if !ok {
+			reset()
+			return false, nil
+		}
+
+		// The text of the expression names the column
+		var sb strings.Builder
+		for _, t := range scanner.Range(start, scanner.Pos()) {
+			sb.WriteString(t.Text)
+		}
+		column := ast.ResultColumn{Expr: expr, Text: strings.TrimSpace(sb.String())}
+
+		optionalX(allX(
+			optWS,
+			optionalX(allX(token(lexer.TokenAs), reqWS)),
+			ident(func(alias string) {
+				column.Alias = alias
+			}),
+		))(scanner)
+
+		nodify(column)
+		return true, nil
+	}
+}
+
+// orderingTerm parses an expression followed by an optional direction and placement of NULLs
+func orderingTerm(nodify func(term ast.OrderingTerm)) parserFn {
+	return func(scanner scan.TinyScanner) (bool, interface{}) {
+		ok, expr := parseExpression()(scanner)
+		if !ok {
+			return false, nil
+		}
+		term := ast.OrderingTerm{Expr: expr}
+
+		optional(allX(
+			reqWS,
+			oneOf([]parserFn{text("ASC"), text("DESC")}, nil),
+		), func(tokens []lexer.Token) {
+			term.Desc = strings.EqualFold(tokens[1].Text, "DESC")
+		})(scanner)
+
+		optional(allX(
+			reqWS,
+			text("NULLS"),
+			reqWS,
+			oneOf([]parserFn{text("FIRST"), text("LAST")}, nil),
+		), func(tokens []lexer.Token) {
+			term.Nulls = strings.ToUpper(tokens[3].Text)
+		})(scanner)
+
+		nodify(term)
+		return true, nil
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
 	"github.com/joeandaverde/tinydb/tsql/scan"
 )
 
@@ -22,7 +23,88 @@ func Test_parseSelect(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal(&ast.SelectStatement{
 		From:    []ast.TableAlias{{Name: "apples", Alias: ""}},
-		Columns: []string{"*"},
+		Columns: []ast.ResultColumn{{Text: "*"}},
 		Filter:  nil,
 	}, stmt)
 }
+
+func Test_parseSelect_RealLiterals(t *testing.T) {
+	assert := require.New(t)
+
+	literals := []string{"1.5", ".25", "2.", "2.5e3", "1E-3", "7e+2"}
+	for _, literal := range literals {
+		stmt, err := parseSelect(scan.NewScanner("SELECT * FROM m WHERE r > " + literal))
+		assert.NoError(err)
+		assert.Equal(&ast.BinaryOperation{
+			Left:     &ast.Ident{Value: "r"},
+			Operator: ">",
+			Right:    &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: literal},
+		}, stmt.Filter, literal)
+	}
+}
+
+func Test_parseSelect_ResultColumns(t *testing.T) {
+	assert := require.New(t)
+
+	stmt, err := parseSelect(scan.NewScanner("SELECT *, name, age + 1 AS next, (age * 2) double FROM people"))
+	assert.NoError(err)
+	assert.Equal([]ast.ResultColumn{
+		{Text: "*"},
+		{Expr: &ast.Ident{Value: "name"}, Text: "name"},
+		{
+			Expr: &ast.BinaryOperation{
+				Left:     &ast.Ident{Value: "age"},
+				Operator: "+",
+				Right:    &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "1"},
+			},
+			Alias: "next",
+			Text:  "age + 1",
+		},
+		{
+			Expr: &ast.BinaryOperation{
+				Left:     &ast.Ident{Value: "age"},
+				Operator: "*",
+				Right:    &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "2"},
+			},
+			Alias: "double",
+			Text:  "(age * 2)",
+		},
+	}, stmt.Columns)
+	assert.Equal("next", stmt.Columns[2].Name())
+	assert.Equal("name", stmt.Columns[1].Name())
+}
+
+func Test_parseSelect_OrderBy(t *testing.T) {
+	assert := require.New(t)
+
+	stmt, err := parseSelect(scan.NewScanner("SELECT * FROM people WHERE age > 1 ORDER BY name, age + 1 DESC, 2 asc nulls last"))
+	assert.NoError(err)
+	assert.Equal(&ast.BinaryOperation{
+		Left:     &ast.Ident{Value: "age"},
+		Operator: ">",
+		Right:    &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "1"},
+	}, stmt.Filter)
+	assert.Equal([]ast.OrderingTerm{
+		{Expr: &ast.Ident{Value: "name"}},
+		{
+			Expr: &ast.BinaryOperation{
+				Left:     &ast.Ident{Value: "age"},
+				Operator: "+",
+				Right:    &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "1"},
+			},
+			Desc: true,
+		},
+		{Expr: &ast.BasicLiteral{Kind: lexer.TokenNumber, Value: "2"}, Nulls: "LAST"},
+	}, stmt.OrderBy)
+
+	// NULLs are the smallest values unless placed explicitly
+	assert.True(stmt.OrderBy[0].NullsFirst())
+	assert.False(stmt.OrderBy[1].NullsFirst())
+	assert.False(stmt.OrderBy[2].NullsFirst())
+
+	stmt, err = parseSelect(scan.NewScanner("SELECT * FROM people p ORDER BY name DESC NULLS FIRST"))
+	assert.NoError(err)
+	assert.Equal([]ast.TableAlias{{Name: "people", Alias: "p"}}, stmt.From)
+	assert.Equal([]ast.OrderingTerm{{Expr: &ast.Ident{Value: "name"}, Desc: true, Nulls: "FIRST"}}, stmt.OrderBy)
+	assert.True(stmt.OrderBy[0].NullsFirst())
+}
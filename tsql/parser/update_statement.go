@@ -0,0 +1,60 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+	"github.com/joeandaverde/tinydb/tsql/scan"
+)
+
+func parseUpdate(scanner scan.TinyScanner) (*ast.UpdateStatement, error) {
+	updateStatement := ast.UpdateStatement{
+		Values: make(ast.ValueSet),
+	}
+
+	var columns []string
+	var values []ast.Expression
+
+	assignment := allX(
+		ident(func(column string) {
+			columns = append(columns, column)
+		}),
+		optWS,
+		token(lexer.TokenEquals),
+		optWS,
+		makeExpressionParser(func(e ast.Expression) {
+			values = append(values, e)
+		}),
+	)
+
+	whereClause := allX(
+		keyword(lexer.TokenWhere),
+		committed("WHERE", makeExpressionParser(func(filter ast.Expression) {
+			updateStatement.Filter = filter
+		})),
+	)
+
+	ok, _ := allX(
+		committed("UPDATE", keyword(lexer.TokenUpdate)),
+		committed("RELATION", ident(func(tableName string) {
+			updateStatement.Table = tableName
+		})),
+		committed("SET", keyword(lexer.TokenSet)),
+		committed("ASSIGNMENTS", commaSeparated(assignment)),
+		optionalX(whereClause),
+	)(scanner)
+
+	if !ok {
+		return nil, nil
+	}
+
+	for i, column := range columns {
+		if _, ok := updateStatement.Values[column]; ok {
+			return nil, fmt.Errorf("column %s assigned more than once", column)
+		}
+		updateStatement.Values[column] = values[i]
+	}
+
+	return &updateStatement, nil
+}
@@ -0,0 +1,36 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+	"github.com/joeandaverde/tinydb/tsql/scan"
+)
+
+func Test_parseUpdate(t *testing.T) {
+	assert := require.New(t)
+
+	scanner := scan.NewScanner(`
+		UPDATE apples SET color = 'green', size=10 WHERE color = 'red'
+	`)
+
+	stmt, err := parseUpdate(scanner)
+
+	assert.NotNil(stmt)
+	assert.NoError(err)
+	assert.Equal(&ast.UpdateStatement{
+		Table: "apples",
+		Values: ast.ValueSet{
+			"color": &ast.BasicLiteral{Value: "green", Kind: lexer.TokenString},
+			"size":  &ast.BasicLiteral{Value: "10", Kind: lexer.TokenNumber},
+		},
+		Filter: &ast.BinaryOperation{
+			Left:     &ast.Ident{Value: "color"},
+			Right:    &ast.BasicLiteral{Value: "red", Kind: lexer.TokenString},
+			Operator: "=",
+		},
+	}, stmt)
+}
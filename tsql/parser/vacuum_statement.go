@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"github.com/joeandaverde/tinydb/tsql/ast"
+	"github.com/joeandaverde/tinydb/tsql/lexer"
+	"github.com/joeandaverde/tinydb/tsql/scan"
+)
+
+func parseVacuum(scanner scan.TinyScanner) (*ast.VacuumStatement, error) {
+	vacuumStatement := ast.VacuumStatement{}
+
+	intoClause := allX(
+		keyword(lexer.TokenInto),
+		committed("INTO", requiredToken(lexer.TokenString, func(tokens []lexer.Token) {
+			vacuumStatement.Into = tokens[0].Text[1 : len(tokens[0].Text)-1]
+		})),
+	)
+
+	ok, _ := allX(
+		committed("VACUUM", keyword(lexer.TokenVacuum)),
+		optionalX(intoClause),
+	)(scanner)
+
+	if ok {
+		return &vacuumStatement, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,21 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/joeandaverde/tinydb/tsql/ast"
+)
+
+func Test_parseVacuum(t *testing.T) {
+	assert := require.New(t)
+
+	stmt, err := ParseStatement(`VACUUM`)
+	assert.NoError(err)
+	assert.Equal(&ast.VacuumStatement{}, stmt)
+
+	stmt, err = ParseStatement(`vacuum into '/tmp/copy.db'`)
+	assert.NoError(err)
+	assert.Equal(&ast.VacuumStatement{Into: "/tmp/copy.db"}, stmt)
+}